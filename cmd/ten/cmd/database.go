@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/humper/tor_exit_nodes/pkg/database"
+	"github.com/humper/tor_exit_nodes/pkg/database/memory"
+	"github.com/humper/tor_exit_nodes/pkg/database/psql"
+	"github.com/humper/tor_exit_nodes/pkg/database/sqlite"
+	"github.com/humper/tor_exit_nodes/pkg/util"
+)
+
+// dbDriverConfig picks the database backend; the rest of the DB config file is read by the
+// backend itself.
+type dbDriverConfig struct {
+	// Driver is postgres, the default, sqlite or memory.
+	Driver string `yaml:"driver"`
+}
+
+// loadDatabase opens the database the DB config file at filename describes.
+func loadDatabase(ctx context.Context, filename string) (*database.Database, error) {
+	cfg, err := util.ReadYamlFile[dbDriverConfig](filename)
+	if err != nil {
+		return nil, err
+	}
+	switch cfg.Driver {
+	case "", "postgres":
+		return psql.Load(ctx, filename)
+	case "sqlite":
+		return sqlite.Load(ctx, filename)
+	case "memory":
+		return memory.Load(ctx, filename)
+	default:
+		return nil, fmt.Errorf("unknown database driver %q", cfg.Driver)
+	}
+}
+
+// closeDatabase closes db on shutdown, which for the memory backend writes its final snapshot.
+func closeDatabase(ctx context.Context, db *database.Database) {
+	if err := db.Close(); err != nil {
+		slog.ErrorContext(ctx, "Failed to close database", "error", err)
+	}
+}
@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/humper/tor_exit_nodes/pkg/dnsbl"
+	"github.com/humper/tor_exit_nodes/pkg/util"
+	"github.com/spf13/cobra"
+)
+
+type dnsblConfig struct {
+	Listen         string        `yaml:"listen"`
+	Zone           string        `yaml:"zone"`
+	TTL            uint32        `yaml:"ttl"`
+	ReloadInterval time.Duration `yaml:"reload_interval"`
+}
+
+func makeDNSBLCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "dnsbl",
+		Short: "serve exit nodes as a DNS blocklist",
+		Args:  cobra.NoArgs,
+	}
+
+	dbConfigPath := cmd.PersistentFlags().String("db_config_path", "/app_config/db.yaml", "DB config file path")
+	listen := cmd.PersistentFlags().String("listen", ":53", "UDP address to answer queries on")
+	zone := cmd.PersistentFlags().String("zone", "", "DNS zone to answer queries under, e.g. tor.example.com")
+	ttl := cmd.PersistentFlags().Uint32("ttl", dnsbl.DefaultTTL, "TTL of returned records")
+	reloadInterval := cmd.PersistentFlags().Duration("reload_interval", dnsbl.DefaultReloadInterval, "how often to reload exit nodes from the database")
+
+	cmd.Run = func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+
+		if *zone == "" {
+			slog.ErrorContext(ctx, "--zone is required")
+			os.Exit(-1)
+		}
+
+		db, err := loadDatabase(ctx, *dbConfigPath)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load DB configuration", "error", err)
+			os.Exit(-1)
+		}
+
+		srv := dnsbl.New(ctx, &dnsbl.NewServerParams{
+			DB:   db,
+			Zone: *zone,
+			TTL:  *ttl,
+		})
+
+		wctx, cancel := context.WithCancel(ctx)
+
+		var wg sync.WaitGroup
+		startDNSBL(wctx, cancel, &wg, srv, &dnsblConfig{Listen: *listen, ReloadInterval: *reloadInterval})
+
+		go func() {
+			defer cancel()
+			sig := <-util.NewInterruptChan()
+			slog.InfoContext(ctx, "Received signal", "signal", sig)
+		}()
+
+		wg.Wait()
+		closeDatabase(ctx, db)
+	}
+
+	return cmd
+}
+
+// startDNSBL loads the exit node set and starts answering queries and reloading in the background.
+func startDNSBL(ctx context.Context, cancel context.CancelFunc, wg *sync.WaitGroup, srv *dnsbl.Server, cfg *dnsblConfig) {
+	if err := srv.Reload(ctx); err != nil {
+		slog.ErrorContext(ctx, "Failed to load DNSBL", "error", err)
+	}
+
+	interval := cfg.ReloadInterval
+	if interval == 0 {
+		interval = dnsbl.DefaultReloadInterval
+	}
+
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		srv.ReloadEvery(ctx, interval)
+	}()
+	go func() {
+		defer wg.Done()
+		defer cancel()
+
+		slog.InfoContext(ctx, "Starting DNSBL", "listen", cfg.Listen)
+		if err := srv.ListenAndServe(ctx, cfg.Listen); err != nil {
+			slog.ErrorContext(ctx, "Failed to start DNSBL", "error", err)
+		}
+	}()
+}
@@ -0,0 +1,177 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"os"
+	"os/exec"
+	"slices"
+
+	"github.com/humper/tor_exit_nodes/pkg/export"
+	"github.com/humper/tor_exit_nodes/pkg/util"
+	"github.com/spf13/cobra"
+)
+
+var (
+	firewallFormats = []string{"ipset", "nftables", "iptables", "pf"}
+	proxyFormats    = []string{"nginx", "haproxy", "apache"}
+)
+
+type exportFlags struct {
+	dbConfigPath *string
+	user         *string
+	countries    *[]string
+	output       *string
+	reload       *string
+}
+
+func makeExportCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "export exit nodes from the database",
+	}
+
+	// the export may be written to stdout, so keep logs out of it
+	cmd.PersistentPreRun = func(cmd *cobra.Command, args []string) {
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, nil)))
+	}
+
+	flags := &exportFlags{
+		dbConfigPath: cmd.PersistentFlags().String("db_config_path", "/app_config/db.yaml", "DB config file path"),
+		user:         cmd.PersistentFlags().String("user", "", "email of the user whose excluded IPs are omitted"),
+		countries:    cmd.PersistentFlags().StringSlice("country", nil, "only export nodes in these country codes"),
+		output:       cmd.PersistentFlags().String("output", "-", "output file path, or - for stdout; files are replaced atomically"),
+		reload:       cmd.PersistentFlags().String("reload_command", "", "shell command to run after the output file changes"),
+	}
+
+	cmd.AddCommand(makeExportFirewallCmd(flags))
+	cmd.AddCommand(makeExportProxyCmd(flags))
+
+	return cmd
+}
+
+func makeExportFirewallCmd(flags *exportFlags) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "firewall",
+		Short: "render exit nodes as ipset, nftables, iptables or pf rules",
+		Args:  cobra.NoArgs,
+	}
+
+	formatName := cmd.Flags().String("format", "nftables", "one of ipset, nftables, iptables, pf")
+	setName := cmd.Flags().String("set_name", export.DefaultSetName, "name of the generated set, table or chain")
+	action := cmd.Flags().String("action", export.DefaultAction, "action for matching traffic: drop, reject or accept")
+	family := cmd.Flags().String("family", "", "restrict output to ipv4 or ipv6")
+
+	cmd.Run = func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+
+		if !slices.Contains(firewallFormats, *formatName) {
+			slog.ErrorContext(ctx, "Unknown firewall format", "format", *formatName, "available", firewallFormats)
+			os.Exit(-1)
+		}
+
+		opts := &export.Options{
+			SetName: *setName,
+			Action:  *action,
+			Family:  *family,
+		}
+		runExport(ctx, flags, *formatName, opts)
+	}
+
+	return cmd
+}
+
+func makeExportProxyCmd(flags *exportFlags) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "proxy",
+		Short: "render exit nodes as an nginx geo block, HAProxy ACL file or Apache Require block",
+		Args:  cobra.NoArgs,
+	}
+
+	formatName := cmd.Flags().String("format", "nginx", "one of nginx, haproxy, apache")
+	variable := cmd.Flags().String("variable", export.DefaultSetName, "name of the nginx geo variable")
+
+	cmd.Run = func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+
+		if !slices.Contains(proxyFormats, *formatName) {
+			slog.ErrorContext(ctx, "Unknown proxy format", "format", *formatName, "available", proxyFormats)
+			os.Exit(-1)
+		}
+
+		runExport(ctx, flags, *formatName, &export.Options{SetName: *variable})
+	}
+
+	return cmd
+}
+
+func runExport(ctx context.Context, flags *exportFlags, formatName string, opts *export.Options) {
+	if err := opts.Validate(); err != nil {
+		slog.ErrorContext(ctx, "Invalid export options", "error", err)
+		os.Exit(-1)
+	}
+
+	format, err := export.Lookup(formatName)
+	if err != nil {
+		slog.ErrorContext(ctx, "Invalid export format", "error", err)
+		os.Exit(-1)
+	}
+
+	db, err := loadDatabase(ctx, *flags.dbConfigPath)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load DB configuration", "error", err)
+		os.Exit(-1)
+	}
+
+	excludedIPs := []string{}
+	if *flags.user != "" {
+		user, err := db.Users.GetByEmail(ctx, *flags.user)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to get user", "error", err, "email", *flags.user)
+			os.Exit(-1)
+		}
+		excludedIPs = user.AllowedIPs
+	}
+
+	var filter map[string][]string
+	if len(*flags.countries) > 0 {
+		filter = map[string][]string{"country_code": *flags.countries}
+	}
+
+	if *flags.output == "-" {
+		if err := export.Export(ctx, db.TorExitNodes, excludedIPs, filter, format.New(os.Stdout, opts)); err != nil {
+			slog.ErrorContext(ctx, "Failed to export tor exit nodes", "error", err)
+			os.Exit(-1)
+		}
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := export.Export(ctx, db.TorExitNodes, excludedIPs, filter, format.New(&buf, opts)); err != nil {
+		slog.ErrorContext(ctx, "Failed to export tor exit nodes", "error", err)
+		os.Exit(-1)
+	}
+
+	changed, err := util.WriteFileIfChanged(*flags.output, buf.Bytes(), 0644)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to write output file", "error", err, "path", *flags.output)
+		os.Exit(-1)
+	}
+	if !changed {
+		slog.InfoContext(ctx, "Output file unchanged", "path", *flags.output)
+		return
+	}
+	slog.InfoContext(ctx, "Output file updated", "path", *flags.output)
+
+	if *flags.reload != "" {
+		reload := exec.CommandContext(ctx, "sh", "-c", *flags.reload)
+		reload.Stdout = os.Stderr
+		reload.Stderr = os.Stderr
+		if err := reload.Run(); err != nil {
+			slog.ErrorContext(ctx, "Reload command failed", "error", err, "command", *flags.reload)
+			os.Exit(-1)
+		}
+		slog.InfoContext(ctx, "Reload command succeeded", "command", *flags.reload)
+	}
+}
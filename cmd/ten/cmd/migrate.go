@@ -0,0 +1,137 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/humper/tor_exit_nodes/pkg/database/psql"
+	"github.com/humper/tor_exit_nodes/pkg/util"
+	"github.com/spf13/cobra"
+)
+
+func makeMigrateCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "apply, revert or list Postgres schema migrations",
+	}
+
+	dbConfigPath := cmd.PersistentFlags().String("db_config_path", "/app_config/db.yaml", "DB config file path")
+
+	cmd.AddCommand(makeMigrateUpCmd(dbConfigPath))
+	cmd.AddCommand(makeMigrateDownCmd(dbConfigPath))
+	cmd.AddCommand(makeMigrateStatusCmd(dbConfigPath))
+
+	return cmd
+}
+
+// loadMigrator connects to the database to manage its migrations, exiting on failure.  Only
+// Postgres is migrated this way; sqlite creates its schema itself.
+func loadMigrator(ctx context.Context, dbConfigPath string) *psql.Migrator {
+	cfg, err := util.ReadYamlFile[dbDriverConfig](dbConfigPath)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load DB configuration", "error", err)
+		os.Exit(-1)
+	}
+	if cfg.Driver != "" && cfg.Driver != "postgres" {
+		slog.ErrorContext(ctx, "Migrations are only for the postgres driver", "driver", cfg.Driver)
+		os.Exit(-1)
+	}
+
+	migrator, err := psql.LoadMigrator(ctx, dbConfigPath)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to connect to the database", "error", err)
+		os.Exit(-1)
+	}
+	return migrator
+}
+
+func makeMigrateUpCmd(dbConfigPath *string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "up",
+		Short: "apply every migration that hasn't been applied",
+		Args:  cobra.NoArgs,
+	}
+
+	cmd.Run = func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+		migrations, err := loadMigrator(ctx, *dbConfigPath).Up(ctx)
+		for _, migration := range migrations {
+			slog.InfoContext(ctx, "Applied migration", "version", migration.Version, "name", migration.Name)
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to apply migrations", "error", err)
+			os.Exit(-1)
+		}
+		if len(migrations) == 0 {
+			slog.InfoContext(ctx, "Database is up to date")
+		}
+	}
+
+	return cmd
+}
+
+func makeMigrateDownCmd(dbConfigPath *string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "down",
+		Short: "revert the most recently applied migrations",
+		Args:  cobra.NoArgs,
+	}
+
+	steps := cmd.Flags().Int("steps", 1, "how many migrations to revert")
+
+	cmd.Run = func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+		if *steps < 1 {
+			slog.ErrorContext(ctx, "--steps must be at least 1", "steps", *steps)
+			os.Exit(-1)
+		}
+
+		migrations, err := loadMigrator(ctx, *dbConfigPath).Down(ctx, *steps)
+		for _, migration := range migrations {
+			slog.InfoContext(ctx, "Reverted migration", "version", migration.Version, "name", migration.Name)
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to revert migrations", "error", err)
+			os.Exit(-1)
+		}
+		if len(migrations) == 0 {
+			slog.InfoContext(ctx, "No migrations to revert")
+		}
+	}
+
+	return cmd
+}
+
+func makeMigrateStatusCmd(dbConfigPath *string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "status",
+		Short: "list migrations and when they were applied",
+		Args:  cobra.NoArgs,
+	}
+
+	cmd.Run = func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+		statuses, err := loadMigrator(ctx, *dbConfigPath).Status(ctx)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to get migration status", "error", err)
+			os.Exit(-1)
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED")
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.AppliedAt != nil {
+				appliedAt = status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(tw, "%04d\t%s\t%s\n", status.Version, status.Name, appliedAt)
+		}
+		tw.Flush()
+	}
+
+	return cmd
+}
@@ -24,6 +24,10 @@ func init() {
 	}
 
 	rootCmd.AddCommand(makeStartCmd())
+	rootCmd.AddCommand(makeExportCmd())
+	rootCmd.AddCommand(makeDNSBLCmd())
+	rootCmd.AddCommand(makeUserCmd())
+	rootCmd.AddCommand(makeMigrateCmd())
 }
 
 func Execute() {
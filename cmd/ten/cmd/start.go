@@ -7,9 +7,13 @@ import (
 	"os"
 	"sync"
 
-	"github.com/humper/tor_exit_nodes/pkg/database/psql"
+	"github.com/humper/tor_exit_nodes/pkg/auth"
+	"github.com/humper/tor_exit_nodes/pkg/dnsbl"
+	"github.com/humper/tor_exit_nodes/pkg/mail"
+	"github.com/humper/tor_exit_nodes/pkg/oidc"
 	"github.com/humper/tor_exit_nodes/pkg/server"
 	"github.com/humper/tor_exit_nodes/pkg/tor"
+	"github.com/humper/tor_exit_nodes/pkg/users"
 	"github.com/humper/tor_exit_nodes/pkg/util"
 	"github.com/spf13/cobra"
 
@@ -24,10 +28,49 @@ var (
 )
 
 type config struct {
-	GeolocationUrl       string   `yaml:"geolocation_url"`
-	GeolocationBatchSize int      `yaml:"geolocation_batch_size"`
-	TorSourceURLs        []string `yaml:"tor_source_urls"`
-	EtcdHost             string   `yaml:"etcd_host"`
+	GeolocationUrl       string          `yaml:"geolocation_url"`
+	GeolocationBatchSize int             `yaml:"geolocation_batch_size"`
+	TorSourceURLs        []string        `yaml:"tor_source_urls"`
+	EtcdHost             string          `yaml:"etcd_host"`
+	JWT                  auth.KeysConfig `yaml:"jwt"`
+	// TorPolicyURL is an Onionoo details URL to read exit policies from; they aren't tracked if empty.
+	TorPolicyURL string `yaml:"tor_policy_url"`
+	// Roles maps role names to permissions; the built-in admin, user and anonymous roles are used if empty.
+	Roles auth.RolesConfig `yaml:"roles"`
+	// PasswordPolicy sets the complexity new passwords need; by default they need 8 characters.
+	PasswordPolicy *auth.PasswordPolicy `yaml:"password_policy"`
+	// PasswordHashing picks how new passwords are hashed; by default argon2id.  Existing hashes are
+	// upgraded when their users next log in.
+	PasswordHashing *auth.PasswordHasher `yaml:"password_hashing"`
+	// Lockout limits failed logins; by default 5 per account or 20 per IP address in 15 minutes.
+	Lockout *auth.LockoutConfig `yaml:"lockout"`
+	// RequireTOTP lists roles, such as admin, whose users must enable two-factor authentication.
+	RequireTOTP []string `yaml:"require_totp"`
+	// Mail configures how password reset and verification emails are sent; they're logged if it's empty.
+	Mail mail.Config `yaml:"mail"`
+	// PublicURL is the frontend's address, which links in emails point to.
+	PublicURL string `yaml:"public_url"`
+	// RequireVerifiedEmail refuses password logins until users have verified their email address.
+	RequireVerifiedEmail bool `yaml:"require_verified_email"`
+	// BootstrapAdmin optionally creates a first user when the database has none.
+	BootstrapAdmin *users.BootstrapConfig `yaml:"bootstrap_admin"`
+	// OIDC optionally enables single sign-on through an OpenID Connect provider.
+	OIDC *oidc.Config `yaml:"oidc"`
+	// DNSBL optionally serves the exit nodes as a DNS blocklist alongside the HTTP server.
+	DNSBL *dnsblConfig `yaml:"dnsbl"`
+}
+
+// passwordHasher returns the configured password hasher, or the default, exiting if it's invalid.
+func passwordHasher(ctx context.Context, cfg *config) *auth.PasswordHasher {
+	hasher := cfg.PasswordHashing
+	if hasher == nil {
+		hasher = auth.DefaultPasswordHasher()
+	}
+	if err := hasher.Validate(); err != nil {
+		slog.ErrorContext(ctx, "Invalid password hashing configuration", "error", err)
+		os.Exit(-1)
+	}
+	return hasher
 }
 
 func makeStartCmd() *cobra.Command {
@@ -51,20 +94,104 @@ func makeStartCmd() *cobra.Command {
 		}
 		slog.InfoContext(ctx, "Configuration loaded")
 
-		db, err := psql.Load(ctx, *dbConfigPath)
+		if len(cfg.JWT.Keys) > 0 {
+			keys, err := auth.LoadKeySet(&cfg.JWT)
+			if err != nil {
+				slog.ErrorContext(ctx, "Failed to load JWT keys", "error", err)
+				os.Exit(-1)
+			}
+			auth.SetKeys(keys)
+		} else {
+			slog.WarnContext(ctx, "No JWT keys configured; using an ephemeral key, so sessions won't survive restarts or work across replicas")
+		}
+
+		roles, err := auth.LoadRoles(cfg.Roles)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load roles", "error", err)
+			os.Exit(-1)
+		}
+
+		for _, role := range cfg.RequireTOTP {
+			if !roles.Exists(role) {
+				slog.ErrorContext(ctx, "Two-factor authentication required for unknown role", "role", role)
+				os.Exit(-1)
+			}
+		}
+
+		hasher := passwordHasher(ctx, &cfg)
+
+		if cfg.Lockout != nil && cfg.Lockout.Duration <= 0 {
+			slog.ErrorContext(ctx, "Lockout duration must be positive", "duration", cfg.Lockout.Duration)
+			os.Exit(-1)
+		}
+
+		mailer, err := mail.New(&cfg.Mail)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to set up mail", "error", err)
+			os.Exit(-1)
+		}
+
+		var oidcProvider *oidc.Provider
+		if cfg.OIDC != nil {
+			for _, gr := range cfg.OIDC.GroupRoles {
+				if !roles.Exists(gr.Role) {
+					slog.ErrorContext(ctx, "OIDC group mapped to unknown role", "group", gr.Group, "role", gr.Role)
+					os.Exit(-1)
+				}
+			}
+			oidcProvider, err = oidc.New(ctx, cfg.OIDC)
+			if err != nil {
+				slog.ErrorContext(ctx, "Failed to set up OIDC", "error", err)
+				os.Exit(-1)
+			}
+		}
+
+		db, err := loadDatabase(ctx, *dbConfigPath)
 		if err != nil {
 			slog.ErrorContext(ctx, "Failed to load DB configuration", "error", err)
 			os.Exit(-1)
 		}
 		slog.InfoContext(ctx, "Database connection successful")
 
+		if cfg.BootstrapAdmin != nil {
+			if cfg.BootstrapAdmin.Role != "" && !roles.Exists(cfg.BootstrapAdmin.Role) {
+				slog.ErrorContext(ctx, "Bootstrap admin has unknown role", "role", cfg.BootstrapAdmin.Role)
+				os.Exit(-1)
+			}
+			policy := cfg.PasswordPolicy
+			if policy == nil {
+				policy = auth.DefaultPasswordPolicy()
+			}
+			if _, err := users.Bootstrap(ctx, db.Users, policy, hasher, cfg.BootstrapAdmin); err != nil {
+				slog.ErrorContext(ctx, "Failed to create bootstrap admin", "error", err, "email", cfg.BootstrapAdmin.Email)
+				os.Exit(-1)
+			}
+		}
+
+		var dnsblServer *dnsbl.Server
+		if cfg.DNSBL != nil && cfg.DNSBL.Listen != "" {
+			dnsblServer = dnsbl.New(ctx, &dnsbl.NewServerParams{
+				DB:   db,
+				Zone: cfg.DNSBL.Zone,
+				TTL:  cfg.DNSBL.TTL,
+			})
+		}
+
 		tuParams := &tor.NewTorUpdaterParams{
 			DB:           db,
 			SourceURLs:   cfg.TorSourceURLs,
 			GeoURL:       cfg.GeolocationUrl,
 			GeoBatchSize: cfg.GeolocationBatchSize,
+			PolicyURL:    cfg.TorPolicyURL,
 			Client:       http.DefaultClient,
 		}
+		if dnsblServer != nil {
+			tuParams.OnUpdate = func(ctx context.Context) {
+				if err := dnsblServer.Reload(ctx); err != nil {
+					slog.ErrorContext(ctx, "Failed to reload DNSBL", "error", err)
+				}
+			}
+		}
 
 		torUpdater := tor.NewTORUpdater(ctx, tuParams)
 
@@ -78,9 +205,18 @@ func makeStartCmd() *cobra.Command {
 		defer etcdClient.Close()
 
 		params := &server.NewServerParams{
-			DB:         db,
-			TorUpdater: torUpdater,
-			ETCD:       etcdClient,
+			DB:                   db,
+			TorUpdater:           torUpdater,
+			ETCD:                 etcdClient,
+			Roles:                roles,
+			OIDC:                 oidcProvider,
+			PasswordPolicy:       cfg.PasswordPolicy,
+			PasswordHasher:       hasher,
+			Lockout:              cfg.Lockout,
+			RequireTOTP:          cfg.RequireTOTP,
+			Mailer:               mailer,
+			PublicURL:            cfg.PublicURL,
+			RequireVerifiedEmail: cfg.RequireVerifiedEmail,
 		}
 
 		wctx, cancel := context.WithCancel(ctx)
@@ -101,14 +237,18 @@ func makeStartCmd() *cobra.Command {
 			}
 		}()
 
+		if dnsblServer != nil {
+			startDNSBL(wctx, cancel, &wg, dnsblServer, cfg.DNSBL)
+		}
+
 		go func() {
 			defer cancel()
 			sig := <-util.NewInterruptChan()
 			slog.InfoContext(ctx, "Received signal", "signal", sig)
 		}()
 
-		cancel()
 		wg.Wait()
+		closeDatabase(ctx, db)
 	}
 
 	return cmd
@@ -0,0 +1,429 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/humper/tor_exit_nodes/models"
+	"github.com/humper/tor_exit_nodes/pkg/auth"
+	"github.com/humper/tor_exit_nodes/pkg/database"
+	"github.com/humper/tor_exit_nodes/pkg/users"
+	"github.com/humper/tor_exit_nodes/pkg/util"
+	"github.com/spf13/cobra"
+)
+
+var userOutputFormats = []string{"table", "json"}
+
+type userFlags struct {
+	dbConfigPath *string
+	configPath   *string
+	output       *string
+}
+
+// userRow is what the user commands print about a user; it leaves out credentials.
+type userRow struct {
+	ID            uint      `json:"id"`
+	Email         string    `json:"email"`
+	Name          string    `json:"name"`
+	Role          string    `json:"role"`
+	Organization  uint      `json:"organization_id"`
+	EmailVerified bool      `json:"email_verified"`
+	TOTPEnabled   bool      `json:"totp_enabled"`
+	ExcludedIPs   []string  `json:"excluded_ips"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+func newUserRow(user *models.User) *userRow {
+	excluded := []string(user.AllowedIPs)
+	if excluded == nil {
+		excluded = []string{}
+	}
+	return &userRow{
+		ID:            user.ID,
+		Email:         user.Email,
+		Name:          user.Name,
+		Role:          user.Role,
+		Organization:  user.OrganizationID,
+		EmailVerified: user.EmailVerified,
+		TOTPEnabled:   user.TOTPEnabled,
+		ExcludedIPs:   excluded,
+		CreatedAt:     user.CreatedAt,
+	}
+}
+
+// userListing is the JSON output of user list.
+type userListing struct {
+	Page       int        `json:"page"`
+	Limit      int        `json:"limit"`
+	TotalRows  int64      `json:"total_rows"`
+	TotalPages int        `json:"total_pages"`
+	Rows       []*userRow `json:"rows"`
+}
+
+func writeUserTable(w io.Writer, rows []*userRow) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tEMAIL\tNAME\tROLE\tORG\tVERIFIED\t2FA\tEXCLUDED IPS")
+	for _, row := range rows {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%d\t%t\t%t\t%s\n", row.ID, row.Email, row.Name, row.Role, row.Organization, row.EmailVerified, row.TOTPEnabled, strings.Join(row.ExcludedIPs, ","))
+	}
+	return tw.Flush()
+}
+
+// printUser writes user to stdout in the chosen format.
+func printUser(ctx context.Context, flags *userFlags, user *models.User) {
+	row := newUserRow(user)
+	var err error
+	if *flags.output == "json" {
+		err = json.NewEncoder(os.Stdout).Encode(row)
+	} else {
+		err = writeUserTable(os.Stdout, []*userRow{row})
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to write output", "error", err)
+		os.Exit(-1)
+	}
+}
+
+func makeUserCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "user",
+		Short: "manage users directly in the database",
+	}
+
+	// output may be piped, so keep logs out of it
+	cmd.PersistentPreRun = func(cmd *cobra.Command, args []string) {
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, nil)))
+	}
+
+	flags := &userFlags{
+		dbConfigPath: cmd.PersistentFlags().String("db_config_path", "/app_config/db.yaml", "DB config file path"),
+		configPath:   cmd.PersistentFlags().String("config_path", "/app_config/ten.yaml", "config file path, for roles and the password policy"),
+		output:       cmd.PersistentFlags().String("output", "table", "output format: table or json"),
+	}
+
+	cmd.AddCommand(makeUserListCmd(flags))
+	cmd.AddCommand(makeUserCreateCmd(flags))
+	cmd.AddCommand(makeUserUpdateCmd(flags))
+	cmd.AddCommand(makeUserDeleteCmd(flags))
+	cmd.AddCommand(makeUserResetPasswordCmd(flags))
+
+	return cmd
+}
+
+// userCommandSetup loads what every user command needs, exiting on failure.
+func userCommandSetup(ctx context.Context, flags *userFlags) (*database.Database, *auth.Roles, *auth.PasswordPolicy, *auth.PasswordHasher) {
+	if !slices.Contains(userOutputFormats, *flags.output) {
+		slog.ErrorContext(ctx, "Unknown output format", "output", *flags.output, "available", userOutputFormats)
+		os.Exit(-1)
+	}
+
+	cfg, err := util.ReadYamlFile[config](*flags.configPath)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load configuration", "error", err)
+		os.Exit(-1)
+	}
+	roles, err := auth.LoadRoles(cfg.Roles)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load roles", "error", err)
+		os.Exit(-1)
+	}
+	policy := cfg.PasswordPolicy
+	if policy == nil {
+		policy = auth.DefaultPasswordPolicy()
+	}
+	hasher := passwordHasher(ctx, &cfg)
+
+	db, err := loadDatabase(ctx, *flags.dbConfigPath)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load DB configuration", "error", err)
+		os.Exit(-1)
+	}
+	return db, roles, policy, hasher
+}
+
+// getUser looks up the user named on the command line, exiting if there isn't one.
+func getUser(ctx context.Context, db *database.Database, email string) *models.User {
+	user, err := db.Users.GetByEmail(ctx, email)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to get user", "error", err, "email", email)
+		os.Exit(-1)
+	}
+	return user
+}
+
+// checkOrganization exits unless id is 0, for no organization, or names one that exists.
+func checkOrganization(ctx context.Context, db *database.Database, id uint) {
+	if id == 0 {
+		return
+	}
+	if _, err := db.Organizations.GetByID(ctx, id); err != nil {
+		slog.ErrorContext(ctx, "Unknown organization", "error", err, "id", id)
+		os.Exit(-1)
+	}
+}
+
+// revokeSessions logs user out everywhere after their credentials change, as the server does.
+func revokeSessions(ctx context.Context, db *database.Database, user *models.User) {
+	if err := db.Sessions.DeleteByUser(ctx, user.ID); err != nil {
+		slog.ErrorContext(ctx, "Failed to revoke sessions", "error", err, "email", user.Email)
+		os.Exit(-1)
+	}
+}
+
+// readPassword gets a password from the given environment variable or file, or else the first
+// line of stdin.
+func readPassword(env, file string) (string, error) {
+	if env != "" || file != "" {
+		return users.ReadPassword(env, file)
+	}
+	fmt.Fprint(os.Stderr, "Password: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func makeUserCreateCmd(flags *userFlags) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "create",
+		Short: "create a user with a hashed password",
+		Args:  cobra.NoArgs,
+	}
+
+	email := cmd.Flags().String("email", "", "the new user's email address")
+	name := cmd.Flags().String("name", "", "the new user's name")
+	role := cmd.Flags().String("role", auth.DefaultRole, "the new user's role")
+	orgID := cmd.Flags().Uint("organization_id", 0, "the new user's organization; 0 for none")
+	verified := cmd.Flags().Bool("verified", false, "mark the email address as already verified")
+	passwordEnv := cmd.Flags().String("password_env", "", "environment variable holding the password; read from stdin if neither this nor --password_file is given")
+	passwordFile := cmd.Flags().String("password_file", "", "file holding the password")
+
+	cmd.Run = func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+
+		if *email == "" {
+			slog.ErrorContext(ctx, "--email is required")
+			os.Exit(-1)
+		}
+
+		db, roles, policy, hasher := userCommandSetup(ctx, flags)
+		if !roles.Exists(*role) {
+			slog.ErrorContext(ctx, "Unknown role", "role", *role)
+			os.Exit(-1)
+		}
+		checkOrganization(ctx, db, *orgID)
+
+		password, err := readPassword(*passwordEnv, *passwordFile)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to read password", "error", err)
+			os.Exit(-1)
+		}
+
+		user := &models.User{
+			Name:           *name,
+			Email:          *email,
+			Role:           *role,
+			OrganizationID: *orgID,
+			EmailVerified:  *verified,
+			AllowedIPs:     []string{},
+		}
+		if err := users.Create(ctx, db.Users, policy, hasher, user, password); err != nil {
+			slog.ErrorContext(ctx, "Failed to create user", "error", err, "email", *email)
+			os.Exit(-1)
+		}
+		slog.InfoContext(ctx, "Created user", "email", *email, "role", *role)
+		printUser(ctx, flags, user)
+	}
+
+	return cmd
+}
+
+func makeUserListCmd(flags *userFlags) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "list users",
+		Args:  cobra.NoArgs,
+	}
+
+	page := cmd.Flags().Int("page", 1, "page to show")
+	limit := cmd.Flags().Int("limit", 50, "users per page")
+	sort := cmd.Flags().String("sort", "id", "column to sort by, optionally followed by desc")
+	roles := cmd.Flags().StringSlice("role", nil, "only list users with these roles")
+
+	cmd.Run = func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+		db, _, _, _ := userCommandSetup(ctx, flags)
+
+		pagination := &models.Pagination{Page: *page, Limit: *limit, Sort: *sort}
+		if len(*roles) > 0 {
+			pagination.Filter = map[string][]string{"role": *roles}
+		}
+		pagination, err := db.Users.GetAll(ctx, pagination)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to list users", "error", err)
+			os.Exit(-1)
+		}
+		found, _ := pagination.Rows.([]*models.User)
+
+		listing := &userListing{
+			Page:       pagination.GetPage(),
+			Limit:      pagination.GetLimit(),
+			TotalRows:  pagination.TotalRows,
+			TotalPages: pagination.TotalPages,
+			Rows:       []*userRow{},
+		}
+		for _, user := range found {
+			listing.Rows = append(listing.Rows, newUserRow(user))
+		}
+
+		if *flags.output == "json" {
+			err = json.NewEncoder(os.Stdout).Encode(listing)
+		} else {
+			err = writeUserTable(os.Stdout, listing.Rows)
+			fmt.Fprintf(os.Stdout, "page %d of %d, %d users\n", listing.Page, listing.TotalPages, listing.TotalRows)
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to write output", "error", err)
+			os.Exit(-1)
+		}
+	}
+
+	return cmd
+}
+
+func makeUserUpdateCmd(flags *userFlags) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "update <email>",
+		Short: "change a user's name, role, organization or excluded IPs",
+		Args:  cobra.ExactArgs(1),
+	}
+
+	name := cmd.Flags().String("name", "", "new name")
+	role := cmd.Flags().String("role", "", "new role; the user is logged out everywhere")
+	orgID := cmd.Flags().Uint("organization_id", 0, "new organization, or 0 for none; the user is logged out everywhere")
+	addIPs := cmd.Flags().StringSlice("add_excluded_ip", nil, "IP addresses to add to the user's exclusion list")
+	removeIPs := cmd.Flags().StringSlice("remove_excluded_ip", nil, "IP addresses to remove from the user's exclusion list")
+	clearIPs := cmd.Flags().Bool("clear_excluded_ips", false, "empty the user's exclusion list before adding any")
+
+	cmd.Run = func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+		db, roles, _, _ := userCommandSetup(ctx, flags)
+		user := getUser(ctx, db, args[0])
+
+		if cmd.Flags().Changed("name") {
+			user.Name = *name
+		}
+		roleChanged := cmd.Flags().Changed("role") && *role != user.Role
+		if roleChanged {
+			if !roles.Exists(*role) {
+				slog.ErrorContext(ctx, "Unknown role", "role", *role)
+				os.Exit(-1)
+			}
+			user.Role = *role
+		}
+		orgChanged := cmd.Flags().Changed("organization_id") && *orgID != user.OrganizationID
+		if orgChanged {
+			checkOrganization(ctx, db, *orgID)
+			user.OrganizationID = *orgID
+		}
+
+		for _, ip := range slices.Concat(*addIPs, *removeIPs) {
+			if net.ParseIP(ip) == nil {
+				slog.ErrorContext(ctx, "Invalid IP address", "ip", ip)
+				os.Exit(-1)
+			}
+		}
+		excluded := []string(user.AllowedIPs)
+		if *clearIPs {
+			excluded = []string{}
+		}
+		for _, ip := range *addIPs {
+			if !slices.Contains(excluded, ip) {
+				excluded = append(excluded, ip)
+			}
+		}
+		excluded = slices.DeleteFunc(excluded, func(ip string) bool {
+			return slices.Contains(*removeIPs, ip)
+		})
+		user.AllowedIPs = excluded
+
+		if err := db.Users.Update(ctx, user); err != nil {
+			slog.ErrorContext(ctx, "Failed to update user", "error", err, "email", user.Email)
+			os.Exit(-1)
+		}
+		if roleChanged || orgChanged {
+			revokeSessions(ctx, db, user)
+		}
+		slog.InfoContext(ctx, "Updated user", "email", user.Email)
+		printUser(ctx, flags, user)
+	}
+
+	return cmd
+}
+
+func makeUserDeleteCmd(flags *userFlags) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "delete <email>",
+		Short: "delete a user and log them out",
+		Args:  cobra.ExactArgs(1),
+	}
+
+	cmd.Run = func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+		db, _, _, _ := userCommandSetup(ctx, flags)
+		user := getUser(ctx, db, args[0])
+
+		if _, err := db.Users.Delete(ctx, user.ID); err != nil {
+			slog.ErrorContext(ctx, "Failed to delete user", "error", err, "email", user.Email)
+			os.Exit(-1)
+		}
+		revokeSessions(ctx, db, user)
+		slog.InfoContext(ctx, "Deleted user", "email", user.Email)
+		printUser(ctx, flags, user)
+	}
+
+	return cmd
+}
+
+func makeUserResetPasswordCmd(flags *userFlags) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "reset-password <email>",
+		Short: "set a user's password and log them out",
+		Args:  cobra.ExactArgs(1),
+	}
+
+	passwordEnv := cmd.Flags().String("password_env", "", "environment variable holding the password; read from stdin if neither this nor --password_file is given")
+	passwordFile := cmd.Flags().String("password_file", "", "file holding the password")
+
+	cmd.Run = func(cmd *cobra.Command, args []string) {
+		ctx := context.Background()
+		db, _, policy, hasher := userCommandSetup(ctx, flags)
+		user := getUser(ctx, db, args[0])
+
+		password, err := readPassword(*passwordEnv, *passwordFile)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to read password", "error", err)
+			os.Exit(-1)
+		}
+		if err := users.SetPassword(ctx, db.Users, policy, hasher, user, password); err != nil {
+			slog.ErrorContext(ctx, "Failed to set password", "error", err, "email", user.Email)
+			os.Exit(-1)
+		}
+		revokeSessions(ctx, db, user)
+		slog.InfoContext(ctx, "Reset password", "email", user.Email)
+		printUser(ctx, flags, user)
+	}
+
+	return cmd
+}
@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+	"gorm.io/gorm"
+)
+
+// APIKey lets scripts authenticate as a user without logging in.  Only a hash of the key is
+// stored; Prefix identifies the key for lookup and display.
+type APIKey struct {
+	gorm.Model
+	UserID     uint           `gorm:"not null;index" json:"user_id"`
+	Name       string         `json:"name"`
+	Prefix     string         `gorm:"unique;not null" json:"prefix"`
+	Hash       string         `gorm:"not null" json:"-"`
+	Scopes     pq.StringArray `gorm:"type:text[]" json:"scopes"`
+	ExpiresAt  *time.Time     `json:"expires_at"`
+	LastUsedAt *time.Time     `json:"last_used_at"`
+}
@@ -0,0 +1,86 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// AuditEvent records one administrative or data-changing action taken through the API.
+type AuditEvent struct {
+	gorm.Model
+	// ActorID is the user who took the action, or nil if nobody was logged in.
+	ActorID    *uint  `gorm:"index" json:"actor_id"`
+	ActorEmail string `json:"actor_email"`
+	// OrganizationID is the organization of the target, so org admins can see what happened in theirs.
+	OrganizationID uint   `gorm:"index" json:"organization_id"`
+	Action         string `gorm:"not null;index" json:"action"`
+	TargetType     string `gorm:"index" json:"target_type"`
+	TargetID       uint   `gorm:"index" json:"target_id"`
+	// Changes holds the target's fields that the action changed.
+	Changes   AuditChanges `gorm:"type:text" json:"changes"`
+	RequestID string       `gorm:"index" json:"request_id"`
+	IP        string       `json:"ip"`
+}
+
+// AuditFilterColumns are the columns audit events can be filtered on.
+var AuditFilterColumns = []string{"actor_id", "organization_id", "action", "target_type", "target_id", "request_id", "ip"}
+
+const (
+	AuditUserCreate         = "user.create"
+	AuditUserUpdate         = "user.update"
+	AuditUserDelete         = "user.delete"
+	AuditAPIKeyCreate       = "api_key.create"
+	AuditAPIKeyDelete       = "api_key.delete"
+	AuditSessionsRevoke     = "sessions.revoke"
+	AuditTOTPSetup          = "totp.setup"
+	AuditTOTPEnable         = "totp.enable"
+	AuditTOTPDisable        = "totp.disable"
+	AuditTOTPRecoveryCodes  = "totp.recovery_codes"
+	AuditPasswordChange     = "password.change"
+	AuditPasswordReset      = "password.reset"
+	AuditEmailVerify        = "email.verify"
+	AuditOrganizationCreate = "organization.create"
+	AuditOrganizationDelete = "organization.delete"
+	AuditNodesUpdate        = "nodes.update"
+)
+
+// AuditChange is a field's value before and after an action; Before is nil for creations and After
+// for deletions.
+type AuditChange struct {
+	Before any `json:"before"`
+	After  any `json:"after"`
+}
+
+// AuditChanges maps field names to how they changed.  It's stored as JSON.
+type AuditChanges map[string]AuditChange
+
+func (c AuditChanges) Value() (driver.Value, error) {
+	b, err := json.Marshal(c)
+	return string(b), err
+}
+
+func (c *AuditChanges) Scan(value any) error {
+	switch v := value.(type) {
+	case nil:
+		*c = nil
+		return nil
+	case string:
+		return json.Unmarshal([]byte(v), c)
+	case []byte:
+		return json.Unmarshal(v, c)
+	default:
+		return fmt.Errorf("can't scan %T into AuditChanges", value)
+	}
+}
+
+// GobEncode encodes the changes as JSON too, since gob can't encode the arbitrary values they hold.
+func (c AuditChanges) GobEncode() ([]byte, error) {
+	return json.Marshal(c)
+}
+
+func (c *AuditChanges) GobDecode(data []byte) error {
+	return json.Unmarshal(data, c)
+}
@@ -0,0 +1,25 @@
+package models_test
+
+import (
+	"testing"
+
+	"github.com/humper/tor_exit_nodes/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditChangesRoundTrip(t *testing.T) {
+	changes := models.AuditChanges{"Role": {Before: "user", After: "admin"}}
+	value, err := changes.Value()
+	require.NoError(t, err)
+
+	var scanned models.AuditChanges
+	require.NoError(t, scanned.Scan(value))
+	assert.Equal(t, changes, scanned)
+	require.NoError(t, scanned.Scan([]byte(value.(string))))
+	assert.Equal(t, changes, scanned)
+
+	require.NoError(t, scanned.Scan(nil))
+	assert.Nil(t, scanned)
+	assert.Error(t, scanned.Scan(42))
+}
@@ -1,8 +1,12 @@
 package models
 
-import "github.com/dgrijalva/jwt-go"
+import "github.com/golang-jwt/jwt/v4"
 
 type Claims struct {
 	jwt.StandardClaims
 	Role string `json:"role"`
+	// SessionID is the models.Session the token was issued for.
+	SessionID uint `json:"sid"`
+	// OrganizationID is the user's organization, if any.
+	OrganizationID uint `json:"org,omitempty"`
 }
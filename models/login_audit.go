@@ -0,0 +1,32 @@
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+// LoginAudit records one attempt to log in with a password.  Failed attempts are also what lockouts
+// are counted from, so they work across replicas.
+type LoginAudit struct {
+	gorm.Model
+	// UserID is the account that was logged into, or nil if the email didn't match one.
+	UserID *uint `json:"user_id"`
+	// OrganizationID is the organization of the user, if any, so org admins can see their logins.
+	OrganizationID uint   `gorm:"index" json:"organization_id"`
+	Email          string `gorm:"not null;index" json:"email"`
+	IP             string `gorm:"index" json:"ip"`
+	UserAgent      string `json:"user_agent"`
+	Success        bool   `json:"success"`
+	// Reason says why a login failed.
+	Reason string `json:"reason,omitempty"`
+}
+
+// LoginAuditFilterColumns are the columns login audits can be filtered on.
+var LoginAuditFilterColumns = []string{"user_id", "organization_id", "email", "ip", "success", "reason"}
+
+const (
+	LoginInvalidCredentials = "invalid credentials"
+	// LoginInvalidCode attempts had the right password but the wrong two-factor code.
+	LoginInvalidCode = "invalid code"
+	// LoginLocked attempts were refused without checking the password, and don't count towards lockouts.
+	LoginLocked = "locked"
+)
@@ -0,0 +1,12 @@
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+// Organization is a tenant: users only see and manage users in their own organization, unless
+// their role lets them manage organizations.
+type Organization struct {
+	gorm.Model
+	Name string `gorm:"unique;not null" json:"name"`
+}
@@ -55,3 +55,33 @@ type UserPagination struct {
 	Filter     map[string][]string `json:"filter,omitempty;query:filter"`
 	Rows       []*User             `json:"rows"`
 }
+
+type OrganizationPagination struct {
+	Limit      int                 `json:"limit,omitempty;query:limit"`
+	Page       int                 `json:"page,omitempty;query:page"`
+	Sort       string              `json:"sort,omitempty;query:sort"`
+	TotalRows  int64               `json:"total_rows"`
+	TotalPages int                 `json:"total_pages"`
+	Filter     map[string][]string `json:"filter,omitempty;query:filter"`
+	Rows       []*Organization     `json:"rows"`
+}
+
+type LoginAuditPagination struct {
+	Limit      int                 `json:"limit,omitempty;query:limit"`
+	Page       int                 `json:"page,omitempty;query:page"`
+	Sort       string              `json:"sort,omitempty;query:sort"`
+	TotalRows  int64               `json:"total_rows"`
+	TotalPages int                 `json:"total_pages"`
+	Filter     map[string][]string `json:"filter,omitempty;query:filter"`
+	Rows       []*LoginAudit       `json:"rows"`
+}
+
+type AuditEventPagination struct {
+	Limit      int                 `json:"limit,omitempty;query:limit"`
+	Page       int                 `json:"page,omitempty;query:page"`
+	Sort       string              `json:"sort,omitempty;query:sort"`
+	TotalRows  int64               `json:"total_rows"`
+	TotalPages int                 `json:"total_pages"`
+	Filter     map[string][]string `json:"filter,omitempty;query:filter"`
+	Rows       []*AuditEvent       `json:"rows"`
+}
@@ -0,0 +1,22 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Session is one login.  Access tokens name their session in the sid claim and are only accepted
+// while it exists; its refresh token, stored hashed, is replaced every time it is used.
+type Session struct {
+	gorm.Model
+	UserID      uint      `gorm:"not null;index" json:"user_id"`
+	RefreshHash string    `gorm:"unique;not null" json:"-"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	LastUsedAt  time.Time `json:"last_used_at"`
+	IP          string    `json:"ip"`
+	UserAgent   string    `json:"user_agent"`
+	// PreviousRefreshHash is the refresh token the current one replaced.  Seeing it again means the
+	// token was stolen, or the session is being refreshed twice at once, and the session is revoked.
+	PreviousRefreshHash string `gorm:"index" json:"-"`
+}
@@ -9,4 +9,11 @@ type TorExitNode struct {
 	IP          string `gorm:"unique;not null"`
 	CountryName string `json:"country_name"`
 	CountryCode string `json:"country_code"`
+	// ExitPolicy and ExitPolicyV6 summarize the ports the exit connects to over IPv4 and IPv6, in
+	// Tor's "accept 80,443" / "reject 25" form.  They're empty when the policy isn't known.
+	ExitPolicy   string `json:"exit_policy,omitempty"`
+	ExitPolicyV6 string `json:"exit_policy_v6,omitempty"`
 }
+
+// TorExitNodeFilterColumns are the columns exports can be filtered on.
+var TorExitNodeFilterColumns = []string{"country_code", "country_name"}
@@ -12,4 +12,20 @@ type User struct {
 	Password   string `gorm:"not null"`
 	Role       string
 	AllowedIPs pq.StringArray `gorm:"column:allowed_ips;type:text[]"`
+	// EmailVerified is set once the user has followed a link sent to Email.
+	EmailVerified bool
+	// TOTPSecret is set when the user starts enrolling in two-factor authentication, and
+	// TOTPEnabled once they've confirmed it with a code.
+	TOTPSecret  string `json:"-"`
+	TOTPEnabled bool
+	// TOTPLastStep is the time step of the last code used, so it can't be used again.
+	TOTPLastStep int64 `json:"-"`
+	// RecoveryCodes are the hashes of the unused recovery codes.
+	RecoveryCodes pq.StringArray `gorm:"type:text[]" json:"-"`
+
+	// OrganizationID is the models.Organization the user belongs to, or 0 for none.
+	OrganizationID uint `gorm:"index"`
+
+	// OIDCSubject is the subject of the single sign-on identity linked to the user, if any.
+	OIDCSubject string `gorm:"column:oidc_subject;index"`
 }
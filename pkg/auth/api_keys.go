@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"strings"
+
+	"github.com/humper/tor_exit_nodes/models"
+)
+
+// APIKeyPrefix marks ten API keys, so they can be told apart from JWTs and found by secret scanners.
+const APIKeyPrefix = "ten_"
+
+// DefaultScopes are granted to keys created without any.  A key's scopes are any of the
+// Permissions, and only take effect where its owner's role also grants them.
+var DefaultScopes = []string{PermissionNodesRead}
+
+// GenerateAPIKey returns a new key of the form ten_<prefix>_<secret>, the prefix it is looked up
+// by, and the hash to store.  The key itself is only ever shown to its owner once.
+func GenerateAPIKey() (key, prefix, hash string, err error) {
+	b := make([]byte, 36)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", "", err
+	}
+	prefix = hex.EncodeToString(b[:4])
+	key = APIKeyPrefix + prefix + "_" + hex.EncodeToString(b[4:])
+	return key, prefix, HashToken(key), nil
+}
+
+// ParseAPIKey returns the lookup prefix of key, or false if it isn't shaped like a ten API key.
+func ParseAPIKey(key string) (string, bool) {
+	if !strings.HasPrefix(key, APIKeyPrefix) {
+		return "", false
+	}
+	prefix, secret, ok := strings.Cut(strings.TrimPrefix(key, APIKeyPrefix), "_")
+	if !ok || len(prefix) != 8 || len(secret) != 64 {
+		return "", false
+	}
+	return prefix, true
+}
+
+// HashToken hashes an API key or refresh token for storage.  They are random and long, so a plain
+// SHA-256 is enough; a slow password hash would only slow down every request.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func CompareAPIKey(key, hash string) bool {
+	return subtle.ConstantTimeCompare([]byte(HashToken(key)), []byte(hash)) == 1
+}
+
+func NewAPIKeyContext(ctx context.Context, key *models.APIKey) context.Context {
+	return context.WithValue(ctx, "apikey", key)
+}
+
+// GetAPIKey returns the API key the request was authenticated with, or nil for session logins.
+func GetAPIKey(ctx context.Context) *models.APIKey {
+	key, ok := ctx.Value("apikey").(*models.APIKey)
+	if !ok {
+		return nil
+	}
+	return key
+}
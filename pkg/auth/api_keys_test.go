@@ -0,0 +1,40 @@
+package auth_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/humper/tor_exit_nodes/pkg/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateAPIKey(t *testing.T) {
+	key, prefix, hash, err := auth.GenerateAPIKey()
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(key, "ten_"+prefix+"_"))
+	assert.NotContains(t, hash, key)
+
+	parsed, ok := auth.ParseAPIKey(key)
+	require.True(t, ok)
+	assert.Equal(t, prefix, parsed)
+
+	assert.True(t, auth.CompareAPIKey(key, hash))
+	assert.False(t, auth.CompareAPIKey(key+"x", hash))
+
+	other, _, _, err := auth.GenerateAPIKey()
+	require.NoError(t, err)
+	assert.NotEqual(t, key, other)
+}
+
+func TestParseAPIKeyInvalid(t *testing.T) {
+	for _, key := range []string{"", "ten_", "ten_abcd_efgh", "eyJhbGciOiJIUzI1NiJ9.e30.sig"} {
+		_, ok := auth.ParseAPIKey(key)
+		assert.False(t, ok, key)
+	}
+}
+
+func TestValidatePermissions(t *testing.T) {
+	require.NoError(t, auth.ValidatePermissions([]string{"nodes:read", "users:write"}))
+	require.Error(t, auth.ValidatePermissions([]string{"nodes:write"}))
+}
@@ -0,0 +1,268 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// KeyConfig describes one JWT key.  HS256 keys take a shared secret; asymmetric keys take a PEM
+// private key file, or only a public key file for retired keys that should still verify tokens.
+type KeyConfig struct {
+	ID             string `yaml:"kid"`
+	Algorithm      string `yaml:"algorithm"`
+	Secret         string `yaml:"secret"`
+	SecretFile     string `yaml:"secret_file"`
+	PrivateKeyFile string `yaml:"private_key_file"`
+	PublicKeyFile  string `yaml:"public_key_file"`
+}
+
+type KeysConfig struct {
+	// SigningKey is the kid used to sign new tokens.  Defaults to the first key able to sign.
+	SigningKey string      `yaml:"signing_key"`
+	Keys       []KeyConfig `yaml:"keys"`
+}
+
+type key struct {
+	id        string
+	method    jwt.SigningMethod
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+// KeySet holds every key tokens may be verified with, and the one new tokens are signed with.
+// Several keys can be active at once so that signing keys can be rotated without logging everyone out.
+type KeySet struct {
+	signing *key
+	keys    map[string]*key
+}
+
+var keys *KeySet
+
+func init() {
+	var err error
+	keys, err = NewEphemeralKeySet()
+	if err != nil {
+		panic(err)
+	}
+}
+
+// SetKeys replaces the key set used by CreateJWT and ParseJWT.
+func SetKeys(ks *KeySet) {
+	keys = ks
+}
+
+// GetKeys returns the key set used by CreateJWT and ParseJWT.
+func GetKeys() *KeySet {
+	return keys
+}
+
+// NewEphemeralKeySet returns a key set with a random HS256 key.  Tokens signed with it don't survive
+// a restart and aren't accepted by other replicas.
+func NewEphemeralKeySet() (*KeySet, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	k := &key{
+		id:        "ephemeral-" + hex.EncodeToString(secret[:4]),
+		method:    jwt.SigningMethodHS256,
+		signKey:   secret,
+		verifyKey: secret,
+	}
+	return &KeySet{signing: k, keys: map[string]*key{k.id: k}}, nil
+}
+
+func LoadKeySet(cfg *KeysConfig) (*KeySet, error) {
+	ks := &KeySet{keys: map[string]*key{}}
+
+	for _, kc := range cfg.Keys {
+		if kc.ID == "" {
+			return nil, fmt.Errorf("JWT key is missing a kid")
+		}
+		if _, ok := ks.keys[kc.ID]; ok {
+			return nil, fmt.Errorf("duplicate JWT kid %q", kc.ID)
+		}
+		k, err := loadKey(&kc)
+		if err != nil {
+			return nil, fmt.Errorf("JWT key %q: %v", kc.ID, err)
+		}
+		ks.keys[k.id] = k
+
+		if ks.signing == nil && k.signKey != nil && (cfg.SigningKey == "" || cfg.SigningKey == k.id) {
+			ks.signing = k
+		}
+	}
+
+	if ks.signing == nil {
+		if cfg.SigningKey != "" {
+			return nil, fmt.Errorf("signing key %q is not configured with a secret or private key", cfg.SigningKey)
+		}
+		return nil, fmt.Errorf("no JWT key able to sign tokens is configured")
+	}
+	return ks, nil
+}
+
+func loadKey(kc *KeyConfig) (*key, error) {
+	k := &key{id: kc.ID}
+
+	switch kc.Algorithm {
+	case "HS256":
+		k.method = jwt.SigningMethodHS256
+		secret := []byte(kc.Secret)
+		if kc.SecretFile != "" {
+			data, err := os.ReadFile(kc.SecretFile)
+			if err != nil {
+				return nil, err
+			}
+			secret = []byte(strings.TrimSpace(string(data)))
+		}
+		if len(secret) < 32 {
+			return nil, fmt.Errorf("HS256 secret must be at least 32 bytes")
+		}
+		k.signKey = secret
+		k.verifyKey = secret
+		return k, nil
+	case "RS256":
+		k.method = jwt.SigningMethodRS256
+	case "ES256":
+		k.method = jwt.SigningMethodES256
+	case "EdDSA":
+		k.method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %q", kc.Algorithm)
+	}
+
+	if kc.PrivateKeyFile != "" {
+		data, err := os.ReadFile(kc.PrivateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		switch k.method {
+		case jwt.SigningMethodRS256:
+			private, err := jwt.ParseRSAPrivateKeyFromPEM(data)
+			if err != nil {
+				return nil, err
+			}
+			k.signKey, k.verifyKey = private, &private.PublicKey
+		case jwt.SigningMethodES256:
+			private, err := jwt.ParseECPrivateKeyFromPEM(data)
+			if err != nil {
+				return nil, err
+			}
+			k.signKey, k.verifyKey = private, &private.PublicKey
+		case jwt.SigningMethodEdDSA:
+			private, err := jwt.ParseEdPrivateKeyFromPEM(data)
+			if err != nil {
+				return nil, err
+			}
+			k.signKey, k.verifyKey = private, private.(ed25519.PrivateKey).Public()
+		}
+	} else if kc.PublicKeyFile != "" {
+		data, err := os.ReadFile(kc.PublicKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		switch k.method {
+		case jwt.SigningMethodRS256:
+			k.verifyKey, err = jwt.ParseRSAPublicKeyFromPEM(data)
+		case jwt.SigningMethodES256:
+			k.verifyKey, err = jwt.ParseECPublicKeyFromPEM(data)
+		case jwt.SigningMethodEdDSA:
+			k.verifyKey, err = jwt.ParseEdPublicKeyFromPEM(data)
+		}
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		return nil, fmt.Errorf("%s keys need a private_key_file or public_key_file", kc.Algorithm)
+	}
+
+	if ec, ok := k.verifyKey.(*ecdsa.PublicKey); ok && ec.Curve != elliptic.P256() {
+		return nil, fmt.Errorf("ES256 keys must use the P-256 curve")
+	}
+
+	return k, nil
+}
+
+// sign signs claims with the signing key, recording its kid in the token header.
+func (ks *KeySet) sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(ks.signing.method, claims)
+	token.Header["kid"] = ks.signing.id
+	return token.SignedString(ks.signing.signKey)
+}
+
+// keyFunc finds the verification key named by a token's kid, refusing tokens whose algorithm doesn't match it.
+func (ks *KeySet) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	k, ok := ks.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown kid %q", kid)
+	}
+	if token.Method.Alg() != k.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %q for kid %q", token.Method.Alg(), kid)
+	}
+	return k.verifyKey, nil
+}
+
+type JWK struct {
+	KeyType   string `json:"kty"`
+	ID        string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	Curve     string `json:"crv,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public halves of the asymmetric keys, so that other services can verify tokens.
+// Shared HS256 secrets are never published.
+func (ks *KeySet) JWKS() *JWKS {
+	jwks := &JWKS{Keys: []JWK{}}
+	for _, k := range ks.keys {
+		jwk := JWK{ID: k.id, Use: "sig", Algorithm: k.method.Alg()}
+		switch pub := k.verifyKey.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = b64(pub.N.Bytes())
+			jwk.E = b64(big.NewInt(int64(pub.E)).Bytes())
+		case *ecdsa.PublicKey:
+			jwk.KeyType = "EC"
+			jwk.Curve = "P-256"
+			jwk.X = b64(pub.X.FillBytes(make([]byte, 32)))
+			jwk.Y = b64(pub.Y.FillBytes(make([]byte, 32)))
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = b64(pub)
+		default:
+			continue
+		}
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+	sort.Slice(jwks.Keys, func(i, j int) bool {
+		return jwks.Keys[i].ID < jwks.Keys[j].ID
+	})
+	return jwks
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
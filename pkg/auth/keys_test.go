@@ -0,0 +1,194 @@
+package auth_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/humper/tor_exit_nodes/models"
+	"github.com/humper/tor_exit_nodes/pkg/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+const testSecret = "0123456789abcdef0123456789abcdef"
+
+func writePEM(t *testing.T, name, blockType string, der []byte) string {
+	path := filepath.Join(t.TempDir(), name)
+	err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600)
+	require.NoError(t, err)
+	return path
+}
+
+func writePrivateKey(t *testing.T, name string, key interface{}) string {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	return writePEM(t, name, "PRIVATE KEY", der)
+}
+
+func useKeys(t *testing.T, cfg *auth.KeysConfig) *auth.KeySet {
+	ks, err := auth.LoadKeySet(cfg)
+	require.NoError(t, err)
+
+	previous := auth.GetKeys()
+	auth.SetKeys(ks)
+	t.Cleanup(func() { auth.SetKeys(previous) })
+	return ks
+}
+
+func testUser() *models.User {
+	return &models.User{
+		Model: gorm.Model{ID: 7},
+		Email: "test@test.com",
+		Role:  "user",
+	}
+}
+
+func TestAlgorithms(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	for _, kc := range []auth.KeyConfig{
+		{ID: "hs", Algorithm: "HS256", Secret: testSecret},
+		{ID: "rs", Algorithm: "RS256", PrivateKeyFile: writePrivateKey(t, "rs.pem", rsaKey)},
+		{ID: "es", Algorithm: "ES256", PrivateKeyFile: writePrivateKey(t, "es.pem", ecKey)},
+		{ID: "ed", Algorithm: "EdDSA", PrivateKeyFile: writePrivateKey(t, "ed.pem", edKey)},
+	} {
+		t.Run(kc.Algorithm, func(t *testing.T) {
+			useKeys(t, &auth.KeysConfig{Keys: []auth.KeyConfig{kc}})
+
+			tokenString, _, err := auth.CreateJWT(testUser(), 1)
+			require.NoError(t, err)
+
+			token, _, err := new(jwt.Parser).ParseUnverified(tokenString, &models.Claims{})
+			require.NoError(t, err)
+			assert.Equal(t, kc.ID, token.Header["kid"])
+			assert.Equal(t, kc.Algorithm, token.Method.Alg())
+
+			claims, err := auth.ParseJWT(tokenString)
+			require.NoError(t, err)
+			assert.Equal(t, "7", claims.Id)
+		})
+	}
+}
+
+func TestRotation(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(edKey.Public())
+	require.NoError(t, err)
+	publicPath := writePEM(t, "old.pub", "PUBLIC KEY", der)
+	privatePath := writePrivateKey(t, "old.pem", edKey)
+
+	useKeys(t, &auth.KeysConfig{Keys: []auth.KeyConfig{
+		{ID: "old", Algorithm: "EdDSA", PrivateKeyFile: privatePath},
+	}})
+	oldToken, _, err := auth.CreateJWT(testUser(), 1)
+	require.NoError(t, err)
+
+	// the old key is retired to verification only and a new one signs
+	useKeys(t, &auth.KeysConfig{
+		SigningKey: "new",
+		Keys: []auth.KeyConfig{
+			{ID: "old", Algorithm: "EdDSA", PublicKeyFile: publicPath},
+			{ID: "new", Algorithm: "HS256", Secret: testSecret},
+		},
+	})
+
+	_, err = auth.ParseJWT(oldToken)
+	require.NoError(t, err, "tokens signed by a retired key should still verify")
+
+	newToken, _, err := auth.CreateJWT(testUser(), 1)
+	require.NoError(t, err)
+	token, _, err := new(jwt.Parser).ParseUnverified(newToken, &models.Claims{})
+	require.NoError(t, err)
+	assert.Equal(t, "new", token.Header["kid"])
+
+	// once the old key is removed entirely its tokens are rejected
+	useKeys(t, &auth.KeysConfig{Keys: []auth.KeyConfig{
+		{ID: "new", Algorithm: "HS256", Secret: testSecret},
+	}})
+	_, err = auth.ParseJWT(oldToken)
+	require.Error(t, err)
+}
+
+func TestAlgorithmMismatch(t *testing.T) {
+	useKeys(t, &auth.KeysConfig{Keys: []auth.KeyConfig{
+		{ID: "k", Algorithm: "HS256", Secret: testSecret},
+	}})
+
+	// same kid and secret, but a different HMAC algorithm than the key is configured for
+	token := jwt.NewWithClaims(jwt.SigningMethodHS512, &models.Claims{})
+	token.Header["kid"] = "k"
+	tokenString, err := token.SignedString([]byte(testSecret))
+	require.NoError(t, err)
+
+	_, err = auth.ParseJWT(tokenString)
+	require.Error(t, err)
+}
+
+func TestLoadKeySetErrors(t *testing.T) {
+	for name, cfg := range map[string]*auth.KeysConfig{
+		"empty":           {},
+		"missing kid":     {Keys: []auth.KeyConfig{{Algorithm: "HS256", Secret: testSecret}}},
+		"short secret":    {Keys: []auth.KeyConfig{{ID: "k", Algorithm: "HS256", Secret: "short"}}},
+		"bad algorithm":   {Keys: []auth.KeyConfig{{ID: "k", Algorithm: "none"}}},
+		"no key file":     {Keys: []auth.KeyConfig{{ID: "k", Algorithm: "RS256"}}},
+		"unknown signer":  {SigningKey: "other", Keys: []auth.KeyConfig{{ID: "k", Algorithm: "HS256", Secret: testSecret}}},
+		"duplicate kid":   {Keys: []auth.KeyConfig{{ID: "k", Algorithm: "HS256", Secret: testSecret}, {ID: "k", Algorithm: "HS256", Secret: testSecret}}},
+		"missing keyfile": {Keys: []auth.KeyConfig{{ID: "k", Algorithm: "ES256", PrivateKeyFile: "/does/not/exist"}}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := auth.LoadKeySet(cfg)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	ks := useKeys(t, &auth.KeysConfig{Keys: []auth.KeyConfig{
+		{ID: "a-hs", Algorithm: "HS256", Secret: testSecret},
+		{ID: "b-rs", Algorithm: "RS256", PrivateKeyFile: writePrivateKey(t, "rs.pem", rsaKey)},
+		{ID: "c-es", Algorithm: "ES256", PrivateKeyFile: writePrivateKey(t, "es.pem", ecKey)},
+		{ID: "d-ed", Algorithm: "EdDSA", PrivateKeyFile: writePrivateKey(t, "ed.pem", edKey)},
+	}})
+
+	jwks := ks.JWKS()
+	require.Len(t, jwks.Keys, 3, "shared secrets must not be published")
+
+	assert.Equal(t, "b-rs", jwks.Keys[0].ID)
+	assert.Equal(t, "RSA", jwks.Keys[0].KeyType)
+	assert.Equal(t, "AQAB", jwks.Keys[0].E)
+	assert.NotEmpty(t, jwks.Keys[0].N)
+
+	assert.Equal(t, "c-es", jwks.Keys[1].ID)
+	assert.Equal(t, "EC", jwks.Keys[1].KeyType)
+	assert.Equal(t, "P-256", jwks.Keys[1].Curve)
+	assert.NotEmpty(t, jwks.Keys[1].X)
+	assert.NotEmpty(t, jwks.Keys[1].Y)
+
+	assert.Equal(t, "d-ed", jwks.Keys[2].ID)
+	assert.Equal(t, "OKP", jwks.Keys[2].KeyType)
+	assert.Equal(t, "Ed25519", jwks.Keys[2].Curve)
+	assert.Equal(t, "EdDSA", jwks.Keys[2].Algorithm)
+}
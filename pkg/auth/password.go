@@ -0,0 +1,247 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// maxPasswordLength is the most bcrypt will hash.  It applies whichever algorithm is configured,
+// so that switching back to bcrypt never locks anyone out.
+const maxPasswordLength = 72
+
+// PasswordPolicy is the complexity a new password must meet.
+type PasswordPolicy struct {
+	MinLength     int  `yaml:"min_length"`
+	RequireUpper  bool `yaml:"require_upper"`
+	RequireLower  bool `yaml:"require_lower"`
+	RequireDigit  bool `yaml:"require_digit"`
+	RequireSymbol bool `yaml:"require_symbol"`
+}
+
+// DefaultPasswordPolicy is used when no policy is configured.
+func DefaultPasswordPolicy() *PasswordPolicy {
+	return &PasswordPolicy{MinLength: 8}
+}
+
+// Check returns an error, suitable for showing the user, if password doesn't meet the policy.
+func (p *PasswordPolicy) Check(password string) error {
+	if len(password) < p.MinLength {
+		return fmt.Errorf("password must be at least %d characters", p.MinLength)
+	}
+	if len(password) > maxPasswordLength {
+		return fmt.Errorf("password must be at most %d bytes", maxPasswordLength)
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			symbol = true
+		}
+	}
+	switch {
+	case p.RequireUpper && !upper:
+		return errors.New("password must contain an upper case letter")
+	case p.RequireLower && !lower:
+		return errors.New("password must contain a lower case letter")
+	case p.RequireDigit && !digit:
+		return errors.New("password must contain a digit")
+	case p.RequireSymbol && !symbol:
+		return errors.New("password must contain a symbol")
+	}
+	return nil
+}
+
+// LockoutConfig limits failed logins.  Once an account or IP address has failed MaxAccountFailures
+// or MaxIPFailures times within Duration, further attempts are refused until the oldest of them is
+// Duration old.  A successful login clears the account's count.
+type LockoutConfig struct {
+	MaxAccountFailures int           `yaml:"max_account_failures"`
+	MaxIPFailures      int           `yaml:"max_ip_failures"`
+	Duration           time.Duration `yaml:"duration"`
+}
+
+// DefaultLockoutConfig is used when no lockout is configured.
+func DefaultLockoutConfig() *LockoutConfig {
+	return &LockoutConfig{
+		MaxAccountFailures: 5,
+		MaxIPFailures:      20,
+		Duration:           15 * time.Minute,
+	}
+}
+
+// Password hashing algorithms.  The algorithm is recorded in each hash, so hashes made with any of
+// them can be checked whichever is configured.
+const (
+	HashArgon2id = "argon2id"
+	HashBcrypt   = "bcrypt"
+)
+
+// PasswordHasher hashes new passwords.  Zero parameters take their defaults: argon2id with the
+// OWASP recommended 19 MiB of memory, 2 iterations and 1 thread, or bcrypt with cost 12.
+type PasswordHasher struct {
+	Algorithm  string         `yaml:"algorithm"`
+	BcryptCost int            `yaml:"bcrypt_cost"`
+	Argon2id   Argon2idParams `yaml:"argon2id"`
+
+	dummyHash     string
+	dummyHashOnce sync.Once
+}
+
+// Argon2idParams tune argon2id.  Memory is in KiB.
+type Argon2idParams struct {
+	Memory      uint32 `yaml:"memory"`
+	Iterations  uint32 `yaml:"iterations"`
+	Parallelism uint8  `yaml:"parallelism"`
+}
+
+const (
+	defaultBcryptCost = 12
+
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+var defaultArgon2id = Argon2idParams{Memory: 19 * 1024, Iterations: 2, Parallelism: 1}
+
+// DefaultPasswordHasher is used when no hashing is configured.
+func DefaultPasswordHasher() *PasswordHasher {
+	return &PasswordHasher{Algorithm: HashArgon2id}
+}
+
+// Validate returns an error if h can't hash passwords.
+func (h *PasswordHasher) Validate() error {
+	switch h.algorithm() {
+	case HashArgon2id:
+		return nil
+	case HashBcrypt:
+		if cost := h.bcryptCost(); cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+			return fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown password hashing algorithm %q", h.Algorithm)
+	}
+}
+
+func (h *PasswordHasher) algorithm() string {
+	if h.Algorithm == "" {
+		return HashArgon2id
+	}
+	return h.Algorithm
+}
+
+func (h *PasswordHasher) bcryptCost() int {
+	if h.BcryptCost == 0 {
+		return defaultBcryptCost
+	}
+	return h.BcryptCost
+}
+
+func (h *PasswordHasher) argon2id() Argon2idParams {
+	params := h.Argon2id
+	if params.Memory == 0 {
+		params.Memory = defaultArgon2id.Memory
+	}
+	if params.Iterations == 0 {
+		params.Iterations = defaultArgon2id.Iterations
+	}
+	if params.Parallelism == 0 {
+		params.Parallelism = defaultArgon2id.Parallelism
+	}
+	return params
+}
+
+// Hash hashes password with the configured algorithm.
+func (h *PasswordHasher) Hash(password string) (string, error) {
+	if h.algorithm() == HashBcrypt {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), h.bcryptCost())
+		return string(hash), err
+	}
+
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	params := h.argon2id()
+	key := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, argon2KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, params.Memory, params.Iterations, params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// NeedsRehash reports whether hash was made with another algorithm or other parameters than h
+// would use, so it should be replaced next time the password is known.
+func (h *PasswordHasher) NeedsRehash(hash string) bool {
+	if hash == "" {
+		return false
+	}
+	if params, _, _, err := parseArgon2id(hash); err == nil {
+		return h.algorithm() != HashArgon2id || params != h.argon2id()
+	}
+	cost, err := bcrypt.Cost([]byte(hash))
+	if err != nil {
+		return true
+	}
+	return h.algorithm() != HashBcrypt || cost != h.bcryptCost()
+}
+
+// CompareDummy takes as long as checking a password against one of h's hashes but always fails, so
+// that logins to unknown users can't be told apart by how long they take.
+func (h *PasswordHasher) CompareDummy(password string) bool {
+	h.dummyHashOnce.Do(func() {
+		h.dummyHash, _ = h.Hash("not a real password")
+	})
+	ComparePassword(password, h.dummyHash)
+	return false
+}
+
+// ComparePassword reports whether password matches hash, made by any of the supported algorithms.
+func ComparePassword(password, hash string) bool {
+	if strings.HasPrefix(hash, "$argon2id$") {
+		params, salt, key, err := parseArgon2id(hash)
+		if err != nil {
+			return false
+		}
+		other := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+		return subtle.ConstantTimeCompare(key, other) == 1
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// parseArgon2id splits a hash in the PHC string format, $argon2id$v=19$m=..,t=..,p=..$salt$key.
+func parseArgon2id(hash string) (params Argon2idParams, salt, key []byte, err error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, errors.New("not an argon2id hash")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("unsupported argon2 version %q", parts[2])
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return params, nil, nil, err
+	}
+	if salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return params, nil, nil, err
+	}
+	if key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(key) == 0 {
+		return params, nil, nil, errors.New("invalid argon2id key")
+	}
+	return params, salt, key, nil
+}
@@ -0,0 +1,77 @@
+package auth_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/humper/tor_exit_nodes/pkg/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPasswordPolicy(t *testing.T) {
+	strict := &auth.PasswordPolicy{
+		MinLength:     10,
+		RequireUpper:  true,
+		RequireLower:  true,
+		RequireDigit:  true,
+		RequireSymbol: true,
+	}
+
+	tests := []struct {
+		policy   *auth.PasswordPolicy
+		password string
+		err      string
+	}{
+		{auth.DefaultPasswordPolicy(), "password", ""},
+		{auth.DefaultPasswordPolicy(), "short", "at least 8"},
+		{auth.DefaultPasswordPolicy(), strings.Repeat("a", 73), "at most 72"},
+		{strict, "Passw0rd!!", ""},
+		{strict, "Passw0rd!", "at least 10"},
+		{strict, "passw0rd!!", "upper case"},
+		{strict, "PASSW0RD!!", "lower case"},
+		{strict, "Password!!", "digit"},
+		{strict, "Passw0rd00", "symbol"},
+	}
+	for _, test := range tests {
+		err := test.policy.Check(test.password)
+		if test.err == "" {
+			assert.NoError(t, err, test.password)
+		} else if assert.Error(t, err, test.password) {
+			assert.Contains(t, err.Error(), test.err, test.password)
+		}
+	}
+}
+
+func TestPasswordHasher(t *testing.T) {
+	argon2id := &auth.PasswordHasher{Algorithm: auth.HashArgon2id, Argon2id: auth.Argon2idParams{Memory: 1024, Iterations: 1}}
+	bcrypt := &auth.PasswordHasher{Algorithm: auth.HashBcrypt, BcryptCost: 4}
+
+	for _, hasher := range []*auth.PasswordHasher{argon2id, bcrypt} {
+		require.NoError(t, hasher.Validate())
+		hash, err := hasher.Hash("password")
+		require.NoError(t, err, hasher.Algorithm)
+		assert.NotContains(t, hash, "password")
+		assert.True(t, auth.ComparePassword("password", hash), hasher.Algorithm)
+		assert.False(t, auth.ComparePassword("wrong_password", hash), hasher.Algorithm)
+		assert.False(t, hasher.NeedsRehash(hash), hasher.Algorithm)
+		assert.False(t, hasher.CompareDummy("password"))
+	}
+
+	hash, err := argon2id.Hash("password")
+	require.NoError(t, err)
+	assert.Regexp(t, `^\$argon2id\$v=19\$m=1024,t=1,p=1\$`, hash)
+	assert.True(t, bcrypt.NeedsRehash(hash), "other algorithm")
+	assert.True(t, auth.DefaultPasswordHasher().NeedsRehash(hash), "other parameters")
+
+	hash, err = bcrypt.Hash("password")
+	require.NoError(t, err)
+	assert.True(t, argon2id.NeedsRehash(hash), "other algorithm")
+	assert.True(t, (&auth.PasswordHasher{Algorithm: auth.HashBcrypt, BcryptCost: 5}).NeedsRehash(hash), "other cost")
+
+	assert.False(t, auth.ComparePassword("", ""), "users without a password can't log in with one")
+	assert.False(t, argon2id.NeedsRehash(""))
+	assert.Error(t, (&auth.PasswordHasher{Algorithm: "md5"}).Validate())
+	assert.Error(t, (&auth.PasswordHasher{Algorithm: auth.HashBcrypt, BcryptCost: 40}).Validate())
+	assert.NoError(t, (&auth.PasswordHasher{}).Validate(), "everything defaults")
+}
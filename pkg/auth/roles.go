@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"slices"
+)
+
+const (
+	PermissionUsersRead    = "users:read"
+	PermissionUsersWrite   = "users:write"
+	PermissionNodesRead    = "nodes:read"
+	PermissionAdminUpdates = "admin:updates"
+	PermissionAuditRead    = "audit:read"
+	// PermissionOrgsManage manages organizations, and lifts the restriction of the other
+	// permissions to the caller's own organization.
+	PermissionOrgsManage = "orgs:manage"
+
+	// AnonymousRole holds the permissions of requests that aren't logged in.
+	AnonymousRole = "anonymous"
+	// DefaultRole is given to users who register themselves.
+	DefaultRole = "user"
+	// OrgAdminRole administers one organization.
+	OrgAdminRole = "org_admin"
+)
+
+// Permissions lists every permission a role or API key can be granted.
+var Permissions = []string{PermissionUsersRead, PermissionUsersWrite, PermissionNodesRead, PermissionAdminUpdates, PermissionAuditRead, PermissionOrgsManage}
+
+// RolesConfig maps role names to the permissions they grant.
+type RolesConfig map[string][]string
+
+// DefaultRolesConfig is used when no roles are configured.
+func DefaultRolesConfig() RolesConfig {
+	return RolesConfig{
+		"admin":       slices.Clone(Permissions),
+		OrgAdminRole:  {PermissionUsersRead, PermissionUsersWrite, PermissionNodesRead, PermissionAuditRead},
+		DefaultRole:   {PermissionNodesRead},
+		AnonymousRole: {PermissionNodesRead},
+	}
+}
+
+type Roles struct {
+	permissions map[string][]string
+}
+
+func DefaultRoles() *Roles {
+	roles, err := LoadRoles(DefaultRolesConfig())
+	if err != nil {
+		panic(err)
+	}
+	return roles
+}
+
+// LoadRoles checks that every permission in cfg is known.  An empty config gives the defaults.
+func LoadRoles(cfg RolesConfig) (*Roles, error) {
+	if len(cfg) == 0 {
+		cfg = DefaultRolesConfig()
+	}
+	roles := &Roles{permissions: map[string][]string{}}
+	for role, permissions := range cfg {
+		if err := ValidatePermissions(permissions); err != nil {
+			return nil, fmt.Errorf("role %q: %v", role, err)
+		}
+		roles.permissions[role] = slices.Clone(permissions)
+	}
+	if _, ok := roles.permissions[DefaultRole]; !ok {
+		return nil, fmt.Errorf("the %q role must be configured", DefaultRole)
+	}
+	return roles, nil
+}
+
+// ValidatePermissions returns an error naming the first permission that isn't known.
+func ValidatePermissions(permissions []string) error {
+	for _, permission := range permissions {
+		if !slices.Contains(Permissions, permission) {
+			return fmt.Errorf("unknown permission %q", permission)
+		}
+	}
+	return nil
+}
+
+// Exists reports whether role can be assigned to users.
+func (r *Roles) Exists(role string) bool {
+	_, ok := r.permissions[role]
+	return ok && role != AnonymousRole
+}
+
+func (r *Roles) Has(role string, permission string) bool {
+	return slices.Contains(r.permissions[role], permission)
+}
+
+// Allowed reports whether the request in ctx has permission: its user's role must grant it, and
+// if the request was made with an API key, the key's scopes must include it as well.
+func (r *Roles) Allowed(ctx context.Context, permission string) bool {
+	role := AnonymousRole
+	if user := GetUser(ctx); user != nil {
+		role = user.Role
+	}
+	return r.Has(role, permission) && KeyAllows(ctx, permission)
+}
+
+// Grants reports whether the caller in ctx holds every permission role would give, and so may
+// give it to someone else.
+func (r *Roles) Grants(ctx context.Context, role string) bool {
+	for _, permission := range r.permissions[role] {
+		if !r.Allowed(ctx, permission) {
+			return false
+		}
+	}
+	return true
+}
+
+// KeyAllows reports whether the API key the request was made with, if any, is scoped to permission.
+func KeyAllows(ctx context.Context, permission string) bool {
+	key := GetAPIKey(ctx)
+	return key == nil || slices.Contains(key.Scopes, permission)
+}
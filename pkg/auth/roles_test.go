@@ -0,0 +1,38 @@
+package auth_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/humper/tor_exit_nodes/models"
+	"github.com/humper/tor_exit_nodes/pkg/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadRoles(t *testing.T) {
+	roles, err := auth.LoadRoles(nil)
+	require.NoError(t, err)
+	assert.True(t, roles.Has("admin", auth.PermissionAdminUpdates))
+	assert.True(t, roles.Has("user", auth.PermissionNodesRead))
+	assert.False(t, roles.Has("user", auth.PermissionUsersRead))
+	assert.True(t, roles.Exists("user"))
+	assert.False(t, roles.Exists(auth.AnonymousRole))
+
+	_, err = auth.LoadRoles(auth.RolesConfig{"user": {"nodes:write"}})
+	require.Error(t, err)
+	_, err = auth.LoadRoles(auth.RolesConfig{"admin": {"nodes:read"}})
+	require.Error(t, err, "the default role must exist")
+}
+
+func TestGrants(t *testing.T) {
+	roles := auth.DefaultRoles()
+	orgAdmin := auth.NewContext(context.Background(), &models.User{Role: auth.OrgAdminRole})
+	admin := auth.NewContext(context.Background(), &models.User{Role: "admin"})
+
+	assert.True(t, roles.Grants(orgAdmin, auth.DefaultRole))
+	assert.True(t, roles.Grants(orgAdmin, auth.OrgAdminRole))
+	assert.False(t, roles.Grants(orgAdmin, "admin"))
+	assert.True(t, roles.Grants(admin, "admin"))
+	assert.False(t, roles.Grants(context.Background(), auth.OrgAdminRole))
+}
@@ -0,0 +1,79 @@
+package auth_test
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/humper/tor_exit_nodes/pkg/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// the RFC 6238 test key, "12345678901234567890"
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	// the RFC's SHA1 vectors, truncated to six digits
+	vectors := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1111111111: "050471",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for unix, want := range vectors {
+		code, err := auth.TOTPCode(rfcSecret, time.Unix(unix, 0))
+		require.NoError(t, err)
+		assert.Equal(t, want, code, unix)
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret, err := auth.GenerateTOTPSecret()
+	require.NoError(t, err)
+	now := time.Now()
+
+	code, err := auth.TOTPCode(secret, now)
+	require.NoError(t, err)
+	step, ok := auth.ValidateTOTP(secret, code, now, 0)
+	require.True(t, ok)
+
+	_, ok = auth.ValidateTOTP(secret, code, now, step)
+	assert.False(t, ok, "codes can't be replayed")
+
+	late, err := auth.TOTPCode(secret, now.Add(-30*time.Second))
+	require.NoError(t, err)
+	_, ok = auth.ValidateTOTP(secret, late, now, 0)
+	assert.True(t, ok, "the previous code is accepted for clock drift")
+
+	stale, err := auth.TOTPCode(secret, now.Add(-2*time.Minute))
+	require.NoError(t, err)
+	_, ok = auth.ValidateTOTP(secret, stale, now, 0)
+	assert.False(t, ok)
+
+	_, ok = auth.ValidateTOTP("not base32!", code, now, 0)
+	assert.False(t, ok)
+}
+
+func TestTOTPURI(t *testing.T) {
+	u, err := url.Parse(auth.TOTPURI(rfcSecret, "test@test.com"))
+	require.NoError(t, err)
+	assert.Equal(t, "otpauth", u.Scheme)
+	assert.Equal(t, "totp", u.Host)
+	assert.Equal(t, "/ten:test@test.com", u.Path)
+	assert.Equal(t, rfcSecret, u.Query().Get("secret"))
+	assert.Equal(t, "ten", u.Query().Get("issuer"))
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, hashes, err := auth.GenerateRecoveryCodes()
+	require.NoError(t, err)
+	require.Len(t, codes, auth.RecoveryCodeCount)
+	require.Len(t, hashes, auth.RecoveryCodeCount)
+
+	assert.Equal(t, 3, auth.MatchRecoveryCode(codes[3], hashes))
+	assert.Equal(t, 3, auth.MatchRecoveryCode(strings.ToUpper(strings.ReplaceAll(codes[3], "-", "")), hashes))
+	assert.Equal(t, -1, auth.MatchRecoveryCode("00000-00000", hashes))
+}
@@ -2,32 +2,30 @@ package auth
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"time"
 
-	"github.com/dgrijalva/jwt-go"
+	"github.com/golang-jwt/jwt/v4"
 	"github.com/humper/tor_exit_nodes/models"
-	"golang.org/x/crypto/bcrypt"
 )
 
-// LOL don't put secrets in code
-var jwtKey = []byte("super_secret_key")
-
-func ComparePassword(password, hash string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-	return err == nil
-}
-
-func HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
-	return string(bytes), err
-}
+const (
+	// AccessTokenTTL is how long a session token is accepted before it must be refreshed.
+	AccessTokenTTL = 15 * time.Minute
+	// RefreshTokenTTL is how long a session lasts without being refreshed.
+	RefreshTokenTTL = 30 * 24 * time.Hour
+)
 
-func CreateJWT(user *models.User) (string, time.Time, error) {
+// CreateJWT creates a short-lived access token for user's session.
+func CreateJWT(user *models.User, sessionID uint) (string, time.Time, error) {
 
-	expirationTime := time.Now().Add(24 * time.Hour)
+	expirationTime := time.Now().Add(AccessTokenTTL)
 	claims := &models.Claims{
-		Role: user.Role,
+		Role:           user.Role,
+		SessionID:      sessionID,
+		OrganizationID: user.OrganizationID,
 		StandardClaims: jwt.StandardClaims{
 			ExpiresAt: expirationTime.Unix(),
 			Issuer:    "ten",
@@ -36,15 +34,27 @@ func CreateJWT(user *models.User) (string, time.Time, error) {
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	ss, err := token.SignedString(jwtKey)
+	ss, err := keys.sign(claims)
 	return ss, expirationTime, err
 }
 
+// GenerateRefreshToken returns a new refresh token and the hash to store for it.
+func GenerateRefreshToken() (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = hex.EncodeToString(b)
+	return token, HashToken(token), nil
+}
+
+// Fingerprint summarizes s for embedding in a token, so that the token stops working when s changes.
+func Fingerprint(s string) string {
+	return HashToken(s)[:16]
+}
+
 func ParseJWT(tokenString string) (claims *models.Claims, err error) {
-	token, err := jwt.ParseWithClaims(tokenString, &models.Claims{}, func(token *jwt.Token) (interface{}, error) {
-		return jwtKey, nil
-	})
+	token, err := jwt.ParseWithClaims(tokenString, &models.Claims{}, keys.keyFunc)
 
 	if err != nil {
 		return nil, err
@@ -53,12 +63,44 @@ func ParseJWT(tokenString string) (claims *models.Claims, err error) {
 	claims, ok := token.Claims.(*models.Claims)
 
 	if !ok {
-		return nil, err
+		return nil, fmt.Errorf("unexpected claims type %T", token.Claims)
+	}
+
+	// tokens minted for another purpose must never be accepted as sessions
+	if claims.Audience != "" {
+		return nil, fmt.Errorf("token is for %q, not a session", claims.Audience)
 	}
 
 	return claims, nil
 }
 
+// PurposeClaims are claims embedding jwt.StandardClaims, whose audience names what the token is for.
+type PurposeClaims interface {
+	jwt.Claims
+	VerifyAudience(cmp string, req bool) bool
+}
+
+// SignToken signs a token for something other than a session, such as OIDC login state.  The
+// claims' audience must name its purpose, which ParseToken checks, so that tokens can't be replayed
+// for something else.
+func SignToken(claims PurposeClaims) (string, error) {
+	if claims.VerifyAudience("", false) { // true only when there is no audience
+		return "", fmt.Errorf("purpose tokens need an audience")
+	}
+	return keys.sign(claims)
+}
+
+// ParseToken verifies a token created by SignToken for purpose, filling in claims.
+func ParseToken(tokenString string, purpose string, claims PurposeClaims) error {
+	if _, err := jwt.ParseWithClaims(tokenString, claims, keys.keyFunc); err != nil {
+		return err
+	}
+	if !claims.VerifyAudience(purpose, true) {
+		return fmt.Errorf("token is not for %s", purpose)
+	}
+	return nil
+}
+
 func NewContext(ctx context.Context, user *models.User) context.Context {
 	return context.WithValue(ctx, "user", user)
 }
@@ -70,3 +112,16 @@ func GetUser(ctx context.Context) *models.User {
 	}
 	return user
 }
+
+func NewSessionContext(ctx context.Context, session *models.Session) context.Context {
+	return context.WithValue(ctx, "session", session)
+}
+
+// GetSession returns the session the request was made in, or nil for API keys and anonymous requests.
+func GetSession(ctx context.Context) *models.Session {
+	session, ok := ctx.Value("session").(*models.Session)
+	if !ok {
+		return nil
+	}
+	return session
+}
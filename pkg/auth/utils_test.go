@@ -4,7 +4,9 @@ import (
 	"context"
 	"fmt"
 	"testing"
+	"time"
 
+	"github.com/golang-jwt/jwt/v4"
 	"github.com/humper/tor_exit_nodes/models"
 	"github.com/humper/tor_exit_nodes/pkg/auth"
 	"github.com/stretchr/testify/assert"
@@ -12,22 +14,6 @@ import (
 	"gorm.io/gorm"
 )
 
-func TestComparePassword(t *testing.T) {
-	password := "password"
-	hash, _ := auth.HashPassword(password)
-
-	assert.True(t, auth.ComparePassword(password, hash))
-	assert.False(t, auth.ComparePassword("wrong_password", hash))
-}
-
-func TestHashPassword(t *testing.T) {
-	password := "password"
-	hash, err := auth.HashPassword(password)
-
-	require.NoError(t, err, "Error hashing password")
-	assert.NotEqual(t, password, hash, "HashPassword did not hash the password")
-}
-
 func TestCreateJWT(t *testing.T) {
 	user := &models.User{
 		Model: gorm.Model{
@@ -36,7 +22,7 @@ func TestCreateJWT(t *testing.T) {
 		Email: "test@test.com",
 	}
 
-	tokenString, _, err := auth.CreateJWT(user)
+	tokenString, _, err := auth.CreateJWT(user, 5)
 	require.NoError(t, err, "Error creating JWT")
 	assert.NotEmpty(t, tokenString, "CreateJWT did not generate a token")
 }
@@ -46,15 +32,18 @@ func TestParseJWT(t *testing.T) {
 		Model: gorm.Model{
 			ID: 1,
 		},
-		Email: "test@test.com",
+		Email:          "test@test.com",
+		OrganizationID: 3,
 	}
 
-	tokenString, _, _ := auth.CreateJWT(user)
+	tokenString, _, _ := auth.CreateJWT(user, 5)
 	claims, err := auth.ParseJWT(tokenString)
 
 	require.NoError(t, err, "Error parsing JWT")
 	assert.Equal(t, user.Email, claims.StandardClaims.Subject, "ParseJWT did not parse the correct username")
 	assert.Equal(t, fmt.Sprintf("%d", user.ID), claims.StandardClaims.Id, "ParseJWT did not parse the correct user ID")
+	assert.Equal(t, uint(5), claims.SessionID, "ParseJWT did not parse the session ID")
+	assert.Equal(t, uint(3), claims.OrganizationID, "ParseJWT did not parse the organization ID")
 }
 
 func TestParseJWTBadToken(t *testing.T) {
@@ -77,3 +66,18 @@ func TestNewContext(t *testing.T) {
 	require.NotNil(t, result, "NewContext did not set the user in the context")
 	assert.Equal(t, user.ID, result.ID, "NewContext did not set the correct user in the context")
 }
+
+func TestPurposeTokens(t *testing.T) {
+	claims := &jwt.StandardClaims{Audience: "reset", ExpiresAt: time.Now().Add(time.Minute).Unix()}
+	token, err := auth.SignToken(claims)
+	require.NoError(t, err)
+
+	require.NoError(t, auth.ParseToken(token, "reset", &jwt.StandardClaims{}))
+	require.Error(t, auth.ParseToken(token, "verify", &jwt.StandardClaims{}))
+
+	_, err = auth.ParseJWT(token)
+	require.Error(t, err, "purpose tokens must not work as sessions")
+
+	_, err = auth.SignToken(&jwt.StandardClaims{})
+	require.Error(t, err, "purpose tokens need an audience")
+}
@@ -0,0 +1,17 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/humper/tor_exit_nodes/models"
+)
+
+type APIKeys interface {
+	Create(ctx context.Context, key *models.APIKey) error
+	GetByPrefix(ctx context.Context, prefix string) (*models.APIKey, error)
+	GetByUser(ctx context.Context, userID uint) ([]*models.APIKey, error)
+	// Delete revokes the key with the given ID, if it belongs to userID.
+	Delete(ctx context.Context, userID uint, id uint) error
+	Touch(ctx context.Context, id uint, lastUsed time.Time) error
+}
@@ -0,0 +1,16 @@
+package database
+
+import (
+	"context"
+
+	"github.com/humper/tor_exit_nodes/models"
+)
+
+type AuditLog interface {
+	Create(ctx context.Context, event *models.AuditEvent) error
+	// GetAll lists events, newest first by default.  The pagination's filter may only name
+	// models.AuditFilterColumns.
+	GetAll(ctx context.Context, pagination *models.Pagination) (*models.Pagination, error)
+	// GetAllByOrganization is GetAll restricted to events in an organization.
+	GetAllByOrganization(ctx context.Context, orgID uint, pagination *models.Pagination) (*models.Pagination, error)
+}
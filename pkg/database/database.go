@@ -1,6 +1,23 @@
 package database
 
+import "io"
+
 type Database struct {
-	Users        Users
-	TorExitNodes TorExitNodes
+	Users         Users
+	TorExitNodes  TorExitNodes
+	APIKeys       APIKeys
+	Sessions      Sessions
+	LoginAudits   LoginAudits
+	Organizations Organizations
+	AuditLog      AuditLog
+	// Closer, if set, releases the backend's connections and flushes anything it hasn't saved.
+	Closer io.Closer
+}
+
+// Close closes the backend, if it needs closing.  The database can't be used afterwards.
+func (d *Database) Close() error {
+	if d.Closer == nil {
+		return nil
+	}
+	return d.Closer.Close()
 }
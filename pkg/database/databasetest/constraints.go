@@ -0,0 +1,114 @@
+package databasetest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/humper/tor_exit_nodes/models"
+	"github.com/humper/tor_exit_nodes/pkg/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testUniqueEmail checks that two users can't share an email address, even once one is deleted.
+func testUniqueEmail(t *testing.T, db *database.Database) {
+	users := createUsers(t, db, 2)
+
+	duplicate := makeTestUser()
+	duplicate.Email = users[0].Email
+	assert.ErrorIs(t, db.Users.Create(ctx, duplicate), database.ErrConflict, "Create")
+
+	user, err := db.Users.GetByID(ctx, users[1].ID)
+	require.NoError(t, err)
+	user.Email = users[0].Email
+	assert.ErrorIs(t, db.Users.Update(ctx, user), database.ErrConflict, "Update")
+	unchanged, err := db.Users.GetByID(ctx, users[1].ID)
+	require.NoError(t, err)
+	assert.Equal(t, users[1].Email, unchanged.Email)
+
+	_, err = db.Users.Delete(ctx, users[0].ID)
+	require.NoError(t, err)
+	duplicate = makeTestUser()
+	duplicate.Email = users[0].Email
+	assert.ErrorIs(t, db.Users.Create(ctx, duplicate), database.ErrConflict, "Deleted users keep their address")
+}
+
+// testUniqueIP checks that adding a node that's already present changes nothing.
+func testUniqueIP(t *testing.T, db *database.Database) {
+	nodes := addNodes(t, db)
+
+	err := db.TorExitNodes.DeleteAndAdd(ctx, []models.TorExitNode{*nodes[0]}, []*models.TorExitNode{{IP: nodes[1].IP}})
+	assert.ErrorIs(t, err, database.ErrConflict)
+	page, err := db.TorExitNodes.GetAll(ctx, nil, &models.Pagination{Sort: "ip asc"})
+	require.NoError(t, err)
+	assert.Equal(t, nodeIPs(nodes), ips(page), "Nodes were deleted")
+}
+
+// testUniqueOrganizationName checks that two organizations can't share a name, even once one is
+// deleted.
+func testUniqueOrganizationName(t *testing.T, db *database.Database) {
+	org := &models.Organization{Name: "org"}
+	require.NoError(t, db.Organizations.Create(ctx, org))
+	assert.ErrorIs(t, db.Organizations.Create(ctx, &models.Organization{Name: "org"}), database.ErrConflict)
+
+	require.NoError(t, db.Organizations.Delete(ctx, org.ID))
+	assert.ErrorIs(t, db.Organizations.Create(ctx, &models.Organization{Name: "org"}), database.ErrConflict)
+}
+
+// testSoftDelete checks that deleted records are gone for every purpose but uniqueness, and that
+// their IDs aren't reused.
+func testSoftDelete(t *testing.T, db *database.Database) {
+	users := createUsers(t, db, 2)
+	deleted, err := db.Users.Delete(ctx, users[0].ID)
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, db.Users.Update(ctx, deleted), database.ErrNotFound, "Update")
+	_, err = db.Users.Delete(ctx, users[0].ID)
+	assert.ErrorIs(t, err, database.ErrNotFound, "Delete")
+	page, err := db.Users.GetAll(ctx, &models.Pagination{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{users[1].Email}, emails(page))
+
+	user := makeTestUser()
+	require.NoError(t, db.Users.Create(ctx, user))
+	assert.Greater(t, user.ID, users[1].ID, "IDs are reused")
+
+	org := &models.Organization{Name: "org"}
+	require.NoError(t, db.Organizations.Create(ctx, org))
+	require.NoError(t, db.Organizations.Delete(ctx, org.ID))
+	orgs, err := db.Organizations.GetAll(ctx, &models.Pagination{})
+	require.NoError(t, err)
+	assert.Empty(t, orgs.Rows)
+	assert.ErrorIs(t, db.Organizations.Delete(ctx, org.ID), database.ErrNotFound)
+}
+
+// testTimestamps checks that records get creation and update times, and keep the creation time.
+func testTimestamps(t *testing.T, db *database.Database) {
+	user := makeTestUser()
+	require.NoError(t, db.Users.Create(ctx, user))
+	assert.False(t, user.CreatedAt.IsZero(), "Create didn't set CreatedAt")
+	assert.False(t, user.UpdatedAt.IsZero(), "Create didn't set UpdatedAt")
+
+	stored, err := db.Users.GetByID(ctx, user.ID)
+	require.NoError(t, err)
+	assert.WithinDuration(t, user.CreatedAt, stored.CreatedAt, time.Millisecond)
+	stored.Name = "Renamed"
+	require.NoError(t, db.Users.Update(ctx, stored))
+	updated, err := db.Users.GetByID(ctx, user.ID)
+	require.NoError(t, err)
+	assert.WithinDuration(t, user.CreatedAt, updated.CreatedAt, time.Millisecond, "Update changed CreatedAt")
+	assert.False(t, updated.UpdatedAt.Before(stored.UpdatedAt), "Update didn't advance UpdatedAt")
+
+	require.NoError(t, db.TorExitNodes.DeleteAndAdd(ctx, nil, []*models.TorExitNode{{IP: "1.1.1.1"}}))
+	missing, err := db.TorExitNodes.GetMissingCountries(ctx, -1)
+	require.NoError(t, err)
+	require.Len(t, missing, 1)
+	assert.False(t, missing[0].CreatedAt.IsZero(), "Nodes lost CreatedAt")
+	missing[0].CountryName = "Australia"
+	require.NoError(t, db.TorExitNodes.Update(ctx, missing))
+	page, err := db.TorExitNodes.GetAll(ctx, nil, &models.Pagination{})
+	require.NoError(t, err)
+	node := page.Rows.([]*models.TorExitNode)[0]
+	assert.Equal(t, "Australia", node.CountryName)
+	assert.WithinDuration(t, missing[0].CreatedAt, node.CreatedAt, time.Millisecond, "Update changed CreatedAt")
+}
@@ -0,0 +1,47 @@
+// Package databasetest is a conformance suite for implementations of database.Database, so that
+// every backend answers the same calls the same way.
+package databasetest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/humper/tor_exit_nodes/pkg/database"
+)
+
+var ctx = context.Background()
+
+// Run runs the suite against the backend open returns a new, empty database of.
+func Run(t *testing.T, open func(t *testing.T) *database.Database) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, db *database.Database)
+	}{
+		{"CreateUser", testCreateUser},
+		{"GetAllUsers", testGetAllUsers},
+		{"GetUserByID", testGetUserByID},
+		{"UpdateUser", testUpdateUser},
+		{"DeleteUser", testDeleteUser},
+		{"UserNotFound", testUserNotFound},
+		{"UserArrays", testUserArrays},
+		{"UserPagination", testUserPagination},
+		{"UserFilters", testUserFilters},
+		{"TorExitNodePagination", testTorExitNodePagination},
+		{"TorExitNodeFiltersAndExclusions", testTorExitNodeFiltersAndExclusions},
+		{"IterateTorExitNodes", testIterateTorExitNodes},
+		{"DeleteAndAdd", testDeleteAndAdd},
+		{"GetMissingCountries", testGetMissingCountries},
+		{"RotateSession", testRotateSession},
+		{"NotFound", testNotFound},
+		{"UniqueEmail", testUniqueEmail},
+		{"UniqueIP", testUniqueIP},
+		{"UniqueOrganizationName", testUniqueOrganizationName},
+		{"SoftDelete", testSoftDelete},
+		{"Timestamps", testTimestamps},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.test(t, open(t))
+		})
+	}
+}
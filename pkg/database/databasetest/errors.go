@@ -0,0 +1,32 @@
+package databasetest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/humper/tor_exit_nodes/pkg/database"
+	"github.com/stretchr/testify/assert"
+)
+
+// testNotFound checks that looking up or deleting anything that doesn't exist fails with
+// database.ErrNotFound, which the server tells apart from other errors.
+func testNotFound(t *testing.T, db *database.Database) {
+	_, err := db.APIKeys.GetByPrefix(ctx, "nothing")
+	assert.ErrorIs(t, err, database.ErrNotFound, "APIKeys.GetByPrefix")
+	assert.ErrorIs(t, db.APIKeys.Delete(ctx, 1, 999), database.ErrNotFound, "APIKeys.Delete")
+
+	_, err = db.Sessions.GetByID(ctx, 999)
+	assert.ErrorIs(t, err, database.ErrNotFound, "Sessions.GetByID")
+	_, err = db.Sessions.GetByRefreshHash(ctx, "nothing")
+	assert.ErrorIs(t, err, database.ErrNotFound, "Sessions.GetByRefreshHash")
+	assert.ErrorIs(t, db.Sessions.Delete(ctx, 999), database.ErrNotFound, "Sessions.Delete")
+	assert.NoError(t, db.Sessions.DeleteByUser(ctx, 999), "Users without sessions have none to delete")
+
+	_, err = db.Organizations.GetByID(ctx, 999)
+	assert.ErrorIs(t, err, database.ErrNotFound, "Organizations.GetByID")
+	assert.ErrorIs(t, db.Organizations.Delete(ctx, 999), database.ErrNotFound, "Organizations.Delete")
+
+	count, err := db.LoginAudits.CountFailuresByEmail(ctx, "nobody@example.com", time.Now().Add(-time.Hour))
+	assert.NoError(t, err)
+	assert.Zero(t, count)
+}
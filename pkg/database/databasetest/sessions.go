@@ -0,0 +1,45 @@
+package databasetest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/humper/tor_exit_nodes/models"
+	"github.com/humper/tor_exit_nodes/pkg/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testRotateSession(t *testing.T, db *database.Database) {
+	session := &models.Session{UserID: 1, RefreshHash: "first", ExpiresAt: time.Now().Add(time.Hour)}
+	require.NoError(t, db.Sessions.Create(ctx, session))
+
+	// two refreshes read the session before either rotates it
+	winner, err := db.Sessions.GetByRefreshHash(ctx, "first")
+	require.NoError(t, err)
+	loser, err := db.Sessions.GetByRefreshHash(ctx, "first")
+	require.NoError(t, err)
+
+	winner.RefreshHash = "second"
+	winner.IP = "10.0.0.1"
+	require.NoError(t, db.Sessions.Rotate(ctx, winner, "first"))
+	loser.RefreshHash = "third"
+	assert.ErrorIs(t, db.Sessions.Rotate(ctx, loser, "first"), database.ErrNotFound, "Only one rotation of a token wins")
+
+	stored, err := db.Sessions.GetByRefreshHash(ctx, "second")
+	require.NoError(t, err)
+	assert.Equal(t, session.ID, stored.ID)
+	assert.Equal(t, "10.0.0.1", stored.IP)
+	_, err = db.Sessions.GetByRefreshHash(ctx, "third")
+	assert.ErrorIs(t, err, database.ErrNotFound)
+
+	previous, err := db.Sessions.GetByPreviousRefreshHash(ctx, "first")
+	require.NoError(t, err)
+	assert.Equal(t, session.ID, previous.ID)
+
+	require.NoError(t, db.Sessions.Delete(ctx, session.ID))
+	stored.RefreshHash = "fourth"
+	assert.ErrorIs(t, db.Sessions.Rotate(ctx, stored, "second"), database.ErrNotFound, "Deleted sessions can't be rotated")
+	_, err = db.Sessions.GetByPreviousRefreshHash(ctx, "first")
+	assert.ErrorIs(t, err, database.ErrNotFound, "Deleted sessions aren't found")
+}
@@ -0,0 +1,205 @@
+package databasetest
+
+import (
+	"testing"
+
+	"github.com/humper/tor_exit_nodes/models"
+	"github.com/humper/tor_exit_nodes/pkg/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// addNodes adds 1.1.1.1 in AU, 2.2.2.2 in US, 3.3.3.3 in AU, 4.4.4.4 in NZ and 5.5.5.5 in AU, in
+// that order.
+func addNodes(t *testing.T, db *database.Database) []*models.TorExitNode {
+	nodes := []*models.TorExitNode{
+		{IP: "1.1.1.1", CountryCode: "AU"},
+		{IP: "2.2.2.2", CountryCode: "US"},
+		{IP: "3.3.3.3", CountryCode: "AU"},
+		{IP: "4.4.4.4", CountryCode: "NZ"},
+		{IP: "5.5.5.5", CountryCode: "AU"},
+	}
+	require.NoError(t, db.TorExitNodes.DeleteAndAdd(ctx, nil, nodes))
+	return nodes
+}
+
+func ips(page *models.Pagination) []string {
+	return nodeIPs(page.Rows.([]*models.TorExitNode))
+}
+
+func nodeIPs(nodes []*models.TorExitNode) []string {
+	ips := []string{}
+	for _, node := range nodes {
+		ips = append(ips, node.IP)
+	}
+	return ips
+}
+
+func testTorExitNodePagination(t *testing.T, db *database.Database) {
+	addNodes(t, db)
+
+	page, err := db.TorExitNodes.GetAll(ctx, nil, &models.Pagination{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"5.5.5.5", "4.4.4.4", "3.3.3.3", "2.2.2.2", "1.1.1.1"}, ips(page), "Nodes are newest first by default")
+	assert.EqualValues(t, 5, page.TotalRows)
+	assert.Equal(t, 1, page.TotalPages)
+
+	page, err = db.TorExitNodes.GetAll(ctx, nil, &models.Pagination{Page: 2, Limit: 2, Sort: "ip asc"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"3.3.3.3", "4.4.4.4"}, ips(page))
+	assert.EqualValues(t, 5, page.TotalRows)
+	assert.Equal(t, 3, page.TotalPages)
+
+	page, err = db.TorExitNodes.GetAll(ctx, nil, &models.Pagination{Limit: 3, Sort: "country_code desc"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"US", "NZ", "AU"}, []string{
+		page.Rows.([]*models.TorExitNode)[0].CountryCode,
+		page.Rows.([]*models.TorExitNode)[1].CountryCode,
+		page.Rows.([]*models.TorExitNode)[2].CountryCode,
+	})
+
+	page, err = db.TorExitNodes.GetAll(ctx, nil, &models.Pagination{Page: 6, Limit: 1})
+	require.NoError(t, err)
+	assert.Empty(t, page.Rows, "Pages past the end are empty")
+
+	_, err = db.TorExitNodes.GetAll(ctx, nil, &models.Pagination{Sort: "no_such_column desc"})
+	assert.Error(t, err, "Sorting by an unknown column")
+}
+
+func testTorExitNodeFiltersAndExclusions(t *testing.T, db *database.Database) {
+	addNodes(t, db)
+
+	page, err := db.TorExitNodes.GetAll(ctx, []string{"3.3.3.3", "9.9.9.9"}, &models.Pagination{Sort: "ip asc"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1.1.1.1", "2.2.2.2", "4.4.4.4", "5.5.5.5"}, ips(page))
+	assert.EqualValues(t, 4, page.TotalRows, "Excluded nodes aren't counted")
+
+	page, err = db.TorExitNodes.GetAll(ctx, []string{"3.3.3.3"}, &models.Pagination{Sort: "ip asc", Limit: 2, Filter: map[string][]string{"country_code": {"AU", "NZ"}}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1.1.1.1", "4.4.4.4"}, ips(page))
+	assert.EqualValues(t, 3, page.TotalRows)
+	assert.Equal(t, 2, page.TotalPages)
+
+	page, err = db.TorExitNodes.GetAll(ctx, nil, &models.Pagination{Filter: map[string][]string{"country_code": {"AU"}, "ip": {"1.1.1.1", "2.2.2.2"}}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1.1.1.1"}, ips(page), "Every filter applies")
+
+	page, err = db.TorExitNodes.GetAll(ctx, nil, &models.Pagination{Filter: map[string][]string{"country_code": {"FR"}}})
+	require.NoError(t, err)
+	assert.Empty(t, page.Rows)
+	assert.EqualValues(t, 0, page.TotalRows)
+
+	_, err = db.TorExitNodes.GetAll(ctx, nil, &models.Pagination{Filter: map[string][]string{"no_such_column": {"x"}}})
+	assert.Error(t, err, "Filtering by an unknown column")
+	err = db.TorExitNodes.Iterate(ctx, nil, map[string][]string{"no_such_column": {"x"}}, func(*models.TorExitNode) error { return nil })
+	assert.Error(t, err, "Filtering by an unknown column")
+	err = db.TorExitNodes.Iterate(ctx, nil, map[string][]string{"1=1) OR (1": {"1"}}, func(*models.TorExitNode) error { return nil })
+	assert.Error(t, err, "Filtering by SQL")
+}
+
+func testIterateTorExitNodes(t *testing.T, db *database.Database) {
+	addNodes(t, db)
+
+	ips := []string{}
+	err := db.TorExitNodes.Iterate(ctx, []string{"3.3.3.3"}, map[string][]string{"country_code": {"AU"}}, func(node *models.TorExitNode) error {
+		ips = append(ips, node.IP)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1.1.1.1", "5.5.5.5"}, ips, "Nodes are iterated in ID order")
+
+	err = db.TorExitNodes.Iterate(ctx, nil, nil, func(node *models.TorExitNode) error {
+		return assert.AnError
+	})
+	require.ErrorIs(t, err, assert.AnError)
+}
+
+func testDeleteAndAdd(t *testing.T, db *database.Database) {
+	added := addNodes(t, db)
+	ids := map[uint]bool{}
+	for _, node := range added {
+		assert.NotZero(t, node.ID, "DeleteAndAdd didn't set the ID")
+		ids[node.ID] = true
+	}
+	assert.Len(t, ids, len(added), "IDs aren't unique")
+
+	page, err := db.TorExitNodes.GetAll(ctx, nil, &models.Pagination{Sort: "ip asc"})
+	require.NoError(t, err)
+	stored := page.Rows.([]*models.TorExitNode)
+	newNode := &models.TorExitNode{IP: "6.6.6.6", CountryCode: "FR", CountryName: "France", ExitPolicy: "accept 80,443", ExitPolicyV6: "reject 1-65535"}
+	err = db.TorExitNodes.DeleteAndAdd(ctx, []models.TorExitNode{*stored[1], *stored[3]}, []*models.TorExitNode{newNode})
+	require.NoError(t, err)
+
+	page, err = db.TorExitNodes.GetAll(ctx, nil, &models.Pagination{Sort: "ip asc"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1.1.1.1", "3.3.3.3", "5.5.5.5", "6.6.6.6"}, ips(page))
+	assert.EqualValues(t, 4, page.TotalRows)
+
+	remaining := page.Rows.([]*models.TorExitNode)
+	for _, node := range remaining[:3] {
+		assert.True(t, ids[node.ID], "Remaining nodes keep their IDs")
+	}
+	assert.NotZero(t, newNode.ID)
+	assert.Equal(t, newNode.ID, remaining[3].ID)
+	for _, node := range remaining[:3] {
+		assert.NotEqual(t, node.ID, newNode.ID, "IDs aren't unique")
+	}
+	assert.Equal(t, "FR", remaining[3].CountryCode)
+	assert.Equal(t, "France", remaining[3].CountryName)
+	assert.Equal(t, "accept 80,443", remaining[3].ExitPolicy)
+	assert.Equal(t, "reject 1-65535", remaining[3].ExitPolicyV6)
+
+	// deleted addresses can be added again
+	err = db.TorExitNodes.DeleteAndAdd(ctx, nil, []*models.TorExitNode{{IP: "2.2.2.2", CountryCode: "US"}})
+	require.NoError(t, err)
+	page, err = db.TorExitNodes.GetAll(ctx, nil, &models.Pagination{})
+	require.NoError(t, err)
+	assert.EqualValues(t, 5, page.TotalRows)
+
+	require.NoError(t, db.TorExitNodes.DeleteAndAdd(ctx, nil, nil), "Empty changes")
+}
+
+func testGetMissingCountries(t *testing.T, db *database.Database) {
+	nodes := []*models.TorExitNode{
+		{IP: "1.1.1.1"},
+		{IP: "2.2.2.2", CountryCode: "US", CountryName: "United States"},
+		{IP: "3.3.3.3"},
+		{IP: "4.4.4.4"},
+	}
+	require.NoError(t, db.TorExitNodes.DeleteAndAdd(ctx, nil, nodes))
+
+	missing, err := db.TorExitNodes.GetMissingCountries(ctx, 2)
+	require.NoError(t, err)
+	require.Len(t, missing, 2, "GetMissingCountries returns at most a batch")
+	for _, node := range missing {
+		assert.Empty(t, node.CountryName)
+		assert.NotEqual(t, "2.2.2.2", node.IP)
+	}
+
+	for _, node := range missing {
+		node.CountryCode = "AU"
+		node.CountryName = "Australia"
+	}
+	require.NoError(t, db.TorExitNodes.Update(ctx, missing))
+
+	rest, err := db.TorExitNodes.GetMissingCountries(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, rest, 1)
+	assert.NotContains(t, nodeIPs(missing), rest[0].IP)
+
+	page, err := db.TorExitNodes.GetAll(ctx, nil, &models.Pagination{Filter: map[string][]string{"country_code": {"AU"}}})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, nodeIPs(missing), ips(page), "Updated nodes keep their addresses")
+	for _, node := range page.Rows.([]*models.TorExitNode) {
+		assert.Equal(t, "Australia", node.CountryName)
+	}
+	page, err = db.TorExitNodes.GetAll(ctx, nil, &models.Pagination{})
+	require.NoError(t, err)
+	assert.EqualValues(t, 4, page.TotalRows, "Update doesn't add nodes")
+
+	rest[0].CountryName = "Somewhere"
+	require.NoError(t, db.TorExitNodes.Update(ctx, rest))
+	rest, err = db.TorExitNodes.GetMissingCountries(ctx, 10)
+	require.NoError(t, err)
+	assert.Empty(t, rest)
+}
@@ -0,0 +1,251 @@
+package databasetest
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/humper/tor_exit_nodes/models"
+	"github.com/humper/tor_exit_nodes/pkg/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func makeTestUser() *models.User {
+	return &models.User{
+		Name:       "Test Person",
+		Email:      "test@example.com",
+		Password:   "password",
+		Role:       "admin",
+		AllowedIPs: []string{},
+	}
+}
+
+func compareUsers(t *testing.T, expected, actual *models.User) {
+	assert.Equal(t, expected.Name, actual.Name)
+	assert.Equal(t, expected.Email, actual.Email)
+	assert.Equal(t, expected.Password, actual.Password)
+	assert.Equal(t, expected.Role, actual.Role)
+	assert.ElementsMatch(t, expected.AllowedIPs, actual.AllowedIPs)
+}
+
+// createUsers creates n users, user0@example.com to user<n-1>@example.com, alternately admins
+// and users, and returns them in the order they were created.
+func createUsers(t *testing.T, db *database.Database, n int) []*models.User {
+	users := make([]*models.User, n)
+	for i := range users {
+		users[i] = makeTestUser()
+		users[i].Email = fmt.Sprintf("user%d@example.com", i)
+		if i%2 == 1 {
+			users[i].Role = "user"
+		}
+		require.NoError(t, db.Users.Create(ctx, users[i]), "Failed to create user")
+		require.NotZero(t, users[i].ID, "Create didn't set the ID")
+	}
+	return users
+}
+
+func emails(page *models.Pagination) []string {
+	var emails []string
+	for _, user := range page.Rows.([]*models.User) {
+		emails = append(emails, user.Email)
+	}
+	return emails
+}
+
+func testCreateUser(t *testing.T, db *database.Database) {
+	user := makeTestUser()
+
+	err := db.Users.Create(ctx, user)
+	require.NoError(t, err, "Failed to create user")
+
+	// Verify that the user was created successfully
+	createdUser, err := db.Users.GetByEmail(ctx, user.Email)
+	require.NoError(t, err, "Failed to get user by Email")
+
+	compareUsers(t, user, createdUser)
+	assert.Equal(t, user.ID, createdUser.ID)
+}
+
+func testGetAllUsers(t *testing.T, db *database.Database) {
+	user := makeTestUser()
+	user2 := makeTestUser()
+	user2.Email = "foo@bar.com"
+	user2.Name = "Foo Bar"
+
+	err := db.Users.Create(ctx, user)
+	require.NoError(t, err, "Failed to create user")
+	err = db.Users.Create(ctx, user2)
+	require.NoError(t, err, "Failed to create user 2")
+
+	pagination := &models.Pagination{
+		Page:  1,
+		Limit: 10,
+	}
+	pagination, err = db.Users.GetAll(ctx, pagination)
+	require.NoError(t, err, "Failed to get all users")
+	require.Len(t, pagination.Rows, 2, "Unexpected number of users")
+}
+
+func testGetUserByID(t *testing.T, db *database.Database) {
+	user := makeTestUser()
+
+	err := db.Users.Create(ctx, user)
+	require.NoError(t, err, "Failed to create user")
+
+	createdUser, err := db.Users.GetByEmail(ctx, user.Email)
+	require.NoError(t, err, "Failed to get user by Email")
+
+	userByID, err := db.Users.GetByID(ctx, createdUser.ID)
+	require.NoError(t, err, "Failed to get user by ID")
+
+	compareUsers(t, user, userByID)
+}
+
+func testUpdateUser(t *testing.T, db *database.Database) {
+	user := makeTestUser()
+
+	err := db.Users.Create(ctx, user)
+	require.NoError(t, err, "Failed to create user")
+
+	createdUser, err := db.Users.GetByEmail(ctx, user.Email)
+	require.NoError(t, err, "Failed to get user by Email")
+
+	// Update the user's email
+	newEmail := "newemail@example.com"
+	createdUser.Email = newEmail
+	createdUser.AllowedIPs = []string{"1.2.3.4"}
+
+	err = db.Users.Update(ctx, createdUser)
+	require.NoError(t, err, "Failed to update user")
+
+	// Get the user by ID
+	retrievedUser, err := db.Users.GetByID(ctx, createdUser.ID)
+	require.NoError(t, err, "Failed to get user by ID")
+
+	// Verify that the user's email was updated
+	compareUsers(t, createdUser, retrievedUser)
+	byEmail, err := db.Users.GetByEmail(ctx, newEmail)
+	require.NoError(t, err, "Failed to get user by new Email")
+	assert.Equal(t, createdUser.ID, byEmail.ID)
+}
+
+func testDeleteUser(t *testing.T, db *database.Database) {
+	user := makeTestUser()
+	err := db.Users.Create(ctx, user)
+	require.NoError(t, err, "Failed to create user")
+
+	// Verify that the user was created successfully
+	createdUser, err := db.Users.GetByEmail(ctx, user.Email)
+	require.NoError(t, err, "Failed to get user by Email")
+
+	// Delete the user
+	user, err = db.Users.Delete(ctx, createdUser.ID)
+	require.NoError(t, err, "Failed to delete user")
+	assert.Equal(t, user.ID, createdUser.ID, "Unexpected user ID")
+
+	// Verify that the user was deleted
+	_, err = db.Users.GetByEmail(ctx, user.Email)
+	require.Error(t, err, "User was not deleted")
+	_, err = db.Users.GetByID(ctx, user.ID)
+	require.ErrorIs(t, err, database.ErrNotFound, "User was not deleted")
+
+	page, err := db.Users.GetAll(ctx, &models.Pagination{})
+	require.NoError(t, err)
+	assert.Empty(t, page.Rows, "Deleted users are listed")
+}
+
+func testUserNotFound(t *testing.T, db *database.Database) {
+	_, err := db.Users.GetByID(ctx, 999)
+	assert.ErrorIs(t, err, database.ErrNotFound, "Expected error when user is not found")
+
+	_, err = db.Users.GetByEmail(ctx, "bogus")
+	assert.ErrorIs(t, err, database.ErrNotFound, "Expected error when user is not found")
+
+	user := makeTestUser()
+	user.ID = 999
+	err = db.Users.Update(ctx, user)
+	assert.Error(t, err, "Expected error when user is not found")
+	_, err = db.Users.GetByID(ctx, 999)
+	assert.ErrorIs(t, err, database.ErrNotFound, "Updating an unknown user created it")
+
+	user, err = db.Users.Delete(ctx, uint(999999))
+	assert.ErrorIs(t, err, database.ErrNotFound, "Expected error when user is not found")
+	assert.Nil(t, user, "Expected nil user when user is not found")
+}
+
+func testUserArrays(t *testing.T, db *database.Database) {
+	user := makeTestUser()
+	user.AllowedIPs = []string{"1.2.3.4", "10.0.0.0/8", `quoted "and", comma`}
+	user.RecoveryCodes = []string{"first", "second"}
+	require.NoError(t, db.Users.Create(ctx, user))
+
+	created, err := db.Users.GetByID(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1.2.3.4", "10.0.0.0/8", `quoted "and", comma`}, []string(created.AllowedIPs))
+	assert.Equal(t, []string{"first", "second"}, []string(created.RecoveryCodes))
+
+	created.AllowedIPs = []string{"5.6.7.8"}
+	created.RecoveryCodes = []string{"second"}
+	require.NoError(t, db.Users.Update(ctx, created))
+	updated, err := db.Users.GetByID(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"5.6.7.8"}, []string(updated.AllowedIPs))
+	assert.Equal(t, []string{"second"}, []string(updated.RecoveryCodes))
+}
+
+func testUserPagination(t *testing.T, db *database.Database) {
+	createUsers(t, db, 5)
+
+	page, err := db.Users.GetAll(ctx, &models.Pagination{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"user4@example.com", "user3@example.com", "user2@example.com", "user1@example.com", "user0@example.com"}, emails(page), "Users are newest first by default")
+	assert.EqualValues(t, 5, page.TotalRows)
+	assert.Equal(t, 1, page.TotalPages)
+
+	page, err = db.Users.GetAll(ctx, &models.Pagination{Page: 2, Limit: 2, Sort: "email asc"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"user2@example.com", "user3@example.com"}, emails(page))
+	assert.EqualValues(t, 5, page.TotalRows)
+	assert.Equal(t, 3, page.TotalPages)
+
+	page, err = db.Users.GetAll(ctx, &models.Pagination{Page: 3, Limit: 2, Sort: "Email DESC"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"user0@example.com"}, emails(page))
+
+	page, err = db.Users.GetAll(ctx, &models.Pagination{Page: 4, Limit: 2})
+	require.NoError(t, err)
+	assert.Empty(t, page.Rows, "Pages past the end are empty")
+	assert.EqualValues(t, 5, page.TotalRows)
+
+	_, err = db.Users.GetAll(ctx, &models.Pagination{Sort: "no_such_column"})
+	assert.Error(t, err, "Sorting by an unknown column")
+}
+
+func testUserFilters(t *testing.T, db *database.Database) {
+	users := createUsers(t, db, 5)
+	for _, user := range users[3:] {
+		user.OrganizationID = 7
+		require.NoError(t, db.Users.Update(ctx, user))
+	}
+
+	page, err := db.Users.GetAll(ctx, &models.Pagination{Sort: "email asc", Filter: map[string][]string{"role": {"user"}}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"user1@example.com", "user3@example.com"}, emails(page))
+	assert.EqualValues(t, 2, page.TotalRows)
+
+	page, err = db.Users.GetAll(ctx, &models.Pagination{Sort: "email asc", Filter: map[string][]string{"role": {"user", "admin"}, "organization_id": {"7"}}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"user3@example.com", "user4@example.com"}, emails(page))
+
+	page, err = db.Users.GetAllByOrganization(ctx, 7, &models.Pagination{Sort: "email asc", Filter: map[string][]string{"role": {"admin"}}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"user4@example.com"}, emails(page))
+	assert.EqualValues(t, 1, page.TotalRows)
+
+	page, err = db.Users.GetAllByOrganization(ctx, 0, &models.Pagination{Sort: "email asc"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"user0@example.com", "user1@example.com", "user2@example.com"}, emails(page))
+
+	_, err = db.Users.GetAll(ctx, &models.Pagination{Filter: map[string][]string{"no_such_column": {"x"}}})
+	assert.Error(t, err, "Filtering by an unknown column")
+}
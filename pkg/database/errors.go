@@ -0,0 +1,12 @@
+package database
+
+import "errors"
+
+var (
+	// ErrNotFound is returned when the record being looked up, updated or deleted doesn't exist, or
+	// has been deleted.
+	ErrNotFound = errors.New("record not found")
+	// ErrConflict is returned when a write would give a record the same value as another in a column
+	// that must be unique, such as a user's email address.  Deleted records still count.
+	ErrConflict = errors.New("record conflicts with an existing one")
+)
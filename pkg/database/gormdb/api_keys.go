@@ -0,0 +1,49 @@
+package gormdb
+
+import (
+	"context"
+	"time"
+
+	"github.com/humper/tor_exit_nodes/models"
+	"github.com/humper/tor_exit_nodes/pkg/database"
+	"gorm.io/gorm"
+)
+
+type apiKeys struct {
+	db *gorm.DB
+}
+
+func (a *apiKeys) Create(ctx context.Context, key *models.APIKey) error {
+	return a.db.Create(key).Error
+}
+
+func (a *apiKeys) GetByPrefix(ctx context.Context, prefix string) (*models.APIKey, error) {
+	var key models.APIKey
+	if err := a.db.Where("prefix = ?", prefix).First(&key).Error; err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func (a *apiKeys) GetByUser(ctx context.Context, userID uint) ([]*models.APIKey, error) {
+	var keys []*models.APIKey
+	if err := a.db.Where("user_id = ?", userID).Order("id").Find(&keys).Error; err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (a *apiKeys) Delete(ctx context.Context, userID uint, id uint) error {
+	result := a.db.Where("id = ? AND user_id = ?", id, userID).Delete(&models.APIKey{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return database.ErrNotFound
+	}
+	return nil
+}
+
+func (a *apiKeys) Touch(ctx context.Context, id uint, lastUsed time.Time) error {
+	return a.db.Model(&models.APIKey{}).Where("id = ?", id).Update("last_used_at", lastUsed).Error
+}
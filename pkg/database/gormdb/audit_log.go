@@ -0,0 +1,37 @@
+package gormdb
+
+import (
+	"context"
+
+	"github.com/humper/tor_exit_nodes/models"
+	"gorm.io/gorm"
+)
+
+type auditLog struct {
+	db *gorm.DB
+}
+
+func (a *auditLog) Create(ctx context.Context, event *models.AuditEvent) error {
+	return a.db.Create(event).Error
+}
+
+func (a *auditLog) GetAll(ctx context.Context, pagination *models.Pagination) (*models.Pagination, error) {
+	var events []*models.AuditEvent
+	if err := a.db.Scopes(paginate(events, pagination, a.db)).Find(&events).Error; err != nil {
+		return nil, err
+	}
+	pagination.Rows = events
+	return pagination, nil
+}
+
+func (a *auditLog) GetAllByOrganization(ctx context.Context, orgID uint, pagination *models.Pagination) (*models.Pagination, error) {
+	var events []*models.AuditEvent
+
+	db := a.db.Where("organization_id = ?", orgID)
+
+	if err := db.Scopes(paginate(events, pagination, db)).Find(&events).Error; err != nil {
+		return nil, err
+	}
+	pagination.Rows = events
+	return pagination, nil
+}
@@ -0,0 +1,35 @@
+package gormdb
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+
+	"github.com/humper/tor_exit_nodes/pkg/database"
+)
+
+// translateErrors makes every query fail with the database package's errors for missing records and
+// unique constraint violations, in place of GORM's.  GORM only reports the latter when the
+// connection is opened with TranslateError, as Open does.
+func translateErrors(db *gorm.DB) error {
+	translate := func(tx *gorm.DB) {
+		switch {
+		case errors.Is(tx.Error, gorm.ErrRecordNotFound):
+			tx.Error = database.ErrNotFound
+		case errors.Is(tx.Error, gorm.ErrDuplicatedKey):
+			tx.Error = database.ErrConflict
+		}
+	}
+
+	callbacks := db.Callback()
+	if err := callbacks.Create().After("*").Register("ten:translate_errors", translate); err != nil {
+		return err
+	}
+	if err := callbacks.Query().After("*").Register("ten:translate_errors", translate); err != nil {
+		return err
+	}
+	if err := callbacks.Update().After("*").Register("ten:translate_errors", translate); err != nil {
+		return err
+	}
+	return callbacks.Delete().After("*").Register("ten:translate_errors", translate)
+}
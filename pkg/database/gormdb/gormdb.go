@@ -0,0 +1,41 @@
+// Package gormdb keeps the tables in a SQL database through GORM.  The postgres and sqlite backends
+// differ only in how they connect and create their schema, and share everything else from here.
+package gormdb
+
+import (
+	"gorm.io/gorm"
+
+	"github.com/humper/tor_exit_nodes/pkg/database"
+)
+
+// Open connects through dialector with the settings the tables rely on, and reports errors as the
+// database package's.
+func Open(dialector gorm.Dialector) (*gorm.DB, error) {
+	db, err := gorm.Open(dialector, &gorm.Config{TranslateError: true})
+	if err != nil {
+		return nil, err
+	}
+	if err := translateErrors(db); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// New returns a database whose tables are kept in db, which must already have their schema.
+// Closing it closes db.
+func New(db *gorm.DB) (*database.Database, error) {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	return &database.Database{
+		Users:         &users{db: db},
+		TorExitNodes:  &torExitNodes{db: db},
+		APIKeys:       &apiKeys{db: db},
+		Sessions:      &sessions{db: db},
+		LoginAudits:   &loginAudits{db: db},
+		Organizations: &organizations{db: db},
+		AuditLog:      &auditLog{db: db},
+		Closer:        sqlDB,
+	}, nil
+}
@@ -0,0 +1,61 @@
+package gormdb
+
+import (
+	"context"
+	"time"
+
+	"github.com/humper/tor_exit_nodes/models"
+	"github.com/humper/tor_exit_nodes/pkg/database"
+	"gorm.io/gorm"
+)
+
+type loginAudits struct {
+	db *gorm.DB
+}
+
+func (l *loginAudits) Create(ctx context.Context, audit *models.LoginAudit) error {
+	return l.db.Create(audit).Error
+}
+
+func (l *loginAudits) GetAll(ctx context.Context, pagination *models.Pagination) (*models.Pagination, error) {
+	var audits []*models.LoginAudit
+	if err := l.db.Scopes(paginate(audits, pagination, l.db)).Find(&audits).Error; err != nil {
+		return nil, err
+	}
+	pagination.Rows = audits
+	return pagination, nil
+}
+
+func (l *loginAudits) GetAllByOrganization(ctx context.Context, orgID uint, pagination *models.Pagination) (*models.Pagination, error) {
+	var audits []*models.LoginAudit
+
+	db := l.db.Where("organization_id = ?", orgID)
+
+	if err := db.Scopes(paginate(audits, pagination, db)).Find(&audits).Error; err != nil {
+		return nil, err
+	}
+	pagination.Rows = audits
+	return pagination, nil
+}
+
+func (l *loginAudits) CountFailuresByEmail(ctx context.Context, email string, since time.Time) (int64, error) {
+	var lastSuccess models.LoginAudit
+	err := l.db.Where("email = ? AND success AND created_at > ?", email, since).Order("created_at desc").First(&lastSuccess).Error
+	switch err {
+	case nil:
+		since = lastSuccess.CreatedAt
+	case database.ErrNotFound:
+	default:
+		return 0, err
+	}
+
+	var count int64
+	err = l.db.Model(&models.LoginAudit{}).Where("email = ? AND NOT success AND reason <> ? AND created_at > ?", email, models.LoginLocked, since).Count(&count).Error
+	return count, err
+}
+
+func (l *loginAudits) CountFailuresByIP(ctx context.Context, ip string, since time.Time) (int64, error) {
+	var count int64
+	err := l.db.Model(&models.LoginAudit{}).Where("ip = ? AND NOT success AND reason <> ? AND created_at > ?", ip, models.LoginLocked, since).Count(&count).Error
+	return count, err
+}
@@ -0,0 +1,45 @@
+package gormdb
+
+import (
+	"context"
+
+	"github.com/humper/tor_exit_nodes/models"
+	"github.com/humper/tor_exit_nodes/pkg/database"
+	"gorm.io/gorm"
+)
+
+type organizations struct {
+	db *gorm.DB
+}
+
+func (o *organizations) GetAll(ctx context.Context, pagination *models.Pagination) (*models.Pagination, error) {
+	var orgs []*models.Organization
+	if err := o.db.Scopes(paginate(orgs, pagination, o.db)).Find(&orgs).Error; err != nil {
+		return nil, err
+	}
+	pagination.Rows = orgs
+	return pagination, nil
+}
+
+func (o *organizations) GetByID(ctx context.Context, id uint) (*models.Organization, error) {
+	var org models.Organization
+	if err := o.db.Where("id = ?", id).First(&org).Error; err != nil {
+		return nil, err
+	}
+	return &org, nil
+}
+
+func (o *organizations) Create(ctx context.Context, org *models.Organization) error {
+	return o.db.Create(org).Error
+}
+
+func (o *organizations) Delete(ctx context.Context, id uint) error {
+	result := o.db.Where("id = ?", id).Delete(&models.Organization{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return database.ErrNotFound
+	}
+	return nil
+}
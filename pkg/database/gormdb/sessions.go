@@ -0,0 +1,88 @@
+package gormdb
+
+import (
+	"context"
+
+	"github.com/humper/tor_exit_nodes/models"
+	"github.com/humper/tor_exit_nodes/pkg/database"
+	"gorm.io/gorm"
+)
+
+type sessions struct {
+	db *gorm.DB
+}
+
+func (s *sessions) Create(ctx context.Context, session *models.Session) error {
+	return s.db.Create(session).Error
+}
+
+func (s *sessions) GetByID(ctx context.Context, id uint) (*models.Session, error) {
+	var session models.Session
+	if err := s.db.Where("id = ?", id).First(&session).Error; err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (s *sessions) GetByRefreshHash(ctx context.Context, hash string) (*models.Session, error) {
+	var session models.Session
+	if err := s.db.Where("refresh_hash = ?", hash).First(&session).Error; err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (s *sessions) GetByPreviousRefreshHash(ctx context.Context, hash string) (*models.Session, error) {
+	var session models.Session
+	if err := s.db.Where("previous_refresh_hash = ?", hash).First(&session).Error; err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (s *sessions) GetByUser(ctx context.Context, userID uint) ([]*models.Session, error) {
+	var sessions []*models.Session
+	if err := s.db.Where("user_id = ?", userID).Order("id").Find(&sessions).Error; err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+func (s *sessions) Update(ctx context.Context, session *models.Session) error {
+	return s.db.Save(session).Error
+}
+
+func (s *sessions) Rotate(ctx context.Context, session *models.Session, oldHash string) error {
+	session.PreviousRefreshHash = oldHash
+	// a single conditional UPDATE, so of two concurrent refreshes with the same token only one wins
+	result := s.db.Model(session).Where("refresh_hash = ?", oldHash).Updates(map[string]any{
+		"refresh_hash":          session.RefreshHash,
+		"previous_refresh_hash": session.PreviousRefreshHash,
+		"expires_at":            session.ExpiresAt,
+		"last_used_at":          session.LastUsedAt,
+		"ip":                    session.IP,
+		"user_agent":            session.UserAgent,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return database.ErrNotFound
+	}
+	return nil
+}
+
+func (s *sessions) Delete(ctx context.Context, id uint) error {
+	result := s.db.Where("id = ?", id).Delete(&models.Session{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return database.ErrNotFound
+	}
+	return nil
+}
+
+func (s *sessions) DeleteByUser(ctx context.Context, userID uint) error {
+	return s.db.Where("user_id = ?", userID).Delete(&models.Session{}).Error
+}
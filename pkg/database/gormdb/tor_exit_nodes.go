@@ -1,7 +1,9 @@
-package psql
+package gormdb
 
 import (
 	"context"
+	"fmt"
+	"slices"
 
 	"github.com/humper/tor_exit_nodes/models"
 	"gorm.io/gorm"
@@ -29,6 +31,39 @@ func (t *torExitNodes) GetAll(ctx context.Context, excludedIPs []string, paginat
 	return pagination, nil
 }
 
+func (t *torExitNodes) Iterate(ctx context.Context, excludedIPs []string, filter map[string][]string, fn func(node *models.TorExitNode) error) error {
+	db := t.db.Model(&models.TorExitNode{})
+
+	if len(excludedIPs) > 0 {
+		db = db.Where("ip NOT IN ?", excludedIPs)
+	}
+	for key, value := range filter {
+		// the column name is part of the SQL
+		if !slices.Contains(models.TorExitNodeFilterColumns, key) {
+			return fmt.Errorf("unsupported filter column %q", key)
+		}
+		db = db.Where(key+" IN ?", value)
+	}
+
+	rows, err := db.WithContext(ctx).Order("id").Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var node models.TorExitNode
+		if err := db.ScanRows(rows, &node); err != nil {
+			return err
+		}
+		if err := fn(&node); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
 func (t *torExitNodes) DeleteAndAdd(ctx context.Context, nodes_to_delete []models.TorExitNode, nodes_to_add []*models.TorExitNode) error {
 	return t.db.Transaction(func(tx *gorm.DB) error {
 		if len(nodes_to_delete) > 0 {
@@ -1,9 +1,10 @@
-package psql
+package gormdb
 
 import (
 	"context"
 
 	"github.com/humper/tor_exit_nodes/models"
+	"github.com/humper/tor_exit_nodes/pkg/database"
 	"gorm.io/gorm"
 )
 
@@ -17,19 +18,17 @@ func (u *users) GetAll(ctx context.Context, pagination *models.Pagination) (*mod
 		return nil, err
 	}
 
-	// hack to bootstrap the database
+	pagination.Rows = users
+	return pagination, nil
+}
+
+func (u *users) GetAllByOrganization(ctx context.Context, orgID uint, pagination *models.Pagination) (*models.Pagination, error) {
+	var users []*models.User
 
-	if pagination.TotalRows == 0 {
-		err := u.Create(ctx, &models.User{
-			Role:       "admin",
-			Name:       "Admin",
-			Email:      "admin@admin.com",
-			Password:   "password",
-			AllowedIPs: []string{},
-		})
-		if err != nil {
-			return nil, err
-		}
+	db := u.db.Where("organization_id = ?", orgID)
+
+	if err := db.Scopes(paginate(users, pagination, db)).Find(&users).Error; err != nil {
+		return nil, err
 	}
 
 	pagination.Rows = users
@@ -59,9 +58,14 @@ func (u *users) Create(ctx context.Context, user *models.User) error {
 	return nil
 }
 
+// Update saves every field of user.  Unlike Save, it never creates users that don't exist.
 func (u *users) Update(ctx context.Context, user *models.User) error {
-	if err := u.db.Save(user).Error; err != nil {
-		return err
+	result := u.db.Model(user).Select("*").Updates(user)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return database.ErrNotFound
 	}
 	return nil
 }
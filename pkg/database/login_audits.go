@@ -0,0 +1,20 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/humper/tor_exit_nodes/models"
+)
+
+type LoginAudits interface {
+	Create(ctx context.Context, audit *models.LoginAudit) error
+	GetAll(ctx context.Context, pagination *models.Pagination) (*models.Pagination, error)
+	// GetAllByOrganization is GetAll restricted to logins to users in an organization.
+	GetAllByOrganization(ctx context.Context, orgID uint, pagination *models.Pagination) (*models.Pagination, error)
+	// CountFailuresByEmail counts failed logins to email since the later of since and its last
+	// successful login.  Neither count includes attempts refused by a lockout.
+	CountFailuresByEmail(ctx context.Context, email string, since time.Time) (int64, error)
+	// CountFailuresByIP counts failed logins from ip since since.
+	CountFailuresByIP(ctx context.Context, ip string, since time.Time) (int64, error)
+}
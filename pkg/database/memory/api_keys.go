@@ -0,0 +1,94 @@
+package memory
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/humper/tor_exit_nodes/models"
+	"github.com/humper/tor_exit_nodes/pkg/database"
+)
+
+func apiKeyCopy(key *models.APIKey) *models.APIKey {
+	c := *key
+	c.Scopes = slices.Clone(key.Scopes)
+	return &c
+}
+
+type apiKeys struct {
+	byId    map[uint]*models.APIKey
+	mutex   sync.Mutex
+	counter uint
+}
+
+func (a *apiKeys) Create(ctx context.Context, key *models.APIKey) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	for _, other := range a.byId {
+		if other.Prefix == key.Prefix {
+			return database.ErrConflict
+		}
+	}
+
+	a.counter++
+	key.ID = a.counter
+	created(&key.Model, time.Now())
+	a.byId[key.ID] = apiKeyCopy(key)
+	return nil
+}
+
+func (a *apiKeys) GetByPrefix(ctx context.Context, prefix string) (*models.APIKey, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	for _, key := range a.byId {
+		if key.Prefix == prefix && !deleted(&key.Model) {
+			return apiKeyCopy(key), nil
+		}
+	}
+	return nil, database.ErrNotFound
+}
+
+func (a *apiKeys) GetByUser(ctx context.Context, userID uint) ([]*models.APIKey, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	keys := []*models.APIKey{}
+	for _, key := range a.byId {
+		if key.UserID == userID && !deleted(&key.Model) {
+			keys = append(keys, apiKeyCopy(key))
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].ID < keys[j].ID
+	})
+	return keys, nil
+}
+
+func (a *apiKeys) Delete(ctx context.Context, userID uint, id uint) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	key, ok := a.byId[id]
+	if !ok || deleted(&key.Model) || key.UserID != userID {
+		return database.ErrNotFound
+	}
+	softDelete(&key.Model, time.Now())
+	return nil
+}
+
+func (a *apiKeys) Touch(ctx context.Context, id uint, lastUsed time.Time) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	key, ok := a.byId[id]
+	if !ok || deleted(&key.Model) {
+		return database.ErrNotFound
+	}
+	key.LastUsedAt = &lastUsed
+	key.UpdatedAt = time.Now()
+	return nil
+}
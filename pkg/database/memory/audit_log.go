@@ -0,0 +1,75 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/humper/tor_exit_nodes/models"
+)
+
+type auditLog struct {
+	byId    map[uint]*models.AuditEvent
+	mutex   sync.Mutex
+	counter uint
+}
+
+func auditEventCopy(event *models.AuditEvent) *models.AuditEvent {
+	c := *event
+	return &c
+}
+
+var auditEventColumns = columns[*models.AuditEvent]{
+	"id":              func(e *models.AuditEvent) any { return e.ID },
+	"created_at":      func(e *models.AuditEvent) any { return e.CreatedAt },
+	"actor_id":        func(e *models.AuditEvent) any { return derefID(e.ActorID) },
+	"actor_email":     func(e *models.AuditEvent) any { return e.ActorEmail },
+	"organization_id": func(e *models.AuditEvent) any { return e.OrganizationID },
+	"action":          func(e *models.AuditEvent) any { return e.Action },
+	"target_type":     func(e *models.AuditEvent) any { return e.TargetType },
+	"target_id":       func(e *models.AuditEvent) any { return e.TargetID },
+	"request_id":      func(e *models.AuditEvent) any { return e.RequestID },
+	"ip":              func(e *models.AuditEvent) any { return e.IP },
+}
+
+func (a *auditLog) Create(ctx context.Context, event *models.AuditEvent) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.counter++
+	event.ID = a.counter
+	created(&event.Model, time.Now())
+	a.byId[event.ID] = auditEventCopy(event)
+	return nil
+}
+
+func (a *auditLog) GetAll(ctx context.Context, pagination *models.Pagination) (*models.Pagination, error) {
+	return a.getAll(pagination, func(event *models.AuditEvent) bool { return true })
+}
+
+func (a *auditLog) GetAllByOrganization(ctx context.Context, orgID uint, pagination *models.Pagination) (*models.Pagination, error) {
+	return a.getAll(pagination, func(event *models.AuditEvent) bool { return event.OrganizationID == orgID })
+}
+
+func (a *auditLog) getAll(pagination *models.Pagination, include func(*models.AuditEvent) bool) (*models.Pagination, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	allEvents := make([]*models.AuditEvent, 0, len(a.byId))
+	for _, event := range a.byId {
+		if include(event) {
+			allEvents = append(allEvents, event)
+		}
+	}
+	page, err := paginate(pagination, allEvents, auditEventColumns)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]*models.AuditEvent, 0, len(page))
+	for _, event := range page {
+		events = append(events, auditEventCopy(event))
+	}
+	pagination.Rows = events
+	return pagination, nil
+}
@@ -0,0 +1,15 @@
+package memory
+
+import (
+	"github.com/humper/tor_exit_nodes/pkg/database"
+)
+
+// Abandon stops db's snapshots and releases its files without writing a final snapshot, as if the
+// process had crashed.
+func Abandon(db *database.Database) {
+	p := db.Closer.(*persistence)
+	close(p.stop)
+	<-p.done
+	p.log.close()
+	p.lock.Close()
+}
@@ -0,0 +1,117 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/humper/tor_exit_nodes/models"
+)
+
+type loginAudits struct {
+	byId    map[uint]*models.LoginAudit
+	mutex   sync.Mutex
+	counter uint
+}
+
+func loginAuditCopy(audit *models.LoginAudit) *models.LoginAudit {
+	c := *audit
+	return &c
+}
+
+func (l *loginAudits) Create(ctx context.Context, audit *models.LoginAudit) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.counter++
+	audit.ID = l.counter
+	created(&audit.Model, time.Now())
+	l.byId[audit.ID] = loginAuditCopy(audit)
+	return nil
+}
+
+var loginAuditColumns = columns[*models.LoginAudit]{
+	"id":              func(a *models.LoginAudit) any { return a.ID },
+	"created_at":      func(a *models.LoginAudit) any { return a.CreatedAt },
+	"user_id":         func(a *models.LoginAudit) any { return derefID(a.UserID) },
+	"organization_id": func(a *models.LoginAudit) any { return a.OrganizationID },
+	"email":           func(a *models.LoginAudit) any { return a.Email },
+	"ip":              func(a *models.LoginAudit) any { return a.IP },
+	"user_agent":      func(a *models.LoginAudit) any { return a.UserAgent },
+	"success":         func(a *models.LoginAudit) any { return a.Success },
+	"reason":          func(a *models.LoginAudit) any { return a.Reason },
+}
+
+func (l *loginAudits) GetAll(ctx context.Context, pagination *models.Pagination) (*models.Pagination, error) {
+	return l.getAll(pagination, func(audit *models.LoginAudit) bool { return true })
+}
+
+func (l *loginAudits) GetAllByOrganization(ctx context.Context, orgID uint, pagination *models.Pagination) (*models.Pagination, error) {
+	return l.getAll(pagination, func(audit *models.LoginAudit) bool { return audit.OrganizationID == orgID })
+}
+
+func (l *loginAudits) getAll(pagination *models.Pagination, include func(*models.LoginAudit) bool) (*models.Pagination, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	allAudits := make([]*models.LoginAudit, 0, len(l.byId))
+	for _, audit := range l.byId {
+		if include(audit) {
+			allAudits = append(allAudits, audit)
+		}
+	}
+	page, err := paginate(pagination, allAudits, loginAuditColumns)
+	if err != nil {
+		return nil, err
+	}
+
+	audits := make([]*models.LoginAudit, 0, len(page))
+	for _, audit := range page {
+		audits = append(audits, loginAuditCopy(audit))
+	}
+	pagination.Rows = audits
+	return pagination, nil
+}
+
+// sorted returns every audit, newest first.
+func (l *loginAudits) sorted() []*models.LoginAudit {
+	audits := make([]*models.LoginAudit, 0, len(l.byId))
+	for _, audit := range l.byId {
+		audits = append(audits, audit)
+	}
+	sort.Slice(audits, func(i, j int) bool {
+		return audits[i].ID > audits[j].ID
+	})
+	return audits
+}
+
+func (l *loginAudits) CountFailuresByEmail(ctx context.Context, email string, since time.Time) (int64, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	var count int64
+	for _, audit := range l.sorted() {
+		if audit.Email != email || audit.Reason == models.LoginLocked {
+			continue
+		}
+		if audit.Success || !audit.CreatedAt.After(since) {
+			break
+		}
+		count++
+	}
+	return count, nil
+}
+
+func (l *loginAudits) CountFailuresByIP(ctx context.Context, ip string, since time.Time) (int64, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	var count int64
+	for _, audit := range l.byId {
+		if audit.IP == ip && !audit.Success && audit.Reason != models.LoginLocked && audit.CreatedAt.After(since) {
+			count++
+		}
+	}
+	return count, nil
+}
@@ -2,20 +2,123 @@ package memory
 
 import (
 	"context"
+	"time"
 
 	"github.com/humper/tor_exit_nodes/models"
 	"github.com/humper/tor_exit_nodes/pkg/database"
+	"github.com/humper/tor_exit_nodes/pkg/util"
 )
 
-func New(ctx context.Context) (*database.Database, error) {
+// defaultSnapshotInterval is how often a persistent database is snapshotted unless configured.
+const defaultSnapshotInterval = 5 * time.Minute
 
-	return &database.Database{
-		Users: &users{
+// Configuration is the part of the DB config the memory backend reads.
+type Configuration struct {
+	// Path is the snapshot file, loaded on start if it exists.  Changes to users are also logged to
+	// Path+".wal" as they're made, so they survive crashes between snapshots.  Without a path
+	// nothing survives a restart.
+	Path string `yaml:"path"`
+	// SnapshotInterval is how often a snapshot is written; 5 minutes by default.  One is also
+	// written when the database is closed.
+	SnapshotInterval time.Duration `yaml:"snapshot_interval"`
+}
+
+// tables holds the backend's tables, so they can be snapshotted and restored together.
+type tables struct {
+	users         *users
+	torExitNodes  *torExitNodes
+	apiKeys       *apiKeys
+	sessions      *sessions
+	loginAudits   *loginAudits
+	organizations *organizations
+	auditLog      *auditLog
+}
+
+func newTables() *tables {
+	return &tables{
+		users: &users{
 			byEmail: make(map[string]*models.User),
 			byId:    make(map[uint]*models.User),
 		},
-		TorExitNodes: &torExitNodes{
+		torExitNodes: &torExitNodes{
 			nodes: make(map[string]*models.TorExitNode),
 		},
-	}, nil
+		apiKeys: &apiKeys{
+			byId: make(map[uint]*models.APIKey),
+		},
+		sessions: &sessions{
+			byId: make(map[uint]*models.Session),
+		},
+		loginAudits: &loginAudits{
+			byId: make(map[uint]*models.LoginAudit),
+		},
+		organizations: &organizations{
+			byId: make(map[uint]*models.Organization),
+		},
+		auditLog: &auditLog{
+			byId: make(map[uint]*models.AuditEvent),
+		},
+	}
+}
+
+func (t *tables) database() *database.Database {
+	return &database.Database{
+		Users:         t.users,
+		TorExitNodes:  t.torExitNodes,
+		APIKeys:       t.apiKeys,
+		Sessions:      t.sessions,
+		LoginAudits:   t.loginAudits,
+		Organizations: t.organizations,
+		AuditLog:      t.auditLog,
+	}
+}
+
+// New returns an empty database that keeps nothing across restarts.
+func New(ctx context.Context) (*database.Database, error) {
+	return newTables().database(), nil
+}
+
+// Load opens the memory database the yaml file describes.
+func Load(ctx context.Context, filename string) (*database.Database, error) {
+	cfg, err := util.ReadYamlFile[Configuration](filename)
+	if err != nil {
+		return nil, err
+	}
+	return Open(ctx, &cfg)
+}
+
+// Open returns a database restored from the snapshot and user log at cfg.Path, which it keeps up
+// to date until it is closed.  They're locked while it's open, and Open fails if another process
+// already has them open.
+func Open(ctx context.Context, cfg *Configuration) (*database.Database, error) {
+	if cfg.Path == "" {
+		return New(ctx)
+	}
+	interval := cfg.SnapshotInterval
+	if interval <= 0 {
+		interval = defaultSnapshotInterval
+	}
+
+	lock, err := lockFiles(cfg.Path)
+	if err != nil {
+		return nil, err
+	}
+	t := newTables()
+	if err := t.restore(cfg.Path); err != nil {
+		lock.Close()
+		return nil, err
+	}
+	log, err := openUserLog(cfg.Path+".wal", t.users)
+	if err != nil {
+		lock.Close()
+		return nil, err
+	}
+	t.users.log = log
+
+	p := newPersistence(t, cfg.Path, log, lock)
+	go p.run(interval)
+
+	db := t.database()
+	db.Closer = p
+	return db, nil
 }
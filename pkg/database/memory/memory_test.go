@@ -3,161 +3,131 @@ package memory_test
 import (
 	"context"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/humper/tor_exit_nodes/models"
 	"github.com/humper/tor_exit_nodes/pkg/database"
+	"github.com/humper/tor_exit_nodes/pkg/database/databasetest"
 	"github.com/humper/tor_exit_nodes/pkg/database/memory"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
-var db *database.Database
-var ctx context.Context
-
-func TestMain(m *testing.M) {
-	ctx := context.Background()
-
-	var err error
-
-	db, err = memory.New(ctx)
-	if err != nil {
-		panic(err)
-	}
-
-	code := m.Run()
-	os.Exit(code)
-}
-
-func makeTestUser() *models.User {
-	return &models.User{
-		Name:       "Test Person",
-		Email:      "test@example.com",
-		Password:   "password",
-		Role:       "admin",
-		AllowedIPs: []string{},
-	}
-}
-
-func compareUsers(t *testing.T, expected, actual *models.User) {
-	assert.Equal(t, expected.Name, actual.Name)
-	assert.Equal(t, expected.Email, actual.Email)
-	assert.Equal(t, expected.Password, actual.Password)
-	assert.Equal(t, expected.Role, actual.Role)
-	assert.EqualValues(t, expected.AllowedIPs, actual.AllowedIPs)
-}
-
-func TestCreateUser(t *testing.T) {
-	user := makeTestUser()
-
-	err := db.Users.Create(ctx, user)
-	require.NoError(t, err, "Failed to create user")
-
-	// Verify that the user was created successfully
-	createdUser, err := db.Users.GetByEmail(ctx, user.Email)
-	require.NoError(t, err, "Failed to get user by Email")
-
-	compareUsers(t, user, createdUser)
-}
-
-func TestGetAllUsers(t *testing.T) {
-	user := makeTestUser()
-	user2 := makeTestUser()
-	user2.Email = "foo@bar.com"
-	user2.Name = "Foo Bar"
-
-	err := db.Users.Create(ctx, user)
-	require.NoError(t, err, "Failed to create user")
-	err = db.Users.Create(ctx, user2)
-	require.NoError(t, err, "Failed to create user 2")
-
-	pagination := &models.Pagination{
-		Page:  1,
-		Limit: 10,
-	}
-	pagination, err = db.Users.GetAll(ctx, pagination)
-	require.NoError(t, err, "Failed to get all users")
-
-	require.Len(t, pagination.Rows, 2, "Unexpected number of users")
-}
-
-func TestGetUserByIDNotFound(t *testing.T) {
-	_, err := db.Users.GetByID(ctx, 999)
-	require.Error(t, err, "Expected error when user is not found")
+func TestConformance(t *testing.T) {
+	databasetest.Run(t, func(t *testing.T) *database.Database {
+		db, err := memory.New(context.Background())
+		require.NoError(t, err)
+		return db
+	})
 }
 
-func TestGetUserByEmailNotFound(t *testing.T) {
-	_, err := db.Users.GetByEmail(ctx, "bogus")
-	require.Error(t, err, "Expected error when user is not found")
+func TestConformancePersistent(t *testing.T) {
+	databasetest.Run(t, func(t *testing.T) *database.Database {
+		db, err := memory.Open(context.Background(), &memory.Configuration{Path: filepath.Join(t.TempDir(), "ten.db")})
+		require.NoError(t, err)
+		t.Cleanup(func() { db.Close() })
+		return db
+	})
 }
 
-func TestUpdateUserNotFound(t *testing.T) {
-	user := makeTestUser()
-	user.ID = 999
-	err := db.Users.Update(ctx, user)
-	require.Error(t, err, "Expected error when user is not found")
-}
-
-func TestDeleteUserNotFound(t *testing.T) {
-	user, err := db.Users.Delete(ctx, uint(999999))
-	require.Error(t, err, "Expected error when user is not found")
-	assert.Nil(t, user, "Expected nil user when user is not found")
-}
-
-func TestGetUserByID(t *testing.T) {
-	user := makeTestUser()
-
-	err := db.Users.Create(ctx, user)
-	require.NoError(t, err, "Failed to create user")
-
-	createdUser, err := db.Users.GetByEmail(ctx, user.Email)
-	require.NoError(t, err, "Failed to get user by Email")
-
-	userByID, err := db.Users.GetByID(ctx, createdUser.ID)
-	require.NoError(t, err, "Failed to get user by ID")
-
-	compareUsers(t, user, userByID)
+func TestSnapshot(t *testing.T) {
+	ctx := context.Background()
+	cfg := &memory.Configuration{Path: filepath.Join(t.TempDir(), "ten.db")}
+
+	db, err := memory.Open(ctx, cfg)
+	require.NoError(t, err)
+	user := &models.User{Name: "Test", Email: "test@test.com", Password: "hash", TOTPSecret: "secret"}
+	require.NoError(t, db.Users.Create(ctx, user))
+	require.NoError(t, db.TorExitNodes.DeleteAndAdd(ctx, nil, []*models.TorExitNode{{IP: "1.2.3.4", CountryCode: "DE"}}))
+	org := &models.Organization{Name: "org"}
+	require.NoError(t, db.Organizations.Create(ctx, org))
+	require.NoError(t, db.AuditLog.Create(ctx, &models.AuditEvent{Action: models.AuditUserCreate, Changes: models.AuditChanges{"Name": {After: "Test"}}}))
+	require.NoError(t, db.Close())
+
+	db, err = memory.Open(ctx, cfg)
+	require.NoError(t, err)
+	defer db.Close()
+
+	restored, err := db.Users.GetByEmail(ctx, "test@test.com")
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, restored.ID)
+	assert.Equal(t, "hash", restored.Password, "fields hidden from JSON are kept")
+	assert.Equal(t, "secret", restored.TOTPSecret)
+
+	nodes, err := db.TorExitNodes.GetAll(ctx, nil, &models.Pagination{})
+	require.NoError(t, err)
+	require.Len(t, nodes.Rows, 1)
+	assert.Equal(t, "DE", nodes.Rows.([]*models.TorExitNode)[0].CountryCode)
+
+	events, err := db.AuditLog.GetAll(ctx, &models.Pagination{})
+	require.NoError(t, err)
+	require.Len(t, events.Rows, 1)
+	assert.Equal(t, "Test", events.Rows.([]*models.AuditEvent)[0].Changes["Name"].After)
+
+	// IDs carry on from the restored rows
+	another := &models.Organization{Name: "another"}
+	require.NoError(t, db.Organizations.Create(ctx, another))
+	assert.Greater(t, another.ID, org.ID)
 }
 
-func TestUpdateUser(t *testing.T) {
-	user := makeTestUser()
-
-	err := db.Users.Create(ctx, user)
-	require.NoError(t, err, "Failed to create user")
-
-	createdUser, err := db.Users.GetByEmail(ctx, user.Email)
-	require.NoError(t, err, "Failed to get user by Email")
-
-	// Update the user's email
-	newEmail := "newemail@example.com"
-	createdUser.Email = newEmail
-
-	err = db.Users.Update(ctx, createdUser)
-	require.NoError(t, err, "Failed to update user")
-
-	// Get the user by ID
-	retrievedUser, err := db.Users.GetByID(ctx, createdUser.ID)
-	require.NoError(t, err, "Failed to get user by ID")
-
-	// Verify that the user's email was updated
-	compareUsers(t, createdUser, retrievedUser)
+func TestUserLog(t *testing.T) {
+	ctx := context.Background()
+	cfg := &memory.Configuration{Path: filepath.Join(t.TempDir(), "ten.db")}
+
+	// user changes survive without a snapshot, as after a crash
+	db, err := memory.Open(ctx, cfg)
+	require.NoError(t, err)
+	kept := &models.User{Name: "Kept", Email: "kept@test.com", Password: "hash"}
+	require.NoError(t, db.Users.Create(ctx, kept))
+	deleted := &models.User{Name: "Deleted", Email: "deleted@test.com", Password: "hash"}
+	require.NoError(t, db.Users.Create(ctx, deleted))
+	kept.Email = "renamed@test.com"
+	require.NoError(t, db.Users.Update(ctx, kept))
+	_, err = db.Users.Delete(ctx, deleted.ID)
+	require.NoError(t, err)
+	memory.Abandon(db)
+
+	// and so do the changes before an entry that was cut short
+	f, err := os.OpenFile(cfg.Path+".wal", os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{0, 0, 1})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	db, err = memory.Open(ctx, cfg)
+	require.NoError(t, err)
+	restored, err := db.Users.GetByID(ctx, kept.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "renamed@test.com", restored.Email)
+	_, err = db.Users.GetByEmail(ctx, "kept@test.com")
+	assert.Error(t, err)
+	_, err = db.Users.GetByID(ctx, deleted.ID)
+	assert.Error(t, err)
+
+	// the log can be appended to after it's been replayed
+	restored.Name = "Updated"
+	require.NoError(t, db.Users.Update(ctx, restored))
+	memory.Abandon(db)
+	db, err = memory.Open(ctx, cfg)
+	require.NoError(t, err)
+	defer db.Close()
+	restored, err = db.Users.GetByID(ctx, kept.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Updated", restored.Name)
 }
 
-func TestDeleteUser(t *testing.T) {
-	user := makeTestUser()
-	err := db.Users.Create(ctx, user)
-	require.NoError(t, err, "Failed to create user")
-
-	// Verify that the user was created successfully
-	createdUser, err := db.Users.GetByEmail(ctx, user.Email)
-	require.NoError(t, err, "Failed to get user by Email")
+func TestOpenLocks(t *testing.T) {
+	ctx := context.Background()
+	cfg := &memory.Configuration{Path: filepath.Join(t.TempDir(), "ten.db")}
 
-	// Delete the user
-	user, err = db.Users.Delete(ctx, createdUser.ID)
-	require.NoError(t, err, "Failed to delete user")
-	assert.Equal(t, user.ID, createdUser.ID, "Unexpected user ID")
+	db, err := memory.Open(ctx, cfg)
+	require.NoError(t, err)
+	_, err = memory.Open(ctx, cfg)
+	assert.ErrorContains(t, err, "in use by another process")
 
-	// Verify that the user was deleted
-	_, err = db.Users.GetByEmail(ctx, user.Email)
-	require.Error(t, err, "User was not deleted")
+	require.NoError(t, db.Close())
+	db, err = memory.Open(ctx, cfg)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
 }
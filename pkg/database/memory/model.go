@@ -0,0 +1,37 @@
+package memory
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// The tables behave like the SQL backends' gorm.Model tables: deleted rows are kept, hidden from
+// everything but unique constraints, and rows carry their creation and last update times.
+
+// deleted reports whether a row has been soft deleted.
+func deleted(m *gorm.Model) bool {
+	return m.DeletedAt.Valid
+}
+
+// softDelete marks a row deleted.
+func softDelete(m *gorm.Model, now time.Time) {
+	m.DeletedAt = gorm.DeletedAt{Time: now, Valid: true}
+}
+
+// created sets a new row's timestamps, keeping any the caller set, as GORM does.
+func created(m *gorm.Model, now time.Time) {
+	if m.CreatedAt.IsZero() {
+		m.CreatedAt = now
+	}
+	if m.UpdatedAt.IsZero() {
+		m.UpdatedAt = now
+	}
+}
+
+// updated sets an updated row's timestamps, keeping the creation time of the stored row.
+func updated(m *gorm.Model, stored *gorm.Model, now time.Time) {
+	m.CreatedAt = stored.CreatedAt
+	m.UpdatedAt = now
+	m.DeletedAt = stored.DeletedAt
+}
@@ -0,0 +1,91 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/humper/tor_exit_nodes/models"
+	"github.com/humper/tor_exit_nodes/pkg/database"
+)
+
+type organizations struct {
+	byId    map[uint]*models.Organization
+	mutex   sync.Mutex
+	counter uint
+}
+
+func organizationCopy(org *models.Organization) *models.Organization {
+	c := *org
+	return &c
+}
+
+var organizationColumns = columns[*models.Organization]{
+	"id":         func(o *models.Organization) any { return o.ID },
+	"created_at": func(o *models.Organization) any { return o.CreatedAt },
+	"updated_at": func(o *models.Organization) any { return o.UpdatedAt },
+	"name":       func(o *models.Organization) any { return o.Name },
+}
+
+func (o *organizations) GetAll(ctx context.Context, pagination *models.Pagination) (*models.Pagination, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	allOrgs := make([]*models.Organization, 0, len(o.byId))
+	for _, org := range o.byId {
+		if !deleted(&org.Model) {
+			allOrgs = append(allOrgs, org)
+		}
+	}
+	page, err := paginate(pagination, allOrgs, organizationColumns)
+	if err != nil {
+		return nil, err
+	}
+
+	orgs := make([]*models.Organization, 0, len(page))
+	for _, org := range page {
+		orgs = append(orgs, organizationCopy(org))
+	}
+	pagination.Rows = orgs
+	return pagination, nil
+}
+
+func (o *organizations) GetByID(ctx context.Context, id uint) (*models.Organization, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	org, ok := o.byId[id]
+	if !ok || deleted(&org.Model) {
+		return nil, database.ErrNotFound
+	}
+	return organizationCopy(org), nil
+}
+
+func (o *organizations) Create(ctx context.Context, org *models.Organization) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	for _, other := range o.byId {
+		if other.Name == org.Name {
+			return database.ErrConflict
+		}
+	}
+
+	o.counter++
+	org.ID = o.counter
+	created(&org.Model, time.Now())
+	o.byId[org.ID] = organizationCopy(org)
+	return nil
+}
+
+func (o *organizations) Delete(ctx context.Context, id uint) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	org, ok := o.byId[id]
+	if !ok || deleted(&org.Model) {
+		return database.ErrNotFound
+	}
+	softDelete(&org.Model, time.Now())
+	return nil
+}
@@ -0,0 +1,146 @@
+package memory
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/humper/tor_exit_nodes/models"
+)
+
+// columns maps the SQL column names of a table to a row's value in them, so that filters and sorts
+// can name them just as they do for the SQL backends.
+type columns[T any] map[string]func(row T) any
+
+// column returns the value function for a column, named in any case.
+func (c columns[T]) column(name string) (func(row T) any, error) {
+	value, ok := c[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown column %q", name)
+	}
+	return value, nil
+}
+
+// matches reports whether row has one of the filter's values in each of its columns.
+func (c columns[T]) matches(row T, filter map[string][]string) (bool, error) {
+	for name, values := range filter {
+		value, err := c.column(name)
+		if err != nil {
+			return false, err
+		}
+		if !slices.Contains(values, fmt.Sprint(value(row))) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// filter returns the rows that match filter.
+func (c columns[T]) filter(rows []T, filter map[string][]string) ([]T, error) {
+	filtered := make([]T, 0, len(rows))
+	for name := range filter {
+		if _, err := c.column(name); err != nil {
+			return nil, err
+		}
+	}
+	for _, row := range rows {
+		if ok, _ := c.matches(row, filter); ok {
+			filtered = append(filtered, row)
+		}
+	}
+	return filtered, nil
+}
+
+// sort sorts rows by an ORDER BY clause of one column and an optional asc or desc.
+func (c columns[T]) sort(rows []T, orderBy string) error {
+	fields := strings.Fields(orderBy)
+	if len(fields) == 0 || len(fields) > 2 {
+		return fmt.Errorf("unsupported sort %q", orderBy)
+	}
+	value, err := c.column(fields[0])
+	if err != nil {
+		return err
+	}
+	desc := false
+	if len(fields) == 2 {
+		switch strings.ToLower(fields[1]) {
+		case "asc":
+		case "desc":
+			desc = true
+		default:
+			return fmt.Errorf("unsupported sort %q", orderBy)
+		}
+	}
+
+	slices.SortStableFunc(rows, func(a, b T) int {
+		if desc {
+			return compare(value(b), value(a))
+		}
+		return compare(value(a), value(b))
+	})
+	return nil
+}
+
+// derefID returns the value of a nullable ID column, nil if it is NULL.
+func derefID(id *uint) any {
+	if id == nil {
+		return nil
+	}
+	return *id
+}
+
+// compare orders two values of the same column.  Missing values come first.
+func compare(a, b any) int {
+	switch a := a.(type) {
+	case nil:
+		if b == nil {
+			return 0
+		}
+		return -1
+	case uint:
+		b, ok := b.(uint)
+		if !ok {
+			return 1
+		}
+		return cmp.Compare(a, b)
+	case int64:
+		return cmp.Compare(a, b.(int64))
+	case string:
+		return cmp.Compare(a, b.(string))
+	case bool:
+		switch b := b.(bool); {
+		case a == b:
+			return 0
+		case a:
+			return 1
+		default:
+			return -1
+		}
+	case time.Time:
+		return a.Compare(b.(time.Time))
+	}
+	panic(fmt.Sprintf("can't compare %T", a))
+}
+
+// paginate filters and sorts rows as pagination asks, fills in its totals, and returns the rows on
+// its page.
+func paginate[T any](pagination *models.Pagination, rows []T, c columns[T]) ([]T, error) {
+	rows, err := c.filter(rows, pagination.Filter)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.sort(rows, pagination.GetSort()); err != nil {
+		return nil, err
+	}
+
+	totalRows := len(rows)
+	pagination.TotalRows = int64(totalRows)
+	pagination.TotalPages = int(math.Ceil(float64(totalRows) / float64(pagination.GetLimit())))
+
+	start := min(max(pagination.GetOffset(), 0), totalRows)
+	end := min(start+max(pagination.GetLimit(), 0), totalRows)
+	return rows[start:end], nil
+}
@@ -0,0 +1,287 @@
+package memory
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/humper/tor_exit_nodes/models"
+	"github.com/humper/tor_exit_nodes/pkg/util"
+)
+
+// snapshot is every table's rows, as written to the snapshot file with gob.  Gob rather than JSON
+// keeps the fields the models hide from API responses, such as password hashes.
+type snapshot struct {
+	Users         []*models.User
+	TorExitNodes  []*models.TorExitNode
+	APIKeys       []*models.APIKey
+	Sessions      []*models.Session
+	LoginAudits   []*models.LoginAudit
+	Organizations []*models.Organization
+	AuditEvents   []*models.AuditEvent
+}
+
+// rows returns copies of a table's rows in ID order, so unchanged tables snapshot identically.
+func rows[K comparable, T any](table map[K]*T, mutex *sync.Mutex, copy func(*T) *T, id func(*T) uint) []*T {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	rows := make([]*T, 0, len(table))
+	for _, row := range table {
+		rows = append(rows, copy(row))
+	}
+	slices.SortFunc(rows, func(a, b *T) int { return cmp.Compare(id(a), id(b)) })
+	return rows
+}
+
+// restoreRows stores rows in table and returns the highest ID among them, for the table's counter.
+func restoreRows[K comparable, T any](table map[K]*T, rows []*T, key func(*T) K, id func(*T) uint) uint {
+	var maxID uint
+	for _, row := range rows {
+		table[key(row)] = row
+		maxID = max(maxID, id(row))
+	}
+	return maxID
+}
+
+// snapshot copies every table.  The caller must hold the users mutex, which keeps the snapshot
+// consistent with the user log.
+func (t *tables) snapshot() *snapshot {
+	users := make([]*models.User, 0, len(t.users.byId))
+	for _, user := range t.users.byId {
+		users = append(users, userCopy(user))
+	}
+	slices.SortFunc(users, func(a, b *models.User) int { return cmp.Compare(a.ID, b.ID) })
+	return &snapshot{
+		Users:         users,
+		TorExitNodes:  rows(t.torExitNodes.nodes, &t.torExitNodes.mutex, copyExitNode, func(n *models.TorExitNode) uint { return n.ID }),
+		APIKeys:       rows(t.apiKeys.byId, &t.apiKeys.mutex, apiKeyCopy, func(k *models.APIKey) uint { return k.ID }),
+		Sessions:      rows(t.sessions.byId, &t.sessions.mutex, sessionCopy, func(s *models.Session) uint { return s.ID }),
+		LoginAudits:   rows(t.loginAudits.byId, &t.loginAudits.mutex, loginAuditCopy, func(a *models.LoginAudit) uint { return a.ID }),
+		Organizations: rows(t.organizations.byId, &t.organizations.mutex, organizationCopy, func(o *models.Organization) uint { return o.ID }),
+		AuditEvents:   rows(t.auditLog.byId, &t.auditLog.mutex, auditEventCopy, func(e *models.AuditEvent) uint { return e.ID }),
+	}
+}
+
+// restore loads the snapshot at path into the empty tables, if it exists.
+func (t *tables) restore(path string) error {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var s snapshot
+	if err := gob.NewDecoder(bufio.NewReader(f)).Decode(&s); err != nil {
+		return fmt.Errorf("reading snapshot %s: %w", path, err)
+	}
+
+	for _, user := range s.Users {
+		t.users.put(user)
+	}
+	t.torExitNodes.counter = restoreRows(t.torExitNodes.nodes, s.TorExitNodes,
+		func(n *models.TorExitNode) string { return n.IP }, func(n *models.TorExitNode) uint { return n.ID })
+	t.apiKeys.counter = restoreRows(t.apiKeys.byId, s.APIKeys,
+		func(k *models.APIKey) uint { return k.ID }, func(k *models.APIKey) uint { return k.ID })
+	t.sessions.counter = restoreRows(t.sessions.byId, s.Sessions,
+		func(s *models.Session) uint { return s.ID }, func(s *models.Session) uint { return s.ID })
+	t.loginAudits.counter = restoreRows(t.loginAudits.byId, s.LoginAudits,
+		func(a *models.LoginAudit) uint { return a.ID }, func(a *models.LoginAudit) uint { return a.ID })
+	t.organizations.counter = restoreRows(t.organizations.byId, s.Organizations,
+		func(o *models.Organization) uint { return o.ID }, func(o *models.Organization) uint { return o.ID })
+	t.auditLog.counter = restoreRows(t.auditLog.byId, s.AuditEvents,
+		func(e *models.AuditEvent) uint { return e.ID }, func(e *models.AuditEvent) uint { return e.ID })
+	return nil
+}
+
+// lockFiles takes an exclusive lock on path+".lock", so that no other process can open the
+// database at path and overwrite its snapshots and log.  The lock is held until the returned file
+// is closed or the process exits.
+func lockFiles(path string) (*os.File, error) {
+	file, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		file.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, fmt.Errorf("memory database %s is in use by another process", path)
+		}
+		return nil, fmt.Errorf("locking memory database %s: %w", path, err)
+	}
+	return file, nil
+}
+
+// persistence writes snapshots periodically and when the database is closed.
+type persistence struct {
+	tables *tables
+	path   string
+	log    *userLog
+	lock   *os.File
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+func newPersistence(t *tables, path string, log *userLog, lock *os.File) *persistence {
+	return &persistence{
+		tables: t,
+		path:   path,
+		log:    log,
+		lock:   lock,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+func (p *persistence) run(interval time.Duration) {
+	defer close(p.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := p.snapshot(); err != nil {
+				slog.Error("Failed to write memory database snapshot", "error", err, "path", p.path)
+			}
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// snapshot replaces the snapshot file with the tables' current rows, and then empties the user
+// log, whose changes the snapshot now holds.
+func (p *persistence) snapshot() error {
+	p.tables.users.mutex.Lock()
+	defer p.tables.users.mutex.Unlock()
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(p.tables.snapshot()); err != nil {
+		return err
+	}
+	changed, err := util.WriteFileIfChanged(p.path, buf.Bytes(), 0600)
+	if err != nil {
+		return err
+	}
+	// the rename must be on disk before the log it replaces is emptied
+	if changed {
+		if err := util.SyncDir(filepath.Dir(p.path)); err != nil {
+			return err
+		}
+	}
+	return p.log.truncate()
+}
+
+// Close stops the periodic snapshots, writes a final one and releases the lock on the files.
+func (p *persistence) Close() error {
+	close(p.stop)
+	<-p.done
+	err := p.snapshot()
+	return errors.Join(err, p.log.close(), p.lock.Close())
+}
+
+// userLogEntry is one change to the users table: a user's new state.  Deleted users are kept, marked
+// deleted, so there is no separate entry for deletions.
+type userLogEntry struct {
+	User *models.User
+}
+
+// userLog is an append-only file of changes to users since the last snapshot.  Each entry is a
+// 4-byte big-endian length followed by the entry, gob encoded on its own so that the log can be
+// appended to after a restart.
+type userLog struct {
+	file *os.File
+}
+
+// openUserLog replays the log at path into u and opens it for appending.  An entry cut short by a
+// crash is dropped, as its change was never acknowledged.
+func openUserLog(path string, u *users) (*userLog, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	r := bufio.NewReader(file)
+	var good int64
+	for {
+		entry, n, err := readUserLogEntry(r)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("reading user log %s: %w", path, err)
+		}
+		u.put(entry.User)
+		good += n
+	}
+
+	if err := file.Truncate(good); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if _, err := file.Seek(good, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &userLog{file: file}, nil
+}
+
+// readUserLogEntry reads the next entry and returns it and how many bytes it took.
+func readUserLogEntry(r io.Reader) (*userLogEntry, int64, error) {
+	var length uint32
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return nil, 0, err
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, 0, err
+	}
+	var entry userLogEntry
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&entry); err != nil {
+		return nil, 0, err
+	}
+	return &entry, int64(4 + length), nil
+}
+
+// append writes entry to the log and waits for it to reach the disk.
+func (l *userLog) append(entry *userLogEntry) error {
+	var data bytes.Buffer
+	if err := gob.NewEncoder(&data).Encode(entry); err != nil {
+		return err
+	}
+	buf := binary.BigEndian.AppendUint32(nil, uint32(data.Len()))
+	if _, err := l.file.Write(append(buf, data.Bytes()...)); err != nil {
+		return err
+	}
+	return l.file.Sync()
+}
+
+// truncate empties the log.
+func (l *userLog) truncate() error {
+	if err := l.file.Truncate(0); err != nil {
+		return err
+	}
+	_, err := l.file.Seek(0, io.SeekStart)
+	return err
+}
+
+func (l *userLog) close() error {
+	return l.file.Close()
+}
@@ -0,0 +1,148 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/humper/tor_exit_nodes/models"
+	"github.com/humper/tor_exit_nodes/pkg/database"
+)
+
+type sessions struct {
+	byId    map[uint]*models.Session
+	mutex   sync.Mutex
+	counter uint
+}
+
+func sessionCopy(session *models.Session) *models.Session {
+	c := *session
+	return &c
+}
+
+func (s *sessions) Create(ctx context.Context, session *models.Session) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, other := range s.byId {
+		if other.RefreshHash == session.RefreshHash {
+			return database.ErrConflict
+		}
+	}
+
+	s.counter++
+	session.ID = s.counter
+	created(&session.Model, time.Now())
+	s.byId[session.ID] = sessionCopy(session)
+	return nil
+}
+
+func (s *sessions) GetByID(ctx context.Context, id uint) (*models.Session, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	session, ok := s.byId[id]
+	if !ok || deleted(&session.Model) {
+		return nil, database.ErrNotFound
+	}
+	return sessionCopy(session), nil
+}
+
+func (s *sessions) GetByRefreshHash(ctx context.Context, hash string) (*models.Session, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, session := range s.byId {
+		if session.RefreshHash == hash && !deleted(&session.Model) {
+			return sessionCopy(session), nil
+		}
+	}
+	return nil, database.ErrNotFound
+}
+
+func (s *sessions) GetByPreviousRefreshHash(ctx context.Context, hash string) (*models.Session, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, session := range s.byId {
+		if session.PreviousRefreshHash == hash && !deleted(&session.Model) {
+			return sessionCopy(session), nil
+		}
+	}
+	return nil, database.ErrNotFound
+}
+
+func (s *sessions) GetByUser(ctx context.Context, userID uint) ([]*models.Session, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	sessions := []*models.Session{}
+	for _, session := range s.byId {
+		if session.UserID == userID && !deleted(&session.Model) {
+			sessions = append(sessions, sessionCopy(session))
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].ID < sessions[j].ID
+	})
+	return sessions, nil
+}
+
+func (s *sessions) Update(ctx context.Context, session *models.Session) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.update(session, func(*models.Session) bool { return true })
+}
+
+func (s *sessions) Rotate(ctx context.Context, session *models.Session, oldHash string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	session.PreviousRefreshHash = oldHash
+	return s.update(session, func(stored *models.Session) bool { return stored.RefreshHash == oldHash })
+}
+
+// update replaces the stored session if current accepts it.  The caller must hold the mutex.
+func (s *sessions) update(session *models.Session, current func(stored *models.Session) bool) error {
+	stored, ok := s.byId[session.ID]
+	if !ok || deleted(&stored.Model) || !current(stored) {
+		return database.ErrNotFound
+	}
+	for _, other := range s.byId {
+		if other.RefreshHash == session.RefreshHash && other.ID != session.ID {
+			return database.ErrConflict
+		}
+	}
+	sessionToUpdate := sessionCopy(session)
+	updated(&sessionToUpdate.Model, &stored.Model, time.Now())
+	session.Model = sessionToUpdate.Model
+	s.byId[session.ID] = sessionToUpdate
+	return nil
+}
+
+func (s *sessions) Delete(ctx context.Context, id uint) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	session, ok := s.byId[id]
+	if !ok || deleted(&session.Model) {
+		return database.ErrNotFound
+	}
+	softDelete(&session.Model, time.Now())
+	return nil
+}
+
+func (s *sessions) DeleteByUser(ctx context.Context, userID uint) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	for _, session := range s.byId {
+		if session.UserID == userID && !deleted(&session.Model) {
+			softDelete(&session.Model, now)
+		}
+	}
+	return nil
+}
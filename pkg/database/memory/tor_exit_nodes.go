@@ -2,90 +2,136 @@ package memory
 
 import (
 	"context"
-	"math"
+	"fmt"
+	"slices"
 	"sort"
 	"sync"
+	"time"
 
 	mapset "github.com/deckarep/golang-set/v2"
 	"github.com/humper/tor_exit_nodes/models"
-	"gorm.io/gorm"
+	"github.com/humper/tor_exit_nodes/pkg/database"
 )
 
 type torExitNodes struct {
-	nodes map[string]*models.TorExitNode
-	mutex sync.Mutex
+	nodes   map[string]*models.TorExitNode
+	mutex   sync.Mutex
+	counter uint
+}
+
+var torExitNodeColumns = columns[*models.TorExitNode]{
+	"id":           func(n *models.TorExitNode) any { return n.ID },
+	"created_at":   func(n *models.TorExitNode) any { return n.CreatedAt },
+	"updated_at":   func(n *models.TorExitNode) any { return n.UpdatedAt },
+	"ip":           func(n *models.TorExitNode) any { return n.IP },
+	"country_name": func(n *models.TorExitNode) any { return n.CountryName },
+	"country_code": func(n *models.TorExitNode) any { return n.CountryCode },
 }
 
 func copyExitNode(node *models.TorExitNode) *models.TorExitNode {
 	return &models.TorExitNode{
-		Model:       gorm.Model{ID: node.ID},
-		IP:          node.IP,
-		CountryCode: node.CountryCode,
-		CountryName: node.CountryName,
+		Model:        node.Model,
+		IP:           node.IP,
+		CountryCode:  node.CountryCode,
+		CountryName:  node.CountryName,
+		ExitPolicy:   node.ExitPolicy,
+		ExitPolicyV6: node.ExitPolicyV6,
 	}
 }
 
-func (t *torExitNodes) GetAll(ctx context.Context, excludedIPs []string, pagination *models.Pagination) (*models.Pagination, error) {
-	t.mutex.Lock()
-	defer t.mutex.Unlock()
+// filterNodes returns the stored nodes matching filter and not in excludedIPs, in ID order.
+// The caller must hold the mutex.
+func (t *torExitNodes) filterNodes(excludedIPs []string, filter map[string][]string) ([]*models.TorExitNode, error) {
+	exclusionSet := mapset.NewSet[string]()
+	exclusionSet.Append(excludedIPs...)
 
 	allNodes := []*models.TorExitNode{}
 	for _, node := range t.nodes {
-		allNodes = append(allNodes, node)
+		if !exclusionSet.Contains(node.IP) {
+			allNodes = append(allNodes, node)
+		}
 	}
 	sort.Slice(allNodes, func(i, j int) bool {
 		return allNodes[i].ID < allNodes[j].ID
 	})
+	return torExitNodeColumns.filter(allNodes, filter)
+}
 
-	exclusionSet := mapset.NewSet[string]()
-	exclusionSet.Append(excludedIPs...)
+func (t *torExitNodes) GetAll(ctx context.Context, excludedIPs []string, pagination *models.Pagination) (*models.Pagination, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
 
-	filteredNodes := []*models.TorExitNode{}
-NODELOOP:
-	for _, node := range allNodes {
-		for column, filter := range pagination.Filter {
-			if column == "country_code" && len(filter) > 0 {
-				countrySet := mapset.NewSet[string]()
-				countrySet.Append(filter...)
-				if !countrySet.Contains(node.CountryCode) {
-					continue NODELOOP
-				}
-			}
-		}
-		filteredNodes = append(filteredNodes, node)
+	filteredNodes, err := t.filterNodes(excludedIPs, nil)
+	if err != nil {
+		return nil, err
+	}
+	page, err := paginate(pagination, filteredNodes, torExitNodeColumns)
+	if err != nil {
+		return nil, err
 	}
-	data := make([]*models.TorExitNode, 0, pagination.GetLimit())
-
-	totalRows := len(filteredNodes)
 
-	pagination.TotalRows = int64(totalRows)
-	totalPages := int(math.Ceil(float64(totalRows) / float64(pagination.GetLimit())))
-	pagination.TotalPages = totalPages
+	data := make([]*models.TorExitNode, 0, len(page))
+	for _, node := range page {
+		data = append(data, copyExitNode(node))
+	}
+	pagination.Rows = data
+	return pagination, nil
+}
 
-	start := pagination.GetOffset()
-	end := start + pagination.GetLimit()
-	if end > totalRows {
-		end = totalRows
+func (t *torExitNodes) Iterate(ctx context.Context, excludedIPs []string, filter map[string][]string, fn func(node *models.TorExitNode) error) error {
+	for key := range filter {
+		if !slices.Contains(models.TorExitNodeFilterColumns, key) {
+			return fmt.Errorf("unsupported filter column %q", key)
+		}
 	}
 
-	for i := start; i < end; i++ {
-		data = append(data, copyExitNode(filteredNodes[i]))
+	t.mutex.Lock()
+	nodes, err := t.filterNodes(excludedIPs, filter)
+	t.mutex.Unlock()
+	if err != nil {
+		return err
 	}
 
-	pagination.Rows = data
-	return pagination, nil
+	// stored nodes are replaced rather than modified, so they are safe to read without the lock
+	for _, node := range nodes {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(copyExitNode(node)); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
+// DeleteAndAdd removes nodes for good, like the SQL backends, rather than soft deleting them, so
+// their IPs can be added again.  Nothing changes if an added IP is already present.
 func (t *torExitNodes) DeleteAndAdd(ctx context.Context, nodes_to_delete []models.TorExitNode, nodes_to_add []*models.TorExitNode) error {
 	t.mutex.Lock()
 	defer t.mutex.Unlock()
 
+	remaining := mapset.NewSet[string]()
+	for ip := range t.nodes {
+		remaining.Add(ip)
+	}
+	for _, node := range nodes_to_delete {
+		remaining.Remove(node.IP)
+	}
+	for _, node := range nodes_to_add {
+		if !remaining.Add(node.IP) {
+			return database.ErrConflict
+		}
+	}
+
 	for _, node := range nodes_to_delete {
 		delete(t.nodes, node.IP)
 	}
+	now := time.Now()
 	for _, node := range nodes_to_add {
+		t.counter++
+		node.ID = t.counter
+		created(&node.Model, now)
 		t.nodes[node.IP] = copyExitNode(node)
-		t.nodes[node.IP].ID = uint(len(t.nodes))
 	}
 	return nil
 }
@@ -94,11 +140,17 @@ func (t *torExitNodes) GetMissingCountries(ctx context.Context, batchSize int) (
 	t.mutex.Lock()
 	defer t.mutex.Unlock()
 
-	nodes := make([]*models.TorExitNode, 0, batchSize)
-	for _, node := range t.nodes {
-		if node.CountryName == "" {
-			nodes = append(nodes, copyExitNode(node))
-		}
+	missing, err := t.filterNodes(nil, map[string][]string{"country_name": {""}})
+	if err != nil {
+		return nil, err
+	}
+	// like SQL's LIMIT, a negative batch size means no limit
+	if batchSize >= 0 && batchSize < len(missing) {
+		missing = missing[:batchSize]
+	}
+	nodes := make([]*models.TorExitNode, 0, len(missing))
+	for _, node := range missing {
+		nodes = append(nodes, copyExitNode(node))
 	}
 	return nodes, nil
 }
@@ -107,8 +159,16 @@ func (t *torExitNodes) Update(ctx context.Context, nodes []*models.TorExitNode)
 	t.mutex.Lock()
 	defer t.mutex.Unlock()
 
+	now := time.Now()
 	for _, node := range nodes {
-		t.nodes[node.IP] = copyExitNode(node)
+		nodeToUpdate := copyExitNode(node)
+		if stored, ok := t.nodes[node.IP]; ok {
+			updated(&nodeToUpdate.Model, &stored.Model, now)
+		} else {
+			created(&nodeToUpdate.Model, now)
+		}
+		node.Model = nodeToUpdate.Model
+		t.nodes[node.IP] = nodeToUpdate
 	}
 	return nil
 }
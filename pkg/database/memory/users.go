@@ -2,60 +2,86 @@ package memory
 
 import (
 	"context"
-	"math"
 	"slices"
 	"sync"
+	"time"
 
 	"github.com/humper/tor_exit_nodes/models"
-	"gorm.io/gorm"
+	"github.com/humper/tor_exit_nodes/pkg/database"
 )
 
 func userCopy(user *models.User) *models.User {
 	return &models.User{
-		Model:      gorm.Model{ID: user.ID},
+		Model:      user.Model,
 		Role:       user.Role,
 		Name:       user.Name,
 		Email:      user.Email,
 		Password:   user.Password,
 		AllowedIPs: slices.Clone(user.AllowedIPs),
+
+		EmailVerified:  user.EmailVerified,
+		OrganizationID: user.OrganizationID,
+		OIDCSubject:    user.OIDCSubject,
+
+		TOTPSecret:    user.TOTPSecret,
+		TOTPEnabled:   user.TOTPEnabled,
+		TOTPLastStep:  user.TOTPLastStep,
+		RecoveryCodes: slices.Clone(user.RecoveryCodes),
 	}
 }
 
+var userColumns = columns[*models.User]{
+	"id":              func(u *models.User) any { return u.ID },
+	"created_at":      func(u *models.User) any { return u.CreatedAt },
+	"updated_at":      func(u *models.User) any { return u.UpdatedAt },
+	"name":            func(u *models.User) any { return u.Name },
+	"email":           func(u *models.User) any { return u.Email },
+	"role":            func(u *models.User) any { return u.Role },
+	"email_verified":  func(u *models.User) any { return u.EmailVerified },
+	"totp_enabled":    func(u *models.User) any { return u.TOTPEnabled },
+	"organization_id": func(u *models.User) any { return u.OrganizationID },
+}
+
+// users is keyed by ID and by email address.  Deleted users stay in both, so that their addresses
+// can't be reused, as with the SQL backends' unique constraint.
 type users struct {
-	byEmail          map[string]*models.User
-	byId             map[uint]*models.User
-	mutex            sync.Mutex
-	allowlistCounter uint
+	byEmail map[string]*models.User
+	byId    map[uint]*models.User
+	mutex   sync.Mutex
+	counter uint
+	// log, if set, records every change so that it survives until the next snapshot.
+	log *userLog
 }
 
 func (u *users) GetAll(ctx context.Context, pagination *models.Pagination) (*models.Pagination, error) {
+	return u.getAll(pagination, func(user *models.User) bool { return true })
+}
+
+func (u *users) GetAllByOrganization(ctx context.Context, orgID uint, pagination *models.Pagination) (*models.Pagination, error) {
+	return u.getAll(pagination, func(user *models.User) bool { return user.OrganizationID == orgID })
+}
+
+func (u *users) getAll(pagination *models.Pagination, include func(*models.User) bool) (*models.Pagination, error) {
 	u.mutex.Lock()
 	defer u.mutex.Unlock()
 
 	allUsers := []*models.User{}
-	for _, user := range u.byEmail {
-		allUsers = append(allUsers, userCopy(user))
+	for _, user := range u.byId {
+		if !deleted(&user.Model) && include(user) {
+			allUsers = append(allUsers, user)
+		}
 	}
 
-	users := make([]*models.User, 0, pagination.GetLimit())
-
-	totalUsers := len(allUsers)
-	pagination.TotalRows = int64(totalUsers)
-	totalPages := int(math.Ceil(float64(totalUsers) / float64(pagination.GetLimit())))
-	pagination.TotalPages = totalPages
-
-	start := pagination.GetOffset()
-	end := start + pagination.GetLimit()
-	if end > totalUsers {
-		end = totalUsers
+	page, err := paginate(pagination, allUsers, userColumns)
+	if err != nil {
+		return nil, err
 	}
-
-	for i := start; i < end; i++ {
-		users = append(users, allUsers[i])
+	users := make([]*models.User, 0, len(page))
+	for _, user := range page {
+		users = append(users, userCopy(user))
 	}
 	pagination.Rows = users
 	return pagination, nil
-
 }
 
 func (u *users) GetByEmail(ctx context.Context, email string) (*models.User, error) {
@@ -63,8 +89,8 @@ func (u *users) GetByEmail(ctx context.Context, email string) (*models.User, err
 	defer u.mutex.Unlock()
 
 	user, ok := u.byEmail[email]
-	if !ok {
-		return nil, gorm.ErrRecordNotFound
+	if !ok || deleted(&user.Model) {
+		return nil, database.ErrNotFound
 	}
 
 	return userCopy(user), nil
@@ -75,8 +101,8 @@ func (u *users) GetByID(ctx context.Context, id uint) (*models.User, error) {
 	defer u.mutex.Unlock()
 
 	user, ok := u.byId[id]
-	if !ok {
-		return nil, gorm.ErrRecordNotFound
+	if !ok || deleted(&user.Model) {
+		return nil, database.ErrNotFound
 	}
 
 	return userCopy(user), nil
@@ -86,12 +112,18 @@ func (u *users) Create(ctx context.Context, user *models.User) error {
 	u.mutex.Lock()
 	defer u.mutex.Unlock()
 
-	userToCreate := userCopy(user)
-	userToCreate.ID = uint(len(u.byEmail) + 1)
-
-	u.byEmail[user.Email] = userToCreate
-	u.byId[userToCreate.ID] = userToCreate
+	if _, ok := u.byEmail[user.Email]; ok {
+		return database.ErrConflict
+	}
 
+	userToCreate := userCopy(user)
+	userToCreate.ID = u.counter + 1
+	created(&userToCreate.Model, time.Now())
+	if err := u.logChange(userToCreate); err != nil {
+		return err
+	}
+	u.put(userToCreate)
+	user.Model = userToCreate.Model
 	return nil
 }
 
@@ -99,14 +131,21 @@ func (u *users) Update(ctx context.Context, user *models.User) error {
 	u.mutex.Lock()
 	defer u.mutex.Unlock()
 
-	_, ok := u.byId[user.ID]
-	if !ok {
-		return gorm.ErrRecordNotFound
+	stored, ok := u.byId[user.ID]
+	if !ok || deleted(&stored.Model) {
+		return database.ErrNotFound
+	}
+	if other, ok := u.byEmail[user.Email]; ok && other.ID != user.ID {
+		return database.ErrConflict
 	}
 
 	userToUpdate := userCopy(user)
-	u.byEmail[user.Email] = userToUpdate
-	u.byId[user.ID] = userToUpdate
+	updated(&userToUpdate.Model, &stored.Model, time.Now())
+	if err := u.logChange(userToUpdate); err != nil {
+		return err
+	}
+	u.put(userToUpdate)
+	user.Model = userToUpdate.Model
 	return nil
 }
 
@@ -115,10 +154,33 @@ func (u *users) Delete(ctx context.Context, id uint) (*models.User, error) {
 	defer u.mutex.Unlock()
 
 	user, ok := u.byId[id]
-	if !ok {
-		return nil, gorm.ErrRecordNotFound
+	if !ok || deleted(&user.Model) {
+		return nil, database.ErrNotFound
+	}
+	userToDelete := userCopy(user)
+	softDelete(&userToDelete.Model, time.Now())
+	if err := u.logChange(userToDelete); err != nil {
+		return nil, err
+	}
+	u.put(userToDelete)
+	return userCopy(user), nil
+}
+
+// logChange writes a user's new state to the user log, if there is one, before it's stored.  The
+// caller must hold the mutex.
+func (u *users) logChange(user *models.User) error {
+	if u.log == nil {
+		return nil
+	}
+	return u.log.append(&userLogEntry{User: user})
+}
+
+// put stores user, replacing any user with its ID.  The caller must hold the mutex.
+func (u *users) put(user *models.User) {
+	if old, ok := u.byId[user.ID]; ok && old.Email != user.Email {
+		delete(u.byEmail, old.Email)
 	}
-	delete(u.byEmail, user.Email)
-	delete(u.byId, user.ID)
-	return user, nil
+	u.byEmail[user.Email] = user
+	u.byId[user.ID] = user
+	u.counter = max(u.counter, user.ID)
 }
@@ -0,0 +1,111 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: github.com/humper/tor_exit_nodes/pkg/database (interfaces: LoginAudits)
+
+// Package mock_database is a generated GoMock package.
+package mock_database
+
+import (
+	context "context"
+	reflect "reflect"
+	time "time"
+
+	gomock "github.com/golang/mock/gomock"
+	models "github.com/humper/tor_exit_nodes/models"
+)
+
+// MockLoginAudits is a mock of LoginAudits interface.
+type MockLoginAudits struct {
+	ctrl     *gomock.Controller
+	recorder *MockLoginAuditsMockRecorder
+}
+
+// MockLoginAuditsMockRecorder is the mock recorder for MockLoginAudits.
+type MockLoginAuditsMockRecorder struct {
+	mock *MockLoginAudits
+}
+
+// NewMockLoginAudits creates a new mock instance.
+func NewMockLoginAudits(ctrl *gomock.Controller) *MockLoginAudits {
+	mock := &MockLoginAudits{ctrl: ctrl}
+	mock.recorder = &MockLoginAuditsMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockLoginAudits) EXPECT() *MockLoginAuditsMockRecorder {
+	return m.recorder
+}
+
+// CountFailuresByEmail mocks base method.
+func (m *MockLoginAudits) CountFailuresByEmail(arg0 context.Context, arg1 string, arg2 time.Time) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CountFailuresByEmail", arg0, arg1, arg2)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CountFailuresByEmail indicates an expected call of CountFailuresByEmail.
+func (mr *MockLoginAuditsMockRecorder) CountFailuresByEmail(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CountFailuresByEmail", reflect.TypeOf((*MockLoginAudits)(nil).CountFailuresByEmail), arg0, arg1, arg2)
+}
+
+// CountFailuresByIP mocks base method.
+func (m *MockLoginAudits) CountFailuresByIP(arg0 context.Context, arg1 string, arg2 time.Time) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CountFailuresByIP", arg0, arg1, arg2)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CountFailuresByIP indicates an expected call of CountFailuresByIP.
+func (mr *MockLoginAuditsMockRecorder) CountFailuresByIP(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CountFailuresByIP", reflect.TypeOf((*MockLoginAudits)(nil).CountFailuresByIP), arg0, arg1, arg2)
+}
+
+// Create mocks base method.
+func (m *MockLoginAudits) Create(arg0 context.Context, arg1 *models.LoginAudit) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Create", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Create indicates an expected call of Create.
+func (mr *MockLoginAuditsMockRecorder) Create(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Create", reflect.TypeOf((*MockLoginAudits)(nil).Create), arg0, arg1)
+}
+
+// GetAll mocks base method.
+func (m *MockLoginAudits) GetAll(arg0 context.Context, arg1 *models.Pagination) (*models.Pagination, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetAll", arg0, arg1)
+	ret0, _ := ret[0].(*models.Pagination)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetAll indicates an expected call of GetAll.
+func (mr *MockLoginAuditsMockRecorder) GetAll(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAll", reflect.TypeOf((*MockLoginAudits)(nil).GetAll), arg0, arg1)
+}
+
+// GetAllByOrganization mocks base method.
+func (m *MockLoginAudits) GetAllByOrganization(arg0 context.Context, arg1 uint, arg2 *models.Pagination) (*models.Pagination, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetAllByOrganization", arg0, arg1, arg2)
+	ret0, _ := ret[0].(*models.Pagination)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetAllByOrganization indicates an expected call of GetAllByOrganization.
+func (mr *MockLoginAuditsMockRecorder) GetAllByOrganization(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAllByOrganization", reflect.TypeOf((*MockLoginAudits)(nil).GetAllByOrganization), arg0, arg1, arg2)
+}
@@ -0,0 +1,94 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: github.com/humper/tor_exit_nodes/pkg/database (interfaces: Organizations)
+
+// Package mock_database is a generated GoMock package.
+package mock_database
+
+import (
+	context "context"
+	reflect "reflect"
+
+	gomock "github.com/golang/mock/gomock"
+	models "github.com/humper/tor_exit_nodes/models"
+)
+
+// MockOrganizations is a mock of Organizations interface.
+type MockOrganizations struct {
+	ctrl     *gomock.Controller
+	recorder *MockOrganizationsMockRecorder
+}
+
+// MockOrganizationsMockRecorder is the mock recorder for MockOrganizations.
+type MockOrganizationsMockRecorder struct {
+	mock *MockOrganizations
+}
+
+// NewMockOrganizations creates a new mock instance.
+func NewMockOrganizations(ctrl *gomock.Controller) *MockOrganizations {
+	mock := &MockOrganizations{ctrl: ctrl}
+	mock.recorder = &MockOrganizationsMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockOrganizations) EXPECT() *MockOrganizationsMockRecorder {
+	return m.recorder
+}
+
+// Create mocks base method.
+func (m *MockOrganizations) Create(arg0 context.Context, arg1 *models.Organization) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Create", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Create indicates an expected call of Create.
+func (mr *MockOrganizationsMockRecorder) Create(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Create", reflect.TypeOf((*MockOrganizations)(nil).Create), arg0, arg1)
+}
+
+// Delete mocks base method.
+func (m *MockOrganizations) Delete(arg0 context.Context, arg1 uint) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Delete", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Delete indicates an expected call of Delete.
+func (mr *MockOrganizationsMockRecorder) Delete(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockOrganizations)(nil).Delete), arg0, arg1)
+}
+
+// GetAll mocks base method.
+func (m *MockOrganizations) GetAll(arg0 context.Context, arg1 *models.Pagination) (*models.Pagination, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetAll", arg0, arg1)
+	ret0, _ := ret[0].(*models.Pagination)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetAll indicates an expected call of GetAll.
+func (mr *MockOrganizationsMockRecorder) GetAll(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAll", reflect.TypeOf((*MockOrganizations)(nil).GetAll), arg0, arg1)
+}
+
+// GetByID mocks base method.
+func (m *MockOrganizations) GetByID(arg0 context.Context, arg1 uint) (*models.Organization, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetByID", arg0, arg1)
+	ret0, _ := ret[0].(*models.Organization)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetByID indicates an expected call of GetByID.
+func (mr *MockOrganizationsMockRecorder) GetByID(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetByID", reflect.TypeOf((*MockOrganizations)(nil).GetByID), arg0, arg1)
+}
@@ -79,6 +79,20 @@ func (mr *MockTorExitNodesMockRecorder) GetMissingCountries(arg0, arg1 interface
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetMissingCountries", reflect.TypeOf((*MockTorExitNodes)(nil).GetMissingCountries), arg0, arg1)
 }
 
+// Iterate mocks base method.
+func (m *MockTorExitNodes) Iterate(arg0 context.Context, arg1 []string, arg2 map[string][]string, arg3 func(*models.TorExitNode) error) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Iterate", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Iterate indicates an expected call of Iterate.
+func (mr *MockTorExitNodesMockRecorder) Iterate(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Iterate", reflect.TypeOf((*MockTorExitNodes)(nil).Iterate), arg0, arg1, arg2, arg3)
+}
+
 // Update mocks base method.
 func (m *MockTorExitNodes) Update(arg0 context.Context, arg1 []*models.TorExitNode) error {
 	m.ctrl.T.Helper()
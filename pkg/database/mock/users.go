@@ -79,6 +79,21 @@ func (mr *MockUsersMockRecorder) GetAll(arg0, arg1 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAll", reflect.TypeOf((*MockUsers)(nil).GetAll), arg0, arg1)
 }
 
+// GetAllByOrganization mocks base method.
+func (m *MockUsers) GetAllByOrganization(arg0 context.Context, arg1 uint, arg2 *models.Pagination) (*models.Pagination, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetAllByOrganization", arg0, arg1, arg2)
+	ret0, _ := ret[0].(*models.Pagination)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetAllByOrganization indicates an expected call of GetAllByOrganization.
+func (mr *MockUsersMockRecorder) GetAllByOrganization(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAllByOrganization", reflect.TypeOf((*MockUsers)(nil).GetAllByOrganization), arg0, arg1, arg2)
+}
+
 // GetByEmail mocks base method.
 func (m *MockUsers) GetByEmail(arg0 context.Context, arg1 string) (*models.User, error) {
 	m.ctrl.T.Helper()
@@ -0,0 +1,14 @@
+package database
+
+import (
+	"context"
+
+	"github.com/humper/tor_exit_nodes/models"
+)
+
+type Organizations interface {
+	GetAll(ctx context.Context, pagination *models.Pagination) (*models.Pagination, error)
+	GetByID(ctx context.Context, id uint) (*models.Organization, error)
+	Create(ctx context.Context, org *models.Organization) error
+	Delete(ctx context.Context, id uint) error
+}
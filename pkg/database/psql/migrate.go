@@ -0,0 +1,231 @@
+package psql
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"slices"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockID is the Postgres advisory lock held while migrating, so that when several replicas
+// start at once only one of them migrates and the others wait for it.
+const migrationLockID = 7_468_514_209_211_430_001
+
+// createMigrationsTable records which migrations have been applied.
+const createMigrationsTable = `CREATE TABLE IF NOT EXISTS "schema_migrations" (
+	"version" bigint PRIMARY KEY,
+	"name" text NOT NULL,
+	"applied_at" timestamptz NOT NULL DEFAULT now()
+)`
+
+var migrationFileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration is one versioned change to the schema, read from migrations/NNNN_name.up.sql and its
+// matching .down.sql.
+type Migration struct {
+	Version int
+	Name    string
+	// Up applies the migration and Down reverts it.  Each runs in a transaction of its own.
+	Up   string
+	Down string
+}
+
+// MigrationStatus is a migration and when it was applied, nil if it hasn't been.
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// Migrations returns the migrations built into the binary, oldest first.
+func Migrations() ([]Migration, error) {
+	return loadMigrations(migrationFiles, "migrations")
+}
+
+// loadMigrations reads the migrations in dir, checking each has both scripts and that no two share
+// a version.
+func loadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		match := migrationFileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("migration %s isn't named NNNN_name.up.sql or NNNN_name.down.sql", entry.Name())
+		}
+		version, err := strconv.Atoi(match[1])
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: %w", entry.Name(), err)
+		}
+		script, err := fs.ReadFile(fsys, dir+"/"+entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migrations %d_%s and %d_%s share a version", version, m.Name, version, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(script)
+		} else {
+			m.Down = string(script)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs both an up and a down script", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	slices.SortFunc(migrations, func(a, b Migration) int { return a.Version - b.Version })
+	return migrations, nil
+}
+
+// Migrator applies and reverts the built-in migrations.
+type Migrator struct {
+	db         *gorm.DB
+	migrations []Migration
+}
+
+// NewMigrator returns a Migrator for the database db is connected to.
+func NewMigrator(db *gorm.DB) (*Migrator, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+// locked runs f on a single connection holding the migration lock, once the migrations table
+// exists.
+func (m *Migrator) locked(ctx context.Context, f func(conn *gorm.DB) error) error {
+	return m.db.WithContext(ctx).Connection(func(conn *gorm.DB) error {
+		if err := conn.Exec("SELECT pg_advisory_lock(?)", migrationLockID).Error; err != nil {
+			return fmt.Errorf("acquiring the migration lock: %w", err)
+		}
+		defer conn.Exec("SELECT pg_advisory_unlock(?)", migrationLockID)
+
+		if err := conn.Exec(createMigrationsTable).Error; err != nil {
+			return err
+		}
+		return f(conn)
+	})
+}
+
+// applied returns when each applied migration was applied, by version.
+func applied(conn *gorm.DB) (map[int]time.Time, error) {
+	var rows []struct {
+		Version   int
+		AppliedAt time.Time
+	}
+	if err := conn.Raw(`SELECT "version", "applied_at" FROM "schema_migrations"`).Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	versions := make(map[int]time.Time, len(rows))
+	for _, row := range rows {
+		versions[row.Version] = row.AppliedAt
+	}
+	return versions, nil
+}
+
+// Up applies every migration that hasn't been, oldest first, and returns them.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var done []Migration
+	err := m.locked(ctx, func(conn *gorm.DB) error {
+		versions, err := applied(conn)
+		if err != nil {
+			return err
+		}
+		for _, migration := range m.migrations {
+			if _, ok := versions[migration.Version]; ok {
+				continue
+			}
+			err := conn.Transaction(func(tx *gorm.DB) error {
+				if err := tx.Exec(migration.Up).Error; err != nil {
+					return err
+				}
+				return tx.Exec(`INSERT INTO "schema_migrations" ("version", "name") VALUES (?, ?)`, migration.Version, migration.Name).Error
+			})
+			if err != nil {
+				return fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+			done = append(done, migration)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Down reverts the newest steps applied migrations, newest first, and returns them.
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	var done []Migration
+	err := m.locked(ctx, func(conn *gorm.DB) error {
+		versions, err := applied(conn)
+		if err != nil {
+			return err
+		}
+		newest := make([]int, 0, len(versions))
+		for version := range versions {
+			newest = append(newest, version)
+		}
+		slices.SortFunc(newest, func(a, b int) int { return b - a })
+
+		for _, version := range newest[:min(steps, len(newest))] {
+			i := slices.IndexFunc(m.migrations, func(migration Migration) bool { return migration.Version == version })
+			if i < 0 {
+				return fmt.Errorf("migration %d was applied by a newer version and can't be reverted by this one", version)
+			}
+			migration := m.migrations[i]
+			err := conn.Transaction(func(tx *gorm.DB) error {
+				if err := tx.Exec(migration.Down).Error; err != nil {
+					return err
+				}
+				return tx.Exec(`DELETE FROM "schema_migrations" WHERE "version" = ?`, migration.Version).Error
+			})
+			if err != nil {
+				return fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+			done = append(done, migration)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Status returns every built-in migration and whether it has been applied, oldest first.
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	var statuses []MigrationStatus
+	err := m.locked(ctx, func(conn *gorm.DB) error {
+		versions, err := applied(conn)
+		if err != nil {
+			return err
+		}
+		for _, migration := range m.migrations {
+			status := MigrationStatus{Migration: migration}
+			if appliedAt, ok := versions[migration.Version]; ok {
+				status.AppliedAt = &appliedAt
+			}
+			statuses = append(statuses, status)
+		}
+		return nil
+	})
+	return statuses, err
+}
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS "tor_exit_nodes";
+DROP TABLE IF EXISTS "users";
@@ -0,0 +1,31 @@
+-- The schema AutoMigrate created before migrations were versioned.  Everything is created only if
+-- it doesn't exist, so databases it created adopt this as their first migration unchanged; the
+-- tables and columns added since are in the later migrations.
+
+CREATE TABLE IF NOT EXISTS "users" (
+    "id" bigserial,
+    "created_at" timestamptz,
+    "updated_at" timestamptz,
+    "deleted_at" timestamptz,
+    "name" text NOT NULL,
+    "email" text NOT NULL,
+    "password" text NOT NULL,
+    "role" text,
+    "allowed_ips" text[],
+    PRIMARY KEY ("id"),
+    CONSTRAINT "uni_users_email" UNIQUE ("email")
+);
+CREATE INDEX IF NOT EXISTS "idx_users_deleted_at" ON "users" ("deleted_at");
+
+CREATE TABLE IF NOT EXISTS "tor_exit_nodes" (
+    "id" bigserial,
+    "created_at" timestamptz,
+    "updated_at" timestamptz,
+    "deleted_at" timestamptz,
+    "ip" text NOT NULL,
+    "country_name" text,
+    "country_code" text,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "uni_tor_exit_nodes_ip" UNIQUE ("ip")
+);
+CREATE INDEX IF NOT EXISTS "idx_tor_exit_nodes_deleted_at" ON "tor_exit_nodes" ("deleted_at");
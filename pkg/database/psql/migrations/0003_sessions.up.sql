@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS "sessions" (
+    "id" bigserial,
+    "created_at" timestamptz,
+    "updated_at" timestamptz,
+    "deleted_at" timestamptz,
+    "user_id" bigint NOT NULL,
+    "refresh_hash" text NOT NULL,
+    "expires_at" timestamptz,
+    "last_used_at" timestamptz,
+    "ip" text,
+    "user_agent" text,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "uni_sessions_refresh_hash" UNIQUE ("refresh_hash")
+);
+CREATE INDEX IF NOT EXISTS "idx_sessions_user_id" ON "sessions" ("user_id");
+CREATE INDEX IF NOT EXISTS "idx_sessions_deleted_at" ON "sessions" ("deleted_at");
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS "login_audits" (
+    "id" bigserial,
+    "created_at" timestamptz,
+    "updated_at" timestamptz,
+    "deleted_at" timestamptz,
+    "user_id" bigint,
+    "email" text NOT NULL,
+    "ip" text,
+    "user_agent" text,
+    "success" boolean,
+    "reason" text,
+    PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_login_audits_deleted_at" ON "login_audits" ("deleted_at");
+CREATE INDEX IF NOT EXISTS "idx_login_audits_ip" ON "login_audits" ("ip");
+CREATE INDEX IF NOT EXISTS "idx_login_audits_email" ON "login_audits" ("email");
@@ -0,0 +1,4 @@
+ALTER TABLE "users" ADD COLUMN IF NOT EXISTS "totp_secret" text;
+ALTER TABLE "users" ADD COLUMN IF NOT EXISTS "totp_enabled" boolean;
+ALTER TABLE "users" ADD COLUMN IF NOT EXISTS "totp_last_step" bigint;
+ALTER TABLE "users" ADD COLUMN IF NOT EXISTS "recovery_codes" text[];
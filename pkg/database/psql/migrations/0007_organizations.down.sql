@@ -0,0 +1,3 @@
+ALTER TABLE "login_audits" DROP COLUMN IF EXISTS "organization_id";
+ALTER TABLE "users" DROP COLUMN IF EXISTS "organization_id";
+DROP TABLE IF EXISTS "organizations";
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS "organizations" (
+    "id" bigserial,
+    "created_at" timestamptz,
+    "updated_at" timestamptz,
+    "deleted_at" timestamptz,
+    "name" text NOT NULL,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "uni_organizations_name" UNIQUE ("name")
+);
+CREATE INDEX IF NOT EXISTS "idx_organizations_deleted_at" ON "organizations" ("deleted_at");
+
+ALTER TABLE "users" ADD COLUMN IF NOT EXISTS "organization_id" bigint;
+CREATE INDEX IF NOT EXISTS "idx_users_organization_id" ON "users" ("organization_id");
+
+ALTER TABLE "login_audits" ADD COLUMN IF NOT EXISTS "organization_id" bigint;
+CREATE INDEX IF NOT EXISTS "idx_login_audits_organization_id" ON "login_audits" ("organization_id");
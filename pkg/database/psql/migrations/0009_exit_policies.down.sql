@@ -0,0 +1,2 @@
+ALTER TABLE "tor_exit_nodes" DROP COLUMN IF EXISTS "exit_policy_v6";
+ALTER TABLE "tor_exit_nodes" DROP COLUMN IF EXISTS "exit_policy";
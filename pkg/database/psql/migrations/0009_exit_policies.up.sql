@@ -0,0 +1,2 @@
+ALTER TABLE "tor_exit_nodes" ADD COLUMN IF NOT EXISTS "exit_policy" text;
+ALTER TABLE "tor_exit_nodes" ADD COLUMN IF NOT EXISTS "exit_policy_v6" text;
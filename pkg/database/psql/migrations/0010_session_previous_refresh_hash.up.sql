@@ -0,0 +1,2 @@
+ALTER TABLE "sessions" ADD COLUMN IF NOT EXISTS "previous_refresh_hash" text;
+CREATE INDEX IF NOT EXISTS "idx_sessions_previous_refresh_hash" ON "sessions" ("previous_refresh_hash");
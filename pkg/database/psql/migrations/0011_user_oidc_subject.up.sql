@@ -0,0 +1,2 @@
+ALTER TABLE "users" ADD COLUMN IF NOT EXISTS "oidc_subject" text;
+CREATE INDEX IF NOT EXISTS "idx_users_oidc_subject" ON "users" ("oidc_subject");
@@ -10,8 +10,8 @@ import (
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 
-	"github.com/humper/tor_exit_nodes/models"
 	"github.com/humper/tor_exit_nodes/pkg/database"
+	"github.com/humper/tor_exit_nodes/pkg/database/gormdb"
 	"github.com/humper/tor_exit_nodes/pkg/util"
 )
 
@@ -37,15 +37,34 @@ func Load(ctx context.Context, filename string) (*database.Database, error) {
 	if err != nil {
 		return nil, err
 	}
+	return Open(ctx, &cfg)
+}
 
+// LoadMigrator connects to the database a yaml file describes, without migrating it, to manage its
+// migrations.
+func LoadMigrator(ctx context.Context, filename string) (*Migrator, error) {
+	cfg, err := util.ReadYamlFile[Configuration](filename)
+	if err != nil {
+		return nil, err
+	}
+	gormDB, err := Connect(ctx, &cfg)
+	if err != nil {
+		return nil, err
+	}
+	return NewMigrator(gormDB)
+}
+
+// Connect connects to the database cfg describes, retrying for up to a minute while it starts up.
+func Connect(ctx context.Context, cfg *Configuration) (*gorm.DB, error) {
 	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%d sslmode=%s", cfg.Host, cfg.User, cfg.Password, cfg.DBName, cfg.Port, cfg.SSLMode)
 
 	b := util.NewBackoff(dbConnectTimeout)
 
 	var gormDB *gorm.DB
 
-	err = backoff.Retry(func() error {
-		gormDB, err = gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	err := backoff.Retry(func() error {
+		var err error
+		gormDB, err = gormdb.Open(postgres.Open(dsn))
 		if err != nil {
 			return err
 		}
@@ -55,18 +74,22 @@ func Load(ctx context.Context, filename string) (*database.Database, error) {
 	if err != nil {
 		return nil, err
 	}
+	return gormDB, nil
+}
 
-	err = gormDB.AutoMigrate(&models.User{}, &models.TorExitNode{})
+// Open connects to the database cfg describes and applies any migrations it's missing.
+func Open(ctx context.Context, cfg *Configuration) (*database.Database, error) {
+	gormDB, err := Connect(ctx, cfg)
 	if err != nil {
 		return nil, err
 	}
 
-	// hack to bootstrap the database
-	u := &users{db: gormDB}
-	_, err = u.GetAll(ctx, &models.Pagination{Limit: 1})
-
-	return &database.Database{
-		Users:        u,
-		TorExitNodes: &torExitNodes{db: gormDB},
-	}, nil
+	migrator, err := NewMigrator(gormDB)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := migrator.Up(ctx); err != nil {
+		return nil, err
+	}
+	return gormdb.New(gormDB)
 }
@@ -0,0 +1,197 @@
+package psql_test
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"testing"
+
+	embeddedpostgres "github.com/fergusstrange/embedded-postgres"
+	"github.com/humper/tor_exit_nodes/models"
+	"github.com/humper/tor_exit_nodes/pkg/database"
+	"github.com/humper/tor_exit_nodes/pkg/database/databasetest"
+	"github.com/humper/tor_exit_nodes/pkg/database/psql"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// freePort returns a TCP port nothing is listening on.
+func freePort(t *testing.T) uint32 {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	return uint32(l.Addr().(*net.TCPAddr).Port)
+}
+
+// startPostgres starts a throwaway Postgres server, downloaded the first time, and returns its port
+// and a connection to its postgres database.  The test is skipped where that server can't run, such
+// as without network access or as root, unless TEN_REQUIRE_POSTGRES is set, as it is in CI.
+func startPostgres(t *testing.T) (uint32, *sql.DB) {
+	port := freePort(t)
+	server := embeddedpostgres.NewDatabase(embeddedpostgres.DefaultConfig().
+		Port(port).
+		RuntimePath(t.TempDir()).
+		Logger(io.Discard))
+	if err := server.Start(); err != nil {
+		if os.Getenv("TEN_REQUIRE_POSTGRES") != "" {
+			t.Fatalf("Can't start embedded Postgres: %v", err)
+		}
+		t.Skipf("Can't start embedded Postgres: %v", err)
+	}
+	t.Cleanup(func() { server.Stop() })
+
+	admin, err := sql.Open("postgres", fmt.Sprintf("host=localhost port=%d user=postgres password=postgres dbname=postgres sslmode=disable", port))
+	require.NoError(t, err)
+	t.Cleanup(func() { admin.Close() })
+	return port, admin
+}
+
+// createDatabase creates an empty database on the server and returns its configuration.
+func createDatabase(t *testing.T, port uint32, admin *sql.DB, name string) *psql.Configuration {
+	_, err := admin.Exec("CREATE DATABASE " + name)
+	require.NoError(t, err)
+	return &psql.Configuration{
+		Host:     "localhost",
+		Port:     int(port),
+		User:     "postgres",
+		Password: "postgres",
+		DBName:   name,
+		SSLMode:  "disable",
+	}
+}
+
+func TestConformance(t *testing.T) {
+	port, admin := startPostgres(t)
+
+	databases := 0
+	databasetest.Run(t, func(t *testing.T) *database.Database {
+		databases++
+		cfg := createDatabase(t, port, admin, fmt.Sprintf("ten_test_%d", databases))
+		db, err := psql.Open(context.Background(), cfg)
+		require.NoError(t, err)
+		return db
+	})
+}
+
+func TestMigrations(t *testing.T) {
+	migrations, err := psql.Migrations()
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+	for i, migration := range migrations {
+		assert.Equal(t, i+1, migration.Version, "migrations are numbered from 1 without gaps")
+		assert.NotEmpty(t, migration.Name)
+		assert.NotEmpty(t, migration.Up)
+		assert.NotEmpty(t, migration.Down)
+	}
+}
+
+func TestMigrator(t *testing.T) {
+	port, admin := startPostgres(t)
+	ctx := context.Background()
+	gormDB, err := psql.Connect(ctx, createDatabase(t, port, admin, "ten_migrate"))
+	require.NoError(t, err)
+	migrations, err := psql.Migrations()
+	require.NoError(t, err)
+	migrator, err := psql.NewMigrator(gormDB)
+	require.NoError(t, err)
+
+	statuses, err := migrator.Status(ctx)
+	require.NoError(t, err)
+	require.Len(t, statuses, len(migrations))
+	for _, status := range statuses {
+		assert.Nil(t, status.AppliedAt)
+	}
+
+	applied, err := migrator.Up(ctx)
+	require.NoError(t, err)
+	assert.Len(t, applied, len(migrations))
+	applied, err = migrator.Up(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, applied, "applied migrations aren't applied again")
+
+	// the migrations create the schema the models expect
+	for _, model := range []any{&models.User{}, &models.TorExitNode{}, &models.APIKey{}, &models.Session{}, &models.LoginAudit{}, &models.Organization{}, &models.AuditEvent{}} {
+		stmt := &gorm.Statement{DB: gormDB}
+		require.NoError(t, stmt.Parse(model))
+		for _, field := range stmt.Schema.Fields {
+			if field.DBName != "" {
+				assert.True(t, gormDB.Migrator().HasColumn(model, field.DBName), "%s.%s", stmt.Schema.Table, field.DBName)
+			}
+		}
+	}
+
+	reverted, err := migrator.Down(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, reverted, 1)
+	assert.Equal(t, migrations[len(migrations)-1].Version, reverted[0].Version)
+	statuses, err = migrator.Status(ctx)
+	require.NoError(t, err)
+	assert.Nil(t, statuses[len(statuses)-1].AppliedAt)
+
+	_, err = migrator.Down(ctx, len(migrations))
+	require.NoError(t, err)
+	assert.False(t, gormDB.Migrator().HasTable(&models.User{}))
+	_, err = migrator.Up(ctx)
+	require.NoError(t, err)
+	assert.True(t, gormDB.Migrator().HasTable(&models.User{}))
+}
+
+// baselineUser and baselineTorExitNode are the models as they were before migrations were
+// versioned, when AutoMigrate created the schema from them.
+type baselineUser struct {
+	gorm.Model
+	Name       string `gorm:"not null"`
+	Email      string `gorm:"unique;not null"`
+	Password   string `gorm:"not null"`
+	Role       string
+	AllowedIPs pq.StringArray `gorm:"column:allowed_ips;type:text[]"`
+}
+
+func (baselineUser) TableName() string { return "users" }
+
+type baselineTorExitNode struct {
+	gorm.Model
+	IP          string `gorm:"unique;not null"`
+	CountryName string
+	CountryCode string
+}
+
+func (baselineTorExitNode) TableName() string { return "tor_exit_nodes" }
+
+func TestMigrateBaseline(t *testing.T) {
+	port, admin := startPostgres(t)
+	ctx := context.Background()
+	cfg := createDatabase(t, port, admin, "ten_baseline")
+
+	gormDB, err := psql.Connect(ctx, cfg)
+	require.NoError(t, err)
+	require.NoError(t, gormDB.AutoMigrate(&baselineUser{}, &baselineTorExitNode{}))
+	require.NoError(t, gormDB.Create(&baselineUser{Name: "old", Email: "old@example.com", Password: "hash", Role: "admin"}).Error)
+
+	db, err := psql.Open(ctx, cfg)
+	require.NoError(t, err)
+	defer db.Close()
+
+	for _, model := range []any{&models.User{}, &models.TorExitNode{}, &models.APIKey{}, &models.Session{}, &models.LoginAudit{}, &models.Organization{}, &models.AuditEvent{}} {
+		stmt := &gorm.Statement{DB: gormDB}
+		require.NoError(t, stmt.Parse(model))
+		for _, field := range stmt.Schema.Fields {
+			if field.DBName != "" {
+				assert.True(t, gormDB.Migrator().HasColumn(model, field.DBName), "%s.%s", stmt.Schema.Table, field.DBName)
+			}
+		}
+	}
+
+	user, err := db.Users.GetByEmail(ctx, "old@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "old", user.Name)
+	assert.False(t, user.TOTPEnabled)
+	assert.Zero(t, user.OrganizationID)
+	user.EmailVerified = true
+	require.NoError(t, db.Users.Update(ctx, user))
+}
@@ -0,0 +1,26 @@
+package database
+
+import (
+	"context"
+
+	"github.com/humper/tor_exit_nodes/models"
+)
+
+type Sessions interface {
+	Create(ctx context.Context, session *models.Session) error
+	GetByID(ctx context.Context, id uint) (*models.Session, error)
+	GetByRefreshHash(ctx context.Context, hash string) (*models.Session, error)
+	// GetByPreviousRefreshHash returns the session whose refresh token hashed to hash before it was
+	// last rotated.
+	GetByPreviousRefreshHash(ctx context.Context, hash string) (*models.Session, error)
+	GetByUser(ctx context.Context, userID uint) ([]*models.Session, error)
+	Update(ctx context.Context, session *models.Session) error
+	// Rotate saves session, given a new RefreshHash, only if its stored refresh hash is still oldHash,
+	// which it records as the previous one.  It returns ErrNotFound if the session has been deleted or
+	// its token already rotated, so each refresh token can only be exchanged once.
+	Rotate(ctx context.Context, session *models.Session, oldHash string) error
+	// Delete revokes a session.
+	Delete(ctx context.Context, id uint) error
+	// DeleteByUser revokes all of a user's sessions.
+	DeleteByUser(ctx context.Context, userID uint) error
+}
@@ -0,0 +1,60 @@
+package sqlite
+
+import (
+	"context"
+	"errors"
+
+	"github.com/glebarez/sqlite"
+
+	"github.com/humper/tor_exit_nodes/models"
+	"github.com/humper/tor_exit_nodes/pkg/database"
+	"github.com/humper/tor_exit_nodes/pkg/database/gormdb"
+	"github.com/humper/tor_exit_nodes/pkg/util"
+)
+
+// Configuration is the part of the DB config the sqlite backend reads.
+type Configuration struct {
+	// Path is the database file, created if it doesn't exist.  ":memory:" keeps the database in
+	// memory, which is only useful for tests.
+	Path string `yaml:"path"`
+}
+
+// Load opens the sqlite database the yaml file describes.
+func Load(ctx context.Context, filename string) (*database.Database, error) {
+	cfg, err := util.ReadYamlFile[Configuration](filename)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Path == "" {
+		return nil, errors.New("sqlite database path is required")
+	}
+	return Open(ctx, cfg.Path)
+}
+
+// Open opens, and if necessary creates, the sqlite database at path.
+//
+// Text arrays, such as User.AllowedIPs, are stored in TEXT columns in the Postgres array syntax
+// pq.StringArray reads and writes, so the models work unchanged on both backends.
+func Open(ctx context.Context, path string) (*database.Database, error) {
+	// sqlite allows one writer at a time; waiting for the lock is better than failing with
+	// SQLITE_BUSY.  WAL lets readers, such as a streaming export, carry on alongside the writer, and
+	// transactions take the write lock up front so that two can't deadlock upgrading to it.
+	gormDB, err := gormdb.Open(sqlite.Open(path + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_txlock=immediate"))
+	if err != nil {
+		return nil, err
+	}
+	if path == ":memory:" {
+		// every connection to ":memory:" would get a database of its own
+		sqlDB, err := gormDB.DB()
+		if err != nil {
+			return nil, err
+		}
+		sqlDB.SetMaxOpenConns(1)
+	}
+
+	err = gormDB.AutoMigrate(&models.User{}, &models.TorExitNode{}, &models.APIKey{}, &models.Session{}, &models.LoginAudit{}, &models.Organization{}, &models.AuditEvent{})
+	if err != nil {
+		return nil, err
+	}
+	return gormdb.New(gormDB)
+}
@@ -0,0 +1,49 @@
+package sqlite_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/humper/tor_exit_nodes/models"
+	"github.com/humper/tor_exit_nodes/pkg/database"
+	"github.com/humper/tor_exit_nodes/pkg/database/databasetest"
+	"github.com/humper/tor_exit_nodes/pkg/database/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConformance(t *testing.T) {
+	databasetest.Run(t, func(t *testing.T) *database.Database {
+		db, err := sqlite.Open(context.Background(), filepath.Join(t.TempDir(), "ten.db"))
+		require.NoError(t, err)
+		return db
+	})
+}
+
+func TestIterateDoesNotBlockOtherQueries(t *testing.T) {
+	ctx := context.Background()
+	db, err := sqlite.Open(ctx, filepath.Join(t.TempDir(), "ten.db"))
+	require.NoError(t, err)
+	defer db.Close()
+	require.NoError(t, db.TorExitNodes.DeleteAndAdd(ctx, nil, []*models.TorExitNode{{IP: "1.2.3.4"}, {IP: "5.6.7.8"}}))
+
+	// an export streams the nodes while other requests read and write
+	seen := 0
+	err = db.TorExitNodes.Iterate(ctx, nil, nil, func(node *models.TorExitNode) error {
+		seen++
+		if seen > 1 {
+			return nil
+		}
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		if err := db.Users.Create(ctx, &models.User{Email: "test@test.com", Password: "hash"}); err != nil {
+			return err
+		}
+		_, err := db.Users.GetByEmail(ctx, "test@test.com")
+		return err
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, seen)
+}
@@ -8,6 +8,10 @@ import (
 
 type TorExitNodes interface {
 	GetAll(ctx context.Context, excludedIPs []string, pagination *models.Pagination) (*models.Pagination, error)
+	// Iterate calls fn for every exit node matching filter and not in excludedIPs, in ID order,
+	// without loading the whole set into memory.  Iteration stops at the first error returned by fn.
+	// filter may only name models.TorExitNodeFilterColumns.
+	Iterate(ctx context.Context, excludedIPs []string, filter map[string][]string, fn func(node *models.TorExitNode) error) error
 	DeleteAndAdd(ctx context.Context, nodes_to_delete []models.TorExitNode, nodes_to_add []*models.TorExitNode) error
 	Update(ctx context.Context, nodes []*models.TorExitNode) error
 	GetMissingCountries(ctx context.Context, batchSize int) ([]*models.TorExitNode, error)
@@ -8,6 +8,8 @@ import (
 
 type Users interface {
 	GetAll(ctx context.Context, pagination *models.Pagination) (*models.Pagination, error)
+	// GetAllByOrganization is GetAll restricted to the users in an organization.
+	GetAllByOrganization(ctx context.Context, orgID uint, pagination *models.Pagination) (*models.Pagination, error)
 	GetByID(ctx context.Context, id uint) (*models.User, error)
 	GetByEmail(ctx context.Context, email string) (*models.User, error)
 	Create(ctx context.Context, user *models.User) error
@@ -0,0 +1,337 @@
+package dnsbl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/humper/tor_exit_nodes/models"
+	"github.com/humper/tor_exit_nodes/pkg/database"
+	"github.com/humper/tor_exit_nodes/pkg/tor"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	DefaultTTL            = 300
+	DefaultReloadInterval = 10 * time.Minute
+)
+
+// ipPortLabel marks TorDNSEL's ip-port query type,
+// {reversed exit IP}.{port}.{reversed destination IP}.ip-port.{zone}, which asks whether an exit would
+// carry traffic to a destination.  It's answered from the exit's stored policy.
+const ipPortLabel = "ip-port"
+
+// listedAddress is the A record returned for listed IPs, following DNSBL convention.
+var listedAddress = [4]byte{127, 0, 0, 2}
+
+type NewServerParams struct {
+	DB *database.Database
+	// Zone is the DNS suffix queries are answered under, e.g. "tor.example.com".
+	Zone string
+	TTL  uint32
+}
+
+// Server answers DNS blocklist queries from an in-memory copy of the exit node set.
+// Call Reload to refresh the copy from the database.
+type Server struct {
+	db    *database.Database
+	zone  string
+	ttl   uint32
+	mutex sync.RWMutex
+	nodes map[netip.Addr]*models.TorExitNode
+}
+
+func New(ctx context.Context, params *NewServerParams) *Server {
+	ttl := params.TTL
+	if ttl == 0 {
+		ttl = DefaultTTL
+	}
+	return &Server{
+		db:    params.DB,
+		zone:  canonicalName(params.Zone),
+		ttl:   ttl,
+		nodes: map[netip.Addr]*models.TorExitNode{},
+	}
+}
+
+// Reload replaces the served set with the current contents of the database.
+func (s *Server) Reload(ctx context.Context) error {
+	nodes := map[netip.Addr]*models.TorExitNode{}
+	err := s.db.TorExitNodes.Iterate(ctx, nil, nil, func(node *models.TorExitNode) error {
+		addr, err := netip.ParseAddr(node.IP)
+		if err != nil {
+			slog.WarnContext(ctx, "Skipping unparseable exit node address", "ip", node.IP)
+			return nil
+		}
+		nodes[addr.Unmap()] = node
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	s.nodes = nodes
+	s.mutex.Unlock()
+
+	slog.InfoContext(ctx, "Reloaded DNSBL", "num_nodes", len(nodes))
+	return nil
+}
+
+// ReloadEvery calls Reload on the given interval until ctx is cancelled.
+func (s *Server) ReloadEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.Reload(ctx); err != nil {
+				slog.ErrorContext(ctx, "Failed to reload DNSBL", "error", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// ListenAndServe answers UDP queries on addr until ctx is cancelled.
+func (s *Server) ListenAndServe(ctx context.Context, addr string) error {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	buf := make([]byte, 4096)
+	for {
+		n, peer, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		resp, err := s.Answer(buf[:n])
+		if err != nil {
+			slog.DebugContext(ctx, "Dropping malformed DNS query", "error", err, "peer", peer.String())
+			continue
+		}
+		if _, err := conn.WriteTo(resp, peer); err != nil {
+			slog.ErrorContext(ctx, "Failed to write DNS response", "error", err, "peer", peer.String())
+		}
+	}
+}
+
+// Answer builds the response to a single DNS query message.
+func (s *Server) Answer(query []byte) ([]byte, error) {
+	var p dnsmessage.Parser
+	hdr, err := p.Start(query)
+	if err != nil {
+		return nil, err
+	}
+	if hdr.Response {
+		return nil, errors.New("message is a response")
+	}
+	q, err := p.Question()
+	if err != nil {
+		return nil, err
+	}
+
+	respHdr := dnsmessage.Header{
+		ID:               hdr.ID,
+		Response:         true,
+		OpCode:           hdr.OpCode,
+		Authoritative:    true,
+		RecursionDesired: hdr.RecursionDesired,
+	}
+
+	var node *models.TorExitNode
+	if hdr.OpCode != 0 {
+		respHdr.RCode = dnsmessage.RCodeNotImplemented
+	} else if q.Class != dnsmessage.ClassINET && q.Class != dnsmessage.ClassANY {
+		respHdr.RCode = dnsmessage.RCodeRefused
+	} else {
+		node, respHdr.RCode = s.lookup(q.Name.String())
+	}
+
+	b := dnsmessage.NewBuilder(nil, respHdr)
+	b.EnableCompression()
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := b.Question(q); err != nil {
+		return nil, err
+	}
+
+	if node != nil {
+		if err := b.StartAnswers(); err != nil {
+			return nil, err
+		}
+		rh := dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET, TTL: s.ttl}
+		if q.Type == dnsmessage.TypeA || q.Type == dnsmessage.TypeALL {
+			if err := b.AResource(rh, dnsmessage.AResource{A: listedAddress}); err != nil {
+				return nil, err
+			}
+		}
+		if q.Type == dnsmessage.TypeTXT || q.Type == dnsmessage.TypeALL {
+			if err := b.TXTResource(rh, dnsmessage.TXTResource{TXT: []string{describe(node)}}); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return b.Finish()
+}
+
+// lookup resolves a query name to the listed node it refers to, if any.
+// Names under the zone that aren't listed addresses get NXDOMAIN; names outside it are refused.
+func (s *Server) lookup(name string) (*models.TorExitNode, dnsmessage.RCode) {
+	name = strings.ToLower(name)
+	if name == s.zone {
+		return nil, dnsmessage.RCodeSuccess
+	}
+	if !strings.HasSuffix(name, "."+s.zone) {
+		return nil, dnsmessage.RCodeRefused
+	}
+
+	labels := strings.Split(strings.TrimSuffix(name, "."+s.zone), ".")
+	if labels[len(labels)-1] == ipPortLabel {
+		return s.lookupIPPort(labels[:len(labels)-1])
+	}
+
+	addr, ok := parseReversed(labels)
+	if !ok {
+		return nil, dnsmessage.RCodeNameError
+	}
+
+	s.mutex.RLock()
+	node, ok := s.nodes[addr]
+	s.mutex.RUnlock()
+	if !ok {
+		return nil, dnsmessage.RCodeNameError
+	}
+	return node, dnsmessage.RCodeSuccess
+}
+
+// lookupIPPort answers a TorDNSEL ip-port query: the exit is listed only if it's listed and its policy
+// lets it reach the destination port.  Exits whose policy isn't known are refused, since NXDOMAIN
+// would wrongly say they can't reach it.
+func (s *Server) lookupIPPort(labels []string) (*models.TorExitNode, dnsmessage.RCode) {
+	exit, port, dest, ok := parseIPPort(labels)
+	if !ok {
+		return nil, dnsmessage.RCodeNameError
+	}
+
+	s.mutex.RLock()
+	node, ok := s.nodes[exit]
+	s.mutex.RUnlock()
+	if !ok {
+		return nil, dnsmessage.RCodeNameError
+	}
+
+	// like Tor's default policy, no exit connects to private or otherwise non-public addresses
+	if !dest.IsGlobalUnicast() || dest.IsPrivate() {
+		return nil, dnsmessage.RCodeNameError
+	}
+	summary := node.ExitPolicy
+	if dest.Is6() {
+		summary = node.ExitPolicyV6
+	}
+	if summary == "" {
+		return nil, dnsmessage.RCodeRefused
+	}
+	policy, err := tor.ParsePolicySummary(summary)
+	if err != nil {
+		slog.Warn("Invalid stored exit policy", "error", err, "ip", node.IP)
+		return nil, dnsmessage.RCodeRefused
+	}
+	if !policy.Allows(port) {
+		return nil, dnsmessage.RCodeNameError
+	}
+	return node, dnsmessage.RCodeSuccess
+}
+
+// parseIPPort parses the labels of an ip-port query, without the ip-port label itself.
+func parseIPPort(labels []string) (exit netip.Addr, port uint16, dest netip.Addr, ok bool) {
+	// the exit is 4 or 32 labels long; try both, since IPv6 nibbles can look like IPv4 octets
+	for _, exitLen := range []int{4, 32} {
+		if len(labels) <= exitLen+1 {
+			continue
+		}
+		exit, ok := parseReversed(labels[:exitLen])
+		if !ok {
+			continue
+		}
+		p, err := strconv.ParseUint(labels[exitLen], 10, 16)
+		if err != nil || p == 0 {
+			continue
+		}
+		dest, ok := parseReversed(labels[exitLen+1:])
+		if !ok {
+			continue
+		}
+		return exit, uint16(p), dest, true
+	}
+	return netip.Addr{}, 0, netip.Addr{}, false
+}
+
+// parseReversed parses reversed-octet IPv4 (4.3.2.1) and reversed-nibble IPv6 labels.
+func parseReversed(labels []string) (netip.Addr, bool) {
+	switch len(labels) {
+	case 4:
+		var b [4]byte
+		for i, label := range labels {
+			v, err := strconv.ParseUint(label, 10, 8)
+			if err != nil {
+				return netip.Addr{}, false
+			}
+			b[3-i] = byte(v)
+		}
+		return netip.AddrFrom4(b), true
+	case 32:
+		var b [16]byte
+		for i, label := range labels {
+			v, err := strconv.ParseUint(label, 16, 4)
+			if err != nil || len(label) != 1 {
+				return netip.Addr{}, false
+			}
+			pos := 31 - i
+			if pos%2 == 0 {
+				b[pos/2] |= byte(v) << 4
+			} else {
+				b[pos/2] |= byte(v)
+			}
+		}
+		return netip.AddrFrom16(b).Unmap(), true
+	}
+	return netip.Addr{}, false
+}
+
+func describe(node *models.TorExitNode) string {
+	country := node.CountryCode
+	if country == "" {
+		country = "unknown"
+	}
+	txt := fmt.Sprintf("Tor exit node; country=%s", country)
+	if !node.CreatedAt.IsZero() {
+		txt += "; first_seen=" + node.CreatedAt.UTC().Format(time.RFC3339)
+	}
+	return txt
+}
+
+func canonicalName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, ".")) + "."
+}
@@ -0,0 +1,168 @@
+package dnsbl_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/humper/tor_exit_nodes/models"
+	"github.com/humper/tor_exit_nodes/pkg/database/memory"
+	"github.com/humper/tor_exit_nodes/pkg/dnsbl"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+	"gorm.io/gorm"
+)
+
+// firstSeen is when the test nodes were added to the database.
+var firstSeen = gorm.Model{CreatedAt: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)}
+
+func newServer(t *testing.T) *dnsbl.Server {
+	ctx := context.Background()
+	db, err := memory.New(ctx)
+	require.NoError(t, err)
+
+	err = db.TorExitNodes.DeleteAndAdd(ctx, nil, []*models.TorExitNode{
+		{Model: firstSeen, IP: "1.2.3.4", CountryCode: "DE", CountryName: "Germany", ExitPolicy: "accept 80,443,6660-6669", ExitPolicyV6: "reject 1-65535"},
+		{Model: firstSeen, IP: "2001:db8::567:89ab"},
+	})
+	require.NoError(t, err)
+
+	s := dnsbl.New(ctx, &dnsbl.NewServerParams{DB: db, Zone: "Tor.Example.COM."})
+	require.NoError(t, s.Reload(ctx))
+	return s
+}
+
+func query(t *testing.T, s *dnsbl.Server, name string, qtype dnsmessage.Type) *dnsmessage.Message {
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: 42, RecursionDesired: true})
+	require.NoError(t, b.StartQuestions())
+	require.NoError(t, b.Question(dnsmessage.Question{
+		Name:  dnsmessage.MustNewName(name),
+		Type:  qtype,
+		Class: dnsmessage.ClassINET,
+	}))
+	req, err := b.Finish()
+	require.NoError(t, err)
+
+	resp, err := s.Answer(req)
+	require.NoError(t, err)
+
+	var msg dnsmessage.Message
+	require.NoError(t, msg.Unpack(resp))
+	assert.Equal(t, uint16(42), msg.ID)
+	assert.True(t, msg.Response)
+	return &msg
+}
+
+func TestListedIPv4(t *testing.T) {
+	s := newServer(t)
+
+	msg := query(t, s, "4.3.2.1.tor.example.com.", dnsmessage.TypeA)
+	require.Equal(t, dnsmessage.RCodeSuccess, msg.RCode)
+	require.Len(t, msg.Answers, 1)
+	assert.Equal(t, [4]byte{127, 0, 0, 2}, msg.Answers[0].Body.(*dnsmessage.AResource).A)
+
+	msg = query(t, s, "4.3.2.1.TOR.example.com.", dnsmessage.TypeTXT)
+	require.Equal(t, dnsmessage.RCodeSuccess, msg.RCode)
+	require.Len(t, msg.Answers, 1)
+	assert.Equal(t, []string{"Tor exit node; country=DE; first_seen=2024-01-02T03:04:05Z"}, msg.Answers[0].Body.(*dnsmessage.TXTResource).TXT)
+}
+
+func TestListedIPv6(t *testing.T) {
+	s := newServer(t)
+
+	msg := query(t, s, "b.a.9.8.7.6.5.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.tor.example.com.", dnsmessage.TypeA)
+	require.Equal(t, dnsmessage.RCodeSuccess, msg.RCode)
+	require.Len(t, msg.Answers, 1)
+
+	msg = query(t, s, "b.a.9.8.7.6.5.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.tor.example.com.", dnsmessage.TypeTXT)
+	require.Len(t, msg.Answers, 1)
+	assert.Equal(t, []string{"Tor exit node; country=unknown; first_seen=2024-01-02T03:04:05Z"}, msg.Answers[0].Body.(*dnsmessage.TXTResource).TXT)
+}
+
+func TestNotListed(t *testing.T) {
+	s := newServer(t)
+
+	msg := query(t, s, "5.3.2.1.tor.example.com.", dnsmessage.TypeA)
+	assert.Equal(t, dnsmessage.RCodeNameError, msg.RCode)
+	assert.Empty(t, msg.Answers)
+
+	msg = query(t, s, "not.an.address.tor.example.com.", dnsmessage.TypeA)
+	assert.Equal(t, dnsmessage.RCodeNameError, msg.RCode)
+
+	msg = query(t, s, "tor.example.com.", dnsmessage.TypeA)
+	assert.Equal(t, dnsmessage.RCodeSuccess, msg.RCode)
+	assert.Empty(t, msg.Answers)
+}
+
+func TestOutsideZone(t *testing.T) {
+	s := newServer(t)
+
+	msg := query(t, s, "4.3.2.1.example.org.", dnsmessage.TypeA)
+	assert.Equal(t, dnsmessage.RCodeRefused, msg.RCode)
+}
+
+func TestReload(t *testing.T) {
+	ctx := context.Background()
+	db, err := memory.New(ctx)
+	require.NoError(t, err)
+
+	s := dnsbl.New(ctx, &dnsbl.NewServerParams{DB: db, Zone: "tor.example.com"})
+	require.NoError(t, s.Reload(ctx))
+	assert.Equal(t, dnsmessage.RCodeNameError, query(t, s, "4.3.2.1.tor.example.com.", dnsmessage.TypeA).RCode)
+
+	require.NoError(t, db.TorExitNodes.DeleteAndAdd(ctx, nil, []*models.TorExitNode{{IP: "1.2.3.4"}}))
+	require.NoError(t, s.Reload(ctx))
+	assert.Equal(t, dnsmessage.RCodeSuccess, query(t, s, "4.3.2.1.tor.example.com.", dnsmessage.TypeA).RCode)
+}
+
+func TestMalformedQuery(t *testing.T) {
+	s := newServer(t)
+	_, err := s.Answer([]byte{1, 2, 3})
+	require.Error(t, err)
+}
+
+func TestTorDNSELIPPort(t *testing.T) {
+	s := newServer(t)
+
+	// is 1.2.3.4 an exit that reaches 93.184.216.34:443?
+	msg := query(t, s, "4.3.2.1.443.34.216.184.93.ip-port.tor.example.com.", dnsmessage.TypeA)
+	require.Equal(t, dnsmessage.RCodeSuccess, msg.RCode)
+	require.Len(t, msg.Answers, 1)
+	assert.Equal(t, [4]byte{127, 0, 0, 2}, msg.Answers[0].Body.(*dnsmessage.AResource).A)
+
+	msg = query(t, s, "4.3.2.1.6667.34.216.184.93.ip-port.tor.example.com.", dnsmessage.TypeA)
+	assert.Equal(t, dnsmessage.RCodeSuccess, msg.RCode)
+
+	// port 25 isn't accepted
+	msg = query(t, s, "4.3.2.1.25.34.216.184.93.ip-port.tor.example.com.", dnsmessage.TypeA)
+	assert.Equal(t, dnsmessage.RCodeNameError, msg.RCode)
+	assert.Empty(t, msg.Answers)
+
+	// it doesn't exit over IPv6
+	msg = query(t, s, "4.3.2.1.443.1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip-port.tor.example.com.", dnsmessage.TypeA)
+	assert.Equal(t, dnsmessage.RCodeNameError, msg.RCode)
+
+	// nor to private addresses
+	msg = query(t, s, "4.3.2.1.443.1.0.0.10.ip-port.tor.example.com.", dnsmessage.TypeA)
+	assert.Equal(t, dnsmessage.RCodeNameError, msg.RCode)
+
+	// not an exit
+	msg = query(t, s, "5.3.2.1.443.34.216.184.93.ip-port.tor.example.com.", dnsmessage.TypeA)
+	assert.Equal(t, dnsmessage.RCodeNameError, msg.RCode)
+
+	// malformed
+	msg = query(t, s, "4.3.2.1.0.34.216.184.93.ip-port.tor.example.com.", dnsmessage.TypeA)
+	assert.Equal(t, dnsmessage.RCodeNameError, msg.RCode)
+	msg = query(t, s, "4.3.2.1.443.ip-port.tor.example.com.", dnsmessage.TypeA)
+	assert.Equal(t, dnsmessage.RCodeNameError, msg.RCode)
+}
+
+func TestTorDNSELIPPortUnknownPolicy(t *testing.T) {
+	s := newServer(t)
+
+	// 2001:db8::567:89ab has no stored policy, so there's no answer either way
+	msg := query(t, s, "b.a.9.8.7.6.5.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.80.34.216.184.93.ip-port.tor.example.com.", dnsmessage.TypeA)
+	assert.Equal(t, dnsmessage.RCodeRefused, msg.RCode)
+	assert.Empty(t, msg.Answers)
+}
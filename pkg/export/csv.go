@@ -0,0 +1,41 @@
+package export
+
+import (
+	"encoding/csv"
+	"io"
+
+	"github.com/humper/tor_exit_nodes/models"
+)
+
+func init() {
+	register(&Format{
+		Name:        "csv",
+		ContentType: "text/csv",
+		Extension:   "csv",
+		New: func(w io.Writer, opts *Options) Encoder {
+			return &csvEncoder{w: csv.NewWriter(w), columns: opts.getColumns()}
+		},
+	})
+}
+
+type csvEncoder struct {
+	w       *csv.Writer
+	columns []string
+}
+
+func (e *csvEncoder) Begin() error {
+	return e.w.Write(e.columns)
+}
+
+func (e *csvEncoder) Encode(node *models.TorExitNode) error {
+	record := make([]string, len(e.columns))
+	for i, col := range e.columns {
+		record[i] = columns[col](node)
+	}
+	return e.w.Write(record)
+}
+
+func (e *csvEncoder) End() error {
+	e.w.Flush()
+	return e.w.Error()
+}
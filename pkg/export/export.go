@@ -0,0 +1,157 @@
+package export
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/humper/tor_exit_nodes/models"
+	"github.com/humper/tor_exit_nodes/pkg/database"
+)
+
+// Encoder writes a stream of exit nodes in a particular output format.
+// Begin is called once before the first node, End once after the last one.
+type Encoder interface {
+	Begin() error
+	Encode(node *models.TorExitNode) error
+	End() error
+}
+
+type Options struct {
+	// Columns selects which node fields are written by tabular formats.
+	Columns []string
+	// SetName names the set, table or chain generated by rule-based formats, and the nginx variable.
+	SetName string
+	// Action is applied to matching traffic by rule-based formats: drop, reject or accept.
+	Action string
+	// Family restricts rule-based formats to ipv4 or ipv6 addresses.  Empty means both.
+	Family string
+}
+
+type Format struct {
+	Name        string
+	ContentType string
+	Extension   string
+	New         func(w io.Writer, opts *Options) Encoder
+}
+
+const (
+	DefaultSetName = "tor_exit_nodes"
+	DefaultAction  = "drop"
+)
+
+var setNameRegexp = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_-]{0,27}$`)
+
+// DefaultColumns matches the columns shown in the exit node listing.
+var DefaultColumns = []string{"ip", "country_name", "country_code"}
+
+var columns = map[string]func(node *models.TorExitNode) string{
+	"id":           func(node *models.TorExitNode) string { return strconv.FormatUint(uint64(node.ID), 10) },
+	"ip":           func(node *models.TorExitNode) string { return node.IP },
+	"country_name": func(node *models.TorExitNode) string { return node.CountryName },
+	"country_code": func(node *models.TorExitNode) string { return node.CountryCode },
+	"created_at":   func(node *models.TorExitNode) string { return node.CreatedAt.UTC().Format(time.RFC3339) },
+	"updated_at":   func(node *models.TorExitNode) string { return node.UpdatedAt.UTC().Format(time.RFC3339) },
+}
+
+var formats = map[string]*Format{}
+
+func register(format *Format) {
+	formats[format.Name] = format
+}
+
+// Lookup returns the format with the given name.
+func Lookup(name string) (*Format, error) {
+	format, ok := formats[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown export format %q (available: %s)", name, strings.Join(Names(), ", "))
+	}
+	return format, nil
+}
+
+// Names returns the names of all known formats, sorted.
+func Names() []string {
+	names := make([]string, 0, len(formats))
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Export writes every node matching filter and not in excludedIPs to enc.
+func Export(ctx context.Context, nodes database.TorExitNodes, excludedIPs []string, filter map[string][]string, enc Encoder) error {
+	if err := enc.Begin(); err != nil {
+		return err
+	}
+	if err := nodes.Iterate(ctx, excludedIPs, filter, enc.Encode); err != nil {
+		return err
+	}
+	return enc.End()
+}
+
+// ParseColumns validates a comma separated list of column names.  An empty list selects DefaultColumns.
+func ParseColumns(list string) ([]string, error) {
+	if list == "" {
+		return DefaultColumns, nil
+	}
+	cols := strings.Split(list, ",")
+	for i, col := range cols {
+		cols[i] = strings.TrimSpace(col)
+		if _, ok := columns[cols[i]]; !ok {
+			return nil, fmt.Errorf("unknown column %q", cols[i])
+		}
+	}
+	return cols, nil
+}
+
+func (o *Options) getColumns() []string {
+	if o == nil || len(o.Columns) == 0 {
+		return DefaultColumns
+	}
+	return o.Columns
+}
+
+// Validate checks the options that are interpolated into generated configuration.
+func (o *Options) Validate() error {
+	if o.SetName != "" && !setNameRegexp.MatchString(o.SetName) {
+		return fmt.Errorf("invalid set name %q", o.SetName)
+	}
+	switch o.Action {
+	case "", "drop", "reject", "accept":
+	default:
+		return fmt.Errorf("invalid action %q (must be drop, reject or accept)", o.Action)
+	}
+	switch o.Family {
+	case "", "ipv4", "ipv6":
+	default:
+		return fmt.Errorf("invalid family %q (must be ipv4 or ipv6)", o.Family)
+	}
+	return nil
+}
+
+func (o *Options) getSetName() string {
+	if o == nil || o.SetName == "" {
+		return DefaultSetName
+	}
+	return o.SetName
+}
+
+func (o *Options) getAction() string {
+	if o == nil || o.Action == "" {
+		return DefaultAction
+	}
+	return o.Action
+}
+
+func (o *Options) getFamily() string {
+	if o == nil {
+		return ""
+	}
+	return o.Family
+}
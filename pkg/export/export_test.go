@@ -0,0 +1,87 @@
+package export_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/humper/tor_exit_nodes/models"
+	"github.com/humper/tor_exit_nodes/pkg/export"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testNodes = []*models.TorExitNode{
+	{IP: "103.163.218.11", CountryName: "Australia", CountryCode: "AU"},
+	{IP: "2a0b:f4c2::1", CountryName: "Germany", CountryCode: "DE"},
+	{IP: "103.193.179.233", CountryName: "Burkina Faso", CountryCode: "BF"},
+}
+
+func render(t *testing.T, name string, opts *export.Options) string {
+	format, err := export.Lookup(name)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	enc := format.New(&buf, opts)
+	require.NoError(t, enc.Begin())
+	for _, node := range testNodes {
+		require.NoError(t, enc.Encode(node))
+	}
+	require.NoError(t, enc.End())
+	return buf.String()
+}
+
+func TestLookupUnknownFormat(t *testing.T) {
+	_, err := export.Lookup("bogus")
+	require.Error(t, err)
+}
+
+func TestParseColumns(t *testing.T) {
+	cols, err := export.ParseColumns("")
+	require.NoError(t, err)
+	assert.Equal(t, export.DefaultColumns, cols)
+
+	cols, err = export.ParseColumns("ip, country_code")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ip", "country_code"}, cols)
+
+	_, err = export.ParseColumns("ip,password")
+	require.Error(t, err)
+}
+
+func TestCSV(t *testing.T) {
+	out := render(t, "csv", &export.Options{})
+	assert.Equal(t, `ip,country_name,country_code
+103.163.218.11,Australia,AU
+2a0b:f4c2::1,Germany,DE
+103.193.179.233,Burkina Faso,BF
+`, out)
+}
+
+func TestCSVColumns(t *testing.T) {
+	out := render(t, "csv", &export.Options{Columns: []string{"country_code", "ip"}})
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	require.Len(t, lines, 4)
+	assert.Equal(t, "country_code,ip", lines[0])
+	assert.Equal(t, "AU,103.163.218.11", lines[1])
+}
+
+func TestNDJSON(t *testing.T) {
+	out := render(t, "ndjson", nil)
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	require.Len(t, lines, 3)
+
+	var record map[string]string
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &record))
+	assert.Equal(t, map[string]string{
+		"ip":           "2a0b:f4c2::1",
+		"country_name": "Germany",
+		"country_code": "DE",
+	}, record)
+}
+
+func TestText(t *testing.T) {
+	out := render(t, "txt", nil)
+	assert.Equal(t, "103.163.218.11\n2a0b:f4c2::1\n103.193.179.233\n", out)
+}
@@ -0,0 +1,207 @@
+package export
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/netip"
+	"strings"
+
+	"github.com/humper/tor_exit_nodes/models"
+)
+
+func init() {
+	register(&Format{
+		Name:        "ipset",
+		ContentType: "text/plain; charset=utf-8",
+		Extension:   "ipset",
+		New: func(w io.Writer, opts *Options) Encoder {
+			return newAddressSetEncoder(w, opts, writeIPSet)
+		},
+	})
+	register(&Format{
+		Name:        "nftables",
+		ContentType: "text/plain; charset=utf-8",
+		Extension:   "nft",
+		New: func(w io.Writer, opts *Options) Encoder {
+			return newAddressSetEncoder(w, opts, writeNFTables)
+		},
+	})
+	register(&Format{
+		Name:        "iptables",
+		ContentType: "text/plain; charset=utf-8",
+		Extension:   "rules",
+		New: func(w io.Writer, opts *Options) Encoder {
+			return newAddressSetEncoder(w, opts, writeIPTables)
+		},
+	})
+	register(&Format{
+		Name:        "pf",
+		ContentType: "text/plain; charset=utf-8",
+		Extension:   "conf",
+		New: func(w io.Writer, opts *Options) Encoder {
+			return newAddressSetEncoder(w, opts, writePF)
+		},
+	})
+}
+
+// addressSet holds the addresses of one IP family along with the name of the set generated for them.
+type addressSet struct {
+	Name  string
+	IPv6  bool
+	Addrs []netip.Addr
+}
+
+// addressSetEncoder collects addresses split by family, since these formats declare each
+// family's set in a single block, and renders them all in End.
+type addressSetEncoder struct {
+	w      *bufio.Writer
+	opts   *Options
+	v4, v6 *addressSet
+	render renderFunc
+}
+
+// renderFunc writes the collected sets; name is the configured set name without a family suffix.
+type renderFunc func(w *bufio.Writer, name string, sets []*addressSet, action string)
+
+func newAddressSetEncoder(w io.Writer, opts *Options, render renderFunc) Encoder {
+	return &addressSetEncoder{
+		w:      bufio.NewWriter(w),
+		opts:   opts,
+		v4:     &addressSet{Name: opts.getSetName() + "_v4"},
+		v6:     &addressSet{Name: opts.getSetName() + "_v6", IPv6: true},
+		render: render,
+	}
+}
+
+func (e *addressSetEncoder) Begin() error {
+	return nil
+}
+
+func (e *addressSetEncoder) Encode(node *models.TorExitNode) error {
+	addr, err := netip.ParseAddr(node.IP)
+	if err != nil {
+		// a single bad row shouldn't make the whole rule file unloadable
+		return nil
+	}
+	addr = addr.Unmap()
+	if addr.Is4() {
+		e.v4.Addrs = append(e.v4.Addrs, addr)
+	} else {
+		e.v6.Addrs = append(e.v6.Addrs, addr)
+	}
+	return nil
+}
+
+func (e *addressSetEncoder) End() error {
+	sets := []*addressSet{}
+	switch e.opts.getFamily() {
+	case "ipv4":
+		sets = append(sets, e.v4)
+	case "ipv6":
+		sets = append(sets, e.v6)
+	default:
+		sets = append(sets, e.v4, e.v6)
+	}
+	e.render(e.w, e.opts.getSetName(), sets, e.opts.getAction())
+	return e.w.Flush()
+}
+
+// writeIPSet renders input for `ipset restore`.  ipset has no notion of an action; the sets are
+// meant to be referenced from existing iptables rules.
+func writeIPSet(w *bufio.Writer, name string, sets []*addressSet, action string) {
+	for _, set := range sets {
+		family := "inet"
+		if set.IPv6 {
+			family = "inet6"
+		}
+		fmt.Fprintf(w, "create %s hash:ip family %s -exist\n", set.Name, family)
+		fmt.Fprintf(w, "flush %s\n", set.Name)
+		for _, addr := range set.Addrs {
+			fmt.Fprintf(w, "add %s %s\n", set.Name, addr)
+		}
+	}
+}
+
+// writeNFTables renders a self-contained table for `nft -f` with one set per family and an input
+// chain applying the action to both.
+func writeNFTables(w *bufio.Writer, name string, sets []*addressSet, action string) {
+	fmt.Fprintf(w, "table inet %s\n", name)
+	fmt.Fprintf(w, "flush table inet %s\n", name)
+	fmt.Fprintf(w, "table inet %s {\n", name)
+	for _, set := range sets {
+		addrType := "ipv4_addr"
+		if set.IPv6 {
+			addrType = "ipv6_addr"
+		}
+		fmt.Fprintf(w, "\tset %s {\n", set.Name)
+		fmt.Fprintf(w, "\t\ttype %s\n", addrType)
+		if len(set.Addrs) > 0 {
+			fmt.Fprintf(w, "\t\telements = {\n")
+			for i, addr := range set.Addrs {
+				sep := ","
+				if i == len(set.Addrs)-1 {
+					sep = ""
+				}
+				fmt.Fprintf(w, "\t\t\t%s%s\n", addr, sep)
+			}
+			fmt.Fprintf(w, "\t\t}\n")
+		}
+		fmt.Fprintf(w, "\t}\n")
+	}
+	fmt.Fprintf(w, "\tchain input {\n")
+	fmt.Fprintf(w, "\t\ttype filter hook input priority 0; policy accept;\n")
+	for _, set := range sets {
+		proto := "ip"
+		if set.IPv6 {
+			proto = "ip6"
+		}
+		fmt.Fprintf(w, "\t\t%s saddr @%s %s\n", proto, set.Name, action)
+	}
+	fmt.Fprintf(w, "\t}\n")
+	fmt.Fprintf(w, "}\n")
+}
+
+// writeIPTables renders a filter table for iptables-restore (and ip6tables-restore) that fills a
+// dedicated chain.  Each family is a separate COMMIT block; use Family to produce a file that can
+// be fed directly to one of the two tools.
+func writeIPTables(w *bufio.Writer, name string, sets []*addressSet, action string) {
+	for _, set := range sets {
+		tool := "iptables-restore"
+		if set.IPv6 {
+			tool = "ip6tables-restore"
+		}
+		fmt.Fprintf(w, "# %s --noflush\n", tool)
+		fmt.Fprintf(w, "*filter\n")
+		fmt.Fprintf(w, ":%s - [0:0]\n", set.Name)
+		fmt.Fprintf(w, "-F %s\n", set.Name)
+		for _, addr := range set.Addrs {
+			fmt.Fprintf(w, "-A %s -s %s -j %s\n", set.Name, addr, strings.ToUpper(action))
+		}
+		fmt.Fprintf(w, "COMMIT\n")
+	}
+}
+
+// writePF renders persistent pf tables and the rules referencing them.
+func writePF(w *bufio.Writer, name string, sets []*addressSet, action string) {
+	rule := map[string]string{
+		"drop":   "block drop in quick",
+		"reject": "block return in quick",
+		"accept": "pass in quick",
+	}[action]
+
+	for _, set := range sets {
+		fmt.Fprintf(w, "table <%s> persist {", set.Name)
+		for _, addr := range set.Addrs {
+			fmt.Fprintf(w, " \\\n\t%s", addr)
+		}
+		fmt.Fprintf(w, " }\n")
+	}
+	for _, set := range sets {
+		family := "inet"
+		if set.IPv6 {
+			family = "inet6"
+		}
+		fmt.Fprintf(w, "%s %s from <%s>\n", rule, family, set.Name)
+	}
+}
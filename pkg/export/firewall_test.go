@@ -0,0 +1,78 @@
+package export_test
+
+import (
+	"testing"
+
+	"github.com/humper/tor_exit_nodes/pkg/export"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateOptions(t *testing.T) {
+	require.NoError(t, (&export.Options{}).Validate())
+	require.NoError(t, (&export.Options{SetName: "tor", Action: "reject", Family: "ipv6"}).Validate())
+
+	require.Error(t, (&export.Options{SetName: "tor; rm -rf /"}).Validate())
+	require.Error(t, (&export.Options{SetName: "a_name_that_is_far_too_long_for_ipset"}).Validate())
+	require.Error(t, (&export.Options{Action: "log"}).Validate())
+	require.Error(t, (&export.Options{Family: "ipx"}).Validate())
+}
+
+func TestIPSet(t *testing.T) {
+	out := render(t, "ipset", &export.Options{SetName: "tor"})
+	assert.Equal(t, `create tor_v4 hash:ip family inet -exist
+flush tor_v4
+add tor_v4 103.163.218.11
+add tor_v4 103.193.179.233
+create tor_v6 hash:ip family inet6 -exist
+flush tor_v6
+add tor_v6 2a0b:f4c2::1
+`, out)
+}
+
+func TestNFTables(t *testing.T) {
+	out := render(t, "nftables", &export.Options{SetName: "tor", Action: "reject"})
+	assert.Equal(t, `table inet tor
+flush table inet tor
+table inet tor {
+	set tor_v4 {
+		type ipv4_addr
+		elements = {
+			103.163.218.11,
+			103.193.179.233
+		}
+	}
+	set tor_v6 {
+		type ipv6_addr
+		elements = {
+			2a0b:f4c2::1
+		}
+	}
+	chain input {
+		type filter hook input priority 0; policy accept;
+		ip saddr @tor_v4 reject
+		ip6 saddr @tor_v6 reject
+	}
+}
+`, out)
+}
+
+func TestIPTablesFamily(t *testing.T) {
+	out := render(t, "iptables", &export.Options{Family: "ipv4"})
+	assert.Equal(t, `# iptables-restore --noflush
+*filter
+:tor_exit_nodes_v4 - [0:0]
+-F tor_exit_nodes_v4
+-A tor_exit_nodes_v4 -s 103.163.218.11 -j DROP
+-A tor_exit_nodes_v4 -s 103.193.179.233 -j DROP
+COMMIT
+`, out)
+}
+
+func TestPF(t *testing.T) {
+	out := render(t, "pf", &export.Options{Family: "ipv6", Action: "accept"})
+	assert.Equal(t, `table <tor_exit_nodes_v6> persist { \
+	2a0b:f4c2::1 }
+pass in quick inet6 from <tor_exit_nodes_v6>
+`, out)
+}
@@ -0,0 +1,40 @@
+package export
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/humper/tor_exit_nodes/models"
+)
+
+func init() {
+	register(&Format{
+		Name:        "ndjson",
+		ContentType: "application/x-ndjson",
+		Extension:   "ndjson",
+		New: func(w io.Writer, opts *Options) Encoder {
+			return &ndjsonEncoder{enc: json.NewEncoder(w), columns: opts.getColumns()}
+		},
+	})
+}
+
+type ndjsonEncoder struct {
+	enc     *json.Encoder
+	columns []string
+}
+
+func (e *ndjsonEncoder) Begin() error {
+	return nil
+}
+
+func (e *ndjsonEncoder) Encode(node *models.TorExitNode) error {
+	record := make(map[string]string, len(e.columns))
+	for _, col := range e.columns {
+		record[col] = columns[col](node)
+	}
+	return e.enc.Encode(record)
+}
+
+func (e *ndjsonEncoder) End() error {
+	return nil
+}
@@ -0,0 +1,73 @@
+package export
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/humper/tor_exit_nodes/models"
+)
+
+func init() {
+	register(&Format{
+		Name:        "nginx",
+		ContentType: "text/plain; charset=utf-8",
+		Extension:   "conf",
+		New: func(w io.Writer, opts *Options) Encoder {
+			return &lineEncoder{
+				w:      w,
+				header: fmt.Sprintf("geo $%s {\n\tdefault 0;\n", opts.getSetName()),
+				line:   "\t%s 1;\n",
+				footer: "}\n",
+			}
+		},
+	})
+	register(&Format{
+		Name:        "haproxy",
+		ContentType: "text/plain; charset=utf-8",
+		Extension:   "acl",
+		New: func(w io.Writer, opts *Options) Encoder {
+			return &lineEncoder{
+				w:      w,
+				header: "# Tor exit nodes, for use with: acl <name> src -f <this file>\n",
+				line:   "%s\n",
+			}
+		},
+	})
+	register(&Format{
+		Name:        "apache",
+		ContentType: "text/plain; charset=utf-8",
+		Extension:   "conf",
+		New: func(w io.Writer, opts *Options) Encoder {
+			return &lineEncoder{
+				w:      w,
+				header: "<RequireAll>\n\tRequire all granted\n",
+				line:   "\tRequire not ip %s\n",
+				footer: "</RequireAll>\n",
+			}
+		},
+	})
+}
+
+// lineEncoder writes a fixed header, one formatted line per IP address and a fixed footer.
+// The reverse proxies all accept IPv4 and IPv6 addresses side by side, so no buffering is needed.
+type lineEncoder struct {
+	w      io.Writer
+	header string
+	line   string
+	footer string
+}
+
+func (e *lineEncoder) Begin() error {
+	_, err := io.WriteString(e.w, e.header)
+	return err
+}
+
+func (e *lineEncoder) Encode(node *models.TorExitNode) error {
+	_, err := fmt.Fprintf(e.w, e.line, node.IP)
+	return err
+}
+
+func (e *lineEncoder) End() error {
+	_, err := io.WriteString(e.w, e.footer)
+	return err
+}
@@ -0,0 +1,39 @@
+package export_test
+
+import (
+	"testing"
+
+	"github.com/humper/tor_exit_nodes/pkg/export"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNginx(t *testing.T) {
+	out := render(t, "nginx", &export.Options{SetName: "is_tor"})
+	assert.Equal(t, `geo $is_tor {
+	default 0;
+	103.163.218.11 1;
+	2a0b:f4c2::1 1;
+	103.193.179.233 1;
+}
+`, out)
+}
+
+func TestHAProxy(t *testing.T) {
+	out := render(t, "haproxy", nil)
+	assert.Equal(t, `# Tor exit nodes, for use with: acl <name> src -f <this file>
+103.163.218.11
+2a0b:f4c2::1
+103.193.179.233
+`, out)
+}
+
+func TestApache(t *testing.T) {
+	out := render(t, "apache", nil)
+	assert.Equal(t, `<RequireAll>
+	Require all granted
+	Require not ip 103.163.218.11
+	Require not ip 2a0b:f4c2::1
+	Require not ip 103.193.179.233
+</RequireAll>
+`, out)
+}
@@ -0,0 +1,17 @@
+package export
+
+import (
+	"io"
+)
+
+func init() {
+	// one IP address per line, ignoring the column selection
+	register(&Format{
+		Name:        "txt",
+		ContentType: "text/plain; charset=utf-8",
+		Extension:   "txt",
+		New: func(w io.Writer, opts *Options) Encoder {
+			return &lineEncoder{w: w, line: "%s\n"}
+		},
+	})
+}
@@ -0,0 +1,151 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is a plain text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(ctx context.Context, msg *Message) error
+}
+
+// Config picks how mail is sent: through SMTP if it's configured, otherwise to File.
+type Config struct {
+	From string      `yaml:"from"`
+	SMTP *SMTPConfig `yaml:"smtp"`
+	// File receives messages for local testing when SMTP isn't configured.  If it's empty they're
+	// written to the log.
+	File string `yaml:"file"`
+}
+
+type SMTPConfig struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	// PasswordFile holds the password, for keeping it out of the config file.
+	PasswordFile string `yaml:"password_file"`
+}
+
+// DefaultFrom is the sender when none is configured.
+const DefaultFrom = "ten@localhost"
+
+func New(cfg *Config) (Mailer, error) {
+	from := cfg.From
+	if from == "" {
+		from = DefaultFrom
+	}
+	if cfg.SMTP == nil {
+		return &FileMailer{From: from, Path: cfg.File}, nil
+	}
+
+	password := cfg.SMTP.Password
+	if cfg.SMTP.PasswordFile != "" {
+		b, err := os.ReadFile(cfg.SMTP.PasswordFile)
+		if err != nil {
+			return nil, err
+		}
+		password = strings.TrimSpace(string(b))
+	}
+	port := cfg.SMTP.Port
+	if port == 0 {
+		port = 587
+	}
+	return &SMTPMailer{
+		From:     from,
+		Addr:     net.JoinHostPort(cfg.SMTP.Host, strconv.Itoa(port)),
+		Host:     cfg.SMTP.Host,
+		Username: cfg.SMTP.Username,
+		Password: password,
+	}, nil
+}
+
+// checkRecipient stops addresses, which come from users, from adding headers to a message.
+func checkRecipient(msg *Message) error {
+	if strings.ContainsAny(msg.To, "\r\n") {
+		return fmt.Errorf("invalid recipient %q", msg.To)
+	}
+	return nil
+}
+
+// Format renders msg with the headers needed to send it.
+func Format(from string, msg *Message, date time.Time) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	b.WriteString("\r\n")
+	return b.Bytes()
+}
+
+// SMTPMailer sends mail through an SMTP server, using STARTTLS when the server offers it.
+type SMTPMailer struct {
+	From     string
+	Addr     string
+	Host     string
+	Username string
+	Password string
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg *Message) error {
+	if err := checkRecipient(msg); err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+	return smtp.SendMail(m.Addr, auth, m.From, []string{msg.To}, Format(m.From, msg, time.Now()))
+}
+
+// FileMailer appends messages to a file instead of sending them, or logs them if Path is empty.
+type FileMailer struct {
+	From  string
+	Path  string
+	mutex sync.Mutex
+}
+
+func (m *FileMailer) Send(ctx context.Context, msg *Message) error {
+	if err := checkRecipient(msg); err != nil {
+		return err
+	}
+	if m.Path == "" {
+		slog.InfoContext(ctx, "Mail", "to", msg.To, "subject", msg.Subject, "body", msg.Body)
+		return nil
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	f, err := os.OpenFile(m.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(Format(m.From, msg, time.Now()), "\r\n"...)); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,54 @@
+package mail_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/humper/tor_exit_nodes/pkg/mail"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormat(t *testing.T) {
+	msg := &mail.Message{To: "test@test.com", Subject: "Hello", Body: "line one\nline two"}
+	date := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	assert.Equal(t, "From: ten@localhost\r\n"+
+		"To: test@test.com\r\n"+
+		"Subject: Hello\r\n"+
+		"Date: Tue, 02 Jan 2024 03:04:05 +0000\r\n"+
+		"MIME-Version: 1.0\r\n"+
+		"Content-Type: text/plain; charset=utf-8\r\n"+
+		"\r\n"+
+		"line one\r\nline two\r\n", string(mail.Format("ten@localhost", msg, date)))
+}
+
+func TestFileMailer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mail.txt")
+	mailer, err := mail.New(&mail.Config{File: path})
+	require.NoError(t, err)
+
+	require.NoError(t, mailer.Send(context.Background(), &mail.Message{To: "a@test.com", Subject: "First", Body: "one"}))
+	require.NoError(t, mailer.Send(context.Background(), &mail.Message{To: "b@test.com", Subject: "Second", Body: "two"}))
+
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(b), "From: "+mail.DefaultFrom)
+	assert.Contains(t, string(b), "To: a@test.com")
+	assert.Contains(t, string(b), "Subject: Second")
+	assert.Contains(t, string(b), "two")
+
+	assert.Error(t, mailer.Send(context.Background(), &mail.Message{To: "a@test.com\r\nBcc: b@test.com", Subject: "Injected"}))
+}
+
+func TestNewSMTP(t *testing.T) {
+	mailer, err := mail.New(&mail.Config{From: "ten@example.com", SMTP: &mail.SMTPConfig{Host: "smtp.example.com"}})
+	require.NoError(t, err)
+	smtpMailer, ok := mailer.(*mail.SMTPMailer)
+	require.True(t, ok)
+	assert.Equal(t, "smtp.example.com:587", smtpMailer.Addr)
+	assert.Equal(t, "ten@example.com", smtpMailer.From)
+}
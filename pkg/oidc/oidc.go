@@ -0,0 +1,161 @@
+package oidc
+
+import (
+	"context"
+	"fmt"
+
+	gooidc "github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// GroupRole grants Role to members of the IdP group Group.
+type GroupRole struct {
+	Group string `yaml:"group"`
+	Role  string `yaml:"role"`
+}
+
+type Config struct {
+	// Issuer is the IdP's issuer URL; its endpoints are found through OIDC discovery.
+	Issuer       string `yaml:"issuer"`
+	ClientID     string `yaml:"client_id"`
+	ClientSecret string `yaml:"client_secret"`
+	// RedirectURL is ten's callback, e.g. https://ten.example.com/login/oidc/callback.
+	RedirectURL string   `yaml:"redirect_url"`
+	Scopes      []string `yaml:"scopes"`
+	// GroupsClaim names the ID token claim listing the user's groups.  Defaults to "groups".
+	GroupsClaim string `yaml:"groups_claim"`
+	// GroupRoles are checked in order; the first group the user belongs to picks their role.
+	GroupRoles []GroupRole `yaml:"group_roles"`
+	// RequireGroup refuses logins from users in none of the GroupRoles groups.
+	RequireGroup bool `yaml:"require_group"`
+	// PostLoginURL is where the browser is sent after logging in.  Defaults to "/".
+	PostLoginURL string `yaml:"post_login_url"`
+}
+
+// Identity is what ten learns about a user from their ID token.
+type Identity struct {
+	Subject string
+	Email   string
+	Name    string
+	Groups  []string
+}
+
+// Provider runs the authorization code flow against one IdP.
+type Provider struct {
+	cfg      *Config
+	oauth2   *oauth2.Config
+	verifier *gooidc.IDTokenVerifier
+}
+
+// New discovers the IdP's endpoints and keys from cfg.Issuer.
+func New(ctx context.Context, cfg *Config) (*Provider, error) {
+	if cfg.Issuer == "" || cfg.ClientID == "" || cfg.RedirectURL == "" {
+		return nil, fmt.Errorf("OIDC needs an issuer, client_id and redirect_url")
+	}
+
+	provider, err := gooidc.NewProvider(ctx, cfg.Issuer)
+	if err != nil {
+		return nil, fmt.Errorf("OIDC discovery for %s failed: %v", cfg.Issuer, err)
+	}
+
+	scopes := cfg.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"profile", "email", "groups"}
+	}
+
+	return &Provider{
+		cfg: cfg,
+		oauth2: &oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			RedirectURL:  cfg.RedirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       append([]string{gooidc.ScopeOpenID}, scopes...),
+		},
+		verifier: provider.Verifier(&gooidc.Config{ClientID: cfg.ClientID}),
+	}, nil
+}
+
+// AuthCodeURL returns the IdP login URL for a flow identified by state, with nonce bound into the
+// ID token and the PKCE challenge derived from verifier.
+func (p *Provider) AuthCodeURL(state, nonce, verifier string) string {
+	return p.oauth2.AuthCodeURL(state, gooidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier))
+}
+
+// Exchange redeems an authorization code and verifies the ID token it returns.
+func (p *Provider) Exchange(ctx context.Context, code, nonce, verifier string) (*Identity, error) {
+	token, err := p.oauth2.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, fmt.Errorf("code exchange failed: %v", err)
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, fmt.Errorf("token response has no id_token")
+	}
+	idToken, err := p.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token: %v", err)
+	}
+	if idToken.Nonce != nonce {
+		return nil, fmt.Errorf("ID token nonce mismatch")
+	}
+
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, err
+	}
+
+	identity := &Identity{Subject: idToken.Subject}
+	identity.Email, _ = claims["email"].(string)
+	identity.Name, _ = claims["name"].(string)
+	if identity.Email == "" {
+		return nil, fmt.Errorf("ID token has no email claim")
+	}
+	// users are linked to accounts by email, so an address the IdP doesn't vouch for could take
+	// over someone else's account
+	if verified, _ := claims["email_verified"].(bool); !verified {
+		return nil, fmt.Errorf("email %s is not verified", identity.Email)
+	}
+
+	groupsClaim := p.cfg.GroupsClaim
+	if groupsClaim == "" {
+		groupsClaim = "groups"
+	}
+	switch groups := claims[groupsClaim].(type) {
+	case []interface{}:
+		for _, group := range groups {
+			if g, ok := group.(string); ok {
+				identity.Groups = append(identity.Groups, g)
+			}
+		}
+	case string:
+		identity.Groups = []string{groups}
+	}
+
+	return identity, nil
+}
+
+// Role returns the role for the first configured group the identity belongs to, or false if it
+// belongs to none of them.
+func (p *Provider) Role(identity *Identity) (string, bool) {
+	for _, gr := range p.cfg.GroupRoles {
+		for _, group := range identity.Groups {
+			if group == gr.Group {
+				return gr.Role, true
+			}
+		}
+	}
+	return "", false
+}
+
+func (p *Provider) RequireGroup() bool {
+	return p.cfg.RequireGroup
+}
+
+func (p *Provider) PostLoginURL() string {
+	if p.cfg.PostLoginURL == "" {
+		return "/"
+	}
+	return p.cfg.PostLoginURL
+}
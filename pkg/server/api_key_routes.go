@@ -0,0 +1,145 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/humper/tor_exit_nodes/models"
+	"github.com/humper/tor_exit_nodes/pkg/auth"
+	"github.com/lib/pq"
+)
+
+type CreateAPIKeyRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// CreateAPIKeyResponse is the only time the key itself is returned.
+type CreateAPIKeyResponse struct {
+	*models.APIKey
+	Key string `json:"key"`
+}
+
+func (s *Server) AddAPIKeyRoutes(ctx context.Context, mux *http.ServeMux) {
+	// a key acts as its owner, so only they may create one; user managers can list and revoke them
+	mux.HandleFunc("POST /users/{id}/apikeys", s.requireSelf(func(w http.ResponseWriter, r *http.Request) {
+		s.HandleCreateAPIKey(ctx, w, r)
+	}))
+	mux.HandleFunc("GET /users/{id}/apikeys", s.requireSelfOr(auth.PermissionUsersWrite, func(w http.ResponseWriter, r *http.Request) {
+		s.HandleGetAPIKeys(ctx, w, r)
+	}))
+	mux.HandleFunc("DELETE /users/{id}/apikeys/{keyId}", s.requireSelfOr(auth.PermissionUsersWrite, func(w http.ResponseWriter, r *http.Request) {
+		s.HandleDeleteAPIKey(ctx, w, r)
+	}))
+}
+
+// apiKeyOwner returns the user ID from the path.  Keys can only be managed from a login session,
+// never with another API key.
+func apiKeyOwner(w http.ResponseWriter, r *http.Request) (uint, bool) {
+	if auth.GetAPIKey(r.Context()) != nil {
+		HttpError(w, "API keys can't manage API keys", http.StatusForbidden)
+		return 0, false
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		HttpError(w, "Invalid user id", http.StatusBadRequest)
+		return 0, false
+	}
+	return uint(id), true
+}
+
+func (s *Server) HandleCreateAPIKey(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	userID, ok := apiKeyOwner(w, r)
+	if !ok {
+		return
+	}
+
+	var req CreateAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		HttpError(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if len(req.Scopes) == 0 {
+		req.Scopes = auth.DefaultScopes
+	}
+	if err := auth.ValidatePermissions(req.Scopes); err != nil {
+		HttpError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.ExpiresAt != nil && req.ExpiresAt.Before(time.Now()) {
+		HttpError(w, "expires_at is in the past", http.StatusBadRequest)
+		return
+	}
+
+	owner, err := s.db.Users.GetByID(ctx, userID)
+	if err != nil {
+		HttpError(w, "Unknown user", http.StatusNotFound)
+		return
+	}
+
+	rawKey, prefix, hash, err := auth.GenerateAPIKey()
+	if err != nil {
+		HttpError(w, "Failed to generate API key", http.StatusInternalServerError)
+		return
+	}
+
+	key := &models.APIKey{
+		UserID:    userID,
+		Name:      req.Name,
+		Prefix:    prefix,
+		Hash:      hash,
+		Scopes:    pq.StringArray(req.Scopes),
+		ExpiresAt: req.ExpiresAt,
+	}
+	if err := s.db.APIKeys.Create(ctx, key); err != nil {
+		slog.ErrorContext(ctx, "Failed to create API key", "error", err, "user_id", userID)
+		HttpError(w, "Failed to create API key", http.StatusInternalServerError)
+		return
+	}
+	s.audit(ctx, r, &models.AuditEvent{Action: models.AuditAPIKeyCreate, TargetType: "api_key", TargetID: key.ID, OrganizationID: owner.OrganizationID}, nil, key)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(CreateAPIKeyResponse{APIKey: key, Key: rawKey})
+}
+
+func (s *Server) HandleGetAPIKeys(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	userID, ok := apiKeyOwner(w, r)
+	if !ok {
+		return
+	}
+
+	keys, err := s.db.APIKeys.GetByUser(ctx, userID)
+	if err != nil {
+		HttpError(w, "Failed to get API keys", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(keys)
+}
+
+func (s *Server) HandleDeleteAPIKey(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	userID, ok := apiKeyOwner(w, r)
+	if !ok {
+		return
+	}
+
+	keyID, err := strconv.Atoi(r.PathValue("keyId"))
+	if err != nil {
+		HttpError(w, "Invalid key id", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.db.APIKeys.Delete(ctx, userID, uint(keyID)); err != nil {
+		HttpError(w, "Unknown API key", http.StatusNotFound)
+		return
+	}
+	s.audit(ctx, r, &models.AuditEvent{Action: models.AuditAPIKeyDelete, TargetType: "api_key", TargetID: uint(keyID), OrganizationID: s.userOrg(ctx, r, userID)}, nil, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,183 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/humper/tor_exit_nodes/models"
+	"github.com/humper/tor_exit_nodes/pkg/auth"
+	"github.com/humper/tor_exit_nodes/pkg/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newAPIKeyServer returns a server whose user may only log in from 1.1.1.1, one of its exit nodes.
+func newAPIKeyServer(t *testing.T) *testServer {
+	ctx := context.Background()
+	s := newTestServer(t, &server.NewServerParams{})
+	s.user.AllowedIPs = []string{"1.1.1.1"}
+	require.NoError(t, s.db.Users.Update(ctx, s.user))
+	require.NoError(t, s.db.TorExitNodes.DeleteAndAdd(ctx, nil, []*models.TorExitNode{
+		{IP: "1.1.1.1"},
+		{IP: "2.2.2.2"},
+	}))
+	return s
+}
+
+func createAPIKey(t *testing.T, s *testServer, user *models.User, body string) *server.CreateAPIKeyResponse {
+	req, err := http.NewRequest("POST", fmt.Sprintf("/users/%d/apikeys", user.ID), bytes.NewBufferString(body))
+	require.NoError(t, err)
+	addAuth(req, s.db.Sessions, user)
+
+	recorder := httptest.NewRecorder()
+	s.GetHandler().ServeHTTP(recorder, req)
+	require.Equal(t, http.StatusCreated, recorder.Code, recorder.Body.String())
+
+	var resp server.CreateAPIKeyResponse
+	require.NoError(t, json.NewDecoder(recorder.Body).Decode(&resp))
+	return &resp
+}
+
+func getTor(s *testServer, header, value string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("GET", "/tor", nil)
+	req.Header.Set(header, value)
+	recorder := httptest.NewRecorder()
+	s.GetHandler().ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestAPIKeyGetTor(t *testing.T) {
+	s := newAPIKeyServer(t)
+	db, user := s.db, s.user
+
+	created := createAPIKey(t, s, user, `{"name": "siem"}`)
+	assert.Equal(t, []string{"nodes:read"}, []string(created.Scopes))
+	assert.NotEmpty(t, created.Key)
+
+	for _, header := range []struct{ name, value string }{
+		{"Authorization", "Bearer " + created.Key},
+		{"X-API-Key", created.Key},
+	} {
+		recorder := getTor(s, header.name, header.value)
+		require.Equal(t, http.StatusOK, recorder.Code, header.name)
+
+		var response models.TENPagination
+		require.NoError(t, json.NewDecoder(recorder.Body).Decode(&response))
+		require.Len(t, response.Rows, 1, "the key owner's exclusions should apply")
+		assert.Equal(t, "2.2.2.2", response.Rows[0].IP)
+	}
+
+	keys, err := db.APIKeys.GetByUser(context.Background(), user.ID)
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	assert.NotNil(t, keys[0].LastUsedAt)
+	assert.NotEqual(t, created.Key, keys[0].Hash, "keys must be stored hashed")
+}
+
+func TestAPIKeyRejected(t *testing.T) {
+	s := newAPIKeyServer(t)
+	db, user := s.db, s.user
+	created := createAPIKey(t, s, user, `{}`)
+
+	assert.Equal(t, http.StatusUnauthorized, getTor(s, "X-API-Key", "not-a-key").Code)
+	assert.Equal(t, http.StatusUnauthorized, getTor(s, "X-API-Key", created.Key[:len(created.Key)-1]+"x").Code)
+
+	// expired
+	expired := time.Now().Add(-time.Minute)
+	key, prefix, hash, err := auth.GenerateAPIKey()
+	require.NoError(t, err)
+	require.NoError(t, db.APIKeys.Create(context.Background(), &models.APIKey{UserID: user.ID, Prefix: prefix, Hash: hash, ExpiresAt: &expired}))
+	assert.Equal(t, http.StatusUnauthorized, getTor(s, "X-API-Key", key).Code)
+
+	// deleted
+	stored, err := db.APIKeys.GetByPrefix(context.Background(), created.Prefix)
+	require.NoError(t, err)
+	require.NoError(t, db.APIKeys.Delete(context.Background(), user.ID, stored.ID))
+	assert.Equal(t, http.StatusUnauthorized, getTor(s, "X-API-Key", created.Key).Code)
+}
+
+func TestAPIKeyScopes(t *testing.T) {
+	s := newAPIKeyServer(t)
+	user := s.user
+	created := createAPIKey(t, s, user, `{"scopes": ["users:read"]}`)
+
+	assert.Equal(t, http.StatusForbidden, getTor(s, "X-API-Key", created.Key).Code)
+
+	req, err := http.NewRequest("POST", fmt.Sprintf("/users/%d/apikeys", user.ID), bytes.NewBufferString(`{"scopes": ["everything"]}`))
+	require.NoError(t, err)
+	addAuth(req, s.db.Sessions, user)
+	recorder := httptest.NewRecorder()
+	s.GetHandler().ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+}
+
+func TestAPIKeyCannotManageKeys(t *testing.T) {
+	s := newAPIKeyServer(t)
+	db, user := s.db, s.user
+	created := createAPIKey(t, s, user, `{}`)
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("/users/%d/apikeys", user.ID), nil)
+	require.NoError(t, err)
+	req.Header.Set("X-API-Key", created.Key)
+	recorder := httptest.NewRecorder()
+	s.GetHandler().ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusForbidden, recorder.Code)
+
+	other := &models.User{Email: "other@test.com", Role: "user"}
+	require.NoError(t, db.Users.Create(context.Background(), other))
+	other, err = db.Users.GetByEmail(context.Background(), other.Email)
+	require.NoError(t, err)
+	req, err = http.NewRequest("GET", fmt.Sprintf("/users/%d/apikeys", user.ID), nil)
+	require.NoError(t, err)
+	addAuth(req, s.db.Sessions, other)
+	recorder = httptest.NewRecorder()
+	s.GetHandler().ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusForbidden, recorder.Code)
+}
+
+func TestAPIKeyListAndRevoke(t *testing.T) {
+	s := newAPIKeyServer(t)
+	user := s.user
+	created := createAPIKey(t, s, user, `{"name": "cron"}`)
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("/users/%d/apikeys", user.ID), nil)
+	require.NoError(t, err)
+	addAuth(req, s.db.Sessions, user)
+	recorder := httptest.NewRecorder()
+	s.GetHandler().ServeHTTP(recorder, req)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	assert.NotContains(t, recorder.Body.String(), created.Key)
+
+	var keys []*models.APIKey
+	require.NoError(t, json.NewDecoder(recorder.Body).Decode(&keys))
+	require.Len(t, keys, 1)
+	assert.Equal(t, "cron", keys[0].Name)
+	assert.Equal(t, created.Prefix, keys[0].Prefix)
+
+	req, err = http.NewRequest("DELETE", fmt.Sprintf("/users/%d/apikeys/%d", user.ID, created.ID), nil)
+	require.NoError(t, err)
+	addAuth(req, s.db.Sessions, user)
+	recorder = httptest.NewRecorder()
+	s.GetHandler().ServeHTTP(recorder, req)
+	require.Equal(t, http.StatusNoContent, recorder.Code)
+
+	assert.Equal(t, http.StatusUnauthorized, getTor(s, "X-API-Key", created.Key).Code)
+}
+
+func TestAPIKeyManagersCannotCreate(t *testing.T) {
+	s := newAPIKeyServer(t)
+	path := fmt.Sprintf("/users/%d/apikeys", s.user.ID)
+
+	// a key would let the manager act as the user
+	assert.Equal(t, http.StatusForbidden, serve(s, "POST", path, `{"scopes": ["nodes:read"]}`, s.admin).Code)
+
+	created := createAPIKey(t, s, s.user, `{}`)
+	assert.Equal(t, http.StatusOK, serve(s, "GET", path, "", s.admin).Code)
+	assert.Equal(t, http.StatusNoContent, serve(s, "DELETE", fmt.Sprintf("%s/%d", path, created.ID), "", s.admin).Code)
+}
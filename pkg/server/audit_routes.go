@@ -0,0 +1,184 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"regexp"
+	"slices"
+
+	"github.com/humper/tor_exit_nodes/models"
+	"github.com/humper/tor_exit_nodes/pkg/auth"
+)
+
+func (s *Server) AddAuditRoutes(ctx context.Context, mux *http.ServeMux) {
+	mux.HandleFunc("GET /admin/audit", s.require(auth.PermissionAuditRead, func(w http.ResponseWriter, r *http.Request) {
+		s.HandleGetAuditEvents(ctx, w, r)
+	}))
+}
+
+// HandleGetAuditEvents lists audit events, newest first by default, optionally filtered and sorted on
+// models.AuditFilterColumns.  Callers who can't manage organizations only see events in theirs.
+func (s *Server) HandleGetAuditEvents(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	pagination, err := getPagination(w, r)
+	if err != nil {
+		return
+	}
+	if !checkColumns(w, pagination, models.AuditFilterColumns) {
+		return
+	}
+
+	if s.global(r) {
+		pagination, err = s.db.AuditLog.GetAll(ctx, pagination)
+	} else {
+		org, ok := s.ownOrg(w, r)
+		if !ok {
+			return
+		}
+		pagination, err = s.db.AuditLog.GetAllByOrganization(ctx, org, pagination)
+	}
+	if err != nil {
+		HttpError(w, "Failed to get audit events", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pagination)
+}
+
+// audit records an action the caller of r took.  before and after are the target's state either side
+// of it, nil for creations and deletions respectively; only the fields that differ are kept.  The
+// actor is the caller unless event names one.
+func (s *Server) audit(ctx context.Context, r *http.Request, event *models.AuditEvent, before, after any) {
+	if actor := auth.GetUser(r.Context()); actor != nil && event.ActorID == nil {
+		event.ActorID = &actor.ID
+		event.ActorEmail = actor.Email
+	}
+	event.Changes = auditChanges(before, after)
+	event.RequestID = getRequestID(r.Context())
+	event.IP = clientIP(r)
+	if err := s.db.AuditLog.Create(ctx, event); err != nil {
+		slog.ErrorContext(ctx, "Failed to record audit event", "error", err, "action", event.Action, "target_id", event.TargetID)
+	}
+}
+
+// auditUser records an action the caller of r took on user, as audit does.
+func (s *Server) auditUser(ctx context.Context, r *http.Request, action string, user *models.User, before, after any) {
+	s.audit(ctx, r, &models.AuditEvent{Action: action, TargetType: "user", TargetID: user.ID, OrganizationID: user.OrganizationID}, before, after)
+}
+
+// auditSelf records a change user made to their own account without being logged in, with a mailed
+// token or through single sign-on.
+func (s *Server) auditSelf(ctx context.Context, r *http.Request, action string, user, before *models.User) {
+	event := &models.AuditEvent{
+		ActorID:        &user.ID,
+		ActorEmail:     user.Email,
+		OrganizationID: user.OrganizationID,
+		Action:         action,
+		TargetType:     "user",
+		TargetID:       user.ID,
+	}
+	s.audit(ctx, r, event, before, user)
+}
+
+// userOrg returns the organization of the user with id, usually the caller, for auditing actions on
+// things they own.
+func (s *Server) userOrg(ctx context.Context, r *http.Request, id uint) uint {
+	if caller := auth.GetUser(r.Context()); caller != nil && caller.ID == id {
+		return caller.OrganizationID
+	}
+	user, err := s.db.Users.GetByID(ctx, id)
+	if err != nil {
+		return 0
+	}
+	return user.OrganizationID
+}
+
+// auditIgnored fields change as a side effect of every write.
+var auditIgnored = []string{"CreatedAt", "UpdatedAt", "DeletedAt"}
+
+// auditRedacted fields are recorded as having changed, but not what to.
+var auditRedacted = []string{"Password"}
+
+func auditChanges(before, after any) models.AuditChanges {
+	beforeFields, afterFields := auditFields(before), auditFields(after)
+
+	changes := models.AuditChanges{}
+	record := func(name string) {
+		if slices.Contains(auditIgnored, name) {
+			return
+		}
+		if _, ok := changes[name]; ok {
+			return
+		}
+		b, inBefore := beforeFields[name]
+		a, inAfter := afterFields[name]
+		if inBefore == inAfter && reflect.DeepEqual(b, a) {
+			return
+		}
+		if slices.Contains(auditRedacted, name) {
+			if b != nil {
+				b = "[redacted]"
+			}
+			if a != nil {
+				a = "[redacted]"
+			}
+		}
+		changes[name] = models.AuditChange{Before: b, After: a}
+	}
+	for name := range beforeFields {
+		record(name)
+	}
+	for name := range afterFields {
+		record(name)
+	}
+
+	if len(changes) == 0 {
+		return nil
+	}
+	return changes
+}
+
+// auditFields returns v's fields as they'd be encoded as JSON, so secrets tagged json:"-" are left out.
+func auditFields(v any) map[string]any {
+	if v == nil {
+		return nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return nil
+	}
+	return fields
+}
+
+type requestIDKey struct{}
+
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// withRequestID gives every request an ID for the audit log, taken from its X-Request-ID header when
+// a proxy has already assigned one, and returns it in the response.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !requestIDPattern.MatchString(id) {
+			b := make([]byte, 16)
+			rand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+func getRequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
@@ -0,0 +1,99 @@
+package server_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/humper/tor_exit_nodes/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func auditEvents(t *testing.T, f *orgFixture, user *models.User, filter string) []*models.AuditEvent {
+	path := "/admin/audit"
+	if filter != "" {
+		path += "?filter=" + url.QueryEscape(filter)
+	}
+	w := serve(f.s, "GET", path, "", user)
+	require.Equal(t, http.StatusOK, w.Code)
+	var response models.AuditEventPagination
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	return response.Rows
+}
+
+func TestAuditRecordsUserChanges(t *testing.T) {
+	f := newOrgServer(t)
+	ctx := context.Background()
+	userA, err := f.db.Users.GetByID(ctx, f.userA.ID)
+	require.NoError(t, err)
+
+	body := `{"Name": "Renamed", "AllowedIPs": ["1.2.3.4"]}`
+	req := httptest.NewRequest("PUT", fmt.Sprintf("/users/%d", userA.ID), strings.NewReader(body))
+	req.Header.Set("X-Request-ID", "req-123")
+	addAuth(req, f.db.Sessions, f.superuser)
+	w := httptest.NewRecorder()
+	f.s.GetHandler().ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "req-123", w.Header().Get("X-Request-ID"))
+
+	require.Equal(t, http.StatusOK, serve(f.s, "DELETE", fmt.Sprintf("/users/%d", f.userB.ID), "", f.superuser).Code)
+
+	events := auditEvents(t, f, f.superuser, "")
+	require.Len(t, events, 2)
+	deleted, updated := events[0], events[1]
+
+	assert.Equal(t, models.AuditUserUpdate, updated.Action)
+	assert.Equal(t, "user", updated.TargetType)
+	assert.Equal(t, userA.ID, updated.TargetID)
+	assert.Equal(t, f.orgA.ID, updated.OrganizationID)
+	require.NotNil(t, updated.ActorID)
+	assert.Equal(t, f.superuser.ID, *updated.ActorID)
+	assert.Equal(t, f.superuser.Email, updated.ActorEmail)
+	assert.Equal(t, "req-123", updated.RequestID)
+	assert.NotEmpty(t, updated.IP)
+	assert.Equal(t, models.AuditChange{Before: "", After: "Renamed"}, updated.Changes["Name"])
+	assert.Equal(t, models.AuditChange{Before: nil, After: []any{"1.2.3.4"}}, updated.Changes["AllowedIPs"])
+	assert.NotContains(t, updated.Changes, "Email")
+	assert.NotContains(t, updated.Changes, "UpdatedAt")
+
+	assert.Equal(t, models.AuditUserDelete, deleted.Action)
+	assert.Equal(t, f.userB.ID, deleted.TargetID)
+	assert.Equal(t, "user@b.com", deleted.Changes["Email"].Before)
+	assert.Nil(t, deleted.Changes["Email"].After)
+	assert.NotEmpty(t, deleted.RequestID, "requests without an ID get one")
+}
+
+func TestAuditFilterAndScope(t *testing.T) {
+	f := newOrgServer(t)
+	assert.Equal(t, http.StatusForbidden, serve(f.s, "GET", "/admin/audit", "", f.userA).Code)
+
+	require.Equal(t, http.StatusOK, serve(f.s, "DELETE", fmt.Sprintf("/users/%d", f.userA.ID), "", f.adminA).Code)
+	require.Equal(t, http.StatusOK, serve(f.s, "DELETE", fmt.Sprintf("/users/%d", f.userB.ID), "", f.superuser).Code)
+	require.Equal(t, http.StatusCreated, serve(f.s, "POST", "/organizations", `{"name": "c"}`, f.superuser).Code)
+
+	assert.Len(t, auditEvents(t, f, f.superuser, ""), 3)
+	events := auditEvents(t, f, f.superuser, `{"action": ["user.delete"]}`)
+	assert.Len(t, events, 2)
+	events = auditEvents(t, f, f.superuser, fmt.Sprintf(`{"actor_id": ["%d"]}`, f.adminA.ID))
+	require.Len(t, events, 1)
+	assert.Equal(t, f.userA.ID, events[0].TargetID)
+
+	// org admins only see what happened in their organization
+	events = auditEvents(t, f, f.adminA, "")
+	require.Len(t, events, 1)
+	assert.Equal(t, f.userA.ID, events[0].TargetID)
+
+	assert.Equal(t, http.StatusBadRequest, serve(f.s, "GET", "/admin/audit?filter="+url.QueryEscape(`{"1=1; --": ["x"]}`), "", f.superuser).Code)
+
+	// sorts are limited to known columns and directions
+	assert.Equal(t, http.StatusOK, serve(f.s, "GET", "/admin/audit?sort="+url.QueryEscape("target_id ASC"), "", f.superuser).Code)
+	for _, sort := range []string{"changes", "(select 1)", "id desc; drop table users", "id sideways", "id desc id"} {
+		assert.Equal(t, http.StatusBadRequest, serve(f.s, "GET", "/admin/audit?sort="+url.QueryEscape(sort), "", f.superuser).Code, sort)
+	}
+}
@@ -3,11 +3,14 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"log/slog"
 	"net/http"
 	"strconv"
 
 	"github.com/humper/tor_exit_nodes/models"
 	"github.com/humper/tor_exit_nodes/pkg/auth"
+	"github.com/humper/tor_exit_nodes/pkg/database"
 )
 
 type LoginRequest struct {
@@ -22,72 +25,177 @@ func (s *Server) HandleLogin(ctx context.Context, w http.ResponseWriter, r *http
 		return
 	}
 
-	user, err := s.db.Users.GetByEmail(ctx, loginReq.Email)
+	locked, err := s.lockedOut(ctx, loginReq.Email, clientIP(r))
 	if err != nil {
-		HttpError(w, "Unknown user", http.StatusUnauthorized)
+		slog.ErrorContext(ctx, "Failed to check lockout", "error", err)
+		HttpError(w, "Failed to log in", http.StatusInternalServerError)
+		return
+	}
+	if locked {
+		s.auditLogin(ctx, r, loginReq.Email, nil, models.LoginLocked)
+		HttpError(w, "Too many failed logins, try again later", http.StatusTooManyRequests)
+		return
+	}
+
+	// unknown users and wrong passwords look the same, down to how long they take, so that
+	// logging in can't be used to find out who has an account
+	user, err := s.db.Users.GetByEmail(ctx, loginReq.Email)
+	if err != nil || user.Password == "" {
+		s.hasher.CompareDummy(loginReq.Password)
+		if err != nil {
+			user = nil
+		}
+		s.auditLogin(ctx, r, loginReq.Email, user, models.LoginInvalidCredentials)
+		HttpError(w, "Invalid credentials", http.StatusUnauthorized)
 		return
 	}
 
 	if !auth.ComparePassword(loginReq.Password, user.Password) {
+		s.auditLogin(ctx, r, loginReq.Email, user, models.LoginInvalidCredentials)
 		HttpError(w, "Invalid credentials", http.StatusUnauthorized)
 		return
 	}
+	s.rehashPassword(ctx, user, loginReq.Password)
 
-	signedToken, expirationTime, err := auth.CreateJWT(user)
-	if err != nil {
-		HttpError(w, "Failed to create JWT", http.StatusInternalServerError)
+	if s.requireVerified && !user.EmailVerified {
+		HttpError(w, "Email address not verified", http.StatusForbidden)
 		return
 	}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:     "token",
-		Value:    signedToken,
-		Expires:  expirationTime,
-		Domain:   "localhost",
-		Path:     "/",
-		HttpOnly: true,
-	})
+	if user.TOTPEnabled {
+		s.startTOTPLogin(w, user)
+		return
+	}
+
+	if err := s.startSession(ctx, w, r, user); err != nil {
+		HttpError(w, "Failed to create session", http.StatusInternalServerError)
+		return
+	}
+	s.auditLogin(ctx, r, loginReq.Email, user, "")
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(user)
+	json.NewEncoder(w).Encode(newUserResponse(user))
+}
+
+// rehashPassword replaces user's password hash if it was made with other settings than are now
+// configured.  Logins still succeed if it fails; the hash is tried again next time.
+func (s *Server) rehashPassword(ctx context.Context, user *models.User, password string) {
+	if !s.hasher.NeedsRehash(user.Password) {
+		return
+	}
+	hash, err := s.hasher.Hash(password)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to rehash password", "error", err, "user_id", user.ID)
+		return
+	}
+	user.Password = hash
+	if err := s.db.Users.Update(ctx, user); err != nil {
+		slog.ErrorContext(ctx, "Failed to save rehashed password", "error", err, "user_id", user.ID)
+	}
 }
 
 func (s *Server) HandleRegister(ctx context.Context, w http.ResponseWriter, r *http.Request) {
-	var u models.User
-	if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
+	var req CreateUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		HttpError(w, "Invalid request", http.StatusBadRequest)
 		return
 	}
+	u := models.User{
+		Name:           req.Name,
+		Email:          req.Email,
+		Role:           req.Role,
+		AllowedIPs:     req.AllowedIPs,
+		OrganizationID: req.OrganizationID,
+	}
 
-	if _, err := s.db.Users.GetByEmail(ctx, u.Email); err == nil {
-		HttpError(w, "User already exists", http.StatusConflict)
+	// only callers who can manage users may pick a role and organization; everyone else registers
+	// as a regular user in none
+	manager := s.roles.Allowed(r.Context(), auth.PermissionUsersWrite)
+	if u.Role == "" || !manager {
+		u.Role = auth.DefaultRole
+	}
+	if !s.roles.Exists(u.Role) {
+		HttpError(w, "Unknown role", http.StatusBadRequest)
+		return
+	}
+	if manager && !s.roles.Grants(r.Context(), u.Role) {
+		HttpError(w, "Can't grant a role with permissions you don't have", http.StatusForbidden)
+		return
+	}
+	switch {
+	case !manager:
+		u.OrganizationID = 0
+	case !s.global(r):
+		org, ok := s.ownOrg(w, r)
+		if !ok {
+			return
+		}
+		u.OrganizationID = org
+	case u.OrganizationID != 0:
+		if _, err := s.db.Organizations.GetByID(ctx, u.OrganizationID); err != nil {
+			HttpError(w, "Unknown organization", http.StatusBadRequest)
+			return
+		}
+	}
+	if err := s.policy.Check(req.Password); err != nil {
+		HttpError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// registering mails the address, new or not
+	if !manager && !s.allowMail(w, r) {
 		return
 	}
 
-	hashedPassword, err := auth.HashPassword(u.Password)
+	hashedPassword, err := s.hasher.Hash(req.Password)
 	if err != nil {
 		HttpError(w, "Failed to hash password", http.StatusBadRequest)
 		return
 	}
-
 	u.Password = hashedPassword
 
 	if err := s.db.Users.Create(ctx, &u); err != nil {
-		HttpError(w, "Failed to create user", http.StatusInternalServerError)
+		if !errors.Is(err, database.ErrConflict) {
+			HttpError(w, "Failed to create user", http.StatusInternalServerError)
+			return
+		}
+		if manager {
+			HttpError(w, "User already exists", http.StatusConflict)
+			return
+		}
+		// people registering themselves aren't told the address is taken, or they could find out
+		// who has an account; the owner is told instead
+		s.mailUser(ctx, u.Email, s.sendAccountExists)
+		w.WriteHeader(http.StatusAccepted)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
+	s.auditUser(ctx, r, models.AuditUserCreate, &u, nil, &u)
+	s.mailNewUser(ctx, &u, s.sendVerification)
 
-	json.NewEncoder(w).Encode(u)
+	if !manager {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.userView(r, &u))
 }
 
 func (s *Server) HandleLogout(ctx context.Context, w http.ResponseWriter, r *http.Request) {
-	http.SetCookie(w, &http.Cookie{
-		Name:     "token",
-		Value:    "",
-		MaxAge:   0,
-		HttpOnly: true,
-	})
+	if session := auth.GetSession(r.Context()); session != nil {
+		if err := s.db.Sessions.Delete(ctx, session.ID); err != nil {
+			slog.ErrorContext(ctx, "Failed to revoke session", "error", err, "session_id", session.ID)
+		} else {
+			s.audit(ctx, r, &models.AuditEvent{Action: models.AuditSessionsRevoke, TargetType: "session", TargetID: session.ID, OrganizationID: callerOrg(r)}, nil, nil)
+		}
+	} else if cookie, err := r.Cookie(refreshCookie); err == nil && cookie.Value != "" {
+		// the access token may have expired; the refresh token still identifies the session
+		if session, err := s.db.Sessions.GetByRefreshHash(ctx, auth.HashToken(cookie.Value)); err == nil {
+			if err := s.db.Sessions.Delete(ctx, session.ID); err == nil {
+				s.audit(ctx, r, &models.AuditEvent{ActorID: &session.UserID, Action: models.AuditSessionsRevoke, TargetType: "session", TargetID: session.ID, OrganizationID: s.userOrg(ctx, r, session.UserID)}, nil, nil)
+			}
+		}
+	}
+
+	clearSessionCookies(w)
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -97,11 +205,25 @@ func (s *Server) HandleGetUsers(ctx context.Context, w http.ResponseWriter, r *h
 		return
 	}
 
-	pagination, err = s.db.Users.GetAll(ctx, pagination)
+	if s.global(r) {
+		pagination, err = s.db.Users.GetAll(ctx, pagination)
+	} else {
+		org, ok := s.ownOrg(w, r)
+		if !ok {
+			return
+		}
+		pagination, err = s.db.Users.GetAllByOrganization(ctx, org, pagination)
+	}
 	if err != nil {
 		HttpError(w, "Failed to get users", http.StatusInternalServerError)
 		return
 	}
+	users, _ := pagination.Rows.([]*models.User)
+	rows := make([]*AdminUserResponse, 0, len(users))
+	for _, user := range users {
+		rows = append(rows, newAdminUserResponse(user))
+	}
+	pagination.Rows = rows
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(pagination)
@@ -115,58 +237,166 @@ func (s *Server) HandleGetUser(ctx context.Context, w http.ResponseWriter, r *ht
 		return
 	}
 	user, err := s.db.Users.GetByID(ctx, uint(id))
+	if errors.Is(err, database.ErrNotFound) {
+		HttpError(w, "Unknown user", http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		HttpError(w, "Failed to get user", http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(user)
+	json.NewEncoder(w).Encode(s.userView(r, user))
 }
 
+// HandleUpdateUser changes the fields of a user that are in the request.  Passwords are changed with
+// HandleChangePassword.
 func (s *Server) HandleUpdateUser(ctx context.Context, w http.ResponseWriter, r *http.Request) {
-
-	idStr := r.PathValue("id")
-	id, err := strconv.Atoi(idStr)
+	id, err := strconv.Atoi(r.PathValue("id"))
 	if err != nil {
 		HttpError(w, "Invalid user id", http.StatusBadRequest)
 		return
 	}
-
-	var u models.User
-	if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
+	var req UpdateUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		HttpError(w, "Invalid request", http.StatusBadRequest)
 		return
 	}
+	s.updateUser(ctx, w, r, uint(id), &req)
+}
 
-	existingUser, err := s.db.Users.GetByID(ctx, uint(id))
+// updateUser applies req to the user with the given id.
+func (s *Server) updateUser(ctx context.Context, w http.ResponseWriter, r *http.Request, id uint, req *UpdateUserRequest) {
+	if req.Email != nil && *req.Email == "" {
+		HttpError(w, "Email is required", http.StatusBadRequest)
+		return
+	}
+
+	existingUser, err := s.db.Users.GetByID(ctx, id)
+	if errors.Is(err, database.ErrNotFound) {
+		HttpError(w, "Unknown user", http.StatusNotFound)
+		return
+	}
 	if err != nil {
-		HttpError(w, "Unknown user", http.StatusInternalServerError)
+		HttpError(w, "Failed to get user", http.StatusInternalServerError)
 		return
 	}
 
-	loggedInUser := auth.GetUser(r.Context())
-	if loggedInUser == nil || !(loggedInUser.Role == "admin" || loggedInUser.ID == u.ID) {
-		HttpError(w, "Unauthorized", http.StatusUnauthorized)
+	u := *existingUser
+	req.apply(&u)
+
+	if u.Email != existingUser.Email && isSelf(r) && !auth.ComparePassword(req.CurrentPassword, existingUser.Password) {
+		HttpError(w, "Incorrect password", http.StatusForbidden)
 		return
 	}
 
-	u.ID = existingUser.ID
+	if req.Role != nil && *req.Role != existingUser.Role {
+		if !s.roles.Allowed(r.Context(), auth.PermissionUsersWrite) {
+			HttpError(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if !s.roles.Exists(*req.Role) {
+			HttpError(w, "Unknown role", http.StatusBadRequest)
+			return
+		}
+		if !s.roles.Grants(r.Context(), *req.Role) {
+			HttpError(w, "Can't grant a role with permissions you don't have", http.StatusForbidden)
+			return
+		}
+		u.Role = *req.Role
+	}
+
+	if req.OrganizationID != nil && *req.OrganizationID != existingUser.OrganizationID {
+		if !s.global(r) {
+			HttpError(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if *req.OrganizationID != 0 {
+			if _, err := s.db.Organizations.GetByID(ctx, *req.OrganizationID); err != nil {
+				HttpError(w, "Unknown organization", http.StatusBadRequest)
+				return
+			}
+		}
+		u.OrganizationID = *req.OrganizationID
+	}
+
+	// a new address has to be verified again
+	u.EmailVerified = existingUser.EmailVerified && u.Email == existingUser.Email
 
 	if err := s.db.Users.Update(ctx, &u); err != nil {
+		if errors.Is(err, database.ErrConflict) {
+			HttpError(w, "Email is already in use", http.StatusConflict)
+			return
+		}
 		HttpError(w, "Failed to update user", http.StatusInternalServerError)
 		return
 	}
-	json.NewEncoder(w).Encode(u)
+	s.auditUser(ctx, r, models.AuditUserUpdate, &u, existingUser, &u)
+
+	if u.Role != existingUser.Role || u.OrganizationID != existingUser.OrganizationID {
+		// sessions were authorized under the old role or organization; the caller keeps theirs
+		s.revokeSessions(ctx, u.ID, currentSession(r, u.ID), "credentials changed")
+	}
+	if u.Email != existingUser.Email {
+		if err := s.sendVerification(ctx, &u); err != nil {
+			slog.ErrorContext(ctx, "Failed to send verification email", "error", err, "email", u.Email)
+		}
+	}
+	json.NewEncoder(w).Encode(s.userView(r, &u))
 }
 
-func (s *Server) HandleDeleteUser(ctx context.Context, w http.ResponseWriter, r *http.Request) {
-	user := auth.GetUser(r.Context())
-	if user == nil || user.Role != "admin" {
-		HttpError(w, "Unauthorized", http.StatusUnauthorized)
+// HandleChangePassword sets a user's password.  Users changing their own must give their current
+// password; callers who can manage users may set anyone else's.  The user is logged out everywhere
+// else.
+func (s *Server) HandleChangePassword(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if auth.GetAPIKey(r.Context()) != nil {
+		HttpError(w, "API keys can't change passwords", http.StatusForbidden)
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		HttpError(w, "Invalid user id", http.StatusBadRequest)
+		return
+	}
+
+	var req ChangePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		HttpError(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	user, err := s.db.Users.GetByID(ctx, uint(id))
+	if err != nil {
+		HttpError(w, "Unknown user", http.StatusNotFound)
+		return
+	}
+	if isSelf(r) && !auth.ComparePassword(req.OldPassword, user.Password) {
+		HttpError(w, "Incorrect password", http.StatusForbidden)
+		return
+	}
+	if err := s.policy.Check(req.NewPassword); err != nil {
+		HttpError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	hashedPassword, err := s.hasher.Hash(req.NewPassword)
+	if err != nil {
+		HttpError(w, "Failed to hash password", http.StatusInternalServerError)
+		return
+	}
+	before := *user
+	user.Password = hashedPassword
+	if err := s.db.Users.Update(ctx, user); err != nil {
+		HttpError(w, "Failed to update user", http.StatusInternalServerError)
+		return
+	}
+	s.auditUser(ctx, r, models.AuditPasswordChange, user, &before, user)
+	s.revokeSessions(ctx, user.ID, currentSession(r, user.ID), "password changed")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) HandleDeleteUser(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	idStr := r.PathValue("id")
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
@@ -174,11 +404,23 @@ func (s *Server) HandleDeleteUser(ctx context.Context, w http.ResponseWriter, r
 		return
 	}
 	u, err := s.db.Users.Delete(ctx, uint(id))
+	if errors.Is(err, database.ErrNotFound) {
+		HttpError(w, "Unknown user", http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		HttpError(w, "Failed to delete user", http.StatusInternalServerError)
 		return
 	}
-	json.NewEncoder(w).Encode(u)
+	s.auditUser(ctx, r, models.AuditUserDelete, u, u, nil)
+	s.revokeSessions(ctx, u.ID, 0, "user deleted")
+	json.NewEncoder(w).Encode(s.userView(r, u))
+}
+
+// HandleGetJWKS publishes the public keys ten-issued tokens can be verified with.
+func (s *Server) HandleGetJWKS(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(auth.GetKeys().JWKS())
 }
 
 func (s *Server) AddAuthRoutes(ctx context.Context, mux *http.ServeMux) {
@@ -188,19 +430,25 @@ func (s *Server) AddAuthRoutes(ctx context.Context, mux *http.ServeMux) {
 	mux.HandleFunc("POST /users", func(w http.ResponseWriter, r *http.Request) {
 		s.HandleRegister(ctx, w, r)
 	})
-	mux.HandleFunc("GET /users", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("GET /users", s.require(auth.PermissionUsersRead, func(w http.ResponseWriter, r *http.Request) {
 		s.HandleGetUsers(ctx, w, r)
-	})
-	mux.HandleFunc("GET /users/{id}", func(w http.ResponseWriter, r *http.Request) {
+	}))
+	mux.HandleFunc("GET /users/{id}", s.requireSelfOr(auth.PermissionUsersRead, func(w http.ResponseWriter, r *http.Request) {
 		s.HandleGetUser(ctx, w, r)
-	})
-	mux.HandleFunc("PUT /users/{id}", func(w http.ResponseWriter, r *http.Request) {
+	}))
+	mux.HandleFunc("PUT /users/{id}", s.requireSelfOr(auth.PermissionUsersWrite, func(w http.ResponseWriter, r *http.Request) {
 		s.HandleUpdateUser(ctx, w, r)
-	})
-	mux.HandleFunc("DELETE /users/{id}", func(w http.ResponseWriter, r *http.Request) {
+	}))
+	mux.HandleFunc("PUT /users/{id}/password", s.requireSelfOr(auth.PermissionUsersWrite, func(w http.ResponseWriter, r *http.Request) {
+		s.HandleChangePassword(ctx, w, r)
+	}))
+	mux.HandleFunc("DELETE /users/{id}", s.requireInOrg(auth.PermissionUsersWrite, func(w http.ResponseWriter, r *http.Request) {
 		s.HandleDeleteUser(ctx, w, r)
-	})
+	}))
 	mux.HandleFunc("POST /logout", func(w http.ResponseWriter, r *http.Request) {
 		s.HandleLogout(ctx, w, r)
 	})
+	mux.HandleFunc("GET /.well-known/jwks.json", func(w http.ResponseWriter, r *http.Request) {
+		s.HandleGetJWKS(ctx, w, r)
+	})
 }
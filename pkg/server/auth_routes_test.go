@@ -4,14 +4,18 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	"github.com/humper/tor_exit_nodes/models"
 	"github.com/humper/tor_exit_nodes/pkg/auth"
 	"github.com/humper/tor_exit_nodes/pkg/database"
+	"github.com/humper/tor_exit_nodes/pkg/database/memory"
 	mock_database "github.com/humper/tor_exit_nodes/pkg/database/mock"
 	"github.com/humper/tor_exit_nodes/pkg/server"
 	"github.com/stretchr/testify/assert"
@@ -25,7 +29,7 @@ var passwords = map[string]string{
 }
 
 func testAccount() *models.User {
-	password, _ := auth.HashPassword(passwords["test@test.com"])
+	password, _ := auth.DefaultPasswordHasher().Hash(passwords["test@test.com"])
 
 	return &models.User{
 		Model: gorm.Model{
@@ -39,7 +43,7 @@ func testAccount() *models.User {
 }
 
 func adminAccount() *models.User {
-	password, _ := auth.HashPassword(passwords["admin@admin.com"])
+	password, _ := auth.DefaultPasswordHasher().Hash(passwords["admin@admin.com"])
 
 	return &models.User{
 		Model: gorm.Model{
@@ -52,11 +56,77 @@ func adminAccount() *models.User {
 	}
 }
 
-func addAuth(req *http.Request, user *models.User) {
-	jwt, _, _ := auth.CreateJWT(user)
+// newSessions returns an empty session store for servers backed by mocks.
+func newSessions() database.Sessions {
+	db, _ := memory.New(context.Background())
+	return db.Sessions
+}
+
+// newLoginAudits returns an empty login audit store, so lockouts from one test don't affect another.
+func newLoginAudits() database.LoginAudits {
+	db, _ := memory.New(context.Background())
+	return db.LoginAudits
+}
+
+// newAuditLog returns an empty audit log for servers backed by mocks.
+func newAuditLog() database.AuditLog {
+	db, _ := memory.New(context.Background())
+	return db.AuditLog
+}
+
+// addAuth logs user in with a new session in sessions, which must be the server's.
+func addAuth(req *http.Request, sessions database.Sessions, user *models.User) {
+	_, hash, _ := auth.GenerateRefreshToken()
+	session := &models.Session{UserID: user.ID, RefreshHash: hash, ExpiresAt: time.Now().Add(time.Hour)}
+	sessions.Create(context.Background(), session)
+
+	jwt, _, _ := auth.CreateJWT(user, session.ID)
 	req.Header.Set("Cookie", "token="+jwt)
 }
 
+// testServer is a server under test with the in-memory database behind it, which holds
+// testAccount as user and adminAccount as admin.
+type testServer struct {
+	*server.Server
+	db          *database.Database
+	user, admin *models.User
+}
+
+// newTestServer returns a server made with params, on a new in-memory database unless params has one.
+func newTestServer(t *testing.T, params *server.NewServerParams) *testServer {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	if params.DB == nil {
+		db, err := memory.New(ctx)
+		require.NoError(t, err)
+		params.DB = db
+	}
+
+	s := &testServer{db: params.DB}
+	for _, u := range []*models.User{testAccount(), adminAccount()} {
+		u.ID = 0
+		require.NoError(t, s.db.Users.Create(ctx, u))
+	}
+	var err error
+	s.user, err = s.db.Users.GetByEmail(ctx, "test@test.com")
+	require.NoError(t, err)
+	s.admin, err = s.db.Users.GetByEmail(ctx, "admin@admin.com")
+	require.NoError(t, err)
+
+	s.Server = server.New(ctx, params)
+	return s
+}
+
+func serve(s *testServer, method, path string, body string, user *models.User) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+	if user != nil {
+		addAuth(req, s.db.Sessions, user)
+	}
+	recorder := httptest.NewRecorder()
+	s.GetHandler().ServeHTTP(recorder, req)
+	return recorder
+}
+
 func TestHandleLoginHappy(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -69,7 +139,9 @@ func TestHandleLoginHappy(t *testing.T) {
 		Return(user, nil)
 
 	db := &database.Database{
-		Users: users,
+		Users:       users,
+		Sessions:    newSessions(),
+		LoginAudits: newLoginAudits(),
 	}
 
 	s := server.New(context.Background(), &server.NewServerParams{
@@ -90,13 +162,17 @@ func TestHandleLoginHappy(t *testing.T) {
 	s.GetHandler().ServeHTTP(recorder, req)
 	require.Equal(t, http.StatusOK, recorder.Code)
 	assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
-	require.Len(t, recorder.Result().Cookies(), 1)
+	require.Len(t, recorder.Result().Cookies(), 2)
 	jwt := recorder.Result().Cookies()[0]
 	assert.Equal(t, "token", jwt.Name)
 	assert.NotEmpty(t, jwt.Value)
 	assert.Equal(t, "/", jwt.Path)
 	assert.Equal(t, "localhost", jwt.Domain)
 	assert.True(t, jwt.HttpOnly)
+	refresh := recorder.Result().Cookies()[1]
+	assert.Equal(t, "refresh_token", refresh.Name)
+	assert.NotEmpty(t, refresh.Value)
+	assert.True(t, refresh.HttpOnly)
 
 	val := jwt.Value
 	claims, err := auth.ParseJWT(val)
@@ -125,10 +201,12 @@ func TestHandleLoginUnknownUser(t *testing.T) {
 	users := mock_database.NewMockUsers(ctrl)
 	users.EXPECT().GetByEmail(gomock.Any(), gomock.Any()).
 		Times(1).
-		Return(nil, gorm.ErrRecordNotFound)
+		Return(nil, database.ErrNotFound)
 
 	db := &database.Database{
-		Users: users,
+		Users:       users,
+		Sessions:    newSessions(),
+		LoginAudits: newLoginAudits(),
 	}
 
 	s := server.New(context.Background(), &server.NewServerParams{
@@ -148,6 +226,7 @@ func TestHandleLoginUnknownUser(t *testing.T) {
 
 	s.GetHandler().ServeHTTP(recorder, req)
 	require.Equal(t, http.StatusUnauthorized, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "Invalid credentials")
 }
 
 func TestHandleLoginWrongPassword(t *testing.T) {
@@ -162,7 +241,9 @@ func TestHandleLoginWrongPassword(t *testing.T) {
 		Return(user, nil)
 
 	db := &database.Database{
-		Users: users,
+		Users:       users,
+		Sessions:    newSessions(),
+		LoginAudits: newLoginAudits(),
 	}
 
 	s := server.New(context.Background(), &server.NewServerParams{
@@ -182,6 +263,32 @@ func TestHandleLoginWrongPassword(t *testing.T) {
 
 	s.GetHandler().ServeHTTP(recorder, req)
 	require.Equal(t, http.StatusUnauthorized, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "Invalid credentials")
+}
+
+func TestHandleLoginRehashesPassword(t *testing.T) {
+	ctx := context.Background()
+	hasher := &auth.PasswordHasher{Algorithm: auth.HashArgon2id, Argon2id: auth.Argon2idParams{Memory: 1024, Iterations: 1}}
+	s := newTestServer(t, &server.NewServerParams{PasswordHasher: hasher})
+	db := s.db
+
+	bcrypt := &auth.PasswordHasher{Algorithm: auth.HashBcrypt, BcryptCost: 4}
+	hash, err := bcrypt.Hash(passwords["test@test.com"])
+	require.NoError(t, err)
+	s.user.Password = hash
+	require.NoError(t, db.Users.Update(ctx, s.user))
+	login(t, s)
+
+	user, err := db.Users.GetByEmail(ctx, "test@test.com")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(user.Password, "$argon2id$"), user.Password)
+	assert.False(t, hasher.NeedsRehash(user.Password))
+	rehashed := user.Password
+
+	login(t, s)
+	user, err = db.Users.GetByEmail(ctx, "test@test.com")
+	require.NoError(t, err)
+	assert.Equal(t, rehashed, user.Password, "current hashes are kept")
 }
 
 func TestHandleRegisterHappy(t *testing.T) {
@@ -189,16 +296,18 @@ func TestHandleRegisterHappy(t *testing.T) {
 	defer ctrl.Finish()
 
 	users := mock_database.NewMockUsers(ctrl)
-	users.EXPECT().GetByEmail(gomock.Any(), gomock.Eq("test@test.com")).
-		Times(1).
-		Return(nil, gorm.ErrRecordNotFound)
-
+	var created *models.User
 	users.EXPECT().Create(gomock.Any(), gomock.Any()).
 		Times(1).
-		Return(nil)
+		DoAndReturn(func(ctx context.Context, user *models.User) error {
+			created = user
+			return nil
+		})
 
 	db := &database.Database{
-		Users: users,
+		Users:    users,
+		Sessions: newSessions(),
+		AuditLog: newAuditLog(),
 	}
 
 	s := server.New(context.Background(), &server.NewServerParams{
@@ -218,15 +327,13 @@ func TestHandleRegisterHappy(t *testing.T) {
 	require.NoError(t, err)
 
 	s.GetHandler().ServeHTTP(recorder, req)
-	require.Equal(t, http.StatusOK, recorder.Code)
+	require.Equal(t, http.StatusAccepted, recorder.Code)
+	assert.Empty(t, recorder.Body.String(), "self-registration doesn't describe the account")
 
-	var user models.User
-	err = json.NewDecoder(recorder.Body).Decode(&user)
-	require.NoError(t, err)
-	assert.Equal(t, u.Email, user.Email)
-	assert.Equal(t, u.Role, user.Role)
-
-	assert.True(t, auth.ComparePassword(passwords["test@test.com"], user.Password))
+	require.NotNil(t, created)
+	assert.Equal(t, u.Email, created.Email)
+	assert.Equal(t, u.Role, created.Role)
+	assert.True(t, auth.ComparePassword(passwords["test@test.com"], created.Password))
 }
 
 func TestHandleRegisterBadBody(t *testing.T) {
@@ -247,12 +354,18 @@ func TestHandleRegisterExistingUser(t *testing.T) {
 	user := testAccount()
 
 	users := mock_database.NewMockUsers(ctrl)
-	users.EXPECT().GetByEmail(gomock.Any(), gomock.Eq(user.Email)).
+	users.EXPECT().Create(gomock.Any(), gomock.Any()).
 		Times(1).
+		Return(database.ErrConflict)
+	// the existing account is mailed in the background
+	users.EXPECT().GetByEmail(gomock.Any(), gomock.Eq(user.Email)).
+		AnyTimes().
 		Return(user, nil)
 
 	db := &database.Database{
-		Users: users,
+		Users:    users,
+		Sessions: newSessions(),
+		AuditLog: newAuditLog(),
 	}
 
 	s := server.New(context.Background(), &server.NewServerParams{
@@ -272,7 +385,9 @@ func TestHandleRegisterExistingUser(t *testing.T) {
 	require.NoError(t, err)
 
 	s.GetHandler().ServeHTTP(recorder, req)
-	require.Equal(t, http.StatusConflict, recorder.Code)
+	// the same answer as for a new address
+	require.Equal(t, http.StatusAccepted, recorder.Code)
+	assert.Empty(t, recorder.Body.String())
 }
 
 func TestHandleRegisterPasswordTooLong(t *testing.T) {
@@ -280,12 +395,10 @@ func TestHandleRegisterPasswordTooLong(t *testing.T) {
 	defer ctrl.Finish()
 
 	users := mock_database.NewMockUsers(ctrl)
-	users.EXPECT().GetByEmail(gomock.Any(), gomock.Eq("test@test.com")).
-		Times(1).
-		Return(nil, gorm.ErrRecordNotFound)
-
 	db := &database.Database{
-		Users: users,
+		Users:    users,
+		Sessions: newSessions(),
+		AuditLog: newAuditLog(),
 	}
 
 	s := server.New(context.Background(), &server.NewServerParams{
@@ -313,16 +426,14 @@ func TestHandleRegisterUserCreateFailure(t *testing.T) {
 	defer ctrl.Finish()
 
 	users := mock_database.NewMockUsers(ctrl)
-	users.EXPECT().GetByEmail(gomock.Any(), gomock.Eq("test@test.com")).
-		Times(1).
-		Return(nil, gorm.ErrRecordNotFound)
-
 	users.EXPECT().Create(gomock.Any(), gomock.Any()).
 		Times(1).
 		Return(gorm.ErrInvalidDB)
 
 	db := &database.Database{
-		Users: users,
+		Users:    users,
+		Sessions: newSessions(),
+		AuditLog: newAuditLog(),
 	}
 
 	s := server.New(context.Background(), &server.NewServerParams{
@@ -354,11 +465,12 @@ func TestHandleLogout(t *testing.T) {
 
 	s.GetHandler().ServeHTTP(recorder, req)
 	require.Equal(t, http.StatusNoContent, recorder.Code)
-	require.Len(t, recorder.Result().Cookies(), 1)
+	require.Len(t, recorder.Result().Cookies(), 2)
 	jwt := recorder.Result().Cookies()[0]
 	assert.Equal(t, "token", jwt.Name)
 	assert.Empty(t, jwt.Value)
-	assert.Equal(t, 0, jwt.MaxAge)
+	assert.Less(t, jwt.MaxAge, 0, "the cookie is expired")
+	assert.Equal(t, "/", jwt.Path, "the path matches the cookie being replaced")
 	assert.True(t, jwt.HttpOnly)
 }
 
@@ -367,6 +479,7 @@ func TestHandleGetUsersHappy(t *testing.T) {
 	defer ctrl.Finish()
 
 	user := testAccount()
+	adminUser := adminAccount()
 
 	users := mock_database.NewMockUsers(ctrl)
 	users.EXPECT().GetAll(gomock.Any(), gomock.Eq(&models.Pagination{
@@ -381,9 +494,13 @@ func TestHandleGetUsersHappy(t *testing.T) {
 			TotalPages: 1,
 			Rows:       []models.User{*user},
 		}, nil)
+	users.EXPECT().GetByID(gomock.Any(), gomock.Eq(uint(2))).
+		Times(1).
+		Return(adminUser, nil)
 
 	db := &database.Database{
-		Users: users,
+		Users:    users,
+		Sessions: newSessions(),
 	}
 
 	s := server.New(context.Background(), &server.NewServerParams{
@@ -394,39 +511,86 @@ func TestHandleGetUsersHappy(t *testing.T) {
 	req, err := http.NewRequest("GET", "/users", nil)
 	require.NoError(t, err)
 
+	addAuth(req, db.Sessions, adminUser)
+
 	s.GetHandler().ServeHTTP(recorder, req)
 	require.Equal(t, http.StatusOK, recorder.Code)
 }
 
 func TestHandleGetUsersBadPage(t *testing.T) {
-	s := server.New(context.Background(), &server.NewServerParams{})
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	adminUser := adminAccount()
+
+	users := mock_database.NewMockUsers(ctrl)
+	users.EXPECT().GetByID(gomock.Any(), gomock.Eq(uint(2))).
+		Times(1).
+		Return(adminUser, nil)
+
+	db := &database.Database{Users: users, Sessions: newSessions()}
+	s := server.New(context.Background(), &server.NewServerParams{
+		DB: db,
+	})
 	recorder := httptest.NewRecorder()
 
 	req, err := http.NewRequest("GET", "/users?page=foo", nil)
 	require.NoError(t, err)
 
+	addAuth(req, db.Sessions, adminUser)
+
 	s.GetHandler().ServeHTTP(recorder, req)
 	require.Equal(t, http.StatusBadRequest, recorder.Code)
 }
 
 func TestHandleGetUsersBadLimit(t *testing.T) {
-	s := server.New(context.Background(), &server.NewServerParams{})
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	adminUser := adminAccount()
+
+	users := mock_database.NewMockUsers(ctrl)
+	users.EXPECT().GetByID(gomock.Any(), gomock.Eq(uint(2))).
+		Times(1).
+		Return(adminUser, nil)
+
+	db := &database.Database{Users: users, Sessions: newSessions()}
+	s := server.New(context.Background(), &server.NewServerParams{
+		DB: db,
+	})
 	recorder := httptest.NewRecorder()
 
 	req, err := http.NewRequest("GET", "/users?limit=foo", nil)
 	require.NoError(t, err)
 
+	addAuth(req, db.Sessions, adminUser)
+
 	s.GetHandler().ServeHTTP(recorder, req)
 	require.Equal(t, http.StatusBadRequest, recorder.Code)
 }
 
 func TestHandleGetUsersBadFilter(t *testing.T) {
-	s := server.New(context.Background(), &server.NewServerParams{})
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	adminUser := adminAccount()
+
+	users := mock_database.NewMockUsers(ctrl)
+	users.EXPECT().GetByID(gomock.Any(), gomock.Eq(uint(2))).
+		Times(1).
+		Return(adminUser, nil)
+
+	db := &database.Database{Users: users, Sessions: newSessions()}
+	s := server.New(context.Background(), &server.NewServerParams{
+		DB: db,
+	})
 	recorder := httptest.NewRecorder()
 
 	req, err := http.NewRequest("GET", "/users?filter=foo", nil)
 	require.NoError(t, err)
 
+	addAuth(req, db.Sessions, adminUser)
+
 	s.GetHandler().ServeHTTP(recorder, req)
 	require.Equal(t, http.StatusBadRequest, recorder.Code)
 }
@@ -435,13 +599,19 @@ func TestGetUsersDatabaseError(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
+	adminUser := adminAccount()
+
 	users := mock_database.NewMockUsers(ctrl)
+	users.EXPECT().GetByID(gomock.Any(), gomock.Eq(uint(2))).
+		Times(1).
+		Return(adminUser, nil)
 	users.EXPECT().GetAll(gomock.Any(), gomock.Any()).
 		Times(1).
 		Return(nil, gorm.ErrInvalidDB)
 
 	db := &database.Database{
-		Users: users,
+		Users:    users,
+		Sessions: newSessions(),
 	}
 
 	s := server.New(context.Background(), &server.NewServerParams{
@@ -452,6 +622,8 @@ func TestGetUsersDatabaseError(t *testing.T) {
 	req, err := http.NewRequest("GET", "/users", nil)
 	require.NoError(t, err)
 
+	addAuth(req, db.Sessions, adminUser)
+
 	s.GetHandler().ServeHTTP(recorder, req)
 	require.Equal(t, http.StatusInternalServerError, recorder.Code)
 }
@@ -464,11 +636,12 @@ func TestHandleGetUserHappy(t *testing.T) {
 
 	users := mock_database.NewMockUsers(ctrl)
 	users.EXPECT().GetByID(gomock.Any(), gomock.Eq(uint(1))).
-		Times(1).
+		Times(2).
 		Return(user, nil)
 
 	db := &database.Database{
-		Users: users,
+		Users:    users,
+		Sessions: newSessions(),
 	}
 
 	s := server.New(context.Background(), &server.NewServerParams{
@@ -479,26 +652,45 @@ func TestHandleGetUserHappy(t *testing.T) {
 	req, err := http.NewRequest("GET", "/users/1", nil)
 	require.NoError(t, err)
 
+	addAuth(req, db.Sessions, user)
+
 	s.GetHandler().ServeHTTP(recorder, req)
 	require.Equal(t, http.StatusOK, recorder.Code)
 	require.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
 
-	var u models.User
+	assert.NotContains(t, recorder.Body.String(), "Password")
+	assert.NotContains(t, recorder.Body.String(), user.Password)
+
+	var u server.UserResponse
 	err = json.NewDecoder(recorder.Body).Decode(&u)
 	require.NoError(t, err)
 	assert.Equal(t, user.Email, u.Email)
 	assert.Equal(t, user.Role, u.Role)
 	assert.Equal(t, user.ID, u.ID)
-	assert.True(t, auth.ComparePassword(passwords["test@test.com"], u.Password))
 }
 
 func TestHandleGetUserBadID(t *testing.T) {
-	s := server.New(context.Background(), &server.NewServerParams{})
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	adminUser := adminAccount()
+
+	users := mock_database.NewMockUsers(ctrl)
+	users.EXPECT().GetByID(gomock.Any(), gomock.Eq(uint(2))).
+		Times(1).
+		Return(adminUser, nil)
+
+	db := &database.Database{Users: users, Sessions: newSessions()}
+	s := server.New(context.Background(), &server.NewServerParams{
+		DB: db,
+	})
 	recorder := httptest.NewRecorder()
 
 	req, err := http.NewRequest("GET", "/users/foo", nil)
 	require.NoError(t, err)
 
+	addAuth(req, db.Sessions, adminUser)
+
 	s.GetHandler().ServeHTTP(recorder, req)
 	require.Equal(t, http.StatusBadRequest, recorder.Code)
 }
@@ -507,13 +699,19 @@ func TestHandleGetUserDatabaseError(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
+	adminUser := adminAccount()
+
 	users := mock_database.NewMockUsers(ctrl)
+	users.EXPECT().GetByID(gomock.Any(), gomock.Eq(uint(2))).
+		Times(1).
+		Return(adminUser, nil)
 	users.EXPECT().GetByID(gomock.Any(), gomock.Eq(uint(1))).
 		Times(1).
 		Return(nil, gorm.ErrInvalidDB)
 
 	db := &database.Database{
-		Users: users,
+		Users:    users,
+		Sessions: newSessions(),
 	}
 
 	s := server.New(context.Background(), &server.NewServerParams{
@@ -524,6 +722,8 @@ func TestHandleGetUserDatabaseError(t *testing.T) {
 	req, err := http.NewRequest("GET", "/users/1", nil)
 	require.NoError(t, err)
 
+	addAuth(req, db.Sessions, adminUser)
+
 	s.GetHandler().ServeHTTP(recorder, req)
 	require.Equal(t, http.StatusInternalServerError, recorder.Code)
 }
@@ -539,12 +739,18 @@ func TestHandleUpdateUserHappy(t *testing.T) {
 		Times(2).
 		Return(user, nil)
 
+	var updated *models.User
 	users.EXPECT().Update(gomock.Any(), gomock.Any()).
 		Times(1).
-		Return(nil)
+		DoAndReturn(func(ctx context.Context, user *models.User) error {
+			updated = user
+			return nil
+		})
 
 	db := &database.Database{
-		Users: users,
+		Users:    users,
+		Sessions: newSessions(),
+		AuditLog: newAuditLog(),
 	}
 
 	s := server.New(context.Background(), &server.NewServerParams{
@@ -552,26 +758,29 @@ func TestHandleUpdateUserHappy(t *testing.T) {
 	})
 	recorder := httptest.NewRecorder()
 
-	user.ID = 1
-	user.Name = "New Name"
-	jsonBytes, err := json.Marshal(user)
-	require.NoError(t, err)
+	// fields that are left out, like the password, are kept
+	jsonBytes := []byte(`{"Name": "New Name"}`)
 
 	req, err := http.NewRequest("PUT", "/users/1", bytes.NewBuffer(jsonBytes))
 	require.NoError(t, err)
 
-	addAuth(req, user)
+	addAuth(req, db.Sessions, user)
 
 	s.GetHandler().ServeHTTP(recorder, req)
 	require.Equal(t, http.StatusOK, recorder.Code)
 
-	var u models.User
+	assert.NotContains(t, recorder.Body.String(), "Password")
+
+	var u server.UserResponse
 	err = json.NewDecoder(recorder.Body).Decode(&u)
 	require.NoError(t, err)
-	assert.Equal(t, u.Email, user.Email)
-	assert.Equal(t, u.Role, user.Role)
+	assert.Equal(t, "New Name", u.Name)
+	assert.Equal(t, user.Email, u.Email)
+	assert.Equal(t, user.Role, u.Role)
 
-	assert.True(t, auth.ComparePassword(passwords["test@test.com"], u.Password))
+	require.NotNil(t, updated)
+	assert.Equal(t, "New Name", updated.Name)
+	assert.True(t, auth.ComparePassword(passwords["test@test.com"], updated.Password))
 }
 
 func TestHandleUpdateUserLoggedOut(t *testing.T) {
@@ -581,12 +790,11 @@ func TestHandleUpdateUserLoggedOut(t *testing.T) {
 	user := testAccount()
 
 	users := mock_database.NewMockUsers(ctrl)
-	users.EXPECT().GetByID(gomock.Any(), gomock.Eq(uint(1))).
-		Times(1).
-		Return(user, nil)
 
 	db := &database.Database{
-		Users: users,
+		Users:    users,
+		Sessions: newSessions(),
+		AuditLog: newAuditLog(),
 	}
 
 	s := server.New(context.Background(), &server.NewServerParams{
@@ -607,12 +815,27 @@ func TestHandleUpdateUserLoggedOut(t *testing.T) {
 }
 
 func TestHandleUpdateUserBadUserID(t *testing.T) {
-	s := server.New(context.Background(), &server.NewServerParams{})
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	adminUser := adminAccount()
+
+	users := mock_database.NewMockUsers(ctrl)
+	users.EXPECT().GetByID(gomock.Any(), gomock.Eq(uint(2))).
+		Times(1).
+		Return(adminUser, nil)
+
+	db := &database.Database{Users: users, Sessions: newSessions()}
+	s := server.New(context.Background(), &server.NewServerParams{
+		DB: db,
+	})
 	recorder := httptest.NewRecorder()
 
 	req, err := http.NewRequest("PUT", "/users/foo", nil)
 	require.NoError(t, err)
 
+	addAuth(req, db.Sessions, adminUser)
+
 	s.GetHandler().ServeHTTP(recorder, req)
 	require.Equal(t, http.StatusBadRequest, recorder.Code)
 }
@@ -621,13 +844,20 @@ func TestHandleUpdateUserUnknownUser(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
+	adminUser := adminAccount()
+
 	users := mock_database.NewMockUsers(ctrl)
+	users.EXPECT().GetByID(gomock.Any(), gomock.Eq(uint(2))).
+		Times(1).
+		Return(adminUser, nil)
 	users.EXPECT().GetByID(gomock.Any(), gomock.Eq(uint(1))).
 		Times(1).
-		Return(nil, gorm.ErrRecordNotFound)
+		Return(nil, database.ErrNotFound)
 
 	db := &database.Database{
-		Users: users,
+		Users:    users,
+		Sessions: newSessions(),
+		AuditLog: newAuditLog(),
 	}
 
 	user := testAccount()
@@ -642,8 +872,10 @@ func TestHandleUpdateUserUnknownUser(t *testing.T) {
 	req, err := http.NewRequest("PUT", "/users/1", bytes.NewBuffer(jsonBytes))
 	require.NoError(t, err)
 
+	addAuth(req, db.Sessions, adminUser)
+
 	s.GetHandler().ServeHTTP(recorder, req)
-	require.Equal(t, http.StatusInternalServerError, recorder.Code)
+	require.Equal(t, http.StatusNotFound, recorder.Code)
 }
 
 func TestHandleUpdateUserDatabaseError(t *testing.T) {
@@ -662,7 +894,9 @@ func TestHandleUpdateUserDatabaseError(t *testing.T) {
 		Return(gorm.ErrInvalidDB)
 
 	db := &database.Database{
-		Users: users,
+		Users:    users,
+		Sessions: newSessions(),
+		AuditLog: newAuditLog(),
 	}
 
 	s := server.New(context.Background(), &server.NewServerParams{
@@ -678,19 +912,34 @@ func TestHandleUpdateUserDatabaseError(t *testing.T) {
 	req, err := http.NewRequest("PUT", "/users/1", bytes.NewBuffer(jsonBytes))
 	require.NoError(t, err)
 
-	addAuth(req, user)
+	addAuth(req, db.Sessions, user)
 
 	s.GetHandler().ServeHTTP(recorder, req)
 	require.Equal(t, http.StatusInternalServerError, recorder.Code)
 }
 
 func TestHandleUpdateUserBadBody(t *testing.T) {
-	s := server.New(context.Background(), &server.NewServerParams{})
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	adminUser := adminAccount()
+
+	users := mock_database.NewMockUsers(ctrl)
+	users.EXPECT().GetByID(gomock.Any(), gomock.Eq(uint(2))).
+		Times(1).
+		Return(adminUser, nil)
+
+	db := &database.Database{Users: users, Sessions: newSessions()}
+	s := server.New(context.Background(), &server.NewServerParams{
+		DB: db,
+	})
 	recorder := httptest.NewRecorder()
 
 	req, err := http.NewRequest("PUT", "/users/1", bytes.NewBuffer([]byte("bad json")))
 	require.NoError(t, err)
 
+	addAuth(req, db.Sessions, adminUser)
+
 	s.GetHandler().ServeHTTP(recorder, req)
 	require.Equal(t, http.StatusBadRequest, recorder.Code)
 }
@@ -715,7 +964,9 @@ func TestHandleUpdateUserAdmin(t *testing.T) {
 		Return(nil)
 
 	db := &database.Database{
-		Users: users,
+		Users:    users,
+		Sessions: newSessions(),
+		AuditLog: newAuditLog(),
 	}
 
 	s := server.New(context.Background(), &server.NewServerParams{
@@ -731,7 +982,7 @@ func TestHandleUpdateUserAdmin(t *testing.T) {
 	req, err := http.NewRequest("PUT", "/users/1", bytes.NewBuffer(jsonBytes))
 	require.NoError(t, err)
 
-	addAuth(req, adminUser)
+	addAuth(req, db.Sessions, adminUser)
 
 	s.GetHandler().ServeHTTP(recorder, req)
 	require.Equal(t, http.StatusOK, recorder.Code)
@@ -743,7 +994,7 @@ func TestHandleUpdateUserAdmin(t *testing.T) {
 	assert.Equal(t, u.Role, user.Role)
 }
 
-func TestHandleUpdateUserRegularUserUnauthorized(t *testing.T) {
+func TestHandleUpdateUserRegularUserForbidden(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
@@ -754,12 +1005,11 @@ func TestHandleUpdateUserRegularUserUnauthorized(t *testing.T) {
 	users.EXPECT().GetByID(gomock.Any(), gomock.Eq(uint(1))).
 		Times(1).
 		Return(user, nil)
-	users.EXPECT().GetByID(gomock.Any(), gomock.Eq(uint(2))).
-		Times(1).
-		Return(adminUser, nil)
 
 	db := &database.Database{
-		Users: users,
+		Users:    users,
+		Sessions: newSessions(),
+		AuditLog: newAuditLog(),
 	}
 
 	s := server.New(context.Background(), &server.NewServerParams{
@@ -775,10 +1025,10 @@ func TestHandleUpdateUserRegularUserUnauthorized(t *testing.T) {
 	req, err := http.NewRequest("PUT", "/users/2", bytes.NewBuffer(jsonBytes))
 	require.NoError(t, err)
 
-	addAuth(req, user)
+	addAuth(req, db.Sessions, user)
 
 	s.GetHandler().ServeHTTP(recorder, req)
-	require.Equal(t, http.StatusUnauthorized, recorder.Code)
+	require.Equal(t, http.StatusForbidden, recorder.Code)
 }
 
 func TestHandleDeleteUserHappy(t *testing.T) {
@@ -797,7 +1047,9 @@ func TestHandleDeleteUserHappy(t *testing.T) {
 		Return(adminUser, nil)
 
 	db := &database.Database{
-		Users: users,
+		Users:    users,
+		Sessions: newSessions(),
+		AuditLog: newAuditLog(),
 	}
 
 	s := server.New(context.Background(), &server.NewServerParams{
@@ -808,7 +1060,7 @@ func TestHandleDeleteUserHappy(t *testing.T) {
 	req, err := http.NewRequest("DELETE", "/users/1", nil)
 	require.NoError(t, err)
 
-	addAuth(req, adminUser)
+	addAuth(req, db.Sessions, adminUser)
 
 	s.GetHandler().ServeHTTP(recorder, req)
 	require.Equal(t, http.StatusOK, recorder.Code)
@@ -837,7 +1089,9 @@ func TestHandleDeleteUserNonAdmin(t *testing.T) {
 		Return(testUser, nil)
 
 	db := &database.Database{
-		Users: users,
+		Users:    users,
+		Sessions: newSessions(),
+		AuditLog: newAuditLog(),
 	}
 
 	s := server.New(context.Background(), &server.NewServerParams{
@@ -848,10 +1102,10 @@ func TestHandleDeleteUserNonAdmin(t *testing.T) {
 	req, err := http.NewRequest("DELETE", "/users/1", nil)
 	require.NoError(t, err)
 
-	addAuth(req, testUser)
+	addAuth(req, db.Sessions, testUser)
 
 	s.GetHandler().ServeHTTP(recorder, req)
-	require.Equal(t, http.StatusUnauthorized, recorder.Code)
+	require.Equal(t, http.StatusForbidden, recorder.Code)
 }
 
 func TestHandleDeleteUserBadID(t *testing.T) {
@@ -866,7 +1120,9 @@ func TestHandleDeleteUserBadID(t *testing.T) {
 		Return(adminUser, nil)
 
 	db := &database.Database{
-		Users: users,
+		Users:    users,
+		Sessions: newSessions(),
+		AuditLog: newAuditLog(),
 	}
 
 	s := server.New(context.Background(), &server.NewServerParams{
@@ -877,7 +1133,7 @@ func TestHandleDeleteUserBadID(t *testing.T) {
 	req, err := http.NewRequest("DELETE", "/users/foo", nil)
 	require.NoError(t, err)
 
-	addAuth(req, adminUser)
+	addAuth(req, db.Sessions, adminUser)
 	s.GetHandler().ServeHTTP(recorder, req)
 	require.Equal(t, http.StatusBadRequest, recorder.Code)
 }
@@ -897,7 +1153,9 @@ func TestHandleDeleteUserDatabaseError(t *testing.T) {
 		Return(adminUser, nil)
 
 	db := &database.Database{
-		Users: users,
+		Users:    users,
+		Sessions: newSessions(),
+		AuditLog: newAuditLog(),
 	}
 
 	s := server.New(context.Background(), &server.NewServerParams{
@@ -908,8 +1166,84 @@ func TestHandleDeleteUserDatabaseError(t *testing.T) {
 	req, err := http.NewRequest("DELETE", "/users/1", nil)
 	require.NoError(t, err)
 
-	addAuth(req, adminUser)
+	addAuth(req, db.Sessions, adminUser)
 
 	s.GetHandler().ServeHTTP(recorder, req)
 	require.Equal(t, http.StatusInternalServerError, recorder.Code)
 }
+
+func TestHandleGetJWKS(t *testing.T) {
+	s := server.New(context.Background(), &server.NewServerParams{})
+	recorder := httptest.NewRecorder()
+
+	req, err := http.NewRequest("GET", "/.well-known/jwks.json", nil)
+	require.NoError(t, err)
+
+	s.GetHandler().ServeHTTP(recorder, req)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+
+	var jwks auth.JWKS
+	err = json.NewDecoder(recorder.Body).Decode(&jwks)
+	require.NoError(t, err)
+	// the default ephemeral key is a shared secret, so nothing is published
+	assert.Empty(t, jwks.Keys)
+}
+
+func TestUpdateUserKeepsOmittedFields(t *testing.T) {
+	s := newTestServer(t, &server.NewServerParams{})
+	db, user, admin := s.db, s.user, s.admin
+	ctx := context.Background()
+	user.AllowedIPs = []string{"1.2.3.4"}
+	user.Password = "hash"
+	require.NoError(t, db.Users.Update(ctx, user))
+
+	// a password in the body is ignored rather than stored as is
+	recorder := serve(s, "PUT", fmt.Sprintf("/users/%d", user.ID), `{"Name": "Renamed", "Password": "plaintext"}`, admin)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	var response server.AdminUserResponse
+	require.NoError(t, json.NewDecoder(recorder.Body).Decode(&response))
+	assert.Equal(t, "Renamed", response.Name)
+	assert.Equal(t, []string{"1.2.3.4"}, response.AllowedIPs)
+	assert.True(t, response.HasPassword)
+
+	updated, err := db.Users.GetByID(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Renamed", updated.Name)
+	assert.Equal(t, "test@test.com", updated.Email)
+	assert.Equal(t, []string{"1.2.3.4"}, []string(updated.AllowedIPs))
+	assert.Equal(t, "hash", updated.Password)
+
+	require.Equal(t, http.StatusOK, serve(s, "PUT", fmt.Sprintf("/users/%d", user.ID), `{"AllowedIPs": []}`, user).Code)
+	updated, err = db.Users.GetByID(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Empty(t, updated.AllowedIPs)
+	assert.Equal(t, "Renamed", updated.Name)
+
+	assert.Equal(t, http.StatusBadRequest, serve(s, "PUT", fmt.Sprintf("/users/%d", user.ID), `{"Email": ""}`, user).Code)
+}
+
+func TestChangePassword(t *testing.T) {
+	s := newTestServer(t, &server.NewServerParams{})
+	db, user, admin := s.db, s.user, s.admin
+	ctx := context.Background()
+	hash, err := auth.DefaultPasswordHasher().Hash("old password")
+	require.NoError(t, err)
+	user.Password = hash
+	require.NoError(t, db.Users.Update(ctx, user))
+	path := fmt.Sprintf("/users/%d/password", user.ID)
+
+	assert.Equal(t, http.StatusForbidden, serve(s, "PUT", path, `{"old_password": "wrong", "new_password": "new password"}`, user).Code)
+	assert.Equal(t, http.StatusBadRequest, serve(s, "PUT", path, `{"old_password": "old password", "new_password": "short"}`, user).Code)
+	require.Equal(t, http.StatusNoContent, serve(s, "PUT", path, `{"old_password": "old password", "new_password": "new password"}`, user).Code)
+	updated, err := db.Users.GetByID(ctx, user.ID)
+	require.NoError(t, err)
+	assert.True(t, auth.ComparePassword("new password", updated.Password))
+
+	// admins don't need the old password, but users can't change anyone else's
+	require.Equal(t, http.StatusNoContent, serve(s, "PUT", path, `{"new_password": "admin chosen"}`, admin).Code)
+	updated, err = db.Users.GetByID(ctx, user.ID)
+	require.NoError(t, err)
+	assert.True(t, auth.ComparePassword("admin chosen", updated.Password))
+	assert.Equal(t, http.StatusForbidden, serve(s, "PUT", fmt.Sprintf("/users/%d/password", admin.ID), `{"new_password": "new password"}`, user).Code)
+}
@@ -0,0 +1,246 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/humper/tor_exit_nodes/models"
+	"github.com/humper/tor_exit_nodes/pkg/auth"
+	"github.com/humper/tor_exit_nodes/pkg/mail"
+)
+
+const (
+	passwordResetPurpose = "password-reset"
+	passwordResetTTL     = time.Hour
+	verifyEmailPurpose   = "verify-email"
+	verifyEmailTTL       = 24 * time.Hour
+)
+
+// emailToken is sent to a user's email address to prove they can read it.  It's bound to the
+// address and to a fingerprint of what using it changes, so it only works once.
+type emailToken struct {
+	jwt.StandardClaims
+	Email       string `json:"email"`
+	Fingerprint string `json:"fp"`
+}
+
+type EmailRequest struct {
+	Email string `json:"email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token"`
+}
+
+func (s *Server) AddEmailRoutes(ctx context.Context, mux *http.ServeMux) {
+	mux.HandleFunc("POST /password/forgot", func(w http.ResponseWriter, r *http.Request) {
+		s.HandleForgotPassword(ctx, w, r)
+	})
+	mux.HandleFunc("POST /password/reset", func(w http.ResponseWriter, r *http.Request) {
+		s.HandleResetPassword(ctx, w, r)
+	})
+	mux.HandleFunc("POST /email/verification", func(w http.ResponseWriter, r *http.Request) {
+		s.HandleSendVerification(ctx, w, r)
+	})
+	mux.HandleFunc("POST /email/verify", func(w http.ResponseWriter, r *http.Request) {
+		s.HandleVerifyEmail(ctx, w, r)
+	})
+}
+
+func tokenFingerprint(user *models.User, purpose string) string {
+	if purpose == passwordResetPurpose {
+		return auth.Fingerprint(user.Password)
+	}
+	return auth.Fingerprint(strconv.FormatBool(user.EmailVerified))
+}
+
+// sendEmailToken mails user a link to path with a new token for purpose.
+func (s *Server) sendEmailToken(ctx context.Context, user *models.User, purpose string, ttl time.Duration, path, subject, body string) error {
+	token, err := auth.SignToken(&emailToken{
+		StandardClaims: jwt.StandardClaims{
+			Audience:  purpose,
+			Subject:   fmt.Sprint(user.ID),
+			ExpiresAt: time.Now().Add(ttl).Unix(),
+		},
+		Email:       user.Email,
+		Fingerprint: tokenFingerprint(user, purpose),
+	})
+	if err != nil {
+		return err
+	}
+
+	link := s.publicURL + path + "?" + url.Values{"token": {token}}.Encode()
+	return s.mailer.Send(ctx, &mail.Message{
+		To:      user.Email,
+		Subject: subject,
+		Body:    fmt.Sprintf(body, link),
+	})
+}
+
+func (s *Server) sendPasswordReset(ctx context.Context, user *models.User) error {
+	return s.sendEmailToken(ctx, user, passwordResetPurpose, passwordResetTTL, "/reset-password",
+		"Reset your ten password",
+		"Someone asked to reset the password for your ten account.  To choose a new one, visit\n\n%s\n\nThe link expires in an hour.  If it wasn't you, you can ignore this email.")
+}
+
+func (s *Server) sendVerification(ctx context.Context, user *models.User) error {
+	return s.sendEmailToken(ctx, user, verifyEmailPurpose, verifyEmailTTL, "/verify-email",
+		"Verify your email address for ten",
+		"To confirm this is your email address, visit\n\n%s\n\nThe link expires in a day.  If you didn't sign up for ten, you can ignore this email.")
+}
+
+// sendAccountExists answers an attempt to register an address that already has an account, with a
+// password reset link in case it was the owner.
+func (s *Server) sendAccountExists(ctx context.Context, user *models.User) error {
+	return s.sendEmailToken(ctx, user, passwordResetPurpose, passwordResetTTL, "/reset-password",
+		"Your ten account",
+		"Someone tried to sign up for ten with this email address, which already has an account.  If it was you and you've forgotten your password, you can choose a new one at\n\n%s\n\nThe link expires in an hour.  If it wasn't you, you can ignore this email.")
+}
+
+// parseEmailToken returns the user an email token is for, if it's still good.
+func (s *Server) parseEmailToken(ctx context.Context, tokenString, purpose string) (*models.User, error) {
+	var claims emailToken
+	if err := auth.ParseToken(tokenString, purpose, &claims); err != nil {
+		return nil, err
+	}
+	id, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.db.Users.GetByID(ctx, uint(id))
+	if err != nil {
+		return nil, err
+	}
+	if user.Email != claims.Email || tokenFingerprint(user, purpose) != claims.Fingerprint {
+		return nil, errors.New("token has been used or superseded")
+	}
+	return user, nil
+}
+
+// mailUser queues send for the user with email, if there is one, so that the response doesn't say,
+// even by how long it took, whether the address has an account.
+func (s *Server) mailUser(ctx context.Context, email string, send func(context.Context, *models.User) error) {
+	s.mails.add(ctx, &mailJob{email: email, send: send}, time.Now())
+}
+
+// mailNewUser queues send for a user who has just been created, like mailUser.
+func (s *Server) mailNewUser(ctx context.Context, user *models.User, send func(context.Context, *models.User) error) {
+	s.mails.add(ctx, &mailJob{email: user.Email, user: user, send: send}, time.Now())
+}
+
+// allowMail refuses requests for mail from IP addresses that have made too many, and reports
+// whether r may go ahead.
+func (s *Server) allowMail(w http.ResponseWriter, r *http.Request) bool {
+	if !s.mails.allowIP(clientIP(r), time.Now()) {
+		HttpError(w, "Too many requests, try again later", http.StatusTooManyRequests)
+		return false
+	}
+	return true
+}
+
+// HandleForgotPassword mails a password reset link to the user with the given email, if any.
+func (s *Server) HandleForgotPassword(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	var req EmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Email == "" {
+		HttpError(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if !s.allowMail(w, r) {
+		return
+	}
+	s.mailUser(ctx, req.Email, s.sendPasswordReset)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// HandleResetPassword sets a new password with a token from HandleForgotPassword, logging the
+// user out everywhere.
+func (s *Server) HandleResetPassword(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	var req ResetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		HttpError(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	user, err := s.parseEmailToken(ctx, req.Token, passwordResetPurpose)
+	if err != nil {
+		slog.InfoContext(ctx, "Rejected password reset token", "error", err)
+		HttpError(w, "Invalid or expired token", http.StatusBadRequest)
+		return
+	}
+	if err := s.policy.Check(req.Password); err != nil {
+		HttpError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	hashedPassword, err := s.hasher.Hash(req.Password)
+	if err != nil {
+		HttpError(w, "Failed to hash password", http.StatusBadRequest)
+		return
+	}
+	before := *user
+	user.Password = hashedPassword
+	// the link arrived, so the address works
+	user.EmailVerified = true
+	if err := s.db.Users.Update(ctx, user); err != nil {
+		HttpError(w, "Failed to update user", http.StatusInternalServerError)
+		return
+	}
+	s.auditSelf(ctx, r, models.AuditPasswordReset, user, &before)
+	s.revokeSessions(ctx, user.ID, 0, "password reset")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleSendVerification mails a new verification link to the user with the given email, if any.
+func (s *Server) HandleSendVerification(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	var req EmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Email == "" {
+		HttpError(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if !s.allowMail(w, r) {
+		return
+	}
+	s.mailUser(ctx, req.Email, func(ctx context.Context, user *models.User) error {
+		if user.EmailVerified {
+			return nil
+		}
+		return s.sendVerification(ctx, user)
+	})
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// HandleVerifyEmail marks a user's email address verified with a token from a verification mail.
+func (s *Server) HandleVerifyEmail(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	var req VerifyEmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		HttpError(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	user, err := s.parseEmailToken(ctx, req.Token, verifyEmailPurpose)
+	if err != nil {
+		slog.InfoContext(ctx, "Rejected email verification token", "error", err)
+		HttpError(w, "Invalid or expired token", http.StatusBadRequest)
+		return
+	}
+
+	before := *user
+	user.EmailVerified = true
+	if err := s.db.Users.Update(ctx, user); err != nil {
+		HttpError(w, "Failed to update user", http.StatusInternalServerError)
+		return
+	}
+	s.auditSelf(ctx, r, models.AuditEmailVerify, user, &before)
+	w.WriteHeader(http.StatusNoContent)
+}
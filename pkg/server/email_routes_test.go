@@ -0,0 +1,165 @@
+package server_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/humper/tor_exit_nodes/models"
+	"github.com/humper/tor_exit_nodes/pkg/mail"
+	"github.com/humper/tor_exit_nodes/pkg/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testMailer keeps the messages it's asked to send.
+type testMailer struct {
+	mutex    sync.Mutex
+	messages []*mail.Message
+}
+
+func (m *testMailer) Send(ctx context.Context, msg *mail.Message) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+func (m *testMailer) count() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return len(m.messages)
+}
+
+var tokenPattern = regexp.MustCompile(`token=([^\s]+)`)
+
+// waitForToken waits for the nth message, which may be sent in the background, and returns the
+// token from its link.
+func (m *testMailer) waitForToken(t *testing.T, n int, to, path string) string {
+	require.Eventually(t, func() bool { return m.count() >= n }, 5*time.Second, 10*time.Millisecond)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	msg := m.messages[n-1]
+	assert.Equal(t, to, msg.To)
+	assert.Contains(t, msg.Body, "https://ten.example.com"+path+"?token=")
+	match := tokenPattern.FindStringSubmatch(msg.Body)
+	require.NotNil(t, match)
+	return match[1]
+}
+
+func newEmailServer(t *testing.T, requireVerified bool) (*testServer, *testMailer) {
+	mailer := &testMailer{}
+	s := newTestServer(t, &server.NewServerParams{
+		Mailer:               mailer,
+		PublicURL:            "https://ten.example.com/",
+		RequireVerifiedEmail: requireVerified,
+	})
+	return s, mailer
+}
+
+func TestRegisterVerifiesEmail(t *testing.T) {
+	s, mailer := newEmailServer(t, true)
+	db := s.db
+	ctx := context.Background()
+
+	require.Equal(t, http.StatusAccepted, serve(s, "POST", "/users", `{"Email": "new@test.com", "Password": "password"}`, nil).Code)
+	token := mailer.waitForToken(t, 1, "new@test.com", "/verify-email")
+
+	assert.Equal(t, http.StatusForbidden, tryLogin(s, "10.0.0.1", "new@test.com", "password").Code)
+
+	assert.Equal(t, http.StatusBadRequest, serve(s, "POST", "/email/verify", `{"token": "forged"}`, nil).Code)
+	require.Equal(t, http.StatusNoContent, serve(s, "POST", "/email/verify", fmt.Sprintf(`{"token": %q}`, token), nil).Code)
+	assert.Equal(t, http.StatusBadRequest, serve(s, "POST", "/email/verify", fmt.Sprintf(`{"token": %q}`, token), nil).Code, "tokens only work once")
+
+	user, err := db.Users.GetByEmail(ctx, "new@test.com")
+	require.NoError(t, err)
+	assert.True(t, user.EmailVerified)
+	assert.Equal(t, http.StatusOK, tryLogin(s, "10.0.0.1", "new@test.com", "password").Code)
+
+	// verified users aren't sent another link
+	require.Equal(t, http.StatusAccepted, serve(s, "POST", "/email/verification", `{"email": "new@test.com"}`, nil).Code)
+	require.Equal(t, http.StatusAccepted, serve(s, "POST", "/users", `{"Email": "other@test.com", "Password": "password"}`, nil).Code)
+	mailer.waitForToken(t, 2, "other@test.com", "/verify-email")
+}
+
+func TestRegisterExistingAddress(t *testing.T) {
+	s, mailer := newEmailServer(t, false)
+	db := s.db
+	ctx := context.Background()
+	require.NoError(t, db.Users.Create(ctx, &models.User{Email: "old@test.com", Password: "x", Role: "user"}))
+
+	// the answer is the same as for a new address, and the owner is sent a way back in
+	recorder := serve(s, "POST", "/users", `{"Email": "old@test.com", "Password": "password"}`, nil)
+	require.Equal(t, http.StatusAccepted, recorder.Code)
+	assert.Empty(t, recorder.Body.String())
+	token := mailer.waitForToken(t, 1, "old@test.com", "/reset-password")
+	require.Equal(t, http.StatusNoContent, serve(s, "POST", "/password/reset", fmt.Sprintf(`{"token": %q, "password": "new password"}`, token), nil).Code)
+
+	// user managers are still told
+	assert.Equal(t, http.StatusConflict, serve(s, "POST", "/users", `{"Email": "old@test.com", "Password": "password"}`, s.admin).Code)
+}
+
+func TestMailLimits(t *testing.T) {
+	s, mailer := newEmailServer(t, false)
+
+	// an address is only mailed once a minute, however often it's asked for
+	require.Equal(t, http.StatusAccepted, serve(s, "POST", "/password/forgot", `{"email": "test@test.com"}`, nil).Code)
+	mailer.waitForToken(t, 1, "test@test.com", "/reset-password")
+	require.Equal(t, http.StatusAccepted, serve(s, "POST", "/password/forgot", `{"email": "TEST@test.com"}`, nil).Code)
+	require.Equal(t, http.StatusAccepted, serve(s, "POST", "/email/verification", `{"email": "test@test.com"}`, nil).Code)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 1, mailer.count())
+
+	// and one IP address can only ask so often, for any addresses
+	for i := 3; i < 10; i++ {
+		require.Equal(t, http.StatusAccepted, serve(s, "POST", "/password/forgot", fmt.Sprintf(`{"email": "nobody%d@test.com"}`, i), nil).Code)
+	}
+	assert.Equal(t, http.StatusTooManyRequests, serve(s, "POST", "/password/forgot", `{"email": "other@test.com"}`, nil).Code)
+	assert.Equal(t, http.StatusTooManyRequests, serve(s, "POST", "/users", `{"Email": "new@test.com", "Password": "password"}`, nil).Code)
+}
+
+func TestResendVerification(t *testing.T) {
+	s, mailer := newEmailServer(t, false)
+	db := s.db
+	ctx := context.Background()
+	require.NoError(t, db.Users.Create(ctx, &models.User{Email: "old@test.com", Password: "x", Role: "user"}))
+
+	require.Equal(t, http.StatusAccepted, serve(s, "POST", "/email/verification", `{"email": "old@test.com"}`, nil).Code)
+	token := mailer.waitForToken(t, 1, "old@test.com", "/verify-email")
+	require.Equal(t, http.StatusNoContent, serve(s, "POST", "/email/verify", fmt.Sprintf(`{"token": %q}`, token), nil).Code)
+
+	// a reset token can't be used to verify, or the other way round
+	assert.Equal(t, http.StatusBadRequest, serve(s, "POST", "/password/reset", fmt.Sprintf(`{"token": %q, "password": "new password"}`, token), nil).Code)
+}
+
+func TestPasswordReset(t *testing.T) {
+	s, mailer := newEmailServer(t, false)
+	db, user := s.db, s.user
+	ctx := context.Background()
+
+	access := cookieNamed(tryLogin(s, "10.0.0.1", user.Email, passwords[user.Email]).Result().Cookies(), "token")
+	require.NotNil(t, access)
+
+	// unknown addresses get the same answer, and no mail
+	assert.Equal(t, http.StatusAccepted, serve(s, "POST", "/password/forgot", `{"email": "nobody@test.com"}`, nil).Code)
+	require.Equal(t, http.StatusAccepted, serve(s, "POST", "/password/forgot", `{"email": "test@test.com"}`, nil).Code)
+	token := mailer.waitForToken(t, 1, user.Email, "/reset-password")
+	assert.Equal(t, 1, mailer.count())
+
+	assert.Equal(t, http.StatusBadRequest, serve(s, "POST", "/password/reset", fmt.Sprintf(`{"token": %q, "password": "short"}`, token), nil).Code)
+	require.Equal(t, http.StatusNoContent, serve(s, "POST", "/password/reset", fmt.Sprintf(`{"token": %q, "password": "new password"}`, token), nil).Code)
+	assert.Equal(t, http.StatusBadRequest, serve(s, "POST", "/password/reset", fmt.Sprintf(`{"token": %q, "password": "another password"}`, token), nil).Code, "tokens only work once")
+
+	assert.Equal(t, http.StatusUnauthorized, serveWithCookies(s, "GET", fmt.Sprintf("/users/%d", user.ID), access).Code, "sessions are revoked")
+	assert.Equal(t, http.StatusUnauthorized, tryLogin(s, "10.0.0.1", user.Email, passwords[user.Email]).Code)
+	assert.Equal(t, http.StatusOK, tryLogin(s, "10.0.0.1", user.Email, "new password").Code)
+
+	user, err := db.Users.GetByID(ctx, user.ID)
+	require.NoError(t, err)
+	assert.True(t, user.EmailVerified)
+}
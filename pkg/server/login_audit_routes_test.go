@@ -0,0 +1,117 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/humper/tor_exit_nodes/models"
+	"github.com/humper/tor_exit_nodes/pkg/auth"
+	"github.com/humper/tor_exit_nodes/pkg/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testLockout locks accounts and addresses out after a few failures.
+var testLockout = &auth.LockoutConfig{
+	MaxAccountFailures: 3,
+	MaxIPFailures:      4,
+	Duration:           time.Minute,
+}
+
+func tryLogin(s *testServer, ip, email, password string) *httptest.ResponseRecorder {
+	body := fmt.Sprintf(`{"email": %q, "password": %q}`, email, password)
+	req, _ := http.NewRequest("POST", "/login", bytes.NewBufferString(body))
+	req.RemoteAddr = ip + ":1234"
+	req.Header.Set("User-Agent", "lockout-test")
+	recorder := httptest.NewRecorder()
+	s.GetHandler().ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestLoginAccountLockout(t *testing.T) {
+	s := newTestServer(t, &server.NewServerParams{Lockout: testLockout})
+	user, admin := s.user, s.admin
+
+	for i := 0; i < 3; i++ {
+		recorder := tryLogin(s, "10.0.0.1", user.Email, "wrong")
+		require.Equal(t, http.StatusUnauthorized, recorder.Code)
+		assert.Contains(t, recorder.Body.String(), "Invalid credentials")
+	}
+	// even the right password is refused, from anywhere
+	assert.Equal(t, http.StatusTooManyRequests, tryLogin(s, "10.0.0.2", user.Email, passwords[user.Email]).Code)
+	assert.Equal(t, http.StatusOK, tryLogin(s, "10.0.0.1", admin.Email, passwords[admin.Email]).Code)
+
+	assert.Equal(t, http.StatusForbidden, serve(s, "GET", "/admin/audit/logins", "", user).Code)
+	recorder := serve(s, "GET", "/admin/audit/logins?limit=100", "", admin)
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	var page models.LoginAuditPagination
+	require.NoError(t, json.NewDecoder(recorder.Body).Decode(&page))
+	require.Len(t, page.Rows, 5)
+	newest := page.Rows[0]
+	assert.Equal(t, admin.Email, newest.Email)
+	assert.True(t, newest.Success)
+	require.NotNil(t, newest.UserID)
+	assert.Equal(t, admin.ID, *newest.UserID)
+	assert.Equal(t, "10.0.0.1", newest.IP)
+	assert.Equal(t, "lockout-test", newest.UserAgent)
+	assert.Equal(t, models.LoginLocked, page.Rows[1].Reason)
+	assert.Equal(t, models.LoginInvalidCredentials, page.Rows[2].Reason)
+	assert.False(t, page.Rows[2].Success)
+
+	recorder = serve(s, "GET", "/admin/audit/logins?sort=created_at+asc&filter="+url.QueryEscape(`{"reason": ["locked"]}`), "", admin)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	page = models.LoginAuditPagination{}
+	require.NoError(t, json.NewDecoder(recorder.Body).Decode(&page))
+	require.Len(t, page.Rows, 1)
+	assert.Equal(t, "10.0.0.2", page.Rows[0].IP)
+
+	// only known columns can be filtered and sorted on, since they end up in SQL
+	assert.Equal(t, http.StatusBadRequest, serve(s, "GET", "/admin/audit/logins?filter="+url.QueryEscape(`{"1=1) OR (1": ["1"]}`), "", admin).Code)
+	assert.Equal(t, http.StatusBadRequest, serve(s, "GET", "/admin/audit/logins?sort="+url.QueryEscape("(select password from users limit 1)"), "", admin).Code)
+}
+
+func TestLoginSuccessClearsAccountFailures(t *testing.T) {
+	s := newTestServer(t, &server.NewServerParams{Lockout: testLockout})
+	user := s.user
+
+	for i := 0; i < 2; i++ {
+		require.Equal(t, http.StatusUnauthorized, tryLogin(s, "10.0.0.1", user.Email, "wrong").Code)
+	}
+	require.Equal(t, http.StatusOK, tryLogin(s, "10.0.0.1", user.Email, passwords[user.Email]).Code)
+	assert.Equal(t, http.StatusUnauthorized, tryLogin(s, "10.0.0.1", user.Email, "wrong").Code)
+	assert.Equal(t, http.StatusOK, tryLogin(s, "10.0.0.1", user.Email, passwords[user.Email]).Code)
+}
+
+func TestLoginIPLockout(t *testing.T) {
+	s := newTestServer(t, &server.NewServerParams{Lockout: testLockout})
+	user := s.user
+
+	// unknown users count against the address too, so it can't be used to guess accounts
+	for i := 0; i < 4; i++ {
+		recorder := tryLogin(s, "10.0.0.1", fmt.Sprintf("nobody%d@test.com", i), "wrong")
+		require.Equal(t, http.StatusUnauthorized, recorder.Code)
+		assert.Contains(t, recorder.Body.String(), "Invalid credentials")
+	}
+	assert.Equal(t, http.StatusTooManyRequests, tryLogin(s, "10.0.0.1", user.Email, passwords[user.Email]).Code)
+	assert.Equal(t, http.StatusOK, tryLogin(s, "10.0.0.2", user.Email, passwords[user.Email]).Code)
+}
+
+func TestRegisterPasswordPolicy(t *testing.T) {
+	s := newTestServer(t, &server.NewServerParams{
+		PasswordPolicy: &auth.PasswordPolicy{MinLength: 8, RequireDigit: true},
+	})
+
+	recorder := serve(s, "POST", "/users", `{"email": "new@test.com", "password": "password"}`, nil)
+	require.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "digit")
+
+	recorder = serve(s, "POST", "/users", `{"email": "new@test.com", "password": "passw0rd"}`, nil)
+	assert.Equal(t, http.StatusAccepted, recorder.Code)
+}
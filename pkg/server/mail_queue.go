@@ -0,0 +1,132 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/humper/tor_exit_nodes/models"
+)
+
+const (
+	// mailQueueSize is how many mails can wait to be sent; more are dropped rather than letting
+	// requests pile up goroutines.
+	mailQueueSize = 100
+	// mailCooldown is how long an address waits between mails that anyone can ask for.
+	mailCooldown = time.Minute
+	// maxMailRequestsPerIP is how many requests that send mail one IP address can make within
+	// mailIPWindow.
+	maxMailRequestsPerIP = 10
+	mailIPWindow         = 15 * time.Minute
+)
+
+// mailJob is a mail waiting to be sent: to user, or to the user with email if user is nil.
+type mailJob struct {
+	email string
+	user  *models.User
+	send  func(context.Context, *models.User) error
+}
+
+// mailQueue limits the mail that requests which don't need a login can make the server send, so
+// that it can't be used to flood an inbox or the server itself.
+type mailQueue struct {
+	jobs  chan *mailJob
+	mutex sync.Mutex
+	// sent is when each address was last queued a mail, and requests when each IP address asked for
+	// mail within the window.
+	sent     map[string]time.Time
+	requests map[string][]time.Time
+	pruned   time.Time
+}
+
+func newMailQueue() *mailQueue {
+	return &mailQueue{
+		jobs:     make(chan *mailJob, mailQueueSize),
+		sent:     map[string]time.Time{},
+		requests: map[string][]time.Time{},
+	}
+}
+
+// allowIP records a request for mail from ip, and reports whether it's within the limit.
+func (q *mailQueue) allowIP(ip string, now time.Time) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	recent := q.requests[ip][:0]
+	for _, t := range q.requests[ip] {
+		if now.Sub(t) < mailIPWindow {
+			recent = append(recent, t)
+		}
+	}
+	if len(recent) >= maxMailRequestsPerIP {
+		q.requests[ip] = recent
+		return false
+	}
+	q.requests[ip] = append(recent, now)
+	q.prune(now)
+	return true
+}
+
+// add queues job unless its address was sent one recently or the queue is full.
+func (q *mailQueue) add(ctx context.Context, job *mailJob, now time.Time) {
+	key := strings.ToLower(job.email)
+
+	q.mutex.Lock()
+	if last, ok := q.sent[key]; ok && now.Sub(last) < mailCooldown {
+		q.mutex.Unlock()
+		slog.InfoContext(ctx, "Not mailing recently mailed address", "email", job.email)
+		return
+	}
+	q.sent[key] = now
+	q.mutex.Unlock()
+
+	select {
+	case q.jobs <- job:
+	default:
+		slog.ErrorContext(ctx, "Mail queue is full, dropping mail", "email", job.email)
+	}
+}
+
+// prune forgets addresses and IP addresses whose limits have passed, at most once a cooldown.  The
+// caller holds the mutex.
+func (q *mailQueue) prune(now time.Time) {
+	if now.Sub(q.pruned) < mailCooldown {
+		return
+	}
+	q.pruned = now
+	for email, last := range q.sent {
+		if now.Sub(last) >= mailCooldown {
+			delete(q.sent, email)
+		}
+	}
+	for ip, times := range q.requests {
+		if len(times) == 0 || now.Sub(times[len(times)-1]) >= mailIPWindow {
+			delete(q.requests, ip)
+		}
+	}
+}
+
+// sendQueuedMail sends queued mail one at a time until ctx is cancelled.
+func (s *Server) sendQueuedMail(ctx context.Context) {
+	for {
+		select {
+		case job := <-s.mails.jobs:
+			user := job.user
+			if user == nil {
+				var err error
+				user, err = s.db.Users.GetByEmail(ctx, job.email)
+				if err != nil {
+					slog.InfoContext(ctx, "Not mailing unknown user", "email", job.email)
+					continue
+				}
+			}
+			if err := job.send(ctx, user); err != nil {
+				slog.ErrorContext(ctx, "Failed to send mail", "error", err, "email", job.email)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/humper/tor_exit_nodes/pkg/auth"
+)
+
+// AddMeRoutes serves the caller's own account at /me, so clients don't need to know their user ID.
+// Apart from GET /me, each is the /users/{id} route of the same name with the caller's ID.
+func (s *Server) AddMeRoutes(ctx context.Context, mux *http.ServeMux) {
+	mux.HandleFunc("GET /me", s.requireMe(func(w http.ResponseWriter, r *http.Request) {
+		s.HandleGetMe(ctx, w, r)
+	}))
+	mux.HandleFunc("PUT /me", s.requireMe(s.requireSelfOr(auth.PermissionUsersWrite, func(w http.ResponseWriter, r *http.Request) {
+		s.HandleUpdateMe(ctx, w, r)
+	})))
+	mux.HandleFunc("PUT /me/password", s.requireMe(s.requireSelfOr(auth.PermissionUsersWrite, func(w http.ResponseWriter, r *http.Request) {
+		s.HandleChangePassword(ctx, w, r)
+	})))
+	mux.HandleFunc("GET /me/sessions", s.requireMe(s.requireSelfOr(auth.PermissionUsersRead, func(w http.ResponseWriter, r *http.Request) {
+		s.HandleGetSessions(ctx, w, r)
+	})))
+	mux.HandleFunc("GET /me/apikeys", s.requireMe(s.requireSelfOr(auth.PermissionUsersWrite, func(w http.ResponseWriter, r *http.Request) {
+		s.HandleGetAPIKeys(ctx, w, r)
+	})))
+}
+
+// requireMe runs next for logged-in callers, with the {id} path value set to their own ID.
+func (s *Server) requireMe(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := auth.GetUser(r.Context())
+		if user == nil {
+			deny(w, r)
+			return
+		}
+		r.SetPathValue("id", strconv.FormatUint(uint64(user.ID), 10))
+		next(w, r)
+	}
+}
+
+// HandleGetMe returns the logged-in user.  It works even before a required second factor is set up,
+// so clients can tell who they are.
+func (s *Server) HandleGetMe(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newUserResponse(auth.GetUser(r.Context())))
+}
+
+// HandleUpdateMe changes the parts of their account users may change themselves.
+func (s *Server) HandleUpdateMe(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	var req SelfUpdateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		HttpError(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	s.updateUser(ctx, w, r, auth.GetUser(r.Context()).ID, &UpdateUserRequest{SelfUpdateRequest: req})
+}
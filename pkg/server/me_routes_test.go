@@ -0,0 +1,113 @@
+package server_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/humper/tor_exit_nodes/models"
+	"github.com/humper/tor_exit_nodes/pkg/auth"
+	"github.com/humper/tor_exit_nodes/pkg/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetMe(t *testing.T) {
+	s := newTestServer(t, &server.NewServerParams{})
+	user := s.user
+	token, _ := login(t, s)
+
+	assert.Equal(t, http.StatusUnauthorized, serveWithCookies(s, "GET", "/me").Code)
+
+	recorder := serveWithCookies(s, "GET", "/me", token)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	assert.NotContains(t, recorder.Body.String(), "Password")
+	var me server.UserResponse
+	require.NoError(t, json.NewDecoder(recorder.Body).Decode(&me))
+	assert.Equal(t, user.ID, me.ID)
+	assert.Equal(t, user.Email, me.Email)
+}
+
+func TestUpdateMe(t *testing.T) {
+	s := newTestServer(t, &server.NewServerParams{})
+	db, user := s.db, s.user
+	ctx := context.Background()
+
+	recorder := serve(s, "PUT", "/me", `{"Name": "Me", "AllowedIPs": ["5.6.7.8"], "Role": "admin"}`, user)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	var me server.UserResponse
+	require.NoError(t, json.NewDecoder(recorder.Body).Decode(&me))
+	assert.Equal(t, "Me", me.Name)
+	assert.Equal(t, []string{"5.6.7.8"}, me.AllowedIPs)
+
+	updated, err := db.Users.GetByID(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Me", updated.Name)
+	assert.Equal(t, "user", updated.Role, "roles can't be changed through /me")
+	assert.Equal(t, user.Password, updated.Password)
+}
+
+func TestChangeMyEmail(t *testing.T) {
+	s := newTestServer(t, &server.NewServerParams{})
+	db, user := s.db, s.user
+	ctx := context.Background()
+
+	// a session alone isn't enough to move the account to another address
+	assert.Equal(t, http.StatusForbidden, serve(s, "PUT", "/me", `{"Email": "new@test.com"}`, user).Code)
+	assert.Equal(t, http.StatusForbidden, serve(s, "PUT", fmt.Sprintf("/users/%d", user.ID), `{"Email": "new@test.com", "current_password": "wrong"}`, user).Code)
+	unchanged, err := db.Users.GetByID(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "test@test.com", unchanged.Email)
+
+	body := fmt.Sprintf(`{"Email": "new@test.com", "current_password": %q}`, passwords["test@test.com"])
+	require.Equal(t, http.StatusOK, serve(s, "PUT", "/me", body, user).Code)
+	updated, err := db.Users.GetByID(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "new@test.com", updated.Email)
+	assert.False(t, updated.EmailVerified)
+
+	// other fields don't need it, and neither do user managers changing someone else's address
+	require.Equal(t, http.StatusOK, serve(s, "PUT", "/me", `{"Name": "Me"}`, user).Code)
+	assert.Equal(t, http.StatusOK, serve(s, "PUT", fmt.Sprintf("/users/%d", user.ID), `{"Email": "test@test.com"}`, s.admin).Code)
+}
+
+func TestChangeMyPassword(t *testing.T) {
+	s := newTestServer(t, &server.NewServerParams{})
+	db, user := s.db, s.user
+	token, _ := login(t, s)
+
+	body := `{"old_password": "wrong", "new_password": "new password"}`
+	assert.Equal(t, http.StatusForbidden, serve(s, "PUT", "/me/password", body, user).Code)
+	body = fmt.Sprintf(`{"old_password": %q, "new_password": "new password"}`, passwords["test@test.com"])
+	require.Equal(t, http.StatusNoContent, serve(s, "PUT", "/me/password", body, user).Code)
+
+	updated, err := db.Users.GetByID(context.Background(), user.ID)
+	require.NoError(t, err)
+	assert.True(t, auth.ComparePassword("new password", updated.Password))
+	assert.Equal(t, http.StatusUnauthorized, serveWithCookies(s, "GET", "/me", token).Code, "other sessions are logged out")
+}
+
+func TestMySessionsAndAPIKeys(t *testing.T) {
+	s := newTestServer(t, &server.NewServerParams{})
+	user := s.user
+	token, _ := login(t, s)
+
+	recorder := serveWithCookies(s, "GET", "/me/sessions", token)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	var sessions []*models.Session
+	require.NoError(t, json.NewDecoder(recorder.Body).Decode(&sessions))
+	require.NotEmpty(t, sessions)
+	for _, session := range sessions {
+		assert.Equal(t, user.ID, session.UserID)
+	}
+
+	require.Equal(t, http.StatusCreated, serve(s, "POST", fmt.Sprintf("/users/%d/apikeys", user.ID), `{"name": "script"}`, user).Code)
+	recorder = serveWithCookies(s, "GET", "/me/apikeys", token)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	var keys []*models.APIKey
+	require.NoError(t, json.NewDecoder(recorder.Body).Decode(&keys))
+	require.Len(t, keys, 1)
+	assert.Equal(t, "script", keys[0].Name)
+}
@@ -0,0 +1,249 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/humper/tor_exit_nodes/models"
+	"github.com/humper/tor_exit_nodes/pkg/auth"
+	"github.com/humper/tor_exit_nodes/pkg/database"
+	"github.com/humper/tor_exit_nodes/pkg/oidc"
+	"golang.org/x/oauth2"
+)
+
+const (
+	oidcStateCookie  = "oidc_state"
+	oidcStatePurpose = "oidc-state"
+	oidcStateTTL     = 10 * time.Minute
+)
+
+// oidcState is kept in a signed cookie between the redirect to the IdP and the callback, so that
+// any replica can finish a login another one started.
+type oidcState struct {
+	jwt.StandardClaims
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	// LinkUserID is the logged in user who asked to link their account to the identity, rather than
+	// log in with it.
+	LinkUserID uint `json:"link_user_id,omitempty"`
+}
+
+func (s *Server) AddOIDCRoutes(ctx context.Context, mux *http.ServeMux) {
+	mux.HandleFunc("GET /login/oidc", func(w http.ResponseWriter, r *http.Request) {
+		s.HandleOIDCLogin(ctx, w, r)
+	})
+	mux.HandleFunc("GET /login/oidc/callback", func(w http.ResponseWriter, r *http.Request) {
+		s.HandleOIDCCallback(ctx, w, r)
+	})
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// HandleOIDCLogin starts an authorization code flow by redirecting to the IdP.  With link=true, a
+// logged in user instead links the identity they sign in as to their account.
+func (s *Server) HandleOIDCLogin(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	var linkUserID uint
+	if r.URL.Query().Get("link") == "true" {
+		user := auth.GetUser(r.Context())
+		if user == nil {
+			HttpError(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		linkUserID = user.ID
+	}
+
+	state, err := randomString()
+	if err != nil {
+		HttpError(w, "Failed to start login", http.StatusInternalServerError)
+		return
+	}
+	nonce, err := randomString()
+	if err != nil {
+		HttpError(w, "Failed to start login", http.StatusInternalServerError)
+		return
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	expires := time.Now().Add(oidcStateTTL)
+	cookie, err := auth.SignToken(&oidcState{
+		StandardClaims: jwt.StandardClaims{
+			Audience:  oidcStatePurpose,
+			ExpiresAt: expires.Unix(),
+		},
+		State:      state,
+		Nonce:      nonce,
+		Verifier:   verifier,
+		LinkUserID: linkUserID,
+	})
+	if err != nil {
+		HttpError(w, "Failed to start login", http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    cookie,
+		Expires:  expires,
+		Path:     "/login/oidc",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, s.oidc.AuthCodeURL(state, nonce, verifier), http.StatusFound)
+}
+
+// HandleOIDCCallback finishes the flow: it redeems the code, provisions or links the user by
+// email, and logs them in as HandleLogin does, asking for their code if they have two-factor
+// authentication enabled.
+func (s *Server) HandleOIDCCallback(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if errCode := r.URL.Query().Get("error"); errCode != "" {
+		slog.InfoContext(ctx, "OIDC login refused by provider", "error", errCode, "description", r.URL.Query().Get("error_description"))
+		HttpError(w, "Login refused by identity provider", http.StatusUnauthorized)
+		return
+	}
+
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil {
+		HttpError(w, "Missing login state", http.StatusBadRequest)
+		return
+	}
+	var state oidcState
+	if err := auth.ParseToken(cookie.Value, oidcStatePurpose, &state); err != nil || state.State != r.URL.Query().Get("state") {
+		HttpError(w, "Invalid login state", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: "/login/oidc", MaxAge: -1, HttpOnly: true})
+
+	identity, err := s.oidc.Exchange(ctx, r.URL.Query().Get("code"), state.Nonce, state.Verifier)
+	if err != nil {
+		slog.InfoContext(ctx, "OIDC login failed", "error", err)
+		HttpError(w, "Login failed", http.StatusUnauthorized)
+		return
+	}
+
+	if state.LinkUserID != 0 {
+		s.linkOIDC(ctx, w, r, state.LinkUserID, identity)
+		return
+	}
+
+	user, err := s.oidcUser(ctx, r, identity)
+	if err != nil {
+		slog.InfoContext(ctx, "OIDC login rejected", "error", err, "email", identity.Email)
+		HttpError(w, "Login rejected", http.StatusForbidden)
+		return
+	}
+
+	if user.TOTPEnabled {
+		s.startTOTPLogin(w, user)
+		return
+	}
+
+	if err := s.startSession(ctx, w, r, user); err != nil {
+		HttpError(w, "Failed to create session", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, s.oidc.PostLoginURL(), http.StatusFound)
+}
+
+// linkOIDC links identity to the user with userID, who started the flow logged in.  Accounts with a
+// local password or a role that requires two-factor authentication are only linked this way.
+func (s *Server) linkOIDC(ctx context.Context, w http.ResponseWriter, r *http.Request, userID uint, identity *oidc.Identity) {
+	user, err := s.db.Users.GetByID(ctx, userID)
+	if err != nil {
+		HttpError(w, "Login rejected", http.StatusForbidden)
+		return
+	}
+	// users are found by email when they log in, so an identity with another one would never be used
+	if identity.Email != user.Email {
+		slog.InfoContext(ctx, "OIDC link rejected", "email", user.Email, "identity_email", identity.Email)
+		HttpError(w, "Identity's email address doesn't match the account's", http.StatusForbidden)
+		return
+	}
+
+	before := *user
+	user.OIDCSubject = identity.Subject
+	if err := s.db.Users.Update(ctx, user); err != nil {
+		HttpError(w, "Failed to link account", http.StatusInternalServerError)
+		return
+	}
+	s.auditSelf(ctx, r, models.AuditUserUpdate, user, &before)
+	slog.InfoContext(ctx, "Linked user to OIDC identity", "email", user.Email)
+	http.Redirect(w, r, s.oidc.PostLoginURL(), http.StatusFound)
+}
+
+// oidcUser finds the user with the identity's email, creating them if needed, and brings their
+// role in line with their IdP groups.  An existing user not yet linked to the identity is only
+// linked automatically if they have no local password and their role doesn't require two-factor
+// authentication, as otherwise the IdP could bypass either.
+func (s *Server) oidcUser(ctx context.Context, r *http.Request, identity *oidc.Identity) (*models.User, error) {
+	role, mapped := s.oidc.Role(identity)
+	if !mapped && s.oidc.RequireGroup() {
+		return nil, errors.New("user is in none of the configured groups")
+	}
+	if mapped && !s.roles.Exists(role) {
+		return nil, errors.New("group is mapped to unknown role " + role)
+	}
+
+	user, err := s.db.Users.GetByEmail(ctx, identity.Email)
+	if errors.Is(err, database.ErrNotFound) {
+		if !mapped {
+			role = auth.DefaultRole
+		}
+		// no password: accounts created through single sign-on can't log in any other way until
+		// they reset it.  The IdP has verified the address.
+		user = &models.User{Name: identity.Name, Email: identity.Email, Role: role, EmailVerified: true, OIDCSubject: identity.Subject}
+		if err := s.db.Users.Create(ctx, user); err != nil {
+			return nil, err
+		}
+		slog.InfoContext(ctx, "Provisioned user from OIDC", "email", user.Email, "role", role)
+		s.auditSelf(ctx, r, models.AuditUserCreate, user, nil)
+		return s.db.Users.GetByEmail(ctx, identity.Email)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	before := *user
+	changed := !user.EmailVerified
+	if user.OIDCSubject != identity.Subject {
+		if user.OIDCSubject != "" {
+			return nil, errors.New("account is linked to another identity")
+		}
+		if user.Password != "" || slices.Contains(s.requireTOTP, user.Role) || (mapped && slices.Contains(s.requireTOTP, role)) {
+			return nil, errors.New("account must be linked by its owner before single sign-on")
+		}
+		user.OIDCSubject = identity.Subject
+		changed = true
+	}
+	user.EmailVerified = true
+	roleChanged := mapped && user.Role != role
+	if roleChanged {
+		slog.InfoContext(ctx, "Updating role from OIDC groups", "email", user.Email, "from", user.Role, "to", role)
+		user.Role = role
+		changed = true
+	}
+	if changed {
+		if err := s.db.Users.Update(ctx, user); err != nil {
+			return nil, err
+		}
+		s.auditSelf(ctx, r, models.AuditUserUpdate, user, &before)
+	}
+	if roleChanged {
+		// as when an admin changes a role, sessions issued with the old one end
+		s.revokeSessions(ctx, user.ID, 0, "role changed by identity provider")
+	}
+	return user, nil
+}
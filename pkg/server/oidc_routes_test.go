@@ -0,0 +1,275 @@
+package server_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/humper/tor_exit_nodes/models"
+	"github.com/humper/tor_exit_nodes/pkg/auth"
+	"github.com/humper/tor_exit_nodes/pkg/oidc"
+	"github.com/humper/tor_exit_nodes/pkg/server"
+	"github.com/humper/tor_exit_nodes/testing/fixtures"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newOIDCServer(t *testing.T, requireGroup bool) (*testServer, *fixtures.OIDCProvider) {
+	return newOIDCServerWith(t, requireGroup, &server.NewServerParams{})
+}
+
+// newOIDCServerWith is newOIDCServer with other settings from params.
+func newOIDCServerWith(t *testing.T, requireGroup bool, params *server.NewServerParams) (*testServer, *fixtures.OIDCProvider) {
+	ctx := context.Background()
+
+	idp, err := fixtures.NewOIDCProvider()
+	require.NoError(t, err)
+	t.Cleanup(idp.Close)
+
+	provider, err := oidc.New(ctx, &oidc.Config{
+		Issuer:       idp.URL,
+		ClientID:     idp.ClientID,
+		ClientSecret: idp.ClientSecret,
+		RedirectURL:  "http://ten.test/login/oidc/callback",
+		GroupRoles:   []oidc.GroupRole{{Group: "ten-admins", Role: "admin"}, {Group: "ten-users", Role: "user"}},
+		RequireGroup: requireGroup,
+	})
+	require.NoError(t, err)
+
+	params.OIDC = provider
+	return newTestServer(t, params), idp
+}
+
+func cookieNamed(cookies []*http.Cookie, name string) *http.Cookie {
+	for _, c := range cookies {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// oidcLogin runs the whole browser flow and returns the callback's response.
+func oidcLogin(t *testing.T, s *testServer, idp *fixtures.OIDCProvider, user *fixtures.OIDCUser) *httptest.ResponseRecorder {
+	return oidcFlow(t, s, idp, user, "/login/oidc")
+}
+
+// oidcFlow runs the browser flow starting at path, sending cookies with every request to ten.
+func oidcFlow(t *testing.T, s *testServer, idp *fixtures.OIDCProvider, user *fixtures.OIDCUser, path string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	recorder := serveWithCookies(s, "GET", path, cookies...)
+	require.Equal(t, http.StatusFound, recorder.Code)
+
+	state := cookieNamed(recorder.Result().Cookies(), "oidc_state")
+	require.NotNil(t, state)
+
+	callback, err := idp.Authorize(recorder.Header().Get("Location"), user)
+	require.NoError(t, err)
+	u, err := url.Parse(callback)
+	require.NoError(t, err)
+
+	return serveWithCookies(s, "GET", u.RequestURI(), append(cookies, state)...)
+}
+
+func TestOIDCProvisionsUser(t *testing.T) {
+	s, idp := newOIDCServer(t, false)
+	db := s.db
+
+	recorder := oidcLogin(t, s, idp, &fixtures.OIDCUser{
+		Subject: "abc", Email: "sso@test.com", Name: "Single Sign-On", Groups: []string{"ten-admins"}, EmailVerified: true,
+	})
+	require.Equal(t, http.StatusFound, recorder.Code, recorder.Body.String())
+	assert.Equal(t, "/", recorder.Header().Get("Location"))
+
+	session := cookieNamed(recorder.Result().Cookies(), "token")
+	require.NotNil(t, session)
+	claims, err := auth.ParseJWT(session.Value)
+	require.NoError(t, err)
+	assert.Equal(t, "admin", claims.Role)
+
+	user, err := db.Users.GetByEmail(context.Background(), "sso@test.com")
+	require.NoError(t, err)
+	assert.Equal(t, "admin", user.Role)
+	assert.Equal(t, "Single Sign-On", user.Name)
+	assert.False(t, auth.ComparePassword("", user.Password), "SSO users have no usable password")
+
+	// the session works like a password login's
+	req, err := http.NewRequest("GET", "/users", nil)
+	require.NoError(t, err)
+	req.AddCookie(session)
+	recorder = httptest.NewRecorder()
+	s.GetHandler().ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+}
+
+func TestOIDCLinksExistingUser(t *testing.T) {
+	s, idp := newOIDCServer(t, false)
+	db := s.db
+	ctx := context.Background()
+
+	require.NoError(t, db.Users.Create(ctx, &models.User{Email: "existing@test.com", Role: "admin", AllowedIPs: []string{"1.2.3.4"}}))
+	existing, err := db.Users.GetByEmail(ctx, "existing@test.com")
+	require.NoError(t, err)
+
+	// no mapped group: the existing role is kept
+	recorder := oidcLogin(t, s, idp, &fixtures.OIDCUser{Subject: "x", Email: "existing@test.com", EmailVerified: true})
+	require.Equal(t, http.StatusFound, recorder.Code)
+	adminSession := cookieNamed(recorder.Result().Cookies(), "token")
+	claims, err := auth.ParseJWT(adminSession.Value)
+	require.NoError(t, err)
+	assert.Equal(t, "admin", claims.Role)
+
+	// a mapped group brings the role in line with the IdP
+	recorder = oidcLogin(t, s, idp, &fixtures.OIDCUser{Subject: "x", Email: "existing@test.com", Groups: []string{"ten-users"}, EmailVerified: true})
+	require.Equal(t, http.StatusFound, recorder.Code)
+
+	// and ends the sessions issued under the old role
+	req, err := http.NewRequest("GET", "/users", nil)
+	require.NoError(t, err)
+	req.AddCookie(adminSession)
+	revoked := httptest.NewRecorder()
+	s.GetHandler().ServeHTTP(revoked, req)
+	assert.Equal(t, http.StatusUnauthorized, revoked.Code)
+
+	user, err := db.Users.GetByEmail(ctx, "existing@test.com")
+	require.NoError(t, err)
+	assert.Equal(t, existing.ID, user.ID)
+	assert.Equal(t, "user", user.Role)
+	assert.Equal(t, []string{"1.2.3.4"}, []string(user.AllowedIPs))
+}
+
+func TestOIDCRequireGroup(t *testing.T) {
+	s, idp := newOIDCServer(t, true)
+	db := s.db
+
+	recorder := oidcLogin(t, s, idp, &fixtures.OIDCUser{Subject: "x", Email: "outsider@test.com", Groups: []string{"other"}, EmailVerified: true})
+	assert.Equal(t, http.StatusForbidden, recorder.Code)
+	assert.Nil(t, cookieNamed(recorder.Result().Cookies(), "token"))
+
+	_, err := db.Users.GetByEmail(context.Background(), "outsider@test.com")
+	assert.Error(t, err)
+}
+
+func TestOIDCUnverifiedEmail(t *testing.T) {
+	s, idp := newOIDCServer(t, false)
+
+	recorder := oidcLogin(t, s, idp, &fixtures.OIDCUser{Subject: "x", Email: "someone@test.com", EmailVerified: false})
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+}
+
+func TestOIDCMissingEmailVerified(t *testing.T) {
+	s, idp := newOIDCServer(t, false)
+	db := s.db
+	ctx := context.Background()
+	require.NoError(t, db.Users.Create(ctx, &models.User{Email: "admin@test.com", Role: "admin"}))
+
+	// an IdP that doesn't vouch for the address can't sign into the account that has it
+	recorder := oidcLogin(t, s, idp, &fixtures.OIDCUser{Subject: "x", Email: "admin@test.com", OmitEmailVerified: true})
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+	assert.Nil(t, cookieNamed(recorder.Result().Cookies(), "token"))
+}
+
+func TestOIDCBadState(t *testing.T) {
+	s, idp := newOIDCServer(t, false)
+
+	recorder := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "/login/oidc", nil)
+	require.NoError(t, err)
+	s.GetHandler().ServeHTTP(recorder, req)
+	state := cookieNamed(recorder.Result().Cookies(), "oidc_state")
+
+	callback, err := idp.Authorize(recorder.Header().Get("Location"), &fixtures.OIDCUser{Email: "a@test.com", EmailVerified: true})
+	require.NoError(t, err)
+	u, err := url.Parse(callback)
+	require.NoError(t, err)
+	q := u.Query()
+	q.Set("state", "forged")
+	u.RawQuery = q.Encode()
+
+	// forged state
+	req, err = http.NewRequest("GET", u.RequestURI(), nil)
+	require.NoError(t, err)
+	req.AddCookie(state)
+	recorder = httptest.NewRecorder()
+	s.GetHandler().ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+
+	// no state cookie
+	req, err = http.NewRequest("GET", u.RequestURI(), nil)
+	require.NoError(t, err)
+	recorder = httptest.NewRecorder()
+	s.GetHandler().ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+
+	// the state cookie is signed with the session keys, but must not work as a session
+	req, err = http.NewRequest("GET", "/users", nil)
+	require.NoError(t, err)
+	req.AddCookie(&http.Cookie{Name: "token", Value: state.Value})
+	recorder = httptest.NewRecorder()
+	s.GetHandler().ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+}
+
+func TestOIDCWontLinkPasswordAccounts(t *testing.T) {
+	s, idp := newOIDCServer(t, false)
+	identity := &fixtures.OIDCUser{Subject: "test", Email: s.user.Email, EmailVerified: true}
+
+	// an IdP account with the same address can't take over a password account
+	recorder := oidcLogin(t, s, idp, identity)
+	assert.Equal(t, http.StatusForbidden, recorder.Code)
+	assert.Nil(t, cookieNamed(recorder.Result().Cookies(), "token"))
+
+	// until its owner links it
+	assert.Equal(t, http.StatusUnauthorized, serveWithCookies(s, "GET", "/login/oidc?link=true").Code)
+	access, _ := login(t, s)
+	recorder = oidcFlow(t, s, idp, &fixtures.OIDCUser{Subject: "other", Email: "other@test.com", EmailVerified: true}, "/login/oidc?link=true", access)
+	assert.Equal(t, http.StatusForbidden, recorder.Code, "only identities with the account's address can be linked")
+	recorder = oidcFlow(t, s, idp, identity, "/login/oidc?link=true", access)
+	require.Equal(t, http.StatusFound, recorder.Code, recorder.Body.String())
+
+	recorder = oidcLogin(t, s, idp, identity)
+	require.Equal(t, http.StatusFound, recorder.Code)
+	assert.NotNil(t, cookieNamed(recorder.Result().Cookies(), "token"))
+
+	// and then only that identity
+	recorder = oidcLogin(t, s, idp, &fixtures.OIDCUser{Subject: "impostor", Email: s.user.Email, EmailVerified: true})
+	assert.Equal(t, http.StatusForbidden, recorder.Code)
+}
+
+func TestOIDCWontLinkTOTPRoles(t *testing.T) {
+	s, idp := newOIDCServerWith(t, false, &server.NewServerParams{RequireTOTP: []string{"admin"}})
+	ctx := context.Background()
+	require.NoError(t, s.db.Users.Create(ctx, &models.User{Email: "sso-admin@test.com", Role: "admin"}))
+	require.NoError(t, s.db.Users.Create(ctx, &models.User{Email: "sso-user@test.com", Role: "user"}))
+
+	recorder := oidcLogin(t, s, idp, &fixtures.OIDCUser{Subject: "a", Email: "sso-admin@test.com", EmailVerified: true})
+	assert.Equal(t, http.StatusForbidden, recorder.Code)
+
+	// nor one whose groups would make it one
+	recorder = oidcLogin(t, s, idp, &fixtures.OIDCUser{Subject: "u", Email: "sso-user@test.com", Groups: []string{"ten-admins"}, EmailVerified: true})
+	assert.Equal(t, http.StatusForbidden, recorder.Code)
+	user, err := s.db.Users.GetByEmail(ctx, "sso-user@test.com")
+	require.NoError(t, err)
+	assert.Equal(t, "user", user.Role)
+}
+
+func TestOIDCAsksForTOTPCode(t *testing.T) {
+	s, idp := newOIDCServer(t, false)
+	identity := &fixtures.OIDCUser{Subject: "test", Email: s.user.Email, EmailVerified: true}
+	access, _ := login(t, s)
+	require.Equal(t, http.StatusFound, oidcFlow(t, s, idp, identity, "/login/oidc?link=true", access).Code)
+	_, recoveryCodes := enrollTOTP(t, s, s.user)
+
+	recorder := oidcLogin(t, s, idp, identity)
+	require.Equal(t, http.StatusAccepted, recorder.Code)
+	assert.Nil(t, cookieNamed(recorder.Result().Cookies(), "token"), "no session until the code is checked")
+	var pending server.TOTPLoginResponse
+	require.NoError(t, json.NewDecoder(recorder.Body).Decode(&pending))
+	assert.True(t, pending.TOTPRequired)
+
+	assert.Equal(t, http.StatusUnauthorized, finishTOTPLogin(s, pending.Token, "000000"))
+	// enrolling used up the current code
+	assert.Equal(t, http.StatusOK, finishTOTPLogin(s, pending.Token, recoveryCodes[0]))
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/humper/tor_exit_nodes/models"
+	"github.com/humper/tor_exit_nodes/pkg/auth"
+	"github.com/humper/tor_exit_nodes/pkg/database"
+)
+
+func (s *Server) AddOrganizationRoutes(ctx context.Context, mux *http.ServeMux) {
+	mux.HandleFunc("GET /organizations", s.require(auth.PermissionOrgsManage, func(w http.ResponseWriter, r *http.Request) {
+		s.HandleGetOrganizations(ctx, w, r)
+	}))
+	mux.HandleFunc("POST /organizations", s.require(auth.PermissionOrgsManage, func(w http.ResponseWriter, r *http.Request) {
+		s.HandleCreateOrganization(ctx, w, r)
+	}))
+	mux.HandleFunc("DELETE /organizations/{id}", s.require(auth.PermissionOrgsManage, func(w http.ResponseWriter, r *http.Request) {
+		s.HandleDeleteOrganization(ctx, w, r)
+	}))
+}
+
+func (s *Server) HandleGetOrganizations(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	pagination, err := getPagination(w, r)
+	if err != nil {
+		return
+	}
+
+	pagination, err = s.db.Organizations.GetAll(ctx, pagination)
+	if err != nil {
+		HttpError(w, "Failed to get organizations", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pagination)
+}
+
+func (s *Server) HandleCreateOrganization(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	var org models.Organization
+	if err := json.NewDecoder(r.Body).Decode(&org); err != nil {
+		HttpError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	org = models.Organization{Name: strings.TrimSpace(org.Name)}
+	if org.Name == "" {
+		HttpError(w, "Organization name is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.db.Organizations.Create(ctx, &org); err != nil {
+		if errors.Is(err, database.ErrConflict) {
+			HttpError(w, "Organization already exists", http.StatusConflict)
+			return
+		}
+		slog.ErrorContext(ctx, "Failed to create organization", "error", err, "name", org.Name)
+		HttpError(w, "Failed to create organization", http.StatusInternalServerError)
+		return
+	}
+	s.audit(ctx, r, &models.AuditEvent{Action: models.AuditOrganizationCreate, TargetType: "organization", TargetID: org.ID, OrganizationID: org.ID}, nil, &org)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(org)
+}
+
+// HandleDeleteOrganization deletes an organization once it has no users left, so that nobody is
+// silently moved into the global organization.
+func (s *Server) HandleDeleteOrganization(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id <= 0 {
+		HttpError(w, "Invalid organization id", http.StatusBadRequest)
+		return
+	}
+
+	members, err := s.db.Users.GetAllByOrganization(ctx, uint(id), &models.Pagination{Limit: 1})
+	if err != nil {
+		HttpError(w, "Failed to get organization users", http.StatusInternalServerError)
+		return
+	}
+	if members.TotalRows > 0 {
+		HttpError(w, "Organization still has users", http.StatusConflict)
+		return
+	}
+
+	org, err := s.db.Organizations.GetByID(ctx, uint(id))
+	if err != nil {
+		HttpError(w, "Unknown organization", http.StatusNotFound)
+		return
+	}
+	if err := s.db.Organizations.Delete(ctx, org.ID); err != nil {
+		HttpError(w, "Failed to delete organization", http.StatusInternalServerError)
+		return
+	}
+	s.audit(ctx, r, &models.AuditEvent{Action: models.AuditOrganizationDelete, TargetType: "organization", TargetID: org.ID, OrganizationID: org.ID}, org, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,184 @@
+package server_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/humper/tor_exit_nodes/models"
+	"github.com/humper/tor_exit_nodes/pkg/auth"
+	"github.com/humper/tor_exit_nodes/pkg/database"
+	"github.com/humper/tor_exit_nodes/pkg/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// orgFixture is two organizations, each with an org admin and a user, and the test server's
+// accounts, which aren't in any organization.
+type orgFixture struct {
+	s                *testServer
+	db               *database.Database
+	orgA, orgB       *models.Organization
+	adminA, userA    *models.User
+	userB, superuser *models.User
+}
+
+func newOrgServer(t *testing.T) *orgFixture {
+	ctx := context.Background()
+	s := newTestServer(t, &server.NewServerParams{})
+
+	f := &orgFixture{s: s, db: s.db, superuser: s.admin, orgA: &models.Organization{Name: "a"}, orgB: &models.Organization{Name: "b"}}
+	require.NoError(t, f.db.Organizations.Create(ctx, f.orgA))
+	require.NoError(t, f.db.Organizations.Create(ctx, f.orgB))
+
+	create := func(email, role string, org uint) *models.User {
+		require.NoError(t, f.db.Users.Create(ctx, &models.User{Email: email, Role: role, OrganizationID: org}))
+		user, err := f.db.Users.GetByEmail(ctx, email)
+		require.NoError(t, err)
+		return user
+	}
+	f.adminA = create("admin@a.com", auth.OrgAdminRole, f.orgA.ID)
+	f.userA = create("user@a.com", "user", f.orgA.ID)
+	f.userB = create("user@b.com", "user", f.orgB.ID)
+	return f
+}
+
+func listedEmails(t *testing.T, s *testServer, user *models.User) []string {
+	w := serve(s, "GET", "/users", "", user)
+	require.Equal(t, http.StatusOK, w.Code)
+	var response struct {
+		Rows []*models.User `json:"rows"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	emails := []string{}
+	for _, row := range response.Rows {
+		emails = append(emails, row.Email)
+	}
+	return emails
+}
+
+func TestOrgAdminSeesOwnOrganization(t *testing.T) {
+	f := newOrgServer(t)
+
+	assert.ElementsMatch(t, []string{"admin@a.com", "user@a.com"}, listedEmails(t, f.s, f.adminA))
+	assert.Len(t, listedEmails(t, f.s, f.superuser), 5)
+
+	assert.Equal(t, http.StatusOK, serve(f.s, "GET", fmt.Sprintf("/users/%d", f.userA.ID), "", f.adminA).Code)
+	assert.Equal(t, http.StatusForbidden, serve(f.s, "GET", fmt.Sprintf("/users/%d", f.userB.ID), "", f.adminA).Code)
+	assert.Equal(t, http.StatusForbidden, serve(f.s, "PUT", fmt.Sprintf("/users/%d", f.userB.ID), `{"Name": "pwned"}`, f.adminA).Code)
+	assert.Equal(t, http.StatusForbidden, serve(f.s, "DELETE", fmt.Sprintf("/users/%d", f.userB.ID), "", f.adminA).Code)
+	assert.Equal(t, http.StatusOK, serve(f.s, "DELETE", fmt.Sprintf("/users/%d", f.userA.ID), "", f.adminA).Code)
+}
+
+func TestOrgAdminCreatesUsersInOwnOrganization(t *testing.T) {
+	f := newOrgServer(t)
+	ctx := context.Background()
+
+	body := fmt.Sprintf(`{"Email": "new@a.com", "Password": "password", "Role": "user", "OrganizationID": %d}`, f.orgB.ID)
+	require.Equal(t, http.StatusOK, serve(f.s, "POST", "/users", body, f.adminA).Code)
+	created, err := f.db.Users.GetByEmail(ctx, "new@a.com")
+	require.NoError(t, err)
+	assert.Equal(t, f.orgA.ID, created.OrganizationID)
+
+	body = `{"Email": "root@a.com", "Password": "password", "Role": "admin"}`
+	assert.Equal(t, http.StatusForbidden, serve(f.s, "POST", "/users", body, f.adminA).Code)
+}
+
+func TestOrgAdminCannotEscalate(t *testing.T) {
+	f := newOrgServer(t)
+	ctx := context.Background()
+	userA, err := f.db.Users.GetByID(ctx, f.userA.ID)
+	require.NoError(t, err)
+
+	body, _ := json.Marshal(&models.User{Email: userA.Email, Password: userA.Password, Role: "admin"})
+	assert.Equal(t, http.StatusForbidden, serve(f.s, "PUT", fmt.Sprintf("/users/%d", userA.ID), string(body), f.adminA).Code)
+
+	body, _ = json.Marshal(&models.User{Email: userA.Email, Password: userA.Password, Role: "user", OrganizationID: f.orgB.ID})
+	assert.Equal(t, http.StatusForbidden, serve(f.s, "PUT", fmt.Sprintf("/users/%d", userA.ID), string(body), f.adminA).Code)
+
+	userA, err = f.db.Users.GetByID(ctx, f.userA.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "user", userA.Role)
+	assert.Equal(t, f.orgA.ID, userA.OrganizationID)
+
+	assert.Equal(t, http.StatusForbidden, serve(f.s, "GET", "/organizations", "", f.adminA).Code)
+}
+
+func TestOrgAdminCannotTakeOverAdmins(t *testing.T) {
+	f := newOrgServer(t)
+	ctx := context.Background()
+	require.NoError(t, f.db.Users.Create(ctx, &models.User{Email: "root@a.com", Password: "hash", Role: "admin", OrganizationID: f.orgA.ID}))
+	root, err := f.db.Users.GetByEmail(ctx, "root@a.com")
+	require.NoError(t, err)
+
+	// an admin in the same organization outranks its org admin
+	path := fmt.Sprintf("/users/%d", root.ID)
+	assert.Equal(t, http.StatusForbidden, serve(f.s, "PUT", path+"/password", `{"new_password": "taken over"}`, f.adminA).Code)
+	assert.Equal(t, http.StatusForbidden, serve(f.s, "PUT", path, `{"Email": "mine@a.com"}`, f.adminA).Code)
+	assert.Equal(t, http.StatusForbidden, serve(f.s, "DELETE", path+"/totp", "", f.adminA).Code)
+	assert.Equal(t, http.StatusForbidden, serve(f.s, "DELETE", path, "", f.adminA).Code)
+	unchanged, err := f.db.Users.GetByID(ctx, root.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "root@a.com", unchanged.Email)
+	assert.Equal(t, "hash", unchanged.Password)
+
+	// and org admins in no organization don't share one with the users in none
+	require.NoError(t, f.db.Users.Create(ctx, &models.User{Email: "orphan@test.com", Role: auth.OrgAdminRole}))
+	orphan, err := f.db.Users.GetByEmail(ctx, "orphan@test.com")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, serve(f.s, "GET", "/users", "", orphan).Code)
+	assert.Equal(t, http.StatusForbidden, serve(f.s, "GET", fmt.Sprintf("/users/%d", f.s.user.ID), "", orphan).Code)
+	assert.Equal(t, http.StatusForbidden, serve(f.s, "PUT", fmt.Sprintf("/users/%d/password", f.s.user.ID), `{"new_password": "taken over"}`, orphan).Code)
+	assert.Equal(t, http.StatusForbidden, serve(f.s, "POST", "/users", `{"Email": "new@test.com", "Password": "password"}`, orphan).Code)
+	assert.Equal(t, http.StatusForbidden, serve(f.s, "GET", "/admin/audit", "", orphan).Code)
+	assert.Equal(t, http.StatusOK, serve(f.s, "GET", fmt.Sprintf("/users/%d", orphan.ID), "", orphan).Code)
+}
+
+func TestSuperuserManagesOrganizations(t *testing.T) {
+	f := newOrgServer(t)
+	ctx := context.Background()
+
+	w := serve(f.s, "POST", "/organizations", `{"name": "c"}`, f.superuser)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var orgC models.Organization
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &orgC))
+	assert.Equal(t, "c", orgC.Name)
+	assert.Equal(t, http.StatusBadRequest, serve(f.s, "POST", "/organizations", `{"name": " "}`, f.superuser).Code)
+	assert.Equal(t, http.StatusConflict, serve(f.s, "POST", "/organizations", `{"name": "c"}`, f.superuser).Code)
+
+	// moving a user between organizations
+	userB, err := f.db.Users.GetByID(ctx, f.userB.ID)
+	require.NoError(t, err)
+	body, _ := json.Marshal(&models.User{Email: userB.Email, Password: userB.Password, Role: "user", OrganizationID: orgC.ID})
+	require.Equal(t, http.StatusOK, serve(f.s, "PUT", fmt.Sprintf("/users/%d", userB.ID), string(body), f.superuser).Code)
+	userB, err = f.db.Users.GetByID(ctx, f.userB.ID)
+	require.NoError(t, err)
+	assert.Equal(t, orgC.ID, userB.OrganizationID)
+
+	body, _ = json.Marshal(&models.User{Email: userB.Email, Password: userB.Password, Role: "user", OrganizationID: 99})
+	assert.Equal(t, http.StatusBadRequest, serve(f.s, "PUT", fmt.Sprintf("/users/%d", userB.ID), string(body), f.superuser).Code)
+
+	assert.Equal(t, http.StatusConflict, serve(f.s, "DELETE", fmt.Sprintf("/organizations/%d", orgC.ID), "", f.superuser).Code)
+	assert.Equal(t, http.StatusNoContent, serve(f.s, "DELETE", fmt.Sprintf("/organizations/%d", f.orgB.ID), "", f.superuser).Code)
+	assert.Equal(t, http.StatusNotFound, serve(f.s, "DELETE", fmt.Sprintf("/organizations/%d", f.orgB.ID), "", f.superuser).Code)
+
+	w = serve(f.s, "GET", "/organizations", "", f.superuser)
+	require.Equal(t, http.StatusOK, w.Code)
+	var response models.OrganizationPagination
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, int64(2), response.TotalRows)
+}
+
+func TestUserConflictsAndUnknownUsers(t *testing.T) {
+	f := newOrgServer(t)
+
+	body := fmt.Sprintf(`{"Email": %q}`, f.userB.Email)
+	assert.Equal(t, http.StatusConflict, serve(f.s, "PUT", fmt.Sprintf("/users/%d", f.userA.ID), body, f.superuser).Code)
+
+	assert.Equal(t, http.StatusNotFound, serve(f.s, "GET", "/users/999", "", f.superuser).Code)
+	assert.Equal(t, http.StatusNotFound, serve(f.s, "PUT", "/users/999", `{"Name": "Nobody"}`, f.superuser).Code)
+	require.Equal(t, http.StatusOK, serve(f.s, "DELETE", fmt.Sprintf("/users/%d", f.userB.ID), "", f.superuser).Code)
+	assert.Equal(t, http.StatusNotFound, serve(f.s, "DELETE", fmt.Sprintf("/users/%d", f.userB.ID), "", f.superuser).Code)
+}
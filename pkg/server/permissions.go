@@ -0,0 +1,147 @@
+package server
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/humper/tor_exit_nodes/pkg/auth"
+)
+
+// deny rejects a request: 401 if the caller isn't logged in, 403 if they are but lack permission.
+func deny(w http.ResponseWriter, r *http.Request) {
+	if auth.GetUser(r.Context()) == nil {
+		HttpError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	HttpError(w, "Forbidden", http.StatusForbidden)
+}
+
+// needsTOTP reports whether the caller's role requires two-factor authentication they haven't set up.
+// Until they do, they can only manage it.
+func (s *Server) needsTOTP(w http.ResponseWriter, r *http.Request) bool {
+	user := auth.GetUser(r.Context())
+	if user == nil || user.TOTPEnabled || !slices.Contains(s.requireTOTP, user.Role) {
+		return false
+	}
+	HttpError(w, "Two-factor authentication required", http.StatusForbidden)
+	return true
+}
+
+// require only runs next for callers with permission.
+func (s *Server) require(permission string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.needsTOTP(w, r) {
+			return
+		}
+		if !s.roles.Allowed(r.Context(), permission) {
+			deny(w, r)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// requireInOrg runs next for callers with permission acting on a user (the {id} path value) they
+// manage; see manages.
+func (s *Server) requireInOrg(permission string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.needsTOTP(w, r) {
+			return
+		}
+		if !s.roles.Allowed(r.Context(), permission) || !s.manages(r) {
+			deny(w, r)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// requireSelfOr runs next for callers with permission acting on users they manage (see
+// requireInOrg), and for users acting on their own account.  An API key still needs permission in
+// its scopes to do either.
+func (s *Server) requireSelfOr(permission string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.needsTOTP(w, r) {
+			return
+		}
+		if s.roles.Allowed(r.Context(), permission) && s.manages(r) || isSelf(r) && auth.KeyAllows(r.Context(), permission) {
+			next(w, r)
+			return
+		}
+		deny(w, r)
+	}
+}
+
+// requireSelf only runs next for users acting on their own account.
+func (s *Server) requireSelf(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isSelf(r) {
+			deny(w, r)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// global reports whether the caller can act across organizations.
+func (s *Server) global(r *http.Request) bool {
+	return s.roles.Allowed(r.Context(), auth.PermissionOrgsManage)
+}
+
+// callerOrg returns the caller's organization, or 0 if they have none or aren't logged in.
+func callerOrg(r *http.Request) uint {
+	if user := auth.GetUser(r.Context()); user != nil {
+		return user.OrganizationID
+	}
+	return 0
+}
+
+// ownOrg returns the organization whose users and records a caller who can't manage
+// organizations is limited to.  Callers in no organization don't share one with anybody, so they
+// are denied and ok is false.
+func (s *Server) ownOrg(w http.ResponseWriter, r *http.Request) (org uint, ok bool) {
+	org = callerOrg(r)
+	if org == 0 {
+		deny(w, r)
+		return 0, false
+	}
+	return org, true
+}
+
+// manages reports whether the caller can act on the user in the {id} path value: global callers
+// reach users in every organization, others only users in their own.  Either way the caller must
+// hold every permission the user's role grants, so that nobody can take over an account that
+// outranks their own.
+func (s *Server) manages(r *http.Request) bool {
+	if auth.GetUser(r.Context()) == nil {
+		return false
+	}
+	// callers with every permission outrank everyone, wherever they are
+	if !slices.ContainsFunc(auth.Permissions, func(p string) bool { return !s.roles.Allowed(r.Context(), p) }) {
+		return true
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		// let the handler reject it
+		return true
+	}
+	target, err := s.db.Users.GetByID(r.Context(), uint(id))
+	if err != nil {
+		// global callers are told the user doesn't exist; others can't tell
+		return s.global(r)
+	}
+	if !s.global(r) && (callerOrg(r) == 0 || target.OrganizationID != callerOrg(r)) {
+		return false
+	}
+	return s.roles.Grants(r.Context(), target.Role)
+}
+
+func isSelf(r *http.Request) bool {
+	user := auth.GetUser(r.Context())
+	if user == nil {
+		return false
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	return err == nil && uint(id) == user.ID
+}
@@ -0,0 +1,104 @@
+package server_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/humper/tor_exit_nodes/models"
+	"github.com/humper/tor_exit_nodes/pkg/auth"
+	"github.com/humper/tor_exit_nodes/pkg/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPermissionsUserRoutes(t *testing.T) {
+	s := newTestServer(t, &server.NewServerParams{})
+	user, admin := s.user, s.admin
+
+	assert.Equal(t, http.StatusUnauthorized, serve(s, "GET", "/users", "", nil).Code)
+	assert.Equal(t, http.StatusForbidden, serve(s, "GET", "/users", "", user).Code)
+	assert.Equal(t, http.StatusOK, serve(s, "GET", "/users", "", admin).Code)
+
+	assert.Equal(t, http.StatusUnauthorized, serve(s, "GET", fmt.Sprintf("/users/%d", user.ID), "", nil).Code)
+	assert.Equal(t, http.StatusOK, serve(s, "GET", fmt.Sprintf("/users/%d", user.ID), "", user).Code)
+	assert.Equal(t, http.StatusForbidden, serve(s, "GET", fmt.Sprintf("/users/%d", admin.ID), "", user).Code)
+	assert.Equal(t, http.StatusOK, serve(s, "GET", fmt.Sprintf("/users/%d", user.ID), "", admin).Code)
+
+	assert.Equal(t, http.StatusForbidden, serve(s, "DELETE", fmt.Sprintf("/users/%d", user.ID), "", user).Code)
+}
+
+func TestPermissionsUpdateUsesPathID(t *testing.T) {
+	s := newTestServer(t, &server.NewServerParams{})
+	user, admin := s.user, s.admin
+
+	// the body claims to be the caller, but the path names someone else
+	body := fmt.Sprintf(`{"ID": %d, "Email": "admin@admin.com", "Name": "pwned"}`, user.ID)
+	assert.Equal(t, http.StatusForbidden, serve(s, "PUT", fmt.Sprintf("/users/%d", admin.ID), body, user).Code)
+}
+
+func TestPermissionsRoleChanges(t *testing.T) {
+	s := newTestServer(t, &server.NewServerParams{})
+	db, user, admin := s.db, s.user, s.admin
+	ctx := context.Background()
+
+	body := `{"Email": "test@test.com", "Role": "admin"}`
+	assert.Equal(t, http.StatusForbidden, serve(s, "PUT", fmt.Sprintf("/users/%d", user.ID), body, user).Code)
+
+	// leaving the role out keeps the current one
+	recorder := serve(s, "PUT", fmt.Sprintf("/users/%d", user.ID), `{"Email": "test@test.com", "Name": "Renamed"}`, user)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	updated, err := db.Users.GetByID(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "user", updated.Role)
+	assert.Equal(t, "Renamed", updated.Name)
+
+	assert.Equal(t, http.StatusBadRequest, serve(s, "PUT", fmt.Sprintf("/users/%d", user.ID), `{"Email": "test@test.com", "Role": "superuser"}`, admin).Code)
+	require.Equal(t, http.StatusOK, serve(s, "PUT", fmt.Sprintf("/users/%d", user.ID), body, admin).Code)
+	updated, err = db.Users.GetByID(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "admin", updated.Role)
+}
+
+func TestPermissionsRegisterRole(t *testing.T) {
+	s := newTestServer(t, &server.NewServerParams{})
+	db, admin := s.db, s.admin
+
+	recorder := serve(s, "POST", "/users", `{"Email": "new@test.com", "Password": "password", "Role": "admin"}`, nil)
+	require.Equal(t, http.StatusAccepted, recorder.Code)
+	registered, err := db.Users.GetByEmail(context.Background(), "new@test.com")
+	require.NoError(t, err)
+	assert.Equal(t, "user", registered.Role, "self-registration can't pick a role")
+
+	recorder = serve(s, "POST", "/users", `{"Email": "new-admin@test.com", "Password": "password", "Role": "admin"}`, admin)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	var created models.User
+	require.NoError(t, json.NewDecoder(recorder.Body).Decode(&created))
+	assert.Equal(t, "admin", created.Role)
+}
+
+func TestPermissionsTriggerUpdate(t *testing.T) {
+	s := newTestServer(t, &server.NewServerParams{})
+	user, admin := s.user, s.admin
+
+	assert.Equal(t, http.StatusUnauthorized, serve(s, "POST", "/tor/update", "", nil).Code)
+	assert.Equal(t, http.StatusForbidden, serve(s, "POST", "/tor/update", "", user).Code)
+	assert.Equal(t, http.StatusServiceUnavailable, serve(s, "POST", "/tor/update", "", admin).Code)
+}
+
+func TestPermissionsConfiguredRoles(t *testing.T) {
+	roles, err := auth.LoadRoles(auth.RolesConfig{
+		"admin":   {"users:read", "users:write", "nodes:read"},
+		"user":    {"nodes:read"},
+		"auditor": {"users:read"},
+	})
+	require.NoError(t, err)
+	s := newTestServer(t, &server.NewServerParams{Roles: roles})
+	user := s.user
+
+	// without an anonymous role, logged-out callers can't list nodes
+	assert.Equal(t, http.StatusUnauthorized, serve(s, "GET", "/tor", "", nil).Code)
+	assert.Equal(t, http.StatusOK, serve(s, "GET", "/tor", "", user).Code)
+}
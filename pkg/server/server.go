@@ -6,9 +6,14 @@ import (
 	"log/slog"
 	"net/http"
 	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/humper/tor_exit_nodes/pkg/auth"
 	"github.com/humper/tor_exit_nodes/pkg/database"
+	"github.com/humper/tor_exit_nodes/pkg/mail"
+	"github.com/humper/tor_exit_nodes/pkg/oidc"
 	"github.com/humper/tor_exit_nodes/pkg/tor"
 
 	etcd "go.etcd.io/etcd/client/v3"
@@ -19,31 +24,106 @@ type NewServerParams struct {
 	DB         *database.Database
 	TorUpdater *tor.TORUpdater
 	ETCD       *etcd.Client
+	// Roles maps user roles to permissions.  Defaults to auth.DefaultRoles.
+	Roles *auth.Roles
+	// OIDC enables single sign-on through an OpenID Connect provider.
+	OIDC *oidc.Provider
+	// PasswordPolicy is checked when users register or reset their password.  Defaults to auth.DefaultPasswordPolicy.
+	PasswordPolicy *auth.PasswordPolicy
+	// PasswordHasher hashes new passwords, and replaces outdated hashes on login.  Defaults to
+	// auth.DefaultPasswordHasher.
+	PasswordHasher *auth.PasswordHasher
+	// Lockout limits failed logins.  Defaults to auth.DefaultLockoutConfig.
+	Lockout *auth.LockoutConfig
+	// RequireTOTP lists roles whose users must enable two-factor authentication before they can
+	// use their permissions.
+	RequireTOTP []string
+	// Mailer sends password reset and verification emails.  Defaults to writing them to the log.
+	Mailer mail.Mailer
+	// PublicURL is where links in emails point, the frontend's address.  Defaults to
+	// http://localhost:3000.
+	PublicURL string
+	// RequireVerifiedEmail refuses password logins until users have verified their email address.
+	RequireVerifiedEmail bool
 }
 
 type Server struct {
-	db         *database.Database
-	mux        *http.ServeMux
-	torUpdater *tor.TORUpdater
-	etcd       *etcd.Client
+	db              *database.Database
+	mux             *http.ServeMux
+	torUpdater      *tor.TORUpdater
+	etcd            *etcd.Client
+	roles           *auth.Roles
+	oidc            *oidc.Provider
+	policy          *auth.PasswordPolicy
+	hasher          *auth.PasswordHasher
+	lockout         *auth.LockoutConfig
+	requireTOTP     []string
+	mailer          mail.Mailer
+	publicURL       string
+	requireVerified bool
+	mails           *mailQueue
+	// leader is set while this replica runs the scheduled updates: once it has won the etcd
+	// election, or from the start when there is no etcd.
+	leader atomic.Bool
 }
 
 func New(ctx context.Context, params *NewServerParams) *Server {
 	s := &Server{
-		db:         params.DB,
-		torUpdater: params.TorUpdater,
-		etcd:       params.ETCD,
+		db:              params.DB,
+		torUpdater:      params.TorUpdater,
+		etcd:            params.ETCD,
+		roles:           params.Roles,
+		oidc:            params.OIDC,
+		policy:          params.PasswordPolicy,
+		hasher:          params.PasswordHasher,
+		lockout:         params.Lockout,
+		requireTOTP:     params.RequireTOTP,
+		mailer:          params.Mailer,
+		publicURL:       strings.TrimSuffix(params.PublicURL, "/"),
+		requireVerified: params.RequireVerifiedEmail,
+		mails:           newMailQueue(),
+	}
+	if s.roles == nil {
+		s.roles = auth.DefaultRoles()
+	}
+	if s.policy == nil {
+		s.policy = auth.DefaultPasswordPolicy()
+	}
+	if s.hasher == nil {
+		s.hasher = auth.DefaultPasswordHasher()
+	}
+	if s.lockout == nil {
+		s.lockout = auth.DefaultLockoutConfig()
+	}
+	if s.mailer == nil {
+		s.mailer = &mail.FileMailer{From: mail.DefaultFrom}
+	}
+	if s.publicURL == "" {
+		s.publicURL = "http://localhost:3000"
 	}
 
 	mux := http.NewServeMux()
 
 	s.AddAuthRoutes(ctx, mux)
 	s.AddTorRoutes(ctx, mux)
+	s.AddAPIKeyRoutes(ctx, mux)
+	s.AddSessionRoutes(ctx, mux)
+	s.AddLoginAuditRoutes(ctx, mux)
+	s.AddTOTPRoutes(ctx, mux)
+	s.AddEmailRoutes(ctx, mux)
+	s.AddOrganizationRoutes(ctx, mux)
+	s.AddAuditRoutes(ctx, mux)
+	s.AddMeRoutes(ctx, mux)
+	if s.oidc != nil {
+		s.AddOIDCRoutes(ctx, mux)
+	}
 
 	// mux.Handle("GET /", http.FileServer(http.Dir("static")))
 
 	s.mux = mux
 
+	go s.sendQueuedMail(ctx)
+
 	if s.etcd != nil || s.torUpdater != nil {
 		go s.process(ctx)
 	}
@@ -72,20 +152,23 @@ func (s *Server) process(ctx context.Context) {
 
 			slog.InfoContext(ctx, "Became leader")
 		}
+		s.leader.Store(true)
 
 		if s.torUpdater != nil {
 			go s.torUpdater.UpdateTorExitNodes(ctx)
 		}
 
-		if s.etcd != nil {
-			select {
-			case <-ctx.Done():
-				if err := election.Resign(ctx); err != nil {
-					slog.ErrorContext(ctx, "Failed to resign", "error", err)
-				}
-				slog.InfoContext(ctx, "Resigned Leadership")
-				return
+		if s.etcd == nil {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			s.leader.Store(false)
+			if err := election.Resign(ctx); err != nil {
+				slog.ErrorContext(ctx, "Failed to resign", "error", err)
 			}
+			slog.InfoContext(ctx, "Resigned Leadership")
+			return
 		}
 	}
 }
@@ -94,7 +177,8 @@ func Cors(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", r.Header.Get("Origin"))
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Access-Control-Allow-Headers, Authorization, X-Requested-With")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Access-Control-Allow-Headers, Authorization, X-API-Key, X-Request-ID, X-Requested-With")
+		w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID")
 		w.Header().Set("Access-Control-Allow-Credentials", "true")
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusOK)
@@ -104,30 +188,93 @@ func Cors(next http.Handler) http.Handler {
 	})
 }
 
+// apiKeyFromRequest returns the API key sent in the X-API-Key or Authorization: Bearer header, if any.
+func apiKeyFromRequest(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	scheme, key, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if ok && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(key)
+	}
+	return ""
+}
+
+// apiKeyLogin authenticates a request by API key.  Unlike the cookie, a bad key is always an error,
+// as scripts would otherwise silently get anonymous results.
+func (s *Server) apiKeyLogin(next http.Handler, w http.ResponseWriter, r *http.Request, rawKey string) {
+	prefix, ok := auth.ParseAPIKey(rawKey)
+	if !ok {
+		HttpError(w, "Invalid API key", http.StatusUnauthorized)
+		return
+	}
+	key, err := s.db.APIKeys.GetByPrefix(r.Context(), prefix)
+	if err != nil || !auth.CompareAPIKey(rawKey, key.Hash) {
+		HttpError(w, "Invalid API key", http.StatusUnauthorized)
+		return
+	}
+	now := time.Now()
+	if key.ExpiresAt != nil && key.ExpiresAt.Before(now) {
+		HttpError(w, "API key expired", http.StatusUnauthorized)
+		return
+	}
+	user, err := s.db.Users.GetByID(r.Context(), key.UserID)
+	if err != nil {
+		slog.InfoContext(r.Context(), "API key owner not found", "id", key.UserID, "error", err.Error())
+		HttpError(w, "Invalid API key", http.StatusUnauthorized)
+		return
+	}
+	if err := s.db.APIKeys.Touch(r.Context(), key.ID, now); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to record API key use", "error", err, "prefix", key.Prefix)
+	}
+
+	ctx := auth.NewAPIKeyContext(auth.NewContext(r.Context(), user), key)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
 func (s *Server) GetLogin(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if key := apiKeyFromRequest(r); key != "" {
+			s.apiKeyLogin(next, w, r, key)
+			return
+		}
+
 		cookie, err := r.Cookie("token")
 		if err != nil || cookie.Value == "" {
 			next.ServeHTTP(w, r)
 			return
 		}
+		// a token that's unusable, or whose session has been revoked, is dropped and the request
+		// goes on anonymously, so that the browser holding it can still log in or out; routes that
+		// need a user refuse it as they would any anonymous request
 		claims, err := auth.ParseJWT(cookie.Value)
 		if err != nil {
-			slog.ErrorContext(r.Context(), "Couldn't parse JWT", "error", err, "cookie", cookie.Value)
-			HttpError(w, "Couldn't parse JWT", http.StatusUnauthorized)
+			slog.InfoContext(r.Context(), "Couldn't parse JWT", "error", err)
+			expireCookie(w, "token")
+			next.ServeHTTP(w, r)
 			return
 		}
 
 		userIdStr := claims.StandardClaims.Id
 		userId, err := strconv.Atoi(userIdStr)
 		if err != nil {
-			slog.ErrorContext(r.Context(), "Bad User ID in JWT", "error", err, "id", userIdStr)
-			HttpError(w, "Bad User ID in JWT", http.StatusBadRequest)
+			slog.InfoContext(r.Context(), "Bad User ID in JWT", "error", err, "id", userIdStr)
+			expireCookie(w, "token")
+			next.ServeHTTP(w, r)
 			return
 		}
+		// the token is only good while its session hasn't been revoked
+		session, err := s.db.Sessions.GetByID(r.Context(), claims.SessionID)
+		if err != nil || session.UserID != uint(userId) {
+			slog.InfoContext(r.Context(), "Session revoked", "id", userId, "session_id", claims.SessionID)
+			expireCookie(w, "token")
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		user, err := s.db.Users.GetByID(r.Context(), uint(userId))
 		if err == nil {
-			r = r.WithContext(auth.NewContext(r.Context(), user))
+			r = r.WithContext(auth.NewSessionContext(auth.NewContext(r.Context(), user), session))
 		} else {
 			// this is ok - the user may have been deleted
 			slog.InfoContext(r.Context(), "User not found", "id", userId, "error", err.Error())
@@ -138,9 +285,25 @@ func (s *Server) GetLogin(next http.Handler) http.Handler {
 }
 
 func (s *Server) GetHandler() http.Handler {
-	return Cors(s.GetLogin(s.mux))
+	return Cors(withRequestID(s.GetLogin(s.mux)))
 }
 
+// Serve listens on port until ctx is cancelled, then shuts down gracefully.
 func (s *Server) Serve(ctx context.Context, port int) error {
-	return http.ListenAndServe(fmt.Sprintf(":%d", port), s.GetHandler())
+	srv := &http.Server{
+		Addr:    fmt.Sprintf(":%d", port),
+		Handler: s.GetHandler(),
+	}
+
+	go func() {
+		<-ctx.Done()
+		if err := srv.Shutdown(context.Background()); err != nil {
+			slog.ErrorContext(ctx, "Failed to shut down server", "error", err)
+		}
+	}()
+
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
 }
@@ -0,0 +1,272 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/humper/tor_exit_nodes/models"
+	"github.com/humper/tor_exit_nodes/pkg/auth"
+	"github.com/humper/tor_exit_nodes/pkg/database"
+)
+
+const refreshCookie = "refresh_token"
+
+func (s *Server) AddSessionRoutes(ctx context.Context, mux *http.ServeMux) {
+	mux.HandleFunc("POST /token/refresh", func(w http.ResponseWriter, r *http.Request) {
+		s.HandleRefresh(ctx, w, r)
+	})
+	mux.HandleFunc("GET /users/{id}/sessions", s.requireSelfOr(auth.PermissionUsersRead, func(w http.ResponseWriter, r *http.Request) {
+		s.HandleGetSessions(ctx, w, r)
+	}))
+	mux.HandleFunc("DELETE /users/{id}/sessions", s.requireSelfOr(auth.PermissionUsersWrite, func(w http.ResponseWriter, r *http.Request) {
+		s.HandleDeleteSessions(ctx, w, r)
+	}))
+	mux.HandleFunc("DELETE /users/{id}/sessions/{sessionId}", s.requireSelfOr(auth.PermissionUsersWrite, func(w http.ResponseWriter, r *http.Request) {
+		s.HandleDeleteSession(ctx, w, r)
+	}))
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// startSession logs user in: it records a new session and sets the access and refresh token cookies.
+func (s *Server) startSession(ctx context.Context, w http.ResponseWriter, r *http.Request, user *models.User) error {
+	refreshToken, hash, err := auth.GenerateRefreshToken()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	session := &models.Session{
+		UserID:      user.ID,
+		RefreshHash: hash,
+		ExpiresAt:   now.Add(auth.RefreshTokenTTL),
+		LastUsedAt:  now,
+		IP:          clientIP(r),
+		UserAgent:   r.UserAgent(),
+	}
+	if err := s.db.Sessions.Create(ctx, session); err != nil {
+		return err
+	}
+	return setSessionCookies(w, user, session, refreshToken)
+}
+
+// setSessionCookies sets the access token cookie GetLogin reads, and the refresh token cookie
+// HandleRefresh reads.
+func setSessionCookies(w http.ResponseWriter, user *models.User, session *models.Session, refreshToken string) error {
+	signedToken, expirationTime, err := auth.CreateJWT(user, session.ID)
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     "token",
+		Value:    signedToken,
+		Expires:  expirationTime,
+		Domain:   "localhost",
+		Path:     "/",
+		HttpOnly: true,
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     refreshCookie,
+		Value:    refreshToken,
+		Expires:  session.ExpiresAt,
+		Domain:   "localhost",
+		Path:     "/",
+		HttpOnly: true,
+	})
+	return nil
+}
+
+func clearSessionCookies(w http.ResponseWriter) {
+	for _, name := range []string{"token", refreshCookie} {
+		expireCookie(w, name)
+	}
+}
+
+// expireCookie tells the browser to drop one of the cookies setSessionCookies sets.  The domain
+// and path have to match for it to replace them.
+func expireCookie(w http.ResponseWriter, name string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    "",
+		Domain:   "localhost",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+}
+
+// currentSession returns the session r was made in if it's one of userID's, so that it can be kept
+// when their other sessions are revoked, or 0.
+func currentSession(r *http.Request, userID uint) uint {
+	if session := auth.GetSession(r.Context()); session != nil && session.UserID == userID {
+		return session.ID
+	}
+	return 0
+}
+
+// revokeSessions logs a user out everywhere, except for the session keep (0 to keep none).
+func (s *Server) revokeSessions(ctx context.Context, userID uint, keep uint, reason string) {
+	if keep == 0 {
+		if err := s.db.Sessions.DeleteByUser(ctx, userID); err != nil {
+			slog.ErrorContext(ctx, "Failed to revoke sessions", "error", err, "user_id", userID, "reason", reason)
+		}
+		return
+	}
+
+	sessions, err := s.db.Sessions.GetByUser(ctx, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to revoke sessions", "error", err, "user_id", userID, "reason", reason)
+		return
+	}
+	for _, session := range sessions {
+		if session.ID == keep {
+			continue
+		}
+		if err := s.db.Sessions.Delete(ctx, session.ID); err != nil {
+			slog.ErrorContext(ctx, "Failed to revoke session", "error", err, "session_id", session.ID, "reason", reason)
+		}
+	}
+}
+
+// HandleRefresh exchanges a refresh token for a new access token.  The refresh token is replaced
+// each time, so a stolen one stops working as soon as either party uses it, and presenting the
+// replaced one again revokes the session.
+func (s *Server) HandleRefresh(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie(refreshCookie)
+	if err != nil || cookie.Value == "" {
+		HttpError(w, "Missing refresh token", http.StatusUnauthorized)
+		return
+	}
+
+	oldHash := auth.HashToken(cookie.Value)
+	session, err := s.db.Sessions.GetByRefreshHash(ctx, oldHash)
+	if err != nil {
+		if reused, err := s.db.Sessions.GetByPreviousRefreshHash(ctx, oldHash); err == nil {
+			s.revokeReused(ctx, reused)
+		}
+		HttpError(w, "Invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+
+	now := time.Now()
+	if session.ExpiresAt.Before(now) {
+		s.db.Sessions.Delete(ctx, session.ID)
+		HttpError(w, "Session expired", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := s.db.Users.GetByID(ctx, session.UserID)
+	if err != nil {
+		s.db.Sessions.Delete(ctx, session.ID)
+		HttpError(w, "Invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+
+	refreshToken, hash, err := auth.GenerateRefreshToken()
+	if err != nil {
+		HttpError(w, "Failed to refresh session", http.StatusInternalServerError)
+		return
+	}
+	session.RefreshHash = hash
+	session.LastUsedAt = now
+	session.ExpiresAt = now.Add(auth.RefreshTokenTTL)
+	session.IP = clientIP(r)
+	session.UserAgent = r.UserAgent()
+	if err := s.db.Sessions.Rotate(ctx, session, oldHash); err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			// another request exchanged the same token first
+			s.revokeReused(ctx, session)
+			HttpError(w, "Invalid refresh token", http.StatusUnauthorized)
+			return
+		}
+		HttpError(w, "Failed to refresh session", http.StatusInternalServerError)
+		return
+	}
+
+	if err := setSessionCookies(w, user, session, refreshToken); err != nil {
+		HttpError(w, "Failed to create JWT", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newUserResponse(user))
+}
+
+// revokeReused revokes a session whose refresh token was used after it had been replaced, since
+// whoever used it second may have stolen it.
+func (s *Server) revokeReused(ctx context.Context, session *models.Session) {
+	slog.WarnContext(ctx, "Replaced refresh token reused, revoking session", "session_id", session.ID, "user_id", session.UserID)
+	if err := s.db.Sessions.Delete(ctx, session.ID); err != nil && !errors.Is(err, database.ErrNotFound) {
+		slog.ErrorContext(ctx, "Failed to revoke session", "error", err, "session_id", session.ID)
+	}
+}
+
+func (s *Server) HandleGetSessions(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		HttpError(w, "Invalid user id", http.StatusBadRequest)
+		return
+	}
+
+	sessions, err := s.db.Sessions.GetByUser(ctx, uint(id))
+	if err != nil {
+		HttpError(w, "Failed to get sessions", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sessions)
+}
+
+func (s *Server) HandleDeleteSessions(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		HttpError(w, "Invalid user id", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.db.Sessions.DeleteByUser(ctx, uint(id)); err != nil {
+		HttpError(w, "Failed to revoke sessions", http.StatusInternalServerError)
+		return
+	}
+	s.audit(ctx, r, &models.AuditEvent{Action: models.AuditSessionsRevoke, TargetType: "user", TargetID: uint(id), OrganizationID: s.userOrg(ctx, r, uint(id))}, nil, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) HandleDeleteSession(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		HttpError(w, "Invalid user id", http.StatusBadRequest)
+		return
+	}
+	sessionID, err := strconv.Atoi(r.PathValue("sessionId"))
+	if err != nil {
+		HttpError(w, "Invalid session id", http.StatusBadRequest)
+		return
+	}
+
+	session, err := s.db.Sessions.GetByID(ctx, uint(sessionID))
+	if err != nil || session.UserID != uint(id) {
+		HttpError(w, "Unknown session", http.StatusNotFound)
+		return
+	}
+	if err := s.db.Sessions.Delete(ctx, session.ID); err != nil {
+		HttpError(w, "Failed to revoke session", http.StatusInternalServerError)
+		return
+	}
+	s.audit(ctx, r, &models.AuditEvent{Action: models.AuditSessionsRevoke, TargetType: "session", TargetID: session.ID, OrganizationID: s.userOrg(ctx, r, session.UserID)}, session, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,202 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/humper/tor_exit_nodes/models"
+	"github.com/humper/tor_exit_nodes/pkg/auth"
+	"github.com/humper/tor_exit_nodes/pkg/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// login returns the access and refresh token cookies from a password login.
+func login(t *testing.T, s *testServer) (*http.Cookie, *http.Cookie) {
+	body := fmt.Sprintf(`{"email": "test@test.com", "password": %q}`, passwords["test@test.com"])
+	req, err := http.NewRequest("POST", "/login", bytes.NewBufferString(body))
+	require.NoError(t, err)
+	recorder := httptest.NewRecorder()
+	s.GetHandler().ServeHTTP(recorder, req)
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	cookies := recorder.Result().Cookies()
+	return cookieNamed(cookies, "token"), cookieNamed(cookies, "refresh_token")
+}
+
+func serveWithCookies(s *testServer, method, path string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, path, nil)
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	recorder := httptest.NewRecorder()
+	s.GetHandler().ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestRefreshRotatesToken(t *testing.T) {
+	s := newTestServer(t, &server.NewServerParams{})
+	user := s.user
+	self := fmt.Sprintf("/users/%d", user.ID)
+
+	access, refresh := login(t, s)
+	claims, err := auth.ParseJWT(access.Value)
+	require.NoError(t, err)
+	assert.NotZero(t, claims.SessionID)
+	assert.Equal(t, http.StatusOK, serveWithCookies(s, "GET", self, access).Code)
+
+	recorder := serveWithCookies(s, "POST", "/token/refresh", refresh)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	newAccess := cookieNamed(recorder.Result().Cookies(), "token")
+	newRefresh := cookieNamed(recorder.Result().Cookies(), "refresh_token")
+	require.NotNil(t, newAccess)
+	require.NotNil(t, newRefresh)
+	assert.NotEqual(t, refresh.Value, newRefresh.Value)
+
+	newClaims, err := auth.ParseJWT(newAccess.Value)
+	require.NoError(t, err)
+	assert.Equal(t, claims.SessionID, newClaims.SessionID, "refreshing keeps the session")
+	assert.Equal(t, http.StatusOK, serveWithCookies(s, "GET", self, newAccess).Code)
+
+	// the old refresh token was used up
+	assert.Equal(t, http.StatusUnauthorized, serveWithCookies(s, "POST", "/token/refresh").Code)
+	assert.Equal(t, http.StatusUnauthorized, serveWithCookies(s, "POST", "/token/refresh", refresh).Code)
+
+	// and using it again revoked the session, in case it had been stolen
+	assert.Equal(t, http.StatusUnauthorized, serveWithCookies(s, "GET", self, newAccess).Code)
+	assert.Equal(t, http.StatusUnauthorized, serveWithCookies(s, "POST", "/token/refresh", newRefresh).Code)
+}
+
+func TestConcurrentRefreshes(t *testing.T) {
+	s := newTestServer(t, &server.NewServerParams{})
+	_, refresh := login(t, s)
+
+	codes := make(chan int, 10)
+	var wg sync.WaitGroup
+	for i := 0; i < cap(codes); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- serveWithCookies(s, "POST", "/token/refresh", refresh).Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	ok := 0
+	for code := range codes {
+		if code == http.StatusOK {
+			ok++
+		} else {
+			assert.Equal(t, http.StatusUnauthorized, code)
+		}
+	}
+	assert.Equal(t, 1, ok, "a refresh token is only exchanged once")
+
+	sessions, err := s.db.Sessions.GetByUser(context.Background(), s.user.ID)
+	require.NoError(t, err)
+	assert.Empty(t, sessions, "the session is revoked once its token is reused")
+}
+
+func TestLogoutRevokesSession(t *testing.T) {
+	s := newTestServer(t, &server.NewServerParams{})
+	user := s.user
+	self := fmt.Sprintf("/users/%d", user.ID)
+
+	access, refresh := login(t, s)
+	require.Equal(t, http.StatusNoContent, serveWithCookies(s, "POST", "/logout", access, refresh).Code)
+
+	assert.Equal(t, http.StatusUnauthorized, serveWithCookies(s, "GET", self, access).Code)
+	assert.Equal(t, http.StatusUnauthorized, serveWithCookies(s, "POST", "/token/refresh", refresh).Code)
+}
+
+func TestLoginWithRevokedToken(t *testing.T) {
+	s := newTestServer(t, &server.NewServerParams{})
+	user := s.user
+	self := fmt.Sprintf("/users/%d", user.ID)
+
+	access, refresh := login(t, s)
+	require.Equal(t, http.StatusNoContent, serveWithCookies(s, "POST", "/logout", access, refresh).Code)
+
+	// the browser still sends the revoked token; it's told to drop it rather than refused
+	recorder := serveWithCookies(s, "GET", self, access)
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+	expired := cookieNamed(recorder.Result().Cookies(), "token")
+	require.NotNil(t, expired)
+	assert.Empty(t, expired.Value)
+	assert.Less(t, expired.MaxAge, 0)
+
+	body := fmt.Sprintf(`{"email": "test@test.com", "password": %q}`, passwords["test@test.com"])
+	req, err := http.NewRequest("POST", "/login", bytes.NewBufferString(body))
+	require.NoError(t, err)
+	req.AddCookie(access)
+	recorder = httptest.NewRecorder()
+	s.GetHandler().ServeHTTP(recorder, req)
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	// the new token is set after the old one is expired, so the browser keeps it
+	cookies := recorder.Result().Cookies()
+	renewed := cookies[len(cookies)-2]
+	require.Equal(t, "token", renewed.Name)
+	assert.NotEmpty(t, renewed.Value)
+	assert.Equal(t, http.StatusOK, serveWithCookies(s, "GET", self, renewed).Code)
+
+	assert.Equal(t, http.StatusNoContent, serveWithCookies(s, "POST", "/logout", access).Code)
+}
+
+func TestSessionTokenWithoutSession(t *testing.T) {
+	s := newTestServer(t, &server.NewServerParams{})
+	user := s.user
+
+	token, _, err := auth.CreateJWT(user, 0)
+	require.NoError(t, err)
+	recorder := serveWithCookies(s, "GET", fmt.Sprintf("/users/%d", user.ID), &http.Cookie{Name: "token", Value: token})
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+}
+
+func TestAdminManagesSessions(t *testing.T) {
+	s := newTestServer(t, &server.NewServerParams{})
+	user, admin := s.user, s.admin
+
+	access, _ := login(t, s)
+	self := fmt.Sprintf("/users/%d", user.ID)
+
+	recorder := serve(s, "GET", self+"/sessions", "", admin)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	assert.NotContains(t, recorder.Body.String(), "refresh")
+	var sessions []*models.Session
+	require.NoError(t, json.NewDecoder(recorder.Body).Decode(&sessions))
+	claims, err := auth.ParseJWT(access.Value)
+	require.NoError(t, err)
+	var session *models.Session
+	for _, candidate := range sessions {
+		if candidate.ID == claims.SessionID {
+			session = candidate
+		}
+	}
+	require.NotNil(t, session, "the login's session should be listed")
+	assert.Equal(t, user.ID, session.UserID)
+
+	// users can't see each other's sessions
+	assert.Equal(t, http.StatusForbidden, serve(s, "GET", fmt.Sprintf("/users/%d/sessions", admin.ID), "", user).Code)
+
+	require.Equal(t, http.StatusNoContent, serve(s, "DELETE", fmt.Sprintf("%s/sessions/%d", self, session.ID), "", admin).Code)
+	assert.Equal(t, http.StatusUnauthorized, serveWithCookies(s, "GET", self, access).Code)
+
+	// role changes and deletion end every session
+	access, _ = login(t, s)
+	// updates replace the whole user, so the password hash has to be sent back
+	body := fmt.Sprintf(`{"Email": "test@test.com", "Role": "admin", "Password": %q}`, user.Password)
+	require.Equal(t, http.StatusOK, serve(s, "PUT", self, body, admin).Code)
+	assert.Equal(t, http.StatusUnauthorized, serveWithCookies(s, "GET", self, access).Code)
+
+	access, _ = login(t, s)
+	require.Equal(t, http.StatusOK, serve(s, "DELETE", self, "", admin).Code)
+	assert.Equal(t, http.StatusUnauthorized, serveWithCookies(s, "GET", self, access).Code)
+}
@@ -3,15 +3,26 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"slices"
 
+	"github.com/humper/tor_exit_nodes/models"
 	"github.com/humper/tor_exit_nodes/pkg/auth"
+	"github.com/humper/tor_exit_nodes/pkg/export"
 )
 
 func (s *Server) AddTorRoutes(ctx context.Context, mux *http.ServeMux) {
-	mux.HandleFunc("GET /tor", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("GET /tor", s.require(auth.PermissionNodesRead, func(w http.ResponseWriter, r *http.Request) {
 		s.HandleGetTorExitNodes(ctx, w, r)
-	})
+	}))
+	mux.HandleFunc("GET /tor/export", s.require(auth.PermissionNodesRead, func(w http.ResponseWriter, r *http.Request) {
+		s.HandleExportTorExitNodes(ctx, w, r)
+	}))
+	mux.HandleFunc("POST /tor/update", s.require(auth.PermissionAdminUpdates, func(w http.ResponseWriter, r *http.Request) {
+		s.HandleTriggerUpdate(ctx, w, r)
+	}))
 }
 
 func (s *Server) HandleGetTorExitNodes(ctx context.Context, w http.ResponseWriter, r *http.Request) {
@@ -35,3 +46,107 @@ func (s *Server) HandleGetTorExitNodes(ctx context.Context, w http.ResponseWrite
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(pagination)
 }
+
+func (s *Server) HandleExportTorExitNodes(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	formatName := r.URL.Query().Get("format")
+	if formatName == "" {
+		formatName = "csv"
+	}
+	format, err := export.Lookup(formatName)
+	if err != nil {
+		HttpError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	columns, err := export.ParseColumns(r.URL.Query().Get("columns"))
+	if err != nil {
+		HttpError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	opts := &export.Options{
+		Columns: columns,
+		SetName: r.URL.Query().Get("set_name"),
+		Action:  r.URL.Query().Get("action"),
+		Family:  r.URL.Query().Get("family"),
+	}
+	if err := opts.Validate(); err != nil {
+		HttpError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	filter, err := getFilter(w, r)
+	if err != nil {
+		return
+	}
+	for column := range filter {
+		if !slices.Contains(models.TorExitNodeFilterColumns, column) {
+			HttpError(w, "Invalid filter", http.StatusBadRequest)
+			return
+		}
+	}
+
+	user := auth.GetUser(r.Context())
+	allowed_ips := []string{}
+	if user != nil {
+		allowed_ips = user.AllowedIPs
+	}
+
+	enc := format.New(w, opts)
+
+	// Headers are only sent once the first row arrives so that a failing query can still produce an error response.
+	started := false
+	begin := func() error {
+		started = true
+		w.Header().Set("Content-Type", format.ContentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="tor_exit_nodes.%s"`, format.Extension))
+		return enc.Begin()
+	}
+
+	// the request's context, so a client that goes away stops the query
+	err = s.db.TorExitNodes.Iterate(r.Context(), allowed_ips, filter, func(node *models.TorExitNode) error {
+		if !started {
+			if err := begin(); err != nil {
+				return err
+			}
+		}
+		return enc.Encode(node)
+	})
+	if err == nil && !started {
+		err = begin()
+	}
+	if err == nil {
+		err = enc.End()
+	}
+
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to export tor exit nodes", "error", err, "format", format.Name)
+		if !started {
+			HttpError(w, "Failed to export tor exit nodes", http.StatusInternalServerError)
+		}
+	}
+}
+
+// HandleTriggerUpdate starts an update cycle now rather than waiting for the next scheduled one.
+// Only the leader runs updates, and only one at a time, so other replicas and requests made during
+// an update get 409.
+func (s *Server) HandleTriggerUpdate(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if s.torUpdater == nil {
+		HttpError(w, "Updates are not enabled on this server", http.StatusServiceUnavailable)
+		return
+	}
+	if !s.leader.Load() {
+		HttpError(w, "Updates run on the leader replica, try again there", http.StatusConflict)
+		return
+	}
+	if !s.torUpdater.TryUpdateTorExitNodes(ctx) {
+		HttpError(w, "An update is already in progress", http.StatusConflict)
+		return
+	}
+
+	if user := auth.GetUser(r.Context()); user != nil {
+		slog.InfoContext(ctx, "Update triggered", "user", user.Email)
+	}
+	s.audit(ctx, r, &models.AuditEvent{Action: models.AuditNodesUpdate, TargetType: "nodes", OrganizationID: callerOrg(r)}, nil, nil)
+	w.WriteHeader(http.StatusAccepted)
+}
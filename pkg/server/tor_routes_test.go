@@ -5,13 +5,18 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	"github.com/humper/tor_exit_nodes/models"
 	"github.com/humper/tor_exit_nodes/pkg/database"
+	"github.com/humper/tor_exit_nodes/pkg/database/memory"
 	mock_database "github.com/humper/tor_exit_nodes/pkg/database/mock"
 	"github.com/humper/tor_exit_nodes/pkg/server"
+	"github.com/humper/tor_exit_nodes/pkg/tor"
 	"github.com/humper/tor_exit_nodes/testing/fixtures"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -38,6 +43,7 @@ func TestGetAllTorExitNodesHappy(t *testing.T) {
 	db := &database.Database{
 		Users:        users,
 		TorExitNodes: torExitNodes,
+		Sessions:     newSessions(),
 	}
 
 	s := server.New(context.Background(), &server.NewServerParams{
@@ -103,6 +109,7 @@ func TestGetAllTorExitNodesHappyWithUser(t *testing.T) {
 	db := &database.Database{
 		Users:        users,
 		TorExitNodes: torExitNodes,
+		Sessions:     newSessions(),
 	}
 
 	s := server.New(context.Background(), &server.NewServerParams{
@@ -113,7 +120,7 @@ func TestGetAllTorExitNodesHappyWithUser(t *testing.T) {
 	req, err := http.NewRequest("GET", "/tor", nil)
 	require.NoError(t, err)
 
-	addAuth(req, user)
+	addAuth(req, db.Sessions, user)
 
 	s.GetHandler().ServeHTTP(recorder, req)
 	require.Equal(t, http.StatusOK, recorder.Code)
@@ -140,6 +147,7 @@ func TestGetAllTorExitNodesBadPagination(t *testing.T) {
 	db := &database.Database{
 		Users:        users,
 		TorExitNodes: torExitNodes,
+		Sessions:     newSessions(),
 	}
 
 	s := server.New(context.Background(), &server.NewServerParams{
@@ -169,6 +177,7 @@ func TestGetAllTorNodesDatabaseFailure(t *testing.T) {
 	db := &database.Database{
 		Users:        users,
 		TorExitNodes: torExitNodes,
+		Sessions:     newSessions(),
 	}
 
 	s := server.New(context.Background(), &server.NewServerParams{
@@ -182,3 +191,128 @@ func TestGetAllTorNodesDatabaseFailure(t *testing.T) {
 	s.GetHandler().ServeHTTP(recorder, req)
 	require.Equal(t, http.StatusInternalServerError, recorder.Code)
 }
+
+func TestExportTorExitNodesCSV(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	users := mock_database.NewMockUsers(ctrl)
+	torExitNodes := mock_database.NewMockTorExitNodes(ctrl)
+
+	torExitNodes.EXPECT().Iterate(gomock.Any(), gomock.Eq([]string{}), gomock.Eq(map[string][]string{"country_code": {"AU"}}), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, excludedIPs []string, filter map[string][]string, fn func(*models.TorExitNode) error) error {
+			for _, node := range fixtures.TestRows[:3] {
+				if err := fn(node); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+
+	db := &database.Database{
+		Users:        users,
+		TorExitNodes: torExitNodes,
+		Sessions:     newSessions(),
+	}
+
+	s := server.New(context.Background(), &server.NewServerParams{
+		DB: db,
+	})
+
+	recorder := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", `/tor/export?format=csv&columns=ip&filter={"country_code":["AU"]}`, nil)
+	require.NoError(t, err)
+
+	s.GetHandler().ServeHTTP(recorder, req)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "text/csv", recorder.Header().Get("Content-Type"))
+	assert.Equal(t, `attachment; filename="tor_exit_nodes.csv"`, recorder.Header().Get("Content-Disposition"))
+	assert.Equal(t, "ip\n"+fixtures.TestRows[0].IP+"\n"+fixtures.TestRows[1].IP+"\n"+fixtures.TestRows[2].IP+"\n", recorder.Body.String())
+}
+
+func TestExportTorExitNodesBadFormat(t *testing.T) {
+	s := server.New(context.Background(), &server.NewServerParams{})
+
+	recorder := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "/tor/export?format=xml", nil)
+	require.NoError(t, err)
+
+	s.GetHandler().ServeHTTP(recorder, req)
+	require.Equal(t, http.StatusBadRequest, recorder.Code)
+}
+
+func TestExportTorExitNodesBadFilter(t *testing.T) {
+	s := server.New(context.Background(), &server.NewServerParams{})
+
+	for _, filter := range []string{`{"ip":["1.1.1.1"]}`, `{"1=1) OR (1":["1"]}`} {
+		recorder := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/tor/export?filter="+url.QueryEscape(filter), nil)
+		require.NoError(t, err)
+
+		s.GetHandler().ServeHTTP(recorder, req)
+		assert.Equal(t, http.StatusBadRequest, recorder.Code, filter)
+	}
+}
+
+func TestExportTorExitNodesDatabaseFailure(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	torExitNodes := mock_database.NewMockTorExitNodes(ctrl)
+	torExitNodes.EXPECT().Iterate(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+		Return(assert.AnError)
+
+	s := server.New(context.Background(), &server.NewServerParams{
+		DB: &database.Database{TorExitNodes: torExitNodes},
+	})
+
+	recorder := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "/tor/export?format=ndjson", nil)
+	require.NoError(t, err)
+
+	s.GetHandler().ServeHTTP(recorder, req)
+	require.Equal(t, http.StatusInternalServerError, recorder.Code)
+	assert.Empty(t, recorder.Header().Get("Content-Disposition"))
+}
+
+func TestExportTorExitNodesBadFirewallAction(t *testing.T) {
+	s := server.New(context.Background(), &server.NewServerParams{})
+
+	recorder := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "/tor/export?format=nftables&action=log", nil)
+	require.NoError(t, err)
+
+	s.GetHandler().ServeHTTP(recorder, req)
+	require.Equal(t, http.StatusBadRequest, recorder.Code)
+}
+
+func TestTriggerUpdateWhileUpdating(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db, err := memory.New(ctx)
+	require.NoError(t, err)
+
+	// the exit list source answers only once it's released, keeping updates in progress
+	release := make(chan struct{})
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Write([]byte("1.2.3.4\n"))
+	}))
+	defer source.Close()
+	var once sync.Once
+	defer once.Do(func() { close(release) })
+
+	updater := tor.NewTORUpdater(ctx, &tor.NewTorUpdaterParams{DB: db, SourceURLs: []string{source.URL}, Client: http.DefaultClient})
+	s := newTestServer(t, &server.NewServerParams{DB: db, TorUpdater: updater})
+	admin := s.admin
+
+	// the initial scheduled update is still running
+	require.Eventually(t, func() bool {
+		return serve(s, "POST", "/tor/update", "", admin).Code == http.StatusConflict
+	}, 5*time.Second, 10*time.Millisecond)
+
+	once.Do(func() { close(release) })
+	require.Eventually(t, func() bool {
+		return serve(s, "POST", "/tor/update", "", admin).Code == http.StatusAccepted
+	}, 5*time.Second, 10*time.Millisecond)
+}
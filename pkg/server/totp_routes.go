@@ -0,0 +1,312 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/humper/tor_exit_nodes/models"
+	"github.com/humper/tor_exit_nodes/pkg/auth"
+)
+
+const (
+	totpLoginPurpose = "login-totp"
+	// totpLoginTTL is how long a user has to enter their code after their password.
+	totpLoginTTL = 5 * time.Minute
+)
+
+// TOTPLoginResponse is returned by POST /login, instead of the user, when they have two-factor
+// authentication enabled.  Token is sent back to POST /login/totp with their code.
+type TOTPLoginResponse struct {
+	TOTPRequired bool   `json:"totp_required"`
+	Token        string `json:"totp_token"`
+}
+
+type TOTPLoginRequest struct {
+	Token string `json:"totp_token"`
+	// Code is the current code from the user's authenticator app, or one of their recovery codes.
+	Code string `json:"code"`
+}
+
+type TOTPCodeRequest struct {
+	Code string `json:"code"`
+}
+
+type TOTPEnrollResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+func (s *Server) AddTOTPRoutes(ctx context.Context, mux *http.ServeMux) {
+	mux.HandleFunc("POST /login/totp", func(w http.ResponseWriter, r *http.Request) {
+		s.HandleTOTPLogin(ctx, w, r)
+	})
+	mux.HandleFunc("POST /users/{id}/totp", s.requireSelf(func(w http.ResponseWriter, r *http.Request) {
+		s.HandleStartTOTP(ctx, w, r)
+	}))
+	mux.HandleFunc("POST /users/{id}/totp/verify", s.requireSelf(func(w http.ResponseWriter, r *http.Request) {
+		s.HandleEnableTOTP(ctx, w, r)
+	}))
+	mux.HandleFunc("POST /users/{id}/totp/recovery_codes", s.requireSelf(func(w http.ResponseWriter, r *http.Request) {
+		s.HandleRegenerateRecoveryCodes(ctx, w, r)
+	}))
+	mux.HandleFunc("DELETE /users/{id}/totp", s.requireSelfOr(auth.PermissionUsersWrite, func(w http.ResponseWriter, r *http.Request) {
+		s.HandleDisableTOTP(ctx, w, r)
+	}))
+}
+
+// startTOTPLogin answers a correct password from a user with two-factor authentication enabled.
+func (s *Server) startTOTPLogin(w http.ResponseWriter, user *models.User) {
+	token, err := auth.SignToken(&jwt.StandardClaims{
+		Audience:  totpLoginPurpose,
+		Subject:   fmt.Sprint(user.ID),
+		ExpiresAt: time.Now().Add(totpLoginTTL).Unix(),
+	})
+	if err != nil {
+		HttpError(w, "Failed to log in", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(TOTPLoginResponse{TOTPRequired: true, Token: token})
+}
+
+// checkTOTP reports whether code is the user's current TOTP code or one of their recovery codes,
+// using it up; the caller must save the user.
+func checkTOTP(user *models.User, code string) bool {
+	if step, ok := auth.ValidateTOTP(user.TOTPSecret, code, time.Now(), user.TOTPLastStep); ok {
+		user.TOTPLastStep = step
+		return true
+	}
+	if i := auth.MatchRecoveryCode(code, user.RecoveryCodes); i >= 0 {
+		user.RecoveryCodes = slices.Delete(user.RecoveryCodes, i, i+1)
+		return true
+	}
+	return false
+}
+
+// HandleTOTPLogin finishes a login started with a password by checking the user's code.
+func (s *Server) HandleTOTPLogin(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	var req TOTPLoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		HttpError(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	var claims jwt.StandardClaims
+	if err := auth.ParseToken(req.Token, totpLoginPurpose, &claims); err != nil {
+		HttpError(w, "Invalid or expired login", http.StatusUnauthorized)
+		return
+	}
+	id, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		HttpError(w, "Invalid or expired login", http.StatusUnauthorized)
+		return
+	}
+	user, err := s.db.Users.GetByID(ctx, uint(id))
+	if err != nil || !user.TOTPEnabled {
+		HttpError(w, "Invalid or expired login", http.StatusUnauthorized)
+		return
+	}
+
+	// wrong codes count towards lockouts like wrong passwords, so codes can't be guessed either
+	locked, err := s.lockedOut(ctx, user.Email, clientIP(r))
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to check lockout", "error", err)
+		HttpError(w, "Failed to log in", http.StatusInternalServerError)
+		return
+	}
+	if locked {
+		s.auditLogin(ctx, r, user.Email, user, models.LoginLocked)
+		HttpError(w, "Too many failed logins, try again later", http.StatusTooManyRequests)
+		return
+	}
+
+	if !checkTOTP(user, req.Code) {
+		s.auditLogin(ctx, r, user.Email, user, models.LoginInvalidCode)
+		HttpError(w, "Invalid code", http.StatusUnauthorized)
+		return
+	}
+	if err := s.db.Users.Update(ctx, user); err != nil {
+		HttpError(w, "Failed to log in", http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.startSession(ctx, w, r, user); err != nil {
+		HttpError(w, "Failed to create session", http.StatusInternalServerError)
+		return
+	}
+	s.auditLogin(ctx, r, user.Email, user, "")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newUserResponse(user))
+}
+
+// totpUser loads the user in the path for managing their two-factor authentication.
+func (s *Server) totpUser(ctx context.Context, w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	if auth.GetAPIKey(r.Context()) != nil {
+		HttpError(w, "API keys can't manage two-factor authentication", http.StatusForbidden)
+		return nil, false
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		HttpError(w, "Invalid user id", http.StatusBadRequest)
+		return nil, false
+	}
+	user, err := s.db.Users.GetByID(ctx, uint(id))
+	if err != nil {
+		HttpError(w, "Unknown user", http.StatusNotFound)
+		return nil, false
+	}
+	return user, true
+}
+
+// HandleStartTOTP generates a new secret for the user to add to their authenticator app.  It isn't
+// used for logging in until HandleEnableTOTP confirms it.
+func (s *Server) HandleStartTOTP(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	user, ok := s.totpUser(ctx, w, r)
+	if !ok {
+		return
+	}
+	if user.TOTPEnabled {
+		HttpError(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		HttpError(w, "Failed to generate secret", http.StatusInternalServerError)
+		return
+	}
+	user.TOTPSecret = secret
+	if err := s.db.Users.Update(ctx, user); err != nil {
+		HttpError(w, "Failed to update user", http.StatusInternalServerError)
+		return
+	}
+	s.auditUser(ctx, r, models.AuditTOTPSetup, user, nil, nil)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(TOTPEnrollResponse{Secret: secret, URI: auth.TOTPURI(secret, user.Email)})
+}
+
+// HandleEnableTOTP turns on two-factor authentication once the user proves their app has the secret,
+// and returns their recovery codes.
+func (s *Server) HandleEnableTOTP(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	user, ok := s.totpUser(ctx, w, r)
+	if !ok {
+		return
+	}
+	var req TOTPCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		HttpError(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if user.TOTPEnabled {
+		HttpError(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+	if user.TOTPSecret == "" {
+		HttpError(w, "Two-factor authentication hasn't been started", http.StatusBadRequest)
+		return
+	}
+	step, ok := auth.ValidateTOTP(user.TOTPSecret, req.Code, time.Now(), 0)
+	if !ok {
+		HttpError(w, "Invalid code", http.StatusBadRequest)
+		return
+	}
+
+	codes, hashes, err := auth.GenerateRecoveryCodes()
+	if err != nil {
+		HttpError(w, "Failed to generate recovery codes", http.StatusInternalServerError)
+		return
+	}
+	user.TOTPEnabled = true
+	user.TOTPLastStep = step
+	user.RecoveryCodes = hashes
+	if err := s.db.Users.Update(ctx, user); err != nil {
+		HttpError(w, "Failed to update user", http.StatusInternalServerError)
+		return
+	}
+	s.auditUser(ctx, r, models.AuditTOTPEnable, user, nil, nil)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// HandleRegenerateRecoveryCodes replaces the user's recovery codes, given a current code.
+func (s *Server) HandleRegenerateRecoveryCodes(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	user, ok := s.totpUser(ctx, w, r)
+	if !ok {
+		return
+	}
+	var req TOTPCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		HttpError(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if !user.TOTPEnabled {
+		HttpError(w, "Two-factor authentication isn't enabled", http.StatusBadRequest)
+		return
+	}
+	if !checkTOTP(user, req.Code) {
+		HttpError(w, "Invalid code", http.StatusForbidden)
+		return
+	}
+
+	codes, hashes, err := auth.GenerateRecoveryCodes()
+	if err != nil {
+		HttpError(w, "Failed to generate recovery codes", http.StatusInternalServerError)
+		return
+	}
+	user.RecoveryCodes = hashes
+	if err := s.db.Users.Update(ctx, user); err != nil {
+		HttpError(w, "Failed to update user", http.StatusInternalServerError)
+		return
+	}
+	s.auditUser(ctx, r, models.AuditTOTPRecoveryCodes, user, nil, nil)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// HandleDisableTOTP turns off two-factor authentication.  Users need a current code to turn off
+// their own; admins can reset anyone's, e.g. for a lost phone.
+func (s *Server) HandleDisableTOTP(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	user, ok := s.totpUser(ctx, w, r)
+	if !ok {
+		return
+	}
+	if isSelf(r) && user.TOTPEnabled {
+		var req TOTPCodeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			HttpError(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if !checkTOTP(user, req.Code) {
+			HttpError(w, "Invalid code", http.StatusForbidden)
+			return
+		}
+	}
+
+	user.TOTPSecret = ""
+	user.TOTPEnabled = false
+	user.TOTPLastStep = 0
+	user.RecoveryCodes = nil
+	if err := s.db.Users.Update(ctx, user); err != nil {
+		HttpError(w, "Failed to update user", http.StatusInternalServerError)
+		return
+	}
+	s.auditUser(ctx, r, models.AuditTOTPDisable, user, nil, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
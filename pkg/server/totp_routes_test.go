@@ -0,0 +1,142 @@
+package server_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/humper/tor_exit_nodes/models"
+	"github.com/humper/tor_exit_nodes/pkg/auth"
+	"github.com/humper/tor_exit_nodes/pkg/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// enrollTOTP turns on two-factor authentication for user, returning their secret and recovery codes.
+// The code used to enable it is for the current time step, so the next one to be accepted must be
+// for a later step.
+func enrollTOTP(t *testing.T, s *testServer, user *models.User) (string, []string) {
+	path := fmt.Sprintf("/users/%d/totp", user.ID)
+
+	recorder := serve(s, "POST", path, "", user)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	var enroll server.TOTPEnrollResponse
+	require.NoError(t, json.NewDecoder(recorder.Body).Decode(&enroll))
+	assert.Contains(t, enroll.URI, enroll.Secret)
+
+	assert.Equal(t, http.StatusBadRequest, serve(s, "POST", path+"/verify", `{"code": "000000"}`, user).Code)
+
+	code, err := auth.TOTPCode(enroll.Secret, time.Now())
+	require.NoError(t, err)
+	recorder = serve(s, "POST", path+"/verify", fmt.Sprintf(`{"code": %q}`, code), user)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	var recovery server.RecoveryCodesResponse
+	require.NoError(t, json.NewDecoder(recorder.Body).Decode(&recovery))
+	require.Len(t, recovery.RecoveryCodes, auth.RecoveryCodeCount)
+
+	return enroll.Secret, recovery.RecoveryCodes
+}
+
+// startTOTPLogin logs in with a password, expecting to be asked for a code.
+func startTOTPLogin(t *testing.T, s *testServer, user *models.User) string {
+	recorder := tryLogin(s, "10.0.0.1", user.Email, passwords[user.Email])
+	require.Equal(t, http.StatusAccepted, recorder.Code)
+	assert.Empty(t, recorder.Result().Cookies(), "no session until the code is checked")
+	var pending server.TOTPLoginResponse
+	require.NoError(t, json.NewDecoder(recorder.Body).Decode(&pending))
+	assert.True(t, pending.TOTPRequired)
+	require.NotEmpty(t, pending.Token)
+	return pending.Token
+}
+
+func finishTOTPLogin(s *testServer, token, code string) int {
+	return serve(s, "POST", "/login/totp", fmt.Sprintf(`{"totp_token": %q, "code": %q}`, token, code), nil).Code
+}
+
+func TestTOTPLogin(t *testing.T) {
+	s := newTestServer(t, &server.NewServerParams{})
+	db, user := s.db, s.user
+	secret, recoveryCodes := enrollTOTP(t, s, user)
+
+	token := startTOTPLogin(t, s, user)
+	assert.Equal(t, http.StatusUnauthorized, finishTOTPLogin(s, token, "000000"))
+	assert.Equal(t, http.StatusUnauthorized, finishTOTPLogin(s, "forged", "000000"))
+
+	next, err := auth.TOTPCode(secret, time.Now().Add(30*time.Second))
+	require.NoError(t, err)
+	recorder := serve(s, "POST", "/login/totp", fmt.Sprintf(`{"totp_token": %q, "code": %q}`, token, next), nil)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	assert.NotNil(t, cookieNamed(recorder.Result().Cookies(), "token"))
+	assert.Equal(t, http.StatusUnauthorized, finishTOTPLogin(s, token, next), "codes can't be reused")
+
+	// each recovery code works once
+	assert.Equal(t, http.StatusOK, finishTOTPLogin(s, startTOTPLogin(t, s, user), recoveryCodes[0]))
+	assert.Equal(t, http.StatusUnauthorized, finishTOTPLogin(s, startTOTPLogin(t, s, user), recoveryCodes[0]))
+
+	stored, err := db.Users.GetByID(context.Background(), user.ID)
+	require.NoError(t, err)
+	assert.Len(t, stored.RecoveryCodes, auth.RecoveryCodeCount-1)
+
+	// updating the user doesn't turn it off
+	require.Equal(t, http.StatusOK, serve(s, "PUT", fmt.Sprintf("/users/%d", user.ID), fmt.Sprintf(`{"Email": %q, "Password": %q, "Name": "Renamed"}`, stored.Email, stored.Password), user).Code)
+	stored, err = db.Users.GetByID(context.Background(), user.ID)
+	require.NoError(t, err)
+	assert.True(t, stored.TOTPEnabled)
+	assert.Equal(t, secret, stored.TOTPSecret)
+}
+
+func TestTOTPDisable(t *testing.T) {
+	s := newTestServer(t, &server.NewServerParams{})
+	db, user, admin := s.db, s.user, s.admin
+	path := fmt.Sprintf("/users/%d/totp", user.ID)
+
+	_, recoveryCodes := enrollTOTP(t, s, user)
+	assert.Equal(t, http.StatusConflict, serve(s, "POST", path, "", user).Code)
+	assert.Equal(t, http.StatusForbidden, serve(s, "POST", path, "", admin).Code, "only users can enroll themselves")
+
+	assert.Equal(t, http.StatusForbidden, serve(s, "DELETE", path, `{"code": "000000"}`, user).Code)
+	require.Equal(t, http.StatusNoContent, serve(s, "DELETE", path, fmt.Sprintf(`{"code": %q}`, recoveryCodes[0]), user).Code)
+	assert.Equal(t, http.StatusOK, tryLogin(s, "10.0.0.1", user.Email, passwords[user.Email]).Code)
+
+	// admins can reset it without a code
+	enrollTOTP(t, s, user)
+	require.Equal(t, http.StatusNoContent, serve(s, "DELETE", path, "", admin).Code)
+	stored, err := db.Users.GetByID(context.Background(), user.ID)
+	require.NoError(t, err)
+	assert.False(t, stored.TOTPEnabled)
+	assert.Empty(t, stored.TOTPSecret)
+	assert.Empty(t, stored.RecoveryCodes)
+}
+
+func TestTOTPRegenerateRecoveryCodes(t *testing.T) {
+	s := newTestServer(t, &server.NewServerParams{})
+	user := s.user
+	path := fmt.Sprintf("/users/%d/totp/recovery_codes", user.ID)
+
+	_, oldCodes := enrollTOTP(t, s, user)
+	assert.Equal(t, http.StatusForbidden, serve(s, "POST", path, `{"code": "000000"}`, user).Code)
+	recorder := serve(s, "POST", path, fmt.Sprintf(`{"code": %q}`, oldCodes[0]), user)
+	require.Equal(t, http.StatusOK, recorder.Code)
+	var recovery server.RecoveryCodesResponse
+	require.NoError(t, json.NewDecoder(recorder.Body).Decode(&recovery))
+	require.Len(t, recovery.RecoveryCodes, auth.RecoveryCodeCount)
+
+	assert.Equal(t, http.StatusUnauthorized, finishTOTPLogin(s, startTOTPLogin(t, s, user), oldCodes[1]))
+	assert.Equal(t, http.StatusOK, finishTOTPLogin(s, startTOTPLogin(t, s, user), recovery.RecoveryCodes[1]))
+}
+
+func TestTOTPRequiredForRole(t *testing.T) {
+	s := newTestServer(t, &server.NewServerParams{RequireTOTP: []string{"admin"}})
+	user, admin := s.user, s.admin
+
+	recorder := serve(s, "GET", "/users", "", admin)
+	assert.Equal(t, http.StatusForbidden, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "Two-factor authentication required")
+	assert.Equal(t, http.StatusOK, serve(s, "GET", fmt.Sprintf("/users/%d", user.ID), "", user).Code, "other roles are unaffected")
+
+	enrollTOTP(t, s, admin)
+	assert.Equal(t, http.StatusOK, serve(s, "GET", "/users", "", admin).Code)
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/humper/tor_exit_nodes/models"
+	"github.com/humper/tor_exit_nodes/pkg/auth"
+)
+
+// CreateUserRequest registers a user.  Role and OrganizationID are only honoured for callers who can
+// manage users.
+type CreateUserRequest struct {
+	Name           string
+	Email          string
+	Password       string
+	Role           string
+	AllowedIPs     []string
+	OrganizationID uint
+}
+
+// SelfUpdateRequest changes the parts of an account its user may change themselves.  Fields that are
+// left out keep their values.
+type SelfUpdateRequest struct {
+	Name       *string
+	Email      *string
+	AllowedIPs *[]string
+	// CurrentPassword is needed for users to change their own email address, which would otherwise
+	// let a stolen session take the account over through a password reset.
+	CurrentPassword string `json:"current_password"`
+}
+
+// UpdateUserRequest changes a user as SelfUpdateRequest does, and can also move them to another role
+// or organization.
+type UpdateUserRequest struct {
+	SelfUpdateRequest
+	Role           *string
+	OrganizationID *uint
+}
+
+// ChangePasswordRequest sets a new password.  Users changing their own must give the current one.
+type ChangePasswordRequest struct {
+	OldPassword string `json:"old_password"`
+	NewPassword string `json:"new_password"`
+}
+
+// UserResponse is what users see of their own account; it never includes credentials.
+type UserResponse struct {
+	ID             uint
+	Name           string
+	Email          string
+	Role           string
+	AllowedIPs     []string
+	EmailVerified  bool
+	TOTPEnabled    bool
+	OrganizationID uint
+}
+
+// AdminUserResponse is what callers who can read users see of them.
+type AdminUserResponse struct {
+	UserResponse
+	// HasPassword is false for users who can only log in through single sign-on.
+	HasPassword bool
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+func newUserResponse(user *models.User) *UserResponse {
+	allowedIPs := []string(user.AllowedIPs)
+	if allowedIPs == nil {
+		allowedIPs = []string{}
+	}
+	return &UserResponse{
+		ID:             user.ID,
+		Name:           user.Name,
+		Email:          user.Email,
+		Role:           user.Role,
+		AllowedIPs:     allowedIPs,
+		EmailVerified:  user.EmailVerified,
+		TOTPEnabled:    user.TOTPEnabled,
+		OrganizationID: user.OrganizationID,
+	}
+}
+
+func newAdminUserResponse(user *models.User) *AdminUserResponse {
+	return &AdminUserResponse{
+		UserResponse: *newUserResponse(user),
+		HasPassword:  user.Password != "",
+		CreatedAt:    user.CreatedAt,
+		UpdatedAt:    user.UpdatedAt,
+	}
+}
+
+// userView returns what the caller of r may see of user.
+func (s *Server) userView(r *http.Request, user *models.User) any {
+	if s.roles.Allowed(r.Context(), auth.PermissionUsersRead) {
+		return newAdminUserResponse(user)
+	}
+	return newUserResponse(user)
+}
+
+// apply sets the fields of user that req changes.
+func (req *SelfUpdateRequest) apply(user *models.User) {
+	if req.Name != nil {
+		user.Name = *req.Name
+	}
+	if req.Email != nil {
+		user.Email = *req.Email
+	}
+	if req.AllowedIPs != nil {
+		user.AllowedIPs = *req.AllowedIPs
+	}
+}
@@ -3,7 +3,9 @@ package server
 import (
 	"encoding/json"
 	"net/http"
+	"slices"
 	"strconv"
+	"strings"
 
 	"github.com/humper/tor_exit_nodes/models"
 )
@@ -32,6 +34,20 @@ func getPagination(w http.ResponseWriter, r *http.Request) (*models.Pagination,
 	}
 	sortColumn := r.URL.Query().Get("sort")
 
+	filterDict, err := getFilter(w, r)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.Pagination{
+		Page:   page,
+		Limit:  limit,
+		Sort:   sortColumn,
+		Filter: filterDict,
+	}, nil
+}
+
+func getFilter(w http.ResponseWriter, r *http.Request) (map[string][]string, error) {
 	filterStr := r.URL.Query().Get("filter")
 	var filterDict map[string][]string
 	if filterStr != "" {
@@ -41,11 +57,35 @@ func getPagination(w http.ResponseWriter, r *http.Request) (*models.Pagination,
 			return nil, err
 		}
 	}
+	return filterDict, nil
+}
 
-	return &models.Pagination{
-		Page:   page,
-		Limit:  limit,
-		Sort:   sortColumn,
-		Filter: filterDict,
-	}, nil
+// checkColumns rejects filters on anything but columns, and sorts on anything but them, id and
+// created_at, since the database builds its queries from their names.
+func checkColumns(w http.ResponseWriter, pagination *models.Pagination, columns []string) bool {
+	for column := range pagination.Filter {
+		if !slices.Contains(columns, column) {
+			HttpError(w, "Invalid filter", http.StatusBadRequest)
+			return false
+		}
+	}
+
+	if pagination.Sort == "" {
+		return true
+	}
+	fields := strings.Fields(pagination.Sort)
+	ok := len(fields) == 1 || len(fields) == 2
+	if ok {
+		ok = slices.Contains(columns, fields[0]) || fields[0] == "id" || fields[0] == "created_at"
+	}
+	if ok && len(fields) == 2 {
+		fields[1] = strings.ToLower(fields[1])
+		ok = fields[1] == "asc" || fields[1] == "desc"
+	}
+	if !ok {
+		HttpError(w, "Invalid sort", http.StatusBadRequest)
+		return false
+	}
+	pagination.Sort = strings.Join(fields, " ")
+	return true
 }
@@ -0,0 +1,102 @@
+package tor
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PolicySummary is the port part of an exit policy in Tor's summary form, "accept 80,443,1000-2000"
+// or "reject 25,119", as published in microdescriptors and by Onionoo.  Summaries don't say anything
+// about destination addresses.
+type PolicySummary struct {
+	Accept bool
+	Ports  []PortRange
+}
+
+type PortRange struct {
+	Min, Max uint16
+}
+
+// ParsePolicySummary parses a summary in Tor's "accept|reject ports" form.
+func ParsePolicySummary(s string) (*PolicySummary, error) {
+	action, list, ok := strings.Cut(s, " ")
+	if !ok {
+		return nil, fmt.Errorf("invalid policy summary %q", s)
+	}
+	policy := &PolicySummary{}
+	switch action {
+	case "accept":
+		policy.Accept = true
+	case "reject":
+	default:
+		return nil, fmt.Errorf("invalid policy action %q", action)
+	}
+
+	for _, item := range strings.Split(list, ",") {
+		ports, err := parsePortRange(item)
+		if err != nil {
+			return nil, err
+		}
+		policy.Ports = append(policy.Ports, ports)
+	}
+	return policy, nil
+}
+
+func parsePortRange(s string) (PortRange, error) {
+	lo, hi, isRange := strings.Cut(s, "-")
+	if !isRange {
+		hi = lo
+	}
+	min, err := strconv.ParseUint(lo, 10, 16)
+	if err != nil || min == 0 {
+		return PortRange{}, fmt.Errorf("invalid port %q", s)
+	}
+	max, err := strconv.ParseUint(hi, 10, 16)
+	if err != nil || max < min {
+		return PortRange{}, fmt.Errorf("invalid port range %q", s)
+	}
+	return PortRange{Min: uint16(min), Max: uint16(max)}, nil
+}
+
+// Allows reports whether the policy lets an exit connect to port.
+func (p *PolicySummary) Allows(port uint16) bool {
+	for _, r := range p.Ports {
+		if port >= r.Min && port <= r.Max {
+			return p.Accept
+		}
+	}
+	return !p.Accept
+}
+
+func (p *PolicySummary) String() string {
+	items := make([]string, len(p.Ports))
+	for i, r := range p.Ports {
+		if r.Min == r.Max {
+			items[i] = strconv.Itoa(int(r.Min))
+		} else {
+			items[i] = fmt.Sprintf("%d-%d", r.Min, r.Max)
+		}
+	}
+	action := "reject"
+	if p.Accept {
+		action = "accept"
+	}
+	return action + " " + strings.Join(items, ",")
+}
+
+// onionooPolicy converts Onionoo's {"accept": [...]} or {"reject": [...]} summary object into Tor's
+// summary form, validating it on the way.
+func onionooPolicy(summary map[string][]string) (string, error) {
+	if len(summary) != 1 {
+		return "", fmt.Errorf("invalid policy summary %v", summary)
+	}
+	for action, ports := range summary {
+		policy, err := ParsePolicySummary(action + " " + strings.Join(ports, ","))
+		if err != nil {
+			return "", err
+		}
+		return policy.String(), nil
+	}
+	return "", nil
+}
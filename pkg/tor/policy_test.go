@@ -0,0 +1,28 @@
+package tor_test
+
+import (
+	"testing"
+
+	"github.com/humper/tor_exit_nodes/pkg/tor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicySummary(t *testing.T) {
+	policy, err := tor.ParsePolicySummary("accept 80,443,6660-6669")
+	require.NoError(t, err)
+	assert.True(t, policy.Allows(80))
+	assert.True(t, policy.Allows(6665))
+	assert.False(t, policy.Allows(25))
+	assert.Equal(t, "accept 80,443,6660-6669", policy.String())
+
+	policy, err = tor.ParsePolicySummary("reject 25,119")
+	require.NoError(t, err)
+	assert.False(t, policy.Allows(25))
+	assert.True(t, policy.Allows(443))
+
+	for _, invalid := range []string{"", "accept", "allow 80", "accept 0", "accept 80,", "accept 90-80", "reject 65536"} {
+		_, err := tor.ParsePolicySummary(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
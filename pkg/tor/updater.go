@@ -4,10 +4,13 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
+	"net/netip"
 	"strings"
+	"sync"
 	"time"
 
 	mapset "github.com/deckarep/golang-set/v2"
@@ -21,7 +24,13 @@ type TORUpdater struct {
 	SourceURLs   []string
 	GeoURL       string
 	GeoBatchSize int
+	PolicyURL    string
 	Client       *http.Client
+	OnUpdate     func(ctx context.Context)
+
+	// updating is held while the exit node list is being updated, so scheduled and triggered
+	// updates never run at once.
+	updating sync.Mutex
 }
 
 type NewTorUpdaterParams struct {
@@ -29,7 +38,12 @@ type NewTorUpdaterParams struct {
 	SourceURLs   []string
 	GeoURL       string
 	GeoBatchSize int
-	Client       *http.Client
+	// PolicyURL, if set, is an Onionoo details URL listing exit relays with their addresses and
+	// policy summaries, used to record which ports each exit connects to.
+	PolicyURL string
+	Client    *http.Client
+	// OnUpdate, if set, is called after each successful change to the stored exit nodes.
+	OnUpdate func(ctx context.Context)
 }
 
 type GeoQuery struct {
@@ -43,13 +57,32 @@ type GeoResponse struct {
 	Query       string `json:"query"`
 }
 
+// OnionooDetails is the part of an Onionoo details document the updater reads.
+type OnionooDetails struct {
+	Relays []OnionooRelay `json:"relays"`
+}
+
+type OnionooRelay struct {
+	ORAddresses         []string            `json:"or_addresses"`
+	ExitAddresses       []string            `json:"exit_addresses"`
+	ExitPolicySummary   map[string][]string `json:"exit_policy_summary"`
+	ExitPolicyV6Summary map[string][]string `json:"exit_policy_v6_summary"`
+}
+
+// exitPolicies are an exit's policy summaries, as stored on models.TorExitNode.
+type exitPolicies struct {
+	v4, v6 string
+}
+
 func NewTORUpdater(ctx context.Context, params *NewTorUpdaterParams) *TORUpdater {
 	tu := &TORUpdater{
 		DB:           params.DB,
 		SourceURLs:   params.SourceURLs,
 		GeoURL:       params.GeoURL,
 		GeoBatchSize: params.GeoBatchSize,
+		PolicyURL:    params.PolicyURL,
 		Client:       params.Client,
+		OnUpdate:     params.OnUpdate,
 	}
 
 	return tu
@@ -100,6 +133,14 @@ func (tu *TORUpdater) DoUpdateGeoData(ctx context.Context) {
 		slog.ErrorContext(ctx, "Failed to update countries", "error", err)
 		return
 	}
+
+	tu.notify(ctx)
+}
+
+func (tu *TORUpdater) notify(ctx context.Context) {
+	if tu.OnUpdate != nil {
+		tu.OnUpdate(ctx)
+	}
 }
 
 func (tu *TORUpdater) addGeoData(ctx context.Context, nodes []*models.TorExitNode) error {
@@ -148,7 +189,28 @@ func (tu *TORUpdater) addGeoData(ctx context.Context, nodes []*models.TorExitNod
 	return nil
 }
 
+// DoUpdateTorExitNodes replaces the stored exit nodes with the sources' current lists, waiting for
+// any update already in progress to finish first.
 func (tu *TORUpdater) DoUpdateTorExitNodes(ctx context.Context) {
+	tu.updating.Lock()
+	defer tu.updating.Unlock()
+	tu.updateTorExitNodes(ctx)
+}
+
+// TryUpdateTorExitNodes starts an update in the background and returns true, or returns false
+// without starting one if an update is already in progress.
+func (tu *TORUpdater) TryUpdateTorExitNodes(ctx context.Context) bool {
+	if !tu.updating.TryLock() {
+		return false
+	}
+	go func() {
+		defer tu.updating.Unlock()
+		tu.updateTorExitNodes(ctx)
+	}()
+	return true
+}
+
+func (tu *TORUpdater) updateTorExitNodes(ctx context.Context) {
 	found_ips := mapset.NewSet[string]()
 
 	for _, source := range tu.SourceURLs {
@@ -225,10 +287,29 @@ func (tu *TORUpdater) DoUpdateTorExitNodes(ctx context.Context) {
 			IP: ip,
 		})
 	}
+	policies, err := tu.fetchPolicies(ctx)
+	if err != nil {
+		// keep the policies already stored rather than forgetting them
+		slog.ErrorContext(ctx, "Failed to get exit policies", "error", err, "source", tu.PolicyURL)
+	}
+
 	nodes_to_add := []*models.TorExitNode{}
 	for ip := range ips_to_add.Iter() {
 		if ip != "" {
-			nodes_to_add = append(nodes_to_add, &models.TorExitNode{IP: ip})
+			node := &models.TorExitNode{IP: ip}
+			if policies != nil {
+				setPolicies(node, policies)
+			}
+			nodes_to_add = append(nodes_to_add, node)
+		}
+	}
+
+	nodes_to_update := []*models.TorExitNode{}
+	if policies != nil {
+		for ip := range existing_ip_set.Intersect(found_ips).Iter() {
+			if node := existing_exit_nodes_by_ip[ip]; setPolicies(node, policies) {
+				nodes_to_update = append(nodes_to_update, node)
+			}
 		}
 	}
 
@@ -236,4 +317,84 @@ func (tu *TORUpdater) DoUpdateTorExitNodes(ctx context.Context) {
 		slog.ErrorContext(ctx, "Failed to update tor exit nodes", "error", err)
 		return
 	}
+	if len(nodes_to_update) > 0 {
+		if err := tu.DB.TorExitNodes.Update(ctx, nodes_to_update); err != nil {
+			slog.ErrorContext(ctx, "Failed to update exit policies", "error", err)
+		}
+	}
+
+	tu.notify(ctx)
+}
+
+// setPolicies records node's policies from policies, keyed by exit address, and reports whether they
+// changed.  Exits missing from policies are given empty, unknown, policies.
+func setPolicies(node *models.TorExitNode, policies map[netip.Addr]exitPolicies) bool {
+	var found exitPolicies
+	if addr, err := netip.ParseAddr(node.IP); err == nil {
+		found = policies[addr.Unmap()]
+	}
+	if node.ExitPolicy == found.v4 && node.ExitPolicyV6 == found.v6 {
+		return false
+	}
+	node.ExitPolicy, node.ExitPolicyV6 = found.v4, found.v6
+	return true
+}
+
+// fetchPolicies reads exit policy summaries from PolicyURL, keyed by the addresses each relay exits
+// from.  It returns nil if no PolicyURL is configured.
+func (tu *TORUpdater) fetchPolicies(ctx context.Context) (map[netip.Addr]exitPolicies, error) {
+	if tu.PolicyURL == "" {
+		return nil, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, tu.PolicyURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := tu.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	var details OnionooDetails
+	if err := json.NewDecoder(resp.Body).Decode(&details); err != nil {
+		return nil, err
+	}
+
+	policies := map[netip.Addr]exitPolicies{}
+	for _, relay := range details.Relays {
+		if relay.ExitPolicySummary == nil {
+			continue
+		}
+		var p exitPolicies
+		if p.v4, err = onionooPolicy(relay.ExitPolicySummary); err != nil {
+			slog.WarnContext(ctx, "Skipping relay with invalid exit policy", "error", err, "addresses", relay.ORAddresses)
+			continue
+		}
+		// Onionoo leaves out the IPv6 summary for relays that don't exit over IPv6
+		p.v6 = "reject 1-65535"
+		if relay.ExitPolicyV6Summary != nil {
+			if p.v6, err = onionooPolicy(relay.ExitPolicyV6Summary); err != nil {
+				slog.WarnContext(ctx, "Skipping relay with invalid exit policy", "error", err, "addresses", relay.ORAddresses)
+				continue
+			}
+		}
+
+		// Onionoo only lists exit addresses that differ from the relay's OR addresses
+		for _, a := range relay.ORAddresses {
+			if addrPort, err := netip.ParseAddrPort(a); err == nil {
+				policies[addrPort.Addr().Unmap()] = p
+			}
+		}
+		for _, a := range relay.ExitAddresses {
+			if addr, err := netip.ParseAddr(a); err == nil {
+				policies[addr.Unmap()] = p
+			}
+		}
+	}
+	return policies, nil
 }
@@ -7,6 +7,7 @@ import (
 	"net/http/httptest"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/humper/tor_exit_nodes/models"
 	"github.com/humper/tor_exit_nodes/pkg/database/memory"
@@ -187,3 +188,115 @@ func TestGeoUpdates(t *testing.T) {
 	}
 
 }
+
+func TestOnUpdate(t *testing.T) {
+	ctx := context.Background()
+	db, err := memory.New(ctx)
+	require.NoError(t, err, "Failed to create memory database")
+
+	updates := 0
+	tu := tor.NewTORUpdater(ctx, &tor.NewTorUpdaterParams{
+		DB:           db,
+		SourceURLs:   []string{exitnodeServer.URL + "/tor/tiny"},
+		GeoURL:       geoServer.URL,
+		GeoBatchSize: 100,
+		Client:       http.DefaultClient,
+		OnUpdate: func(ctx context.Context) {
+			updates++
+		},
+	})
+
+	tu.DoUpdateTorExitNodes(ctx)
+	assert.Equal(t, 1, updates)
+
+	tu.DoUpdateGeoData(ctx)
+	assert.Equal(t, 2, updates)
+
+	// nothing left to geolocate
+	tu.DoUpdateGeoData(ctx)
+	assert.Equal(t, 2, updates)
+}
+
+func TestTryUpdateTorExitNodes(t *testing.T) {
+	ctx := context.Background()
+	db, err := memory.New(ctx)
+	require.NoError(t, err, "Failed to create memory database")
+
+	requested := make(chan struct{}, 1)
+	release := make(chan struct{})
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested <- struct{}{}
+		<-release
+		w.Write([]byte("1.2.3.4\n"))
+	}))
+	defer source.Close()
+
+	tu := tor.NewTORUpdater(ctx, &tor.NewTorUpdaterParams{
+		DB:         db,
+		SourceURLs: []string{source.URL},
+		Client:     http.DefaultClient,
+	})
+
+	require.True(t, tu.TryUpdateTorExitNodes(ctx))
+	<-requested
+	assert.False(t, tu.TryUpdateTorExitNodes(ctx), "an update is already in progress")
+
+	close(release)
+	require.Eventually(t, func() bool {
+		pagination, err := db.TorExitNodes.GetAll(ctx, []string{}, &models.Pagination{})
+		return err == nil && pagination.TotalRows == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// DoUpdateTorExitNodes waits for the background update rather than racing it
+	tu.DoUpdateTorExitNodes(ctx)
+	assert.True(t, tu.TryUpdateTorExitNodes(ctx))
+	<-requested
+}
+
+func TestExitPolicies(t *testing.T) {
+	ctx := context.Background()
+	db, err := memory.New(ctx)
+	require.NoError(t, err, "Failed to create memory database")
+
+	tu := tor.NewTORUpdater(ctx, &tor.NewTorUpdaterParams{
+		DB:         db,
+		SourceURLs: []string{exitnodeServer.URL + "/tor/tiny"},
+		PolicyURL:  exitnodeServer.URL + "/onionoo/details",
+		Client:     http.DefaultClient,
+	})
+
+	policies := func() map[string][2]string {
+		pagination, err := db.TorExitNodes.GetAll(ctx, []string{}, &models.Pagination{})
+		require.NoError(t, err, "Failed to get tor exit nodes")
+		found := map[string][2]string{}
+		for _, node := range pagination.Rows.([]*models.TorExitNode) {
+			found[node.IP] = [2]string{node.ExitPolicy, node.ExitPolicyV6}
+		}
+		return found
+	}
+
+	tu.DoUpdateTorExitNodes(ctx)
+	assert.Equal(t, map[string][2]string{
+		"103.163.218.11": {"reject 25,119,135-139", "accept 80,443"},
+		// found through its exit address, and doesn't exit over IPv6
+		"103.172.134.26": {"accept 80,443", "reject 1-65535"},
+		// not in the policy list
+		"103.193.179.233": {"", ""},
+	}, policies())
+
+	// existing nodes pick up changed policies, and lose ones no longer listed
+	nodes, err := db.TorExitNodes.GetAll(ctx, []string{}, &models.Pagination{})
+	require.NoError(t, err, "Failed to get tor exit nodes")
+	for _, node := range nodes.Rows.([]*models.TorExitNode) {
+		node.ExitPolicy = "accept 22"
+	}
+	require.NoError(t, db.TorExitNodes.Update(ctx, nodes.Rows.([]*models.TorExitNode)))
+	tu.DoUpdateTorExitNodes(ctx)
+	assert.Equal(t, [2]string{"accept 80,443", "reject 1-65535"}, policies()["103.172.134.26"])
+	assert.Equal(t, [2]string{"", ""}, policies()["103.193.179.233"])
+
+	// a failed fetch keeps the stored policies
+	tu.PolicyURL = exitnodeServer.URL + "/onionoo/missing"
+	tu.DoUpdateTorExitNodes(ctx)
+	assert.Equal(t, [2]string{"accept 80,443", "reject 1-65535"}, policies()["103.172.134.26"])
+}
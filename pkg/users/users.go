@@ -0,0 +1,111 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/humper/tor_exit_nodes/models"
+	"github.com/humper/tor_exit_nodes/pkg/auth"
+	"github.com/humper/tor_exit_nodes/pkg/database"
+)
+
+var ErrExists = errors.New("user already exists")
+
+// Create adds user with password, hashed by hasher, after checking it against policy.
+func Create(ctx context.Context, users database.Users, policy *auth.PasswordPolicy, hasher *auth.PasswordHasher, user *models.User, password string) error {
+	if _, err := users.GetByEmail(ctx, user.Email); err == nil {
+		return ErrExists
+	}
+	if err := policy.Check(password); err != nil {
+		return err
+	}
+	hash, err := hasher.Hash(password)
+	if err != nil {
+		return err
+	}
+	user.Password = hash
+	return users.Create(ctx, user)
+}
+
+// SetPassword replaces user's password, after checking it against policy, and saves them.
+func SetPassword(ctx context.Context, users database.Users, policy *auth.PasswordPolicy, hasher *auth.PasswordHasher, user *models.User, password string) error {
+	if err := policy.Check(password); err != nil {
+		return err
+	}
+	hash, err := hasher.Hash(password)
+	if err != nil {
+		return err
+	}
+	user.Password = hash
+	return users.Update(ctx, user)
+}
+
+// BootstrapConfig describes the admin created when the database has no users, so that a new
+// deployment can be logged into.
+type BootstrapConfig struct {
+	Email string `yaml:"email"`
+	Name  string `yaml:"name"`
+	// Role defaults to admin.
+	Role string `yaml:"role"`
+	// The password is read from the environment variable PasswordEnv or the file PasswordFile,
+	// never from the config itself.
+	PasswordEnv  string `yaml:"password_env"`
+	PasswordFile string `yaml:"password_file"`
+}
+
+// ReadPassword returns the password in the environment variable env, or else the file file.
+func ReadPassword(env, file string) (string, error) {
+	if env != "" {
+		password, ok := os.LookupEnv(env)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s isn't set", env)
+		}
+		return password, nil
+	}
+	if file != "" {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(b), "\r\n"), nil
+	}
+	return "", errors.New("no password source given")
+}
+
+// Bootstrap creates the user cfg describes if there are no users at all.  It reports whether it
+// did.
+func Bootstrap(ctx context.Context, users database.Users, policy *auth.PasswordPolicy, hasher *auth.PasswordHasher, cfg *BootstrapConfig) (bool, error) {
+	page, err := users.GetAll(ctx, &models.Pagination{Limit: 1})
+	if err != nil {
+		return false, err
+	}
+	if page.TotalRows > 0 {
+		return false, nil
+	}
+
+	password, err := ReadPassword(cfg.PasswordEnv, cfg.PasswordFile)
+	if err != nil {
+		return false, err
+	}
+	role := cfg.Role
+	if role == "" {
+		role = "admin"
+	}
+	user := &models.User{
+		Name:  cfg.Name,
+		Email: cfg.Email,
+		Role:  role,
+		// the operator chose the address
+		EmailVerified: true,
+		AllowedIPs:    []string{},
+	}
+	if err := Create(ctx, users, policy, hasher, user, password); err != nil {
+		return false, err
+	}
+	slog.InfoContext(ctx, "Created bootstrap user", "email", user.Email, "role", role)
+	return true, nil
+}
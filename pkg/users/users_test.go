@@ -0,0 +1,89 @@
+package users_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/humper/tor_exit_nodes/models"
+	"github.com/humper/tor_exit_nodes/pkg/auth"
+	"github.com/humper/tor_exit_nodes/pkg/database/memory"
+	"github.com/humper/tor_exit_nodes/pkg/users"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreate(t *testing.T) {
+	ctx := context.Background()
+	db, err := memory.New(ctx)
+	require.NoError(t, err)
+	policy := auth.DefaultPasswordPolicy()
+	hasher := auth.DefaultPasswordHasher()
+
+	require.NoError(t, users.Create(ctx, db.Users, policy, hasher, &models.User{Email: "test@test.com", Role: "user"}, "password"))
+	user, err := db.Users.GetByEmail(ctx, "test@test.com")
+	require.NoError(t, err)
+	assert.True(t, auth.ComparePassword("password", user.Password))
+
+	assert.ErrorIs(t, users.Create(ctx, db.Users, policy, hasher, &models.User{Email: "test@test.com"}, "password"), users.ErrExists)
+	assert.Error(t, users.Create(ctx, db.Users, policy, hasher, &models.User{Email: "short@test.com"}, "short"))
+}
+
+func TestSetPassword(t *testing.T) {
+	ctx := context.Background()
+	db, err := memory.New(ctx)
+	require.NoError(t, err)
+	policy := auth.DefaultPasswordPolicy()
+	hasher := auth.DefaultPasswordHasher()
+
+	require.NoError(t, users.Create(ctx, db.Users, policy, hasher, &models.User{Email: "test@test.com", Role: "user"}, "password"))
+	user, err := db.Users.GetByEmail(ctx, "test@test.com")
+	require.NoError(t, err)
+
+	assert.Error(t, users.SetPassword(ctx, db.Users, policy, hasher, user, "short"))
+	require.NoError(t, users.SetPassword(ctx, db.Users, policy, hasher, user, "new password"))
+	user, err = db.Users.GetByEmail(ctx, "test@test.com")
+	require.NoError(t, err)
+	assert.True(t, auth.ComparePassword("new password", user.Password))
+}
+
+func TestBootstrap(t *testing.T) {
+	ctx := context.Background()
+	db, err := memory.New(ctx)
+	require.NoError(t, err)
+	policy := auth.DefaultPasswordPolicy()
+	hasher := auth.DefaultPasswordHasher()
+
+	path := filepath.Join(t.TempDir(), "password")
+	require.NoError(t, os.WriteFile(path, []byte("admin_password\n"), 0600))
+	cfg := &users.BootstrapConfig{Email: "admin@test.com", Name: "Admin", PasswordFile: path}
+
+	created, err := users.Bootstrap(ctx, db.Users, policy, hasher, cfg)
+	require.NoError(t, err)
+	assert.True(t, created)
+	admin, err := db.Users.GetByEmail(ctx, "admin@test.com")
+	require.NoError(t, err)
+	assert.Equal(t, "admin", admin.Role)
+	assert.True(t, auth.ComparePassword("admin_password", admin.Password))
+
+	// only an empty database is bootstrapped
+	cfg.Email = "other@test.com"
+	created, err = users.Bootstrap(ctx, db.Users, policy, hasher, cfg)
+	require.NoError(t, err)
+	assert.False(t, created)
+	_, err = db.Users.GetByEmail(ctx, "other@test.com")
+	assert.Error(t, err)
+}
+
+func TestReadPassword(t *testing.T) {
+	t.Setenv("TEN_TEST_PASSWORD", "from env")
+	password, err := users.ReadPassword("TEN_TEST_PASSWORD", "")
+	require.NoError(t, err)
+	assert.Equal(t, "from env", password)
+
+	_, err = users.ReadPassword("TEN_TEST_UNSET_PASSWORD", "")
+	assert.Error(t, err)
+	_, err = users.ReadPassword("", "")
+	assert.Error(t, err)
+}
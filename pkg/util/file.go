@@ -0,0 +1,55 @@
+package util
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+)
+
+// WriteFileIfChanged atomically replaces filename with data, unless it already holds exactly that
+// content.  The data is written to a temporary file in the same directory and renamed into place, so
+// readers never see a partially written file.  It reports whether the file was changed.
+func WriteFileIfChanged(filename string, data []byte, perm os.FileMode) (bool, error) {
+	existing, err := os.ReadFile(filename)
+	if err == nil && bytes.Equal(existing, data) {
+		return false, nil
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".*")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return false, err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return false, err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return false, err
+	}
+	if err := tmp.Close(); err != nil {
+		return false, err
+	}
+
+	if err := os.Rename(tmp.Name(), filename); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// SyncDir flushes dir's entries to disk, so that files renamed into it, as by WriteFileIfChanged,
+// survive a crash.
+func SyncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -1,5 +1,22 @@
 const apiUrl = 'http://localhost:8080';
 
+// verifyTotp finishes logging in a user with two-factor authentication enabled.
+const verifyTotp = (token) => {
+  const code = window.prompt('Enter the code from your authenticator app, or a recovery code');
+  const request = new Request(apiUrl + '/login/totp', {
+    method: 'POST',
+    body: JSON.stringify({ totp_token: token, code }),
+    headers: new Headers({ 'Content-Type': 'application/json' }),
+    credentials: 'include',
+  });
+  return fetch(request).then((response) => {
+    if (response.status < 200 || response.status >= 300) {
+      throw new Error(response.statusText);
+    }
+    return response.json();
+  });
+};
+
 const authProvider = {
   login: ({ username, password }) => {
     const request = new Request(apiUrl + '/login', {
@@ -18,6 +35,7 @@ const authProvider = {
         }
         return response.json();
       })
+      .then((auth) => (auth.totp_required ? verifyTotp(auth.totp_token) : auth))
       .then((auth) => {
         localStorage.setItem('user', JSON.stringify(auth));
       })
@@ -37,16 +55,19 @@ const authProvider = {
     return Promise.resolve();
   },
   getIdentity: () => {
-    try {
-      const { ID, Name } = JSON.parse(localStorage.getItem('user'));
-      return Promise.resolve({ id: ID, fullName: Name});
-    } catch (error) {
-      return Promise.reject(error);
-    }
+    return fetch(apiUrl + '/me', { credentials: 'include' })
+      .then((response) => {
+        if (response.status < 200 || response.status >= 300) {
+          throw new Error(response.statusText);
+        }
+        return response.json();
+      })
+      .then(({ ID, Name }) => ({ id: ID, fullName: Name }));
   },
   logout: (error) => {
     localStorage.removeItem('user');
-    return Promise.resolve();
+    return fetch(apiUrl + '/logout', { method: 'POST', credentials: 'include' })
+      .then(() => {}, () => {});
   },
   // ...
 };
@@ -7,7 +7,19 @@ const httpClient = (url, options={}) => {
     options.headers = new Headers({ Accept: 'application/json' });
   }
   options.credentials = 'include';
-  return fetchUtils.fetchJson(url, options);
+  // access tokens are short-lived; on a 401, refresh the session once and retry
+  return fetchUtils.fetchJson(url, options).catch((error) => {
+    if (error.status !== 401) {
+      throw error;
+    }
+    return fetch(apiUrl + '/token/refresh', { method: 'POST', credentials: 'include' })
+      .then((response) => {
+        if (response.status < 200 || response.status >= 300) {
+          throw error;
+        }
+        return fetchUtils.fetchJson(url, options);
+      });
+  });
 }
 
 const resourceMap = {
@@ -1777,6 +1777,10 @@ var MockEndpoints = map[string]string{
 	"/tor/tiny": `103.163.218.11
 103.172.134.26
 103.193.179.233`,
+	"/onionoo/details": `{"version":"9.0","relays":[
+{"or_addresses":["103.163.218.11:9001","[2001:db8::11]:9001"],"exit_policy_summary":{"reject":["25","119","135-139"]},"exit_policy_v6_summary":{"accept":["80","443"]}},
+{"or_addresses":["198.51.100.7:443"],"exit_addresses":["103.172.134.26"],"exit_policy_summary":{"accept":["80","443"]}}
+]}`,
 }
 
 var GeoData = map[string]tor.GeoResponse{